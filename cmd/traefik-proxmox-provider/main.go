@@ -0,0 +1,96 @@
+// Command traefik-proxmox-provider runs a single scan of a Proxmox cluster and
+// prints the dynamic configuration the provider would send to Traefik.
+// It is meant to validate guest labels before they go live.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/NX211/traefik-proxmox-provider/provider"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("traefik-proxmox-provider", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	config := provider.CreateConfig()
+	flags.StringVar(&config.ApiEndpoint, "endpoint", os.Getenv("API_ENDPOINT"), "Proxmox API endpoint (env API_ENDPOINT)")
+	flags.StringVar(&config.ApiTokenId, "token-id", os.Getenv("API_TOKEN_ID"), "Proxmox API token ID (env API_TOKEN_ID)")
+	flags.StringVar(&config.ApiToken, "token", os.Getenv("API_TOKEN"), "Proxmox API token secret (env API_TOKEN)")
+	flags.StringVar(&config.ApiValidateSSL, "validate-ssl", envOrDefault("API_VALIDATE_SSL", config.ApiValidateSSL), "Whether to validate SSL certificates (env API_VALIDATE_SSL)")
+	flags.StringVar(&config.ApiLogging, "log-level", envOrDefault("API_LOGGING", internal.LogLevelWarn), "Log level written to stderr (env API_LOGGING)")
+	flags.StringVar(&config.LogFormat, "log-format", envOrDefault("LOG_FORMAT", config.LogFormat), "Log format written to stderr: text or json (env LOG_FORMAT)")
+	flags.StringVar(&config.IncludeNodes, "node", "", "Comma-separated list of nodes to scan (default all nodes)")
+	flags.StringVar(&config.FixtureFile, "fixture", "", "Read the cluster state from a JSON fixture file instead of the API")
+	flags.StringVar(&config.LabelPrefix, "label-prefix", config.LabelPrefix, "Prefix used to recognize labels")
+	format := flags.String("format", "json", "Output format: json or yaml")
+	strict := flags.Bool("strict", false, "Also fail on label warnings, such as unknown or misspelled keys")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "json" && *format != "yaml" {
+		fmt.Fprintf(stderr, "unsupported format %q, use json or yaml\n", *format)
+		return 2
+	}
+
+	ctx := context.Background()
+	p, err := provider.New(ctx, config, "traefik-proxmox-provider")
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	configuration, err := p.GenerateOnce(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	data, err := json.MarshalIndent(configuration, "", "  ")
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to marshal configuration: %v\n", err)
+		return 1
+	}
+	if *format == "yaml" {
+		data, err = jsonToYAML(data)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: failed to convert configuration to YAML: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Fprintln(stdout, string(data))
+
+	labelErrors := p.LabelErrors()
+	for _, labelErr := range labelErrors {
+		fmt.Fprintf(stderr, "Malformed labels: %v\n", labelErr)
+	}
+	failed := len(labelErrors) > 0
+	for _, diagnostic := range p.LabelDiagnostics() {
+		if diagnostic.Severity == provider.SeverityError || *strict {
+			fmt.Fprintf(stderr, "Invalid labels: %s\n", diagnostic)
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func envOrDefault(key, fallback string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, description string) *httptest.Server {
+	t.Helper()
+	responses := map[string]string{
+		"/api2/json/version":                    `{"data":{"release":"8.1"}}`,
+		"/api2/json/nodes":                      `{"data":[{"node":"pve1"}]}`,
+		"/api2/json/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"app","status":"running"}]}`,
+		"/api2/json/nodes/pve1/qemu/100/config": fmt.Sprintf(`{"data":{"description":%q}}`, description),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, exists := responses[r.URL.Path]; exists {
+			fmt.Fprint(w, body)
+			return
+		}
+		fmt.Fprint(w, `{"data":[]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		format      string
+		exitCode    int
+		contains    string
+	}{
+		{
+			name:        "JSON output",
+			description: "traefik.enable=true\ntraefik.http.routers.app.rule=Host(`app.example.com`)",
+			format:      "json",
+			exitCode:    0,
+			contains:    `"rule": "Host(` + "`app.example.com`" + `)"`,
+		},
+		{
+			name:        "YAML output",
+			description: "traefik.enable=true\ntraefik.http.routers.app.rule=Host(`app.example.com`)",
+			format:      "yaml",
+			exitCode:    0,
+			contains:    `rule: "Host(` + "`app.example.com`" + `)"`,
+		},
+		{
+			name:        "Malformed labels",
+			description: "traefik.enable=true\n```json\n{\"traefik\":\n```",
+			format:      "json",
+			exitCode:    1,
+			contains:    `"http"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.description)
+			var stdout, stderr bytes.Buffer
+			args := []string{"-endpoint", server.URL, "-token-id", "test@pam!test", "-token", "test-token", "-format", tt.format}
+
+			if code := run(args, &stdout, &stderr); code != tt.exitCode {
+				t.Errorf("Expected exit code %d, got %d (stderr: %s)", tt.exitCode, code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.contains) {
+				t.Errorf("Expected output to contain %s, got:\n%s", tt.contains, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunLabelDiagnostics(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		strict      bool
+		exitCode    int
+		contains    string
+	}{
+		{
+			name:        "Error diagnostic",
+			description: "traefik.enable=true\ntraefik.http.services.app.loadbalancer.server.port=http",
+			exitCode:    1,
+			contains:    "Invalid labels: guest app (ID: 100) on node pve1: label traefik.http.services.app.loadbalancer.server.port",
+		},
+		{
+			name:        "Unknown key",
+			description: "traefik.enable=true\ntraefik.http.router.app.rule=Host(`app.example.com`)",
+			exitCode:    0,
+		},
+		{
+			name:        "Unknown key in strict mode",
+			description: "traefik.enable=true\ntraefik.http.router.app.rule=Host(`app.example.com`)",
+			strict:      true,
+			exitCode:    1,
+			contains:    "Invalid labels: guest app (ID: 100) on node pve1: label traefik.http.router.app.rule",
+		},
+		{
+			name:        "Disabled guest in strict mode",
+			description: "traefik.http.router.app.rule=Host(`app.example.com`)",
+			strict:      true,
+			exitCode:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.description)
+			var stdout, stderr bytes.Buffer
+			args := []string{"-endpoint", server.URL, "-token-id", "test@pam!test", "-token", "test-token", "-log-level", "error"}
+			if tt.strict {
+				args = append(args, "-strict")
+			}
+
+			if code := run(args, &stdout, &stderr); code != tt.exitCode {
+				t.Errorf("Expected exit code %d, got %d (stderr: %s)", tt.exitCode, code, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), tt.contains) {
+				t.Errorf("Expected stderr to contain %s, got:\n%s", tt.contains, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunInvalidFormat(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-format", "xml"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected exit code 2, got %d", code)
+	}
+}
+
+func TestJSONToYAML(t *testing.T) {
+	data, err := jsonToYAML([]byte(`{"b":{"list":["x","y"],"empty":{}},"a":true}`))
+	if err != nil {
+		t.Fatalf("jsonToYAML() error = %v", err)
+	}
+
+	expected := "a: true\nb:\n  empty: {}\n  list:\n    - \"x\"\n    - \"y\""
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, string(data))
+	}
+}
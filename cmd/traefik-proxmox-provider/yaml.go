@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonToYAML converts JSON into an equivalent block-style YAML document.
+// Keys are sorted so the output is stable between runs.
+func jsonToYAML(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeYAML(&buf, value, 0)
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func writeYAML(buf *bytes.Buffer, value interface{}, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if isYAMLScalar(v[k]) {
+				fmt.Fprintf(buf, "%s%s: %s\n", pad, k, yamlScalar(v[k]))
+				continue
+			}
+			fmt.Fprintf(buf, "%s%s:\n", pad, k)
+			writeYAML(buf, v[k], indent+1)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if isYAMLScalar(item) {
+				fmt.Fprintf(buf, "%s- %s\n", pad, yamlScalar(item))
+				continue
+			}
+			fmt.Fprintf(buf, "%s-\n", pad)
+			writeYAML(buf, item, indent+1)
+		}
+	default:
+		fmt.Fprintf(buf, "%s%s\n", pad, yamlScalar(v))
+	}
+}
+
+func isYAMLScalar(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	default:
+		return true
+	}
+}
+
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return strconv.Quote(v)
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -2,22 +2,81 @@ package internal
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Log levels
 const (
-	LogLevelInfo  = "info"
 	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// BuildVersion is the provider version reported in the User-Agent header.
+// It is set at build time with -ldflags "-X github.com/NX211/traefik-proxmox-provider/internal.BuildVersion=<version>".
+var BuildVersion = "dev"
+
+// DefaultUserAgent returns the User-Agent sent when none is configured
+func DefaultUserAgent() string {
+	return "traefik-proxmox-provider/" + BuildVersion
+}
+
+// Connection pool defaults of the API transport
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 10
+	DefaultIdleConnTimeout     = 90 * time.Second
 )
 
+// redactedValue replaces credentials in log output
+const redactedValue = "[REDACTED]"
+
+// APIError is returned when the Proxmox API answers with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Body       string
+	// Status is the status line, where Proxmox puts the error message
+	Status string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsAuthError reports whether the API rejected the credentials
+func (e *APIError) IsAuthError() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// IsGuestAgentNotRunning reports whether a guest agent request failed because
+// the QEMU guest agent of the VM is not running or not configured, which is
+// expected for VMs without an agent
+func (e *APIError) IsGuestAgentNotRunning() bool {
+	if e.StatusCode != http.StatusInternalServerError {
+		return false
+	}
+	message := strings.ToLower(e.Status + " " + e.Body)
+	return strings.Contains(message, "guest agent is not running") || strings.Contains(message, "no qemu guest agent configured")
+}
+
+// IsNotImplemented reports whether the API does not know the requested
+// method, as older Proxmox VE versions answer for endpoints added later
+func (e *APIError) IsNotImplemented() bool {
+	return e.StatusCode == http.StatusNotImplemented
+}
+
 // ProxmoxClient represents a client to the Proxmox API
 type ProxmoxClient struct {
 	BaseURL     string
@@ -25,13 +84,43 @@ type ProxmoxClient struct {
 	Token       string
 	HTTPClient  *http.Client
 	LogLevel    string
+	Logger      *Logger
 	ValidateSSL bool
+	UserAgent   string
+	// LogBodyLimit truncates response bodies in debug logs to this many
+	// bytes, 0 meaning no limit
+	LogBodyLimit int
+	// ResponseObserver is called with the status code of every API response,
+	// or 0 when the request failed without a response
+	ResponseObserver func(statusCode int)
+	// BaseURLs lists the base URLs of all configured endpoints when more
+	// than one is given, BaseURL being the first of them
+	BaseURLs []string
+	// EndpointCooldown is how long an endpoint that failed is skipped
+	EndpointCooldown time.Duration
+	// ExtraHeaders are added to every request, e.g. for a zero-trust proxy
+	// in front of the API. They never replace the headers set by the client.
+	ExtraHeaders http.Header
+
+	// endpoints are the configured endpoints the base URLs are built from
+	endpoints []string
+	health    endpointHealth
+	limiter   *rateLimiter
+
+	// tokenFile is re-read when the API rejects Token, guarded by tokenMu
+	tokenFile string
+	tokenMu   sync.Mutex
 }
 
-// NewProxmoxClient creates a new Proxmox API client
+// NewProxmoxClient creates a new Proxmox API client. apiEndpoint may list
+// several comma-separated endpoints of the same cluster to fail over between.
 func NewProxmoxClient(apiEndpoint, tokenID, token string, validateSSL bool, logLevel string) *ProxmoxClient {
 	httpClient := &http.Client{
 		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        DefaultMaxIdleConns,
+			MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+			IdleConnTimeout:     DefaultIdleConnTimeout,
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: !validateSSL,
 			},
@@ -39,78 +128,313 @@ func NewProxmoxClient(apiEndpoint, tokenID, token string, validateSSL bool, logL
 		Timeout: 30 * time.Second,
 	}
 
-	baseURL := fmt.Sprintf("%s/api2/json", apiEndpoint)
-	if logLevel == LogLevelDebug {
-		log.Printf("Creating new Proxmox client with base URL: %s", baseURL)
+	endpoints := SplitEndpoints(apiEndpoint)
+	var baseURLs []string
+	for _, endpoint := range endpoints {
+		baseURLs = append(baseURLs, apiBaseURL(endpoint, DefaultAPIBasePath))
+	}
+	baseURL := apiBaseURL(apiEndpoint, DefaultAPIBasePath)
+	if len(baseURLs) > 0 {
+		baseURL = baseURLs[0]
 	}
+	if len(baseURLs) <= 1 {
+		baseURLs = nil
+	}
+	logger := NewLogger(logLevel)
+	logger.Redact(token)
+	logger.Debugf("Creating new Proxmox client with base URL: %s", baseURL)
 
 	return &ProxmoxClient{
-		BaseURL:     baseURL,
-		TokenID:     tokenID,
-		Token:       token,
-		HTTPClient:  httpClient,
-		LogLevel:    logLevel,
-		ValidateSSL: validateSSL,
+		BaseURL:          baseURL,
+		TokenID:          tokenID,
+		Token:            token,
+		HTTPClient:       httpClient,
+		LogLevel:         logLevel,
+		Logger:           logger,
+		ValidateSSL:      validateSSL,
+		UserAgent:        DefaultUserAgent(),
+		BaseURLs:         baseURLs,
+		EndpointCooldown: DefaultEndpointCooldown,
+		endpoints:        endpoints,
 	}
 }
 
-// Do performs an HTTP request to the Proxmox API
-func (c *ProxmoxClient) Do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
-	fullURL := c.BaseURL + path
+// SetBasePath replaces the /api2/json path appended to every endpoint, e.g.
+// when a reverse proxy serves the API below a sub-path
+func (c *ProxmoxClient) SetBasePath(basePath string) error {
+	basePath = strings.TrimSpace(basePath)
+	if basePath == "" {
+		basePath = DefaultAPIBasePath
+	}
+
+	baseURLs := make([]string, 0, len(c.endpoints))
+	for _, endpoint := range c.endpoints {
+		baseURL := apiBaseURL(endpoint, basePath)
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return fmt.Errorf("invalid API URL %q: %w", baseURL, err)
+		}
+		if u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("invalid API URL %q: scheme and host are required, query and fragment are not allowed", baseURL)
+		}
+		baseURLs = append(baseURLs, baseURL)
+	}
+	if len(baseURLs) == 0 {
+		return fmt.Errorf("no API endpoint to apply the base path %q to", basePath)
+	}
 
-	if c.LogLevel == LogLevelDebug {
-		log.Printf("API Request: %s %s", method, fullURL)
+	c.BaseURL = baseURLs[0]
+	c.BaseURLs = nil
+	if len(baseURLs) > 1 {
+		c.BaseURLs = baseURLs
 	}
+	c.Logger.Debugf("Using base URL %s for Proxmox API requests", c.BaseURL)
+	return nil
+}
 
-	var reqBody io.Reader
+// SetProxy routes all API requests through the given proxy URL instead of
+// the proxy taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables
+func (c *ProxmoxClient) SetProxy(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid proxy URL %q: scheme and host are required", proxyURL)
+	}
+
+	transport, ok := c.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("cannot set a proxy on a custom transport")
+	}
+	transport.Proxy = http.ProxyURL(u)
+	c.Logger.Debugf("Using proxy %s for Proxmox API requests", u.Redacted())
+	return nil
+}
+
+// reservedHeaders are set by the client itself and cannot be overridden
+var reservedHeaders = []string{"Authorization", "Accept", "Accept-Encoding", "Content-Type", "User-Agent"}
+
+// SetExtraHeaders adds the given headers to every API request. The values
+// are masked in logs, since such headers usually carry credentials.
+func (c *ProxmoxClient) SetExtraHeaders(headers map[string]string) error {
+	extra := make(http.Header)
+	for key, value := range headers {
+		for _, reserved := range reservedHeaders {
+			if strings.EqualFold(key, reserved) {
+				return fmt.Errorf("header %s is set by the provider and cannot be overridden", reserved)
+			}
+		}
+		extra.Set(key, value)
+		c.Logger.Redact(value)
+	}
+	c.ExtraHeaders = extra
+	return nil
+}
+
+// SetConnectionPool configures how many idle keep-alive connections the
+// client keeps open, in total and per endpoint, and for how long, so that
+// guest scans reuse connections instead of repeating TLS handshakes
+func (c *ProxmoxClient) SetConnectionPool(maxIdleConns, maxIdleConnsPerHost int, idleConnTimeout time.Duration) error {
+	if maxIdleConns < 0 || maxIdleConnsPerHost < 0 || idleConnTimeout < 0 {
+		return fmt.Errorf("connection pool settings must not be negative")
+	}
+
+	transport, ok := c.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("cannot configure the connection pool of a custom transport")
+	}
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	return nil
+}
+
+// SetRateLimit smooths outbound requests to requestsPerSecond, allowing
+// bursts of up to burst requests. A rate of 0 disables the limit.
+func (c *ProxmoxClient) SetRateLimit(requestsPerSecond float64, burst int) error {
+	if requestsPerSecond < 0 || burst < 0 {
+		return fmt.Errorf("rate limit settings must not be negative")
+	}
+	if requestsPerSecond == 0 {
+		c.limiter = nil
+		return nil
+	}
+	if burst == 0 {
+		burst = 1
+	}
+	c.limiter = newRateLimiter(requestsPerSecond, burst)
+	return nil
+}
+
+// Do performs an HTTP request to the Proxmox API. With several endpoints
+// configured, a request that fails without a response is retried on the
+// next endpoint and the failed one is skipped for EndpointCooldown. With a
+// token file, a request rejected with 401 is retried once after reloading
+// the token.
+func (c *ProxmoxClient) Do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var jsonBody []byte
 	if body != nil {
-		jsonBody, err := json.Marshal(body)
+		var err error
+		jsonBody, err = json.Marshal(body)
 		if err != nil {
 			return fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		reqBody = bytes.NewBuffer(jsonBody)
+	}
+
+	err := c.doWithFailover(ctx, method, path, jsonBody, result)
+	if isTokenRejected(err) && c.reloadToken() {
+		err = c.doWithFailover(ctx, method, path, jsonBody, result)
+	}
+	return err
+}
+
+// doWithFailover performs a request, trying the endpoints in turn
+func (c *ProxmoxClient) doWithFailover(ctx context.Context, method, path string, jsonBody []byte, result interface{}) error {
+	candidates := c.candidateURLs(time.Now())
+	var err error
+	for i, baseURL := range candidates {
+		if err := c.limiter.wait(ctx); err != nil {
+			return fmt.Errorf("waiting for the API rate limit: %w", err)
+		}
+
+		var failover bool
+		failover, err = c.do(ctx, method, baseURL, path, jsonBody, result)
+		if len(candidates) == 1 {
+			return err
+		}
+		if !failover {
+			c.health.markHealthy(baseURL)
+			return err
+		}
+		// A cancelled or expired context says nothing about the endpoint
+		if ctx.Err() != nil {
+			return err
+		}
+		c.health.markUnhealthy(baseURL, time.Now(), c.EndpointCooldown)
+		if i < len(candidates)-1 {
+			c.Logger.Warnf("Proxmox API endpoint %s failed, trying the next one: %v", baseURL, err)
+		}
+	}
+	return err
+}
+
+// do performs a single request against baseURL. failover reports whether
+// the request failed without a response, so another endpoint may succeed.
+func (c *ProxmoxClient) do(ctx context.Context, method, baseURL, path string, jsonBody []byte, result interface{}) (failover bool, err error) {
+	fullURL := baseURL + path
+
+	c.Logger.Debugf("API Request: %s %s", method, fullURL)
+
+	var reqBody io.Reader
+	if jsonBody != nil {
+		reqBody = bytes.NewReader(jsonBody)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, values := range c.ExtraHeaders {
+		req.Header[key] = append([]string(nil), values...)
 	}
 
 	// Set required headers
-	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", c.TokenID, c.Token))
+	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", c.TokenID, c.currentToken()))
 	req.Header.Set("Accept", "application/json")
-	if body != nil {
+	// Setting the header disables the transparent decompression of the
+	// transport, so gzip responses are decoded by readResponseBody
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("User-Agent", c.UserAgent)
+	if jsonBody != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	if c.Logger.DebugEnabled() {
+		c.Logger.Debugf("API Request headers: %v", redactHeaders(req.Header))
+	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
+		c.observeResponse(0)
+		return true, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer resp.Body.Close()
+	c.observeResponse(resp.StatusCode)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+		respBody, _ := readResponseBody(resp)
+		return false, &APIError{StatusCode: resp.StatusCode, Body: string(respBody), Status: resp.Status}
 	}
 
 	if result != nil {
-		respBody, err := io.ReadAll(resp.Body)
+		respBody, err := readResponseBody(resp)
 		if err != nil {
-			return fmt.Errorf("failed to read response body: %w", err)
+			return false, fmt.Errorf("failed to read response body: %w", err)
 		}
 
-		if c.LogLevel == LogLevelDebug {
-			log.Printf("API Response: %s", string(respBody))
+		if c.Logger.DebugEnabled() {
+			c.Logger.Debugf("API Response: %s", c.redact(truncateBody(respBody, c.LogBodyLimit)))
 		}
 
 		err = json.Unmarshal(respBody, result)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal response: %w", err)
+			return false, fmt.Errorf("failed to unmarshal response: %w", err)
 		}
 	}
 
-	return nil
+	return false, nil
+}
+
+// readResponseBody reads the body of a response, decompressing it when the
+// API sent it gzip-encoded
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return io.ReadAll(resp.Body)
+	}
+
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress response: %w", err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// redactHeaders returns a copy of the headers with credentials masked
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for key, values := range redacted {
+		for i, value := range values {
+			if strings.EqualFold(key, "Authorization") || strings.Contains(value, "PVEAPIToken") {
+				values[i] = redactedValue
+			}
+		}
+	}
+	return redacted
+}
+
+// redact masks the token secret wherever it appears in s
+func (c *ProxmoxClient) redact(s string) string {
+	token := c.currentToken()
+	if token == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, token, redactedValue)
+}
+
+// truncateBody shortens a body to limit bytes for logging, 0 meaning no limit
+func truncateBody(body []byte, limit int) string {
+	if limit <= 0 || len(body) <= limit {
+		return string(body)
+	}
+	return string(body[:limit]) + "... (truncated, " + strconv.Itoa(len(body)) + " bytes)"
+}
+
+func (c *ProxmoxClient) observeResponse(statusCode int) {
+	if c.ResponseObserver != nil {
+		c.ResponseObserver(statusCode)
+	}
 }
 
 // Get performs a GET request to the Proxmox API
@@ -142,6 +466,58 @@ func (c *ProxmoxClient) GetNodes(ctx context.Context) ([]NodeStatus, error) {
 	return response.Data, nil
 }
 
+// GetPools retrieves all resource pools of the cluster
+func (c *ProxmoxClient) GetPools(ctx context.Context) ([]Pool, error) {
+	var response struct {
+		Data []Pool `json:"data"`
+	}
+	err := c.Get(ctx, "/pools", &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetHAStatus retrieves the current status of the HA manager, including
+// one entry per HA resource
+func (c *ProxmoxClient) GetHAStatus(ctx context.Context) ([]HAStatus, error) {
+	var response struct {
+		Data []HAStatus `json:"data"`
+	}
+	err := c.Get(ctx, "/cluster/ha/status/current", &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetClusterStatus retrieves the status of the cluster, including one entry
+// per node
+func (c *ProxmoxClient) GetClusterStatus(ctx context.Context) ([]ClusterStatus, error) {
+	var response struct {
+		Data []ClusterStatus `json:"data"`
+	}
+	err := c.Get(ctx, "/cluster/status", &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetPoolMembers retrieves the guests and storages of a resource pool
+func (c *ProxmoxClient) GetPoolMembers(ctx context.Context, poolID string) ([]PoolMember, error) {
+	var response struct {
+		Data struct {
+			Members []PoolMember `json:"members"`
+		} `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/pools/%s", url.PathEscape(poolID)), &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data.Members, nil
+}
+
 // GetVirtualMachines retrieves all VMs on a node
 func (c *ProxmoxClient) GetVirtualMachines(ctx context.Context, nodeName string) ([]VirtualMachine, error) {
 	var response struct {
@@ -169,27 +545,65 @@ func (c *ProxmoxClient) GetContainers(ctx context.Context, nodeName string) ([]C
 // GetVMConfig retrieves the configuration of a VM
 func (c *ProxmoxClient) GetVMConfig(ctx context.Context, nodeName string, vmID uint64) (*ParsedConfig, error) {
 	var response struct {
-		Data ParsedConfig `json:"data"`
+		Data map[string]interface{} `json:"data"`
 	}
 	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", nodeName, vmID), &response)
 	if err != nil {
 		return nil, err
 	}
-	return &response.Data, nil
+	return NewParsedConfig(response.Data), nil
 }
 
 // GetContainerConfig retrieves the configuration of a container
 func (c *ProxmoxClient) GetContainerConfig(ctx context.Context, nodeName string, vmID uint64) (*ParsedConfig, error) {
 	var response struct {
-		Data ParsedConfig `json:"data"`
+		Data map[string]interface{} `json:"data"`
 	}
 	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/lxc/%d/config", nodeName, vmID), &response)
 	if err != nil {
 		return nil, err
 	}
+	return NewParsedConfig(response.Data), nil
+}
+
+// GetVMCloudInitUserData retrieves the cloud-init user-data of a VM, as
+// generated from its config or read from its cicustom snippet
+func (c *ProxmoxClient) GetVMCloudInitUserData(ctx context.Context, nodeName string, vmID uint64) (string, error) {
+	var response struct {
+		Data string `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/cloudinit/dump?type=user", nodeName, vmID), &response)
+	if err != nil {
+		return "", err
+	}
+	return response.Data, nil
+}
+
+// GetGuestStatus retrieves the current resource usage of a guest, guestType
+// being "qemu" for VMs or "lxc" for containers
+func (c *ProxmoxClient) GetGuestStatus(ctx context.Context, nodeName, guestType string, vmID uint64) (*GuestStatus, error) {
+	var response struct {
+		Data GuestStatus `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/%s/%d/status/current", nodeName, guestType, vmID), &response)
+	if err != nil {
+		return nil, err
+	}
 	return &response.Data, nil
 }
 
+// GetContainerNetworkInterfaces retrieves the network interfaces of a running container
+func (c *ProxmoxClient) GetContainerNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) ([]ContainerInterface, error) {
+	var response struct {
+		Data []ContainerInterface `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/lxc/%d/interfaces", nodeName, vmID), &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
 // GetVMNetworkInterfaces retrieves network interfaces from a VM using the QEMU guest agent
 func (c *ProxmoxClient) GetVMNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*ParsedAgentInterfaces, error) {
 	var response struct {
@@ -0,0 +1,482 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxmoxClient_APIError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		authError  bool
+	}{
+		{name: "Unauthorized", statusCode: http.StatusUnauthorized, authError: true},
+		{name: "Forbidden", statusCode: http.StatusForbidden, authError: true},
+		{name: "Server error", statusCode: http.StatusInternalServerError, authError: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"data":null,"message":"denied"}`, tt.statusCode)
+			}))
+			defer server.Close()
+
+			client := NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, LogLevelInfo)
+			_, err := client.GetVersion(context.Background())
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected an APIError, got %v", err)
+			}
+			if apiErr.StatusCode != tt.statusCode {
+				t.Errorf("Expected status code %d, got %d", tt.statusCode, apiErr.StatusCode)
+			}
+			if apiErr.Body == "" {
+				t.Error("Expected the response body to be kept")
+			}
+			if apiErr.IsAuthError() != tt.authError {
+				t.Errorf("Expected IsAuthError() to be %v", tt.authError)
+			}
+		})
+	}
+}
+
+func TestAPIError_IsGuestAgentNotRunning(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		expected   bool
+	}{
+		{name: "Agent not running", statusCode: http.StatusInternalServerError, body: `{"data":null,"message":"QEMU guest agent is not running\n"}`, expected: true},
+		{name: "Agent not configured", statusCode: http.StatusInternalServerError, body: `{"data":null,"message":"No QEMU guest agent configured\n"}`, expected: true},
+		{name: "Other server error", statusCode: http.StatusInternalServerError, body: `{"data":null,"message":"got timeout\n"}`, expected: false},
+		{name: "Forbidden", statusCode: http.StatusForbidden, body: `{"data":null,"message":"QEMU guest agent is not running\n"}`, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, LogLevelError)
+			_, err := client.GetVMNetworkInterfaces(context.Background(), "pve1", 100)
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected an APIError, got %v", err)
+			}
+			if apiErr.IsGuestAgentNotRunning() != tt.expected {
+				t.Errorf("Expected IsGuestAgentNotRunning() to be %v for %s", tt.expected, tt.body)
+			}
+		})
+	}
+
+	// Proxmox may only report the reason in the status line
+	apiErr := &APIError{StatusCode: http.StatusInternalServerError, Status: "500 QEMU guest agent is not running", Body: `{"data":null}`}
+	if !apiErr.IsGuestAgentNotRunning() {
+		t.Error("Expected the status line to be matched")
+	}
+}
+
+func TestNewProxmoxClient_BaseURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected string
+	}{
+		{endpoint: "https://proxmox.example.com:8006", expected: "https://proxmox.example.com:8006/api2/json"},
+		{endpoint: "https://proxmox.example.com:8006/", expected: "https://proxmox.example.com:8006/api2/json"},
+		{endpoint: "https://example.com/proxmox/", expected: "https://example.com/proxmox/api2/json"},
+	}
+
+	for _, tt := range tests {
+		client := NewProxmoxClient(tt.endpoint, "test@pam!test", "test-token", true, LogLevelInfo)
+		if client.BaseURL != tt.expected {
+			t.Errorf("Expected base URL %s for endpoint %s, got %s", tt.expected, tt.endpoint, client.BaseURL)
+		}
+	}
+}
+
+func TestProxmoxClient_SetBasePath(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"data":{"release":"8.1"}}`))
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL+"/proxmox", "test@pam!test", "test-token", true, LogLevelError)
+	if err := client.SetBasePath("api/json/"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.BaseURL != server.URL+"/proxmox/api/json" {
+		t.Fatalf("Expected the base path below the sub-path, got %s", client.BaseURL)
+	}
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != "/proxmox/api/json/version" {
+		t.Errorf("Expected a request to /proxmox/api/json/version, got %s", path)
+	}
+
+	if err := client.SetBasePath("/api2/json?debug=1"); err == nil {
+		t.Error("Expected an error for a base path with a query")
+	}
+
+	multi := NewProxmoxClient("https://pve1.example.com:8006, https://pve2.example.com:8006", "test@pam!test", "test-token", true, LogLevelError)
+	if err := multi.SetBasePath("/pve/api2/json"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(multi.BaseURLs) != 2 || multi.BaseURLs[1] != "https://pve2.example.com:8006/pve/api2/json" {
+		t.Errorf("Expected the base path on every endpoint, got %v", multi.BaseURLs)
+	}
+}
+
+func TestProxmoxClient_EndpointFailover(t *testing.T) {
+	var requests int
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"data":{"release":"8.1"}}`))
+	}))
+	defer live.Close()
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadURL := dead.URL
+	dead.Close()
+
+	client := NewProxmoxClient(deadURL+", "+live.URL+"/", "test@pam!test", "test-token", true, LogLevelError)
+	if client.BaseURL != deadURL+"/api2/json" {
+		t.Fatalf("Expected base URL of the first endpoint, got %s", client.BaseURL)
+	}
+	if len(client.BaseURLs) != 2 {
+		t.Fatalf("Expected 2 base URLs, got %v", client.BaseURLs)
+	}
+
+	version, err := client.GetVersion(context.Background())
+	if err != nil {
+		t.Fatalf("Expected failover to the second endpoint, got %v", err)
+	}
+	if version.Release != "8.1" {
+		t.Errorf("Expected release 8.1, got %s", version.Release)
+	}
+
+	candidates := client.candidateURLs(time.Now())
+	if candidates[0] != live.URL+"/api2/json" {
+		t.Errorf("Expected the failed endpoint to be skipped during its cooldown, got %v", candidates)
+	}
+	candidates = client.candidateURLs(time.Now().Add(DefaultEndpointCooldown + time.Second))
+	if candidates[0] != deadURL+"/api2/json" {
+		t.Errorf("Expected the failed endpoint to be retried after its cooldown, got %v", candidates)
+	}
+
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests to the live endpoint, got %d", requests)
+	}
+}
+
+func TestProxmoxClient_EndpointFailoverAPIError(t *testing.T) {
+	var secondCalled bool
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondCalled = true
+	}))
+	defer second.Close()
+
+	client := NewProxmoxClient(first.URL+","+second.URL, "test@pam!test", "test-token", true, LogLevelError)
+	_, err := client.GetVersion(context.Background())
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.IsAuthError() {
+		t.Fatalf("Expected auth error, got %v", err)
+	}
+	if secondCalled {
+		t.Error("Expected API errors not to fail over")
+	}
+}
+
+func TestProxmoxClient_EndpointFailoverCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-release
+	}))
+	defer first.Close()
+	defer close(release)
+	var secondCalled bool
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondCalled = true
+	}))
+	defer second.Close()
+
+	client := NewProxmoxClient(first.URL+","+second.URL, "test@pam!test", "test-token", true, LogLevelError)
+	if _, err := client.GetVersion(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancellation error, got %v", err)
+	}
+	if secondCalled {
+		t.Error("Expected a cancelled request not to fail over")
+	}
+
+	candidates := client.candidateURLs(time.Now())
+	if candidates[0] != first.URL+"/api2/json" {
+		t.Errorf("Expected the endpoint to stay healthy after a cancelled request, got %v", candidates)
+	}
+}
+
+func TestProxmoxClient_UserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(`{"data":{"release":"8.1"}}`))
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, LogLevelInfo)
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+	if userAgent != "traefik-proxmox-provider/"+BuildVersion {
+		t.Errorf("Expected default User-Agent, got %q", userAgent)
+	}
+
+	client.UserAgent = "custom-agent/1.0"
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+	if userAgent != "custom-agent/1.0" {
+		t.Errorf("Expected overridden User-Agent, got %q", userAgent)
+	}
+}
+
+func TestProxmoxClient_SetProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte(`{"data":{"release":"8.1"}}`))
+	}))
+	defer proxy.Close()
+
+	client := NewProxmoxClient("http://pve.invalid:8006", "test@pam!test", "test-token", true, LogLevelInfo)
+	if err := client.SetProxy(proxy.URL); err != nil {
+		t.Fatalf("SetProxy() error = %v", err)
+	}
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+	if proxied != "http://pve.invalid:8006/api2/json/version" {
+		t.Errorf("Expected the request to go through the proxy, got %q", proxied)
+	}
+
+	for _, invalid := range []string{"proxy:3128", "://bad"} {
+		if err := client.SetProxy(invalid); err == nil {
+			t.Errorf("Expected an error for proxy URL %q", invalid)
+		}
+	}
+}
+
+func TestProxmoxClient_ExtraHeaders(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Write([]byte(`{"data":{"release":"8.1"}}`))
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, LogLevelInfo)
+	err := client.SetExtraHeaders(map[string]string{
+		"CF-Access-Client-Id":     "client-id",
+		"CF-Access-Client-Secret": "client-secret",
+	})
+	if err != nil {
+		t.Fatalf("SetExtraHeaders() error = %v", err)
+	}
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+	if header.Get("CF-Access-Client-Id") != "client-id" || header.Get("CF-Access-Client-Secret") != "client-secret" {
+		t.Errorf("Expected the extra headers to be attached, got %v", header)
+	}
+	if header.Get("Authorization") != "PVEAPIToken=test@pam!test=test-token" || header.Get("Accept") != "application/json" {
+		t.Errorf("Expected the mandatory headers to be kept, got %v", header)
+	}
+
+	// Headers set on the field directly still cannot replace the mandatory ones
+	client.ExtraHeaders.Set("Authorization", "Bearer other")
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+	if values := header.Values("Authorization"); len(values) != 1 || values[0] != "PVEAPIToken=test@pam!test=test-token" {
+		t.Errorf("Expected the token to win over an extra Authorization header, got %v", values)
+	}
+
+	for _, reserved := range []string{"authorization", "Accept", "User-Agent"} {
+		if err := client.SetExtraHeaders(map[string]string{reserved: "x"}); err == nil {
+			t.Errorf("Expected an error when overriding %s", reserved)
+		}
+	}
+}
+
+func TestProxmoxClient_GzipResponse(t *testing.T) {
+	var acceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.URL.Path == "/api2/json/nodes" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		gz := gzip.NewWriter(w)
+		if r.URL.Path == "/api2/json/nodes" {
+			gz.Write([]byte("cluster not ready"))
+		} else {
+			gz.Write([]byte(`{"data":{"release":"8.1"}}`))
+		}
+		gz.Close()
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, LogLevelError)
+	version, err := client.GetVersion(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if version.Release != "8.1" {
+		t.Errorf("Expected the gzip response to be decoded, got release %q", version.Release)
+	}
+	if acceptEncoding != "gzip" {
+		t.Errorf("Expected Accept-Encoding gzip, got %q", acceptEncoding)
+	}
+
+	_, err = client.GetNodes(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Body != "cluster not ready" {
+		t.Errorf("Expected an API error with the decoded body, got %v", err)
+	}
+}
+
+func TestProxmoxClient_SetConnectionPool(t *testing.T) {
+	client := NewProxmoxClient("https://proxmox.example.com:8006", "test@pam!test", "test-token", true, LogLevelInfo)
+	transport := client.HTTPClient.Transport.(*http.Transport)
+	if transport.MaxIdleConns != DefaultMaxIdleConns || transport.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || transport.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("Expected default connection pool settings, got %d/%d/%s", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	if err := client.SetConnectionPool(50, 5, time.Minute); err != nil {
+		t.Fatalf("SetConnectionPool() error = %v", err)
+	}
+	if transport.MaxIdleConns != 50 {
+		t.Errorf("Expected MaxIdleConns 50, got %d", transport.MaxIdleConns)
+	}
+	if transport.MaxIdleConnsPerHost != 5 {
+		t.Errorf("Expected MaxIdleConnsPerHost 5, got %d", transport.MaxIdleConnsPerHost)
+	}
+	if transport.IdleConnTimeout != time.Minute {
+		t.Errorf("Expected IdleConnTimeout 1m, got %s", transport.IdleConnTimeout)
+	}
+
+	if err := client.SetConnectionPool(-1, 5, time.Minute); err == nil {
+		t.Error("Expected an error for a negative MaxIdleConns")
+	}
+}
+
+func TestProxmoxClient_GetGuestStatus(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"data":{"status":"running","cpu":0.25,"cpus":4,"mem":1073741824,"maxmem":4294967296}}`))
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, LogLevelInfo)
+	status, err := client.GetGuestStatus(context.Background(), "pve1", "lxc", 101)
+	if err != nil {
+		t.Fatalf("GetGuestStatus() error = %v", err)
+	}
+	if path != "/api2/json/nodes/pve1/lxc/101/status/current" {
+		t.Errorf("Unexpected request path %s", path)
+	}
+	if status.CPU != 0.25 || status.CPUs != 4 || status.Mem != 1073741824 || status.MaxMem != 4294967296 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestProxmoxClient_GetHAStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/json/cluster/ha/status/current" {
+			t.Errorf("Unexpected request path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"data":[{"id":"quorum","type":"quorum","status":"OK"},{"id":"service:ct:200","type":"service","sid":"ct:200","node":"pve2","state":"started"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, LogLevelInfo)
+	status, err := client.GetHAStatus(context.Background())
+	if err != nil {
+		t.Fatalf("GetHAStatus() error = %v", err)
+	}
+	if len(status) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", status)
+	}
+	if _, ok := status[0].VMID(); ok {
+		t.Error("Expected the quorum entry to have no VMID")
+	}
+	if vmID, ok := status[1].VMID(); !ok || vmID != 200 || status[1].Node != "pve2" || status[1].State != "started" {
+		t.Errorf("Unexpected service entry %+v", status[1])
+	}
+}
+
+func TestProxmoxClient_DebugLogRedaction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the credentials back to make sure response bodies are redacted too
+		w.Write([]byte(`{"data":{"release":"8.1","auth":"` + r.Header.Get("Authorization") + `"}}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	client := NewProxmoxClient(server.URL, "test@pam!test", "super-secret-token", true, LogLevelDebug)
+	client.Logger.logger = log.New(&buf, "", 0)
+
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+
+	output := buf.String()
+	if strings.Contains(output, "super-secret-token") {
+		t.Errorf("Expected the token to be redacted, got %q", output)
+	}
+	if !strings.Contains(output, "API Request headers") || !strings.Contains(output, redactedValue) {
+		t.Errorf("Expected redacted request headers to be logged, got %q", output)
+	}
+}
+
+func TestTruncateBody(t *testing.T) {
+	body := []byte(`{"data":"0123456789"}`)
+
+	if got := truncateBody(body, 0); got != string(body) {
+		t.Errorf("Expected no truncation without a limit, got %q", got)
+	}
+	if got := truncateBody(body, 100); got != string(body) {
+		t.Errorf("Expected no truncation below the limit, got %q", got)
+	}
+	if got := truncateBody(body, 8); got != `{"data":... (truncated, 21 bytes)` {
+		t.Errorf("Unexpected truncated body %q", got)
+	}
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEndpointCooldown is how long a failed API endpoint is skipped
+// before it is tried again
+const DefaultEndpointCooldown = 30 * time.Second
+
+// DefaultAPIBasePath is the path of the Proxmox API below an endpoint
+const DefaultAPIBasePath = "/api2/json"
+
+// SplitEndpoints splits a comma-separated list of API endpoints, dropping
+// empty entries
+func SplitEndpoints(apiEndpoint string) []string {
+	var endpoints []string
+	for _, endpoint := range strings.Split(apiEndpoint, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// apiBaseURL returns the API base URL of an endpoint
+func apiBaseURL(endpoint, basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return strings.TrimRight(endpoint, "/")
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimRight(endpoint, "/"), basePath)
+}
+
+// endpointHealth tracks API endpoints that recently failed
+type endpointHealth struct {
+	mu             sync.Mutex
+	unhealthyUntil map[string]time.Time
+}
+
+// markUnhealthy skips baseURL until now+cooldown
+func (h *endpointHealth) markUnhealthy(baseURL string, now time.Time, cooldown time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.unhealthyUntil == nil {
+		h.unhealthyUntil = make(map[string]time.Time)
+	}
+	h.unhealthyUntil[baseURL] = now.Add(cooldown)
+}
+
+// markHealthy clears the cooldown of baseURL
+func (h *endpointHealth) markHealthy(baseURL string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.unhealthyUntil, baseURL)
+}
+
+// order returns the base URLs to try: healthy ones first in configured
+// order, then the ones still in their cooldown as a last resort
+func (h *endpointHealth) order(baseURLs []string, now time.Time) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	healthy := make([]string, 0, len(baseURLs))
+	var cooling []string
+	for _, baseURL := range baseURLs {
+		if until, ok := h.unhealthyUntil[baseURL]; ok && now.Before(until) {
+			cooling = append(cooling, baseURL)
+			continue
+		}
+		healthy = append(healthy, baseURL)
+	}
+	return append(healthy, cooling...)
+}
+
+// candidateURLs returns the base URLs a request should try in order
+func (c *ProxmoxClient) candidateURLs(now time.Time) []string {
+	if len(c.BaseURLs) <= 1 {
+		return []string{c.BaseURL}
+	}
+	return c.health.order(c.BaseURLs, now)
+}
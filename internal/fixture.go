@@ -0,0 +1,338 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fixtureBaseURL is the base URL of clients answering from a fixture file
+const fixtureBaseURL = "http://fixture"
+
+// Fixture describes the state of a cluster for offline use
+type Fixture struct {
+	// Version is the Proxmox release reported by the fixture
+	Version string        `json:"version,omitempty"`
+	Nodes   []FixtureNode `json:"nodes"`
+}
+
+// FixtureNode is a node of a fixture with its guests
+type FixtureNode struct {
+	Name       string         `json:"name"`
+	VMs        []FixtureGuest `json:"vms,omitempty"`
+	Containers []FixtureGuest `json:"containers,omitempty"`
+	// Address is the node address reported by the cluster status, if any
+	Address string `json:"address,omitempty"`
+}
+
+// FixtureGuest is a VM or container of a fixture. Config holds the guest
+// config as returned by the API, including its description; IPs are the
+// addresses reported by the QEMU guest agent of a VM or listed on the
+// interfaces of a container.
+type FixtureGuest struct {
+	VMID   uint64 `json:"vmid"`
+	Name   string `json:"name"`
+	Status string `json:"status,omitempty"`
+	Tags   string `json:"tags,omitempty"`
+	Pool   string `json:"pool,omitempty"`
+	// HAState is the HA manager state of the guest on this node, such as
+	// "started" or "fence", empty for guests not managed by HA
+	HAState string                 `json:"haState,omitempty"`
+	CPU     float64                `json:"cpu,omitempty"`
+	Mem     uint64                 `json:"mem,omitempty"`
+	MaxMem  uint64                 `json:"maxmem,omitempty"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+	IPs     []IP                   `json:"ips,omitempty"`
+	// CloudInit is the cloud-init user-data of a VM
+	CloudInit string `json:"cloudInit,omitempty"`
+}
+
+// LoadFixture reads a fixture file
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %w", path, err)
+	}
+	for _, node := range fixture.Nodes {
+		if node.Name == "" {
+			return nil, fmt.Errorf("invalid fixture %s: every node needs a name", path)
+		}
+	}
+	return &fixture, nil
+}
+
+// NewFixtureClient creates a client answering API requests from a fixture
+// file instead of a Proxmox cluster. The file is read again at the start
+// of every poll, so it can be edited while the provider runs.
+func NewFixtureClient(path string, logLevel string) (*ProxmoxClient, error) {
+	transport := &fixtureTransport{path: path}
+	if err := transport.reload(); err != nil {
+		return nil, err
+	}
+
+	client := NewProxmoxClient(fixtureBaseURL, "fixture", "", true, logLevel)
+	client.HTTPClient.Transport = transport
+	return client, nil
+}
+
+// fixtureTransport serves the API endpoints used by the provider from a fixture
+type fixtureTransport struct {
+	path string
+
+	mu      sync.Mutex
+	fixture *Fixture
+}
+
+func (t *fixtureTransport) reload() error {
+	fixture, err := LoadFixture(t.path)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.fixture = fixture
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := strings.TrimPrefix(req.URL.Path, "/api2/json")
+	if path == "/nodes" {
+		// A poll starts by listing the nodes
+		if err := t.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	t.mu.Lock()
+	data, found := t.fixture.respond(strings.Split(strings.Trim(path, "/"), "/"))
+	t.mu.Unlock()
+
+	status := http.StatusOK
+	body, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return nil, err
+	}
+	statusLine := "200 OK"
+	switch {
+	case !found && strings.HasSuffix(path, "/agent/network-get-interfaces"):
+		// Answer like Proxmox does for a VM whose agent is not running
+		status = http.StatusInternalServerError
+		statusLine = "500 QEMU guest agent is not running"
+		body = []byte(`{"data":null}`)
+	case !found:
+		status = http.StatusNotFound
+		statusLine = "404 Not Found"
+		body = []byte(fmt.Sprintf("fixture has no data for %s", path))
+	}
+	return &http.Response{
+		Status:     statusLine,
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// respond returns the data of an API path split into its segments
+func (f *Fixture) respond(segments []string) (interface{}, bool) {
+	switch {
+	case len(segments) == 1 && segments[0] == "version":
+		version := f.Version
+		if version == "" {
+			version = "fixture"
+		}
+		return Version{Release: version}, true
+	case len(segments) == 1 && segments[0] == "nodes":
+		nodes := make([]NodeStatus, 0, len(f.Nodes))
+		for _, node := range f.Nodes {
+			nodes = append(nodes, NodeStatus{Node: node.Name})
+		}
+		return nodes, true
+	case strings.Join(segments, "/") == "cluster/ha/status/current":
+		return f.haStatus(), true
+	case strings.Join(segments, "/") == "cluster/status":
+		return f.clusterStatus(), true
+	case len(segments) == 1 && segments[0] == "pools":
+		return f.pools(), true
+	case len(segments) == 2 && segments[0] == "pools":
+		return map[string]interface{}{"members": f.poolMembers(segments[1])}, true
+	case len(segments) >= 3 && segments[0] == "nodes":
+		node := f.node(segments[1])
+		if node == nil {
+			return nil, false
+		}
+		var guests []FixtureGuest
+		switch segments[2] {
+		case "qemu":
+			guests = node.VMs
+		case "lxc":
+			guests = node.Containers
+		default:
+			return nil, false
+		}
+		if len(segments) == 3 {
+			return guestList(guests), true
+		}
+		return guestData(guests, segments[3:])
+	}
+	return nil, false
+}
+
+func (f *Fixture) node(name string) *FixtureNode {
+	for i := range f.Nodes {
+		if f.Nodes[i].Name == name {
+			return &f.Nodes[i]
+		}
+	}
+	return nil
+}
+
+func (f *Fixture) pools() []Pool {
+	seen := make(map[string]bool)
+	pools := make([]Pool, 0)
+	for _, node := range f.Nodes {
+		for _, guest := range append(append([]FixtureGuest{}, node.VMs...), node.Containers...) {
+			if guest.Pool != "" && !seen[guest.Pool] {
+				seen[guest.Pool] = true
+				pools = append(pools, Pool{PoolID: guest.Pool})
+			}
+		}
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].PoolID < pools[j].PoolID })
+	return pools
+}
+
+func (f *Fixture) haStatus() []HAStatus {
+	status := make([]HAStatus, 0)
+	add := func(node, prefix string, guests []FixtureGuest) {
+		for _, guest := range guests {
+			if guest.HAState != "" {
+				sid := fmt.Sprintf("%s:%d", prefix, guest.VMID)
+				status = append(status, HAStatus{ID: "service:" + sid, Type: "service", SID: sid, Node: node, State: guest.HAState})
+			}
+		}
+	}
+	for _, node := range f.Nodes {
+		add(node.Name, "vm", node.VMs)
+		add(node.Name, "ct", node.Containers)
+	}
+	return status
+}
+
+func (f *Fixture) clusterStatus() []ClusterStatus {
+	status := make([]ClusterStatus, 0, len(f.Nodes))
+	for _, node := range f.Nodes {
+		status = append(status, ClusterStatus{ID: "node/" + node.Name, Type: "node", Name: node.Name, IP: node.Address})
+	}
+	return status
+}
+
+func (f *Fixture) poolMembers(poolID string) []PoolMember {
+	members := make([]PoolMember, 0)
+	add := func(node, guestType string, guests []FixtureGuest) {
+		for _, guest := range guests {
+			if guest.Pool == poolID {
+				members = append(members, PoolMember{
+					ID:   fmt.Sprintf("%s/%d", guestType, guest.VMID),
+					Type: guestType,
+					VMID: guest.VMID,
+					Node: node,
+					Name: guest.Name,
+				})
+			}
+		}
+	}
+	for _, node := range f.Nodes {
+		add(node.Name, "qemu", node.VMs)
+		add(node.Name, "lxc", node.Containers)
+	}
+	return members
+}
+
+// guestList returns the list entries of guests like /nodes/{node}/qemu does
+func guestList(guests []FixtureGuest) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(guests))
+	for _, guest := range guests {
+		list = append(list, map[string]interface{}{
+			"vmid":   guest.VMID,
+			"name":   guest.Name,
+			"status": guest.statusOrDefault(),
+			"tags":   guest.Tags,
+			"cpu":    guest.CPU,
+			"mem":    guest.Mem,
+			"maxmem": guest.MaxMem,
+		})
+	}
+	return list
+}
+
+// guestData answers the config, status and guest agent requests of a guest
+func guestData(guests []FixtureGuest, segments []string) (interface{}, bool) {
+	vmID, err := strconv.ParseUint(segments[0], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	for _, guest := range guests {
+		if guest.VMID != vmID {
+			continue
+		}
+		switch strings.Join(segments[1:], "/") {
+		case "config":
+			if guest.Config == nil {
+				return map[string]interface{}{}, true
+			}
+			return guest.Config, true
+		case "cloudinit/dump":
+			return guest.CloudInit, true
+		case "status/current":
+			return GuestStatus{Status: guest.statusOrDefault(), CPU: guest.CPU, Mem: guest.Mem, MaxMem: guest.MaxMem}, true
+		case "interfaces":
+			// Interfaces of a container, one per address
+			interfaces := make([]ContainerInterface, 0, len(guest.IPs))
+			for _, ip := range guest.IPs {
+				iface := ContainerInterface{Name: ip.Interface}
+				if iface.Name == "" {
+					iface.Name = "eth0"
+				}
+				address := ip.Address
+				if ip.Prefix > 0 {
+					address = fmt.Sprintf("%s/%d", ip.Address, ip.Prefix)
+				}
+				if ip.AddressType == "ipv6" {
+					iface.Inet6 = address
+				} else {
+					iface.Inet = address
+				}
+				interfaces = append(interfaces, iface)
+			}
+			return interfaces, true
+		case "agent/network-get-interfaces":
+			// Guests without addresses behave like guests without an agent
+			if len(guest.IPs) == 0 {
+				return nil, false
+			}
+			return map[string]interface{}{
+				"result": []map[string]interface{}{{"ip-addresses": guest.IPs}},
+			}, true
+		}
+	}
+	return nil, false
+}
+
+func (g FixtureGuest) statusOrDefault() string {
+	if g.Status == "" {
+		return StatusRunning
+	}
+	return g.Status
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFixture(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+	return path
+}
+
+func TestFixtureClient(t *testing.T) {
+	path := writeFixture(t, `{
+		"nodes": [{
+			"name": "pve1",
+			"vms": [{"vmid": 100, "name": "web", "pool": "prod", "cpu": 0.5, "config": {"description": "traefik.enable=true", "cores": 2}, "ips": [{"ip-address": "10.0.0.5", "ip-address-type": "ipv4"}]}],
+			"containers": [{"vmid": 200, "name": "db", "status": "stopped"}, {"vmid": 201, "name": "cache", "ips": [{"ip-address": "10.0.0.6", "ip-address-type": "ipv4", "prefix": 24}]}]
+		}]
+	}`)
+
+	client, err := NewFixtureClient(path, LogLevelError)
+	if err != nil {
+		t.Fatalf("NewFixtureClient() error = %v", err)
+	}
+	ctx := context.Background()
+
+	version, err := client.GetVersion(ctx)
+	if err != nil || version.Release != "fixture" {
+		t.Errorf("Expected the fixture version, got %v, %v", version, err)
+	}
+	nodes, err := client.GetNodes(ctx)
+	if err != nil || len(nodes) != 1 || nodes[0].Node != "pve1" {
+		t.Fatalf("Expected node pve1, got %v, %v", nodes, err)
+	}
+	vms, err := client.GetVirtualMachines(ctx, "pve1")
+	if err != nil || len(vms) != 1 || vms[0].Status != StatusRunning || vms[0].CPU != 0.5 {
+		t.Errorf("Expected a running VM, got %+v, %v", vms, err)
+	}
+	cts, err := client.GetContainers(ctx, "pve1")
+	if err != nil || len(cts) != 2 || cts[0].Status != "stopped" {
+		t.Errorf("Expected a stopped container, got %+v, %v", cts, err)
+	}
+	config, err := client.GetVMConfig(ctx, "pve1", 100)
+	if err != nil || config.Description != "traefik.enable=true" || config.Values["cores"] != "2" {
+		t.Errorf("Expected the VM config, got %+v, %v", config, err)
+	}
+	interfaces, err := client.GetVMNetworkInterfaces(ctx, "pve1", 100)
+	if err != nil || len(interfaces.GetIPs()) != 1 {
+		t.Errorf("Expected the agent addresses, got %+v, %v", interfaces, err)
+	}
+	ctInterfaces, err := client.GetContainerNetworkInterfaces(ctx, "pve1", 201)
+	if ips := GetContainerIPs(ctInterfaces); err != nil || len(ips) != 1 || ips[0].Address != "10.0.0.6" || ips[0].Prefix != 24 {
+		t.Errorf("Expected the container addresses, got %+v, %v", ctInterfaces, err)
+	}
+	members, err := client.GetPoolMembers(ctx, "prod")
+	if err != nil || len(members) != 1 || members[0].VMID != 100 || members[0].Node != "pve1" {
+		t.Errorf("Expected VM 100 in pool prod, got %+v, %v", members, err)
+	}
+
+	var apiErr *APIError
+	if _, err := client.GetVMNetworkInterfaces(ctx, "pve1", 200); !errors.As(err, &apiErr) || !apiErr.IsGuestAgentNotRunning() {
+		t.Errorf("Expected a guest agent not running error for a guest without agent addresses, got %v", err)
+	}
+	if _, err := client.GetVirtualMachines(ctx, "pve9"); !errors.As(err, &apiErr) {
+		t.Errorf("Expected an API error for an unknown node, got %v", err)
+	}
+}
+
+func TestFixtureClientReload(t *testing.T) {
+	path := writeFixture(t, `{"nodes": [{"name": "pve1"}]}`)
+	client, err := NewFixtureClient(path, LogLevelError)
+	if err != nil {
+		t.Fatalf("NewFixtureClient() error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"nodes": [{"name": "pve1"}, {"name": "pve2"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := client.GetNodes(context.Background())
+	if err != nil || len(nodes) != 2 {
+		t.Errorf("Expected the edited fixture to be read on the next poll, got %v, %v", nodes, err)
+	}
+
+	if _, err := NewFixtureClient(writeFixture(t, `{"nodes": [{}]}`), LogLevelError); err == nil {
+		t.Error("Expected an error for a node without a name")
+	}
+	if _, err := NewFixtureClient(filepath.Join(t.TempDir(), "missing.json"), LogLevelError); err == nil {
+		t.Error("Expected an error for a missing fixture")
+	}
+}
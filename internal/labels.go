@@ -0,0 +1,292 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Markers delimiting a structured label block inside a guest description
+const (
+	blockStartMarker = "### traefik-config"
+	blockEndMarker   = "###"
+	fenceMarker      = "```"
+)
+
+// CloudInitLabelKey is the top-level key of the cloud-init user-data
+// listing the labels of a VM
+const CloudInitLabelKey = "traefik_labels"
+
+// GetCloudInitLabels extracts the labels listed under the traefik_labels key
+// of the cloud-init user-data, given either as a block scalar of label lines
+// or as a list of labels. Each label is split like a description line.
+func (pc *ParsedConfig) GetCloudInitLabels(prefix, separator string) map[string]string {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	if separator == "" {
+		separator = DefaultLabelSeparator
+	}
+
+	labels := make([]string, 0)
+	inSection := false
+	for _, raw := range strings.Split(pc.CloudInitUserData, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		trimmed := strings.TrimSpace(line)
+		if !inSection {
+			key, _, found := strings.Cut(line, ":")
+			inSection = found && key == CloudInitLabelKey
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// The section ends with the next top-level key
+		if line == trimmed && !strings.HasPrefix(trimmed, "-") {
+			break
+		}
+		if strings.HasPrefix(trimmed, "- ") {
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "- "))
+		}
+		labels = append(labels, unquoteYAML(trimmed))
+	}
+
+	section := ParsedConfig{Description: strings.Join(labels, "\n")}
+	return section.getLineLabels(prefix, separator)
+}
+
+// extractLabelBlock looks for a structured label block in the description.
+// It supports a "### traefik-config" ... "###" section as well as ```yaml,
+// ```yml and ```json fences. The returned format is either "yaml" or "json".
+func extractLabelBlock(description string) (content string, format string, found bool) {
+	lines := strings.Split(description, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		var end string
+		switch {
+		case strings.EqualFold(trimmed, blockStartMarker):
+			end = blockEndMarker
+			format = "yaml"
+		case strings.HasPrefix(trimmed, fenceMarker):
+			switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(trimmed, fenceMarker))) {
+			case "yaml", "yml":
+				format = "yaml"
+			case "json":
+				format = "json"
+			default:
+				continue
+			}
+			end = fenceMarker
+		default:
+			continue
+		}
+
+		body := make([]string, 0)
+		for _, blockLine := range lines[i+1:] {
+			if strings.TrimSpace(blockLine) == end {
+				break
+			}
+			body = append(body, strings.TrimRight(blockLine, "\r"))
+		}
+
+		content = strings.Join(body, "\n")
+		if format == "yaml" && strings.HasPrefix(strings.TrimSpace(content), "{") {
+			format = "json"
+		}
+		return content, format, true
+	}
+	return "", "", false
+}
+
+// LabelBlockIssue is a key of a structured label block that does not have
+// the shape of a Traefik label
+type LabelBlockIssue struct {
+	// Line is the line of the key within the block, 0 when unknown
+	Line    int
+	Key     string
+	Message string
+}
+
+func (i LabelBlockIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("line %d: key %s: %s", i.Line, i.Key, i.Message)
+	}
+	return fmt.Sprintf("key %s: %s", i.Key, i.Message)
+}
+
+// LabelBlockError lists the issues of a label block that parses but does
+// not have the shape of Traefik labels, typically after an indentation mistake
+type LabelBlockError struct {
+	Issues []LabelBlockIssue
+}
+
+func (e *LabelBlockError) Error() string {
+	issues := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		issues = append(issues, issue.String())
+	}
+	return "invalid label block: " + strings.Join(issues, "; ")
+}
+
+// labelBlock is a structured label block flattened into dotted keys
+type labelBlock struct {
+	labels map[string]string
+	// lines holds the line of each key within the block, when known
+	lines map[string]int
+	// empty holds the keys with neither a value nor nested keys
+	empty []string
+}
+
+func newLabelBlock() *labelBlock {
+	return &labelBlock{labels: make(map[string]string), lines: make(map[string]int), empty: make([]string, 0)}
+}
+
+// parseLabelBlock flattens a structured label block into dotted keys
+func parseLabelBlock(content, format string) (*labelBlock, error) {
+	if format == "json" {
+		return parseJSONLabels(content)
+	}
+	return parseYAMLLabels(content)
+}
+
+// ValidateLabelBlock checks the shape of the structured label block of the
+// description, if any. Every key must carry the prefix and have a value,
+// and checkKey, given a key without the prefix, describes what is wrong with
+// it, or returns "" for a valid key. Blocks that do not parse are left to
+// GetTraefikMap, which reports them.
+func (pc *ParsedConfig) ValidateLabelBlock(prefix string, checkKey func(key string) string) error {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	content, format, found := extractLabelBlock(pc.Description)
+	if !found {
+		return nil
+	}
+	block, err := parseLabelBlock(content, format)
+	if err != nil {
+		return nil
+	}
+
+	issues := make([]LabelBlockIssue, 0)
+	for _, key := range block.empty {
+		issues = append(issues, LabelBlockIssue{Line: block.lines[key], Key: key, Message: "has no value"})
+	}
+	for key := range block.labels {
+		message := ""
+		if !strings.HasPrefix(key, prefix) {
+			message = fmt.Sprintf("is outside %s and ignored, check its indentation", strings.TrimSuffix(prefix, "."))
+		} else if checkKey != nil {
+			message = checkKey(strings.TrimPrefix(key, prefix))
+		}
+		if message != "" {
+			issues = append(issues, LabelBlockIssue{Line: block.lines[key], Key: key, Message: message})
+		}
+	}
+	if len(issues) == 0 {
+		return nil
+	}
+
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Key < issues[j].Key
+	})
+	return &LabelBlockError{Issues: issues}
+}
+
+func parseJSONLabels(content string) (*labelBlock, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return nil, fmt.Errorf("invalid JSON label block: %w", err)
+	}
+
+	block := newLabelBlock()
+	flattenJSON(block, "", data)
+	return block, nil
+}
+
+func flattenJSON(block *labelBlock, path string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		// A YAML key without a value, or a JSON null
+		block.empty = append(block.empty, path)
+	case map[string]interface{}:
+		if len(v) == 0 && path != "" {
+			block.empty = append(block.empty, path)
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flattenJSON(block, joinLabelPath(path, k), v[k])
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, jsonScalarToString(item))
+		}
+		block.labels[path] = strings.Join(items, ",")
+	default:
+		block.labels[path] = jsonScalarToString(v)
+	}
+}
+
+func jsonScalarToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// parseYAMLLabels parses a YAML label block with the parser of ParseYAML,
+// keeping scalars as written. Nested keys are joined with dots and lists
+// with commas, matching the line-based label format.
+func parseYAMLLabels(content string) (*labelBlock, error) {
+	p := newYAMLParser(content)
+	p.rawScalars = true
+	p.keyLines = make(map[string]int)
+	document, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML label block: %w", err)
+	}
+	data, ok := document.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid YAML label block: expected a mapping of keys")
+	}
+
+	block := newLabelBlock()
+	block.lines = p.keyLines
+	flattenJSON(block, "", data)
+	return block, nil
+}
+
+func unquoteYAML(s string) string {
+	if len(s) >= 2 {
+		if (s[0] == '"' && s[len(s)-1] == '"') || (s[0] == '\'' && s[len(s)-1] == '\'') {
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+func joinLabelPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
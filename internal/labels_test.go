@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParsedConfig_GetTraefikMapYAMLBlock(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "My application server\n" +
+			"traefik.http.routers.ignored.rule=Host(`ignored`)\n" +
+			"### traefik-config\n" +
+			"traefik:\n" +
+			"  enable: true\n" +
+			"  http:\n" +
+			"    routers:\n" +
+			"      app:\n" +
+			"        rule: \"Host(`app.example.com`)\"\n" +
+			"        entrypoints: [web, websecure]\n" +
+			"        middlewares:\n" +
+			"          - auth@file\n" +
+			"          - compression\n" +
+			"    services:\n" +
+			"      app:\n" +
+			"        loadbalancer:\n" +
+			"          server:\n" +
+			"            port: 8080 # the web server\n" +
+			"      legacy:\n" +
+			"        loadbalancer:\n" +
+			"          server:\n" +
+			"            port: 08080\n" +
+			"###\n" +
+			"Some trailing notes",
+	}
+
+	m, err := pc.GetTraefikMap(DefaultLabelPrefix, DefaultLabelSeparator)
+	if err != nil {
+		t.Fatalf("GetTraefikMap() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"traefik.enable":                                     "true",
+		"traefik.http.routers.app.rule":                      "Host(`app.example.com`)",
+		"traefik.http.routers.app.entrypoints":               "web,websecure",
+		"traefik.http.routers.app.middlewares":               "auth@file,compression",
+		"traefik.http.services.app.loadbalancer.server.port": "8080",
+		// Scalars are kept as written
+		"traefik.http.services.legacy.loadbalancer.server.port": "08080",
+	}
+	if len(m) != len(expected) {
+		t.Errorf("Expected %d config items, got %d (%v)", len(expected), len(m), m)
+	}
+	for key, value := range expected {
+		if m[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, m[key])
+		}
+	}
+}
+
+func TestParsedConfig_GetTraefikMapJSONFence(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "```json\n" +
+			`{"traefik": {"enable": true, "http": {"routers": {"app": {"rule": "Host(` + "`app`" + `)", "priority": 10}}}}}` + "\n" +
+			"```",
+	}
+
+	m, err := pc.GetTraefikMap(DefaultLabelPrefix, DefaultLabelSeparator)
+	if err != nil {
+		t.Fatalf("GetTraefikMap() error = %v", err)
+	}
+
+	if m["traefik.enable"] != "true" {
+		t.Errorf("Expected traefik.enable=true, got %q", m["traefik.enable"])
+	}
+	if m["traefik.http.routers.app.rule"] != "Host(`app`)" {
+		t.Errorf("Expected correct router rule, got %q", m["traefik.http.routers.app.rule"])
+	}
+	if m["traefik.http.routers.app.priority"] != "10" {
+		t.Errorf("Expected priority 10, got %q", m["traefik.http.routers.app.priority"])
+	}
+}
+
+func TestParsedConfig_GetTraefikMapInvalidBlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+	}{
+		{
+			name:        "Invalid JSON",
+			description: "traefik.enable=true\n```json\n{\"traefik\": \n```",
+		},
+		{
+			name:        "Invalid YAML",
+			description: "traefik.enable=true\n```yaml\ntraefik:\n  not a mapping\n```",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := ParsedConfig{Description: tt.description}
+			m, err := pc.GetTraefikMap(DefaultLabelPrefix, DefaultLabelSeparator)
+			if err == nil {
+				t.Fatal("Expected an error for a malformed label block")
+			}
+			if m["traefik.enable"] != "true" {
+				t.Errorf("Expected line-based labels as fallback, got %v", m)
+			}
+		})
+	}
+}
+
+func TestParsedConfig_GetCloudInitLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		userData string
+		expected map[string]string
+	}{
+		{
+			name: "List",
+			userData: "#cloud-config\nhostname: app\ntraefik_labels:\n" +
+				"  - traefik.enable=true\n" +
+				"  - \"traefik.http.routers.app.rule=Host(`app.example.com`)\"\n" +
+				"packages:\n  - nginx\n",
+			expected: map[string]string{
+				"traefik.enable":                "true",
+				"traefik.http.routers.app.rule": "Host(`app.example.com`)",
+			},
+		},
+		{
+			name:     "Block scalar",
+			userData: "#cloud-config\ntraefik_labels: |\n  traefik.enable=true\n  # comment\n  traefik.http.services.app.loadbalancer.server.port=8080\nusers: []\n",
+			expected: map[string]string{
+				"traefik.enable": "true",
+				"traefik.http.services.app.loadbalancer.server.port": "8080",
+			},
+		},
+		{
+			name:     "Unindented list",
+			userData: "traefik_labels:\n- traefik.enable=true\nruncmd: []\n",
+			expected: map[string]string{"traefik.enable": "true"},
+		},
+		{
+			name:     "No label key",
+			userData: "#cloud-config\nwrite_files:\n  - content: traefik.enable=true\n",
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := ParsedConfig{CloudInitUserData: tt.userData}
+			labels := pc.GetCloudInitLabels(DefaultLabelPrefix, DefaultLabelSeparator)
+			if len(labels) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, labels)
+			}
+			for key, value := range tt.expected {
+				if labels[key] != value {
+					t.Errorf("Expected %s=%s, got %q", key, value, labels[key])
+				}
+			}
+		})
+	}
+}
+
+func TestParsedConfig_ValidateLabelBlock(t *testing.T) {
+	valid := ParsedConfig{
+		Description: "```yaml\n" +
+			"traefik:\n" +
+			"  enable: true\n" +
+			"  http:\n" +
+			"    routers:\n" +
+			"      app:\n" +
+			"        rule: \"Host(`app.example.com`)\"\n" +
+			"        middlewares:\n" +
+			"          - auth@file\n" +
+			"```",
+	}
+	if err := valid.ValidateLabelBlock(DefaultLabelPrefix, nil); err != nil {
+		t.Errorf("Expected a valid block to pass, got %v", err)
+	}
+
+	// The http section lost its indentation and the router its options
+	malformed := ParsedConfig{
+		Description: "```yaml\n" +
+			"traefik:\n" +
+			"  enable: true\n" +
+			"  http:\n" +
+			"    routers:\n" +
+			"      app:\n" +
+			"      rule: \"Host(`app.example.com`)\"\n" +
+			"http:\n" +
+			"  services:\n" +
+			"    app:\n" +
+			"      loadbalancer:\n" +
+			"        server:\n" +
+			"          port: 8080\n" +
+			"```",
+	}
+	checkKey := func(key string) string {
+		if key == "http.routers.rule" {
+			return "expected http.routers.<name>.<option>"
+		}
+		return ""
+	}
+	err := malformed.ValidateLabelBlock(DefaultLabelPrefix, checkKey)
+	var blockErr *LabelBlockError
+	if !errors.As(err, &blockErr) {
+		t.Fatalf("Expected a LabelBlockError, got %v", err)
+	}
+	expected := []LabelBlockIssue{
+		{Line: 5, Key: "traefik.http.routers.app", Message: "has no value"},
+		{Line: 6, Key: "traefik.http.routers.rule", Message: "expected http.routers.<name>.<option>"},
+		{Line: 12, Key: "http.services.app.loadbalancer.server.port", Message: "is outside traefik and ignored, check its indentation"},
+	}
+	if len(blockErr.Issues) != len(expected) {
+		t.Fatalf("Expected %d issues, got %v", len(expected), blockErr.Issues)
+	}
+	for i, issue := range blockErr.Issues {
+		if issue != expected[i] {
+			t.Errorf("Expected issue %d to be %+v, got %+v", i, expected[i], issue)
+		}
+	}
+	if !strings.Contains(err.Error(), "line 5: key traefik.http.routers.app: has no value") {
+		t.Errorf("Expected the error to name the line and key, got %q", err.Error())
+	}
+
+	// The labels of the block are still returned
+	m, err := malformed.GetTraefikMap(DefaultLabelPrefix, DefaultLabelSeparator)
+	if err != nil || m["traefik.enable"] != "true" {
+		t.Errorf("Expected the labels of the block, got %v (%v)", m, err)
+	}
+
+	jsonBlock := ParsedConfig{Description: "```json\n{\"traefik\": {\"enable\": true, \"http\": {\"routers\": {\"app\": {}}}}}\n```"}
+	if err := jsonBlock.ValidateLabelBlock(DefaultLabelPrefix, nil); err == nil || !strings.Contains(err.Error(), "key traefik.http.routers.app: has no value") {
+		t.Errorf("Expected an empty JSON object to be reported, got %v", err)
+	}
+}
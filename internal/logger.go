@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Logger is a minimal leveled logger honoring the configured log level
+type Logger struct {
+	level  int
+	logger *log.Logger
+	// json writes every line as an object instead of free text
+	json bool
+	// fields are the contextual fields added by With, written in JSON only
+	fields []logField
+	// secrets are masked in messages and field values, shared with the
+	// loggers derived with With
+	secrets *logSecrets
+}
+
+// logSecrets guards the secrets, which Redact may add while other
+// goroutines are logging
+type logSecrets struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// Severity of each log level, lower is more verbose
+var logLevelSeverity = map[string]int{
+	LogLevelDebug: 0,
+	LogLevelInfo:  1,
+	LogLevelWarn:  2,
+	LogLevelError: 3,
+}
+
+// defaultLogger is used when a nil Logger is called
+var defaultLogger = NewLogger(LogLevelInfo)
+
+// NewLogger creates a logger writing messages at or above the given level.
+// Unknown levels fall back to info.
+func NewLogger(level string) *Logger {
+	severity, exists := logLevelSeverity[strings.ToLower(strings.TrimSpace(level))]
+	if !exists {
+		severity = logLevelSeverity[LogLevelInfo]
+	}
+	return &Logger{
+		level:   severity,
+		logger:  log.New(os.Stderr, "", log.LstdFlags),
+		secrets: &logSecrets{},
+	}
+}
+
+// SetFormat switches the output between LogFormatText and LogFormatJSON.
+// An empty format keeps the text format.
+func (l *Logger) SetFormat(format string) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", LogFormatText:
+		l.json = false
+		l.logger.SetFlags(log.LstdFlags)
+	case LogFormatJSON:
+		l.json = true
+		l.logger.SetFlags(0)
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
+
+// SetOutput redirects the log lines, written to stderr by default
+func (l *Logger) SetOutput(w io.Writer) {
+	l.logger.SetOutput(w)
+}
+
+// Redact masks secret wherever it appears in later log lines, including
+// those of the loggers derived with With
+func (l *Logger) Redact(secret string) {
+	if secret == "" {
+		return
+	}
+	l.secrets.mu.Lock()
+	defer l.secrets.mu.Unlock()
+	l.secrets.values = append(l.secrets.values, secret)
+}
+
+// With returns a logger adding a contextual field, such as the node or
+// VMID a message is about, to every JSON line
+func (l *Logger) With(key string, value interface{}) *Logger {
+	child := *l.get()
+	child.fields = append(append([]logField{}, child.fields...), logField{key: key, value: value})
+	return &child
+}
+
+// DebugEnabled reports whether debug messages are written
+func (l *Logger) DebugEnabled() bool {
+	return l.get().level <= logLevelSeverity[LogLevelDebug]
+}
+
+// Debugf logs a debug message
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.get().logf(LogLevelDebug, format, args...)
+}
+
+// Infof logs an informational message
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.get().logf(LogLevelInfo, format, args...)
+}
+
+// Warnf logs a warning
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.get().logf(LogLevelWarn, format, args...)
+}
+
+// Errorf logs an error
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.get().logf(LogLevelError, format, args...)
+}
+
+func (l *Logger) get() *Logger {
+	if l == nil {
+		return defaultLogger
+	}
+	return l
+}
+
+func (l *Logger) logf(level string, format string, args ...interface{}) {
+	if logLevelSeverity[level] < l.level {
+		return
+	}
+	msg := l.redact(fmt.Sprintf(format, args...))
+	if !l.json {
+		l.logger.Printf("[%s] %s", strings.ToUpper(level), msg)
+		return
+	}
+
+	entry := map[string]interface{}{
+		"time":  time.Now().Format(time.RFC3339),
+		"level": level,
+		"msg":   msg,
+	}
+	for _, field := range l.fields {
+		if _, reserved := entry[field.key]; reserved {
+			continue
+		}
+		if value, ok := field.value.(string); ok {
+			entry[field.key] = l.redact(value)
+			continue
+		}
+		entry[field.key] = field.value
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		l.logger.Printf(`{"level":%q,"msg":%q}`, level, msg)
+		return
+	}
+	l.logger.Print(string(line))
+}
+
+func (l *Logger) redact(s string) string {
+	l.secrets.mu.RLock()
+	defer l.secrets.mu.RUnlock()
+	for _, secret := range l.secrets.values {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	return s
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogger_Levels(t *testing.T) {
+	tests := []struct {
+		level    string
+		expected []string
+		skipped  []string
+	}{
+		{level: "debug", expected: []string{"[DEBUG]", "[INFO]", "[WARN]", "[ERROR]"}},
+		{level: "info", expected: []string{"[INFO]", "[WARN]", "[ERROR]"}, skipped: []string{"[DEBUG]"}},
+		{level: "error", expected: []string{"[ERROR]"}, skipped: []string{"[DEBUG]", "[INFO]", "[WARN]"}},
+		{level: "unknown", expected: []string{"[INFO]"}, skipped: []string{"[DEBUG]"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := NewLogger(tt.level)
+			logger.logger = log.New(&buf, "", 0)
+
+			logger.Debugf("debug message")
+			logger.Infof("info message")
+			logger.Warnf("warn message")
+			logger.Errorf("error message")
+
+			output := buf.String()
+			for _, prefix := range tt.expected {
+				if !strings.Contains(output, prefix) {
+					t.Errorf("Expected output to contain %s, got %q", prefix, output)
+				}
+			}
+			for _, prefix := range tt.skipped {
+				if strings.Contains(output, prefix) {
+					t.Errorf("Expected output not to contain %s, got %q", prefix, output)
+				}
+			}
+		})
+	}
+}
+
+func TestLogger_Nil(t *testing.T) {
+	var logger *Logger
+	logger.Infof("Logging through a nil logger must not panic")
+	if logger.DebugEnabled() {
+		t.Error("Expected debug to be disabled for a nil logger")
+	}
+}
+
+func TestLogger_RedactConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(LogLevelInfo)
+	logger.logger = log.New(&buf, "", 0)
+	child := logger.With("node", "pve1")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			child.Infof("token rotated-%d", i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		logger.Redact(fmt.Sprintf("rotated-%d", i))
+	}
+	<-done
+
+	buf.Reset()
+	child.Infof("token rotated-42")
+	if strings.Contains(buf.String(), "rotated-42") {
+		t.Errorf("Expected a secret added after With to be masked, got %q", buf.String())
+	}
+}
+
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(LogLevelInfo)
+	logger.logger = log.New(&buf, "", 0)
+	if err := logger.SetFormat(LogFormatJSON); err != nil {
+		t.Fatalf("SetFormat() error = %v", err)
+	}
+	logger.Redact("s3cr3t")
+
+	logger.With("node", "pve1").With("vmid", 100).With("service", "s3cr3t-app").Warnf("token %s rejected", "s3cr3t")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if entry["level"] != LogLevelWarn || entry["msg"] != "token [REDACTED] rejected" {
+		t.Errorf("Unexpected level or message %v", entry)
+	}
+	if entry["node"] != "pve1" || entry["vmid"] != float64(100) || entry["service"] != "[REDACTED]-app" {
+		t.Errorf("Unexpected contextual fields %v", entry)
+	}
+	if strings.Contains(buf.String(), "s3cr3t") {
+		t.Errorf("Expected the secret to be masked, got %q", buf.String())
+	}
+
+	if err := logger.SetFormat("xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestLogger_TextIgnoresFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(LogLevelInfo)
+	logger.logger = log.New(&buf, "", 0)
+
+	logger.With("node", "pve1").Infof("scanned")
+
+	if got := strings.TrimSpace(buf.String()); got != "[INFO] scanned" {
+		t.Errorf("Expected the text format to be unchanged, got %q", got)
+	}
+}
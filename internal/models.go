@@ -1,17 +1,164 @@
 package internal
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+const (
+	// DefaultLabelPrefix is the prefix used to recognize Traefik labels when none is configured
+	DefaultLabelPrefix = "traefik."
+	// DefaultLabelSeparator separates label keys from values when none is configured
+	DefaultLabelSeparator = "="
+	// StatusRunning is the Proxmox status of a running guest
+	StatusRunning = "running"
+	// GuestTypeVM and GuestTypeContainer are the guest types of a Service,
+	// named like the API paths of VMs and containers
+	GuestTypeVM        = "qemu"
+	GuestTypeContainer = "lxc"
+)
+
 type ParsedConfig struct {
 	Description string `json:"description,omitempty"`
+	// Values holds all other config keys returned by the API, stringified
+	Values map[string]string `json:"-"`
+	// CloudInitUserData is the cloud-init user-data of a VM, when it was read
+	CloudInitUserData string `json:"-"`
+}
+
+// NewParsedConfig builds a ParsedConfig from the raw config returned by the API
+func NewParsedConfig(raw map[string]interface{}) *ParsedConfig {
+	pc := &ParsedConfig{Values: make(map[string]string)}
+	for key, value := range raw {
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			s = strconv.FormatBool(v)
+		case nil:
+			continue
+		default:
+			s = fmt.Sprintf("%v", v)
+		}
+
+		if key == "description" {
+			pc.Description = s
+			continue
+		}
+		pc.Values[key] = s
+	}
+	return pc
+}
+
+// IsOnboot reports whether the guest is started when its node boots
+func (pc *ParsedConfig) IsOnboot() bool {
+	return pc.Values["onboot"] == "1"
+}
+
+// IsProtected reports whether the guest is protected against removal
+func (pc *ParsedConfig) IsProtected() bool {
+	return pc.Values["protection"] == "1"
+}
+
+// GetNetworkIPs returns the static addresses of the netN entries of an LXC config,
+// e.g. "name=eth0,bridge=vmbr0,ip=10.0.0.5/24". Dynamic addresses are skipped.
+func (pc *ParsedConfig) GetNetworkIPs() []IP {
+	keys := make([]string, 0)
+	for key := range pc.Values {
+		if index := strings.TrimPrefix(key, "net"); index != key {
+			if _, err := strconv.Atoi(index); err == nil {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	ips := make([]IP, 0)
+	for _, key := range keys {
+		network := pc.Values[key]
+		var name string
+		for _, option := range strings.Split(network, ",") {
+			if strings.HasPrefix(option, "name=") {
+				name = strings.TrimPrefix(option, "name=")
+			}
+		}
+		for _, option := range strings.Split(network, ",") {
+			key, value, found := strings.Cut(option, "=")
+			if !found || (key != "ip" && key != "ip6") {
+				continue
+			}
+			if value == "dhcp" || value == "manual" || value == "auto" {
+				continue
+			}
+
+			addressType := "ipv4"
+			if key == "ip6" {
+				addressType = "ipv6"
+			}
+			address, prefix, _ := strings.Cut(value, "/")
+			ip := IP{Address: address, AddressType: addressType, Interface: name}
+			if p, err := strconv.ParseUint(prefix, 10, 64); err == nil {
+				ip.Prefix = p
+			}
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// HasCloudInit reports whether a VM config has a cloud-init drive or a
+// custom cloud-init snippet
+func (pc *ParsedConfig) HasCloudInit() bool {
+	if _, exists := pc.Values["cicustom"]; exists {
+		return true
+	}
+	for _, value := range pc.Values {
+		if strings.Contains(value, "cloudinit") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseStaticIPs turns a comma-separated list of addresses into IPs
+func ParseStaticIPs(value string) []IP {
+	ips := make([]IP, 0)
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		addressType := "ipv4"
+		if strings.Contains(address, ":") {
+			addressType = "ipv6"
+		}
+		ips = append(ips, IP{Address: address, AddressType: addressType})
+	}
+	return ips
 }
 
 type ParsedAgentInterfaces struct {
-	Result []struct {
-		IPAddresses []IP `json:"ip-addresses"`
-	} `json:"result"`
+	Result []AgentInterface `json:"result"`
+}
+
+// AgentInterface is a network interface reported by the QEMU guest agent
+type AgentInterface struct {
+	Name        string `json:"name"`
+	IPAddresses []IP   `json:"ip-addresses"`
+}
+
+// ContainerInterface is a network interface of a running container, with
+// its addresses in CIDR notation
+type ContainerInterface struct {
+	Name   string `json:"name"`
+	HWAddr string `json:"hwaddr,omitempty"`
+	Inet   string `json:"inet,omitempty"`
+	Inet6  string `json:"inet6,omitempty"`
 }
 
 type Node struct {
@@ -24,16 +171,86 @@ type NodeStatus struct {
 	Node string `json:"node"`
 }
 
+type Pool struct {
+	PoolID  string `json:"poolid"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// PoolMember is a guest or storage belonging to a resource pool
+type PoolMember struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	VMID uint64 `json:"vmid,omitempty"`
+	Node string `json:"node,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// IsGuest reports whether the member is a VM or a container rather than a storage
+func (m PoolMember) IsGuest() bool {
+	return m.Type == "qemu" || m.Type == "lxc"
+}
+
 type VirtualMachine struct {
-	VMID   uint64 `json:"vmid"`
-	Name   string `json:"name"`
-	Status string `json:"status"`
+	VMID   uint64  `json:"vmid"`
+	Name   string  `json:"name"`
+	Status string  `json:"status"`
+	Tags   string  `json:"tags,omitempty"`
+	CPU    float64 `json:"cpu,omitempty"`
+	Mem    uint64  `json:"mem,omitempty"`
+	MaxMem uint64  `json:"maxmem,omitempty"`
 }
 
 type Container struct {
-	VMID   uint64 `json:"vmid"`
-	Name   string `json:"name"`
-	Status string `json:"status"`
+	VMID   uint64  `json:"vmid"`
+	Name   string  `json:"name"`
+	Status string  `json:"status"`
+	Tags   string  `json:"tags,omitempty"`
+	CPU    float64 `json:"cpu,omitempty"`
+	Mem    uint64  `json:"mem,omitempty"`
+	MaxMem uint64  `json:"maxmem,omitempty"`
+}
+
+// GuestStatus is the current resource usage of a VM or container. CPU is
+// the used fraction of the guest's CPUs, between 0 and 1.
+type GuestStatus struct {
+	Status string  `json:"status"`
+	CPU    float64 `json:"cpu"`
+	CPUs   float64 `json:"cpus"`
+	Mem    uint64  `json:"mem"`
+	MaxMem uint64  `json:"maxmem"`
+}
+
+// HAStatus is an entry of the HA manager status. Entries of type "service"
+// describe an HA resource, such as "vm:100", and the node it runs on.
+type HAStatus struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	SID    string `json:"sid,omitempty"`
+	Node   string `json:"node,omitempty"`
+	State  string `json:"state,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// VMID returns the VMID of an HA service entry, reporting false for other entries
+func (s HAStatus) VMID() (uint64, bool) {
+	if s.Type != "service" {
+		return 0, false
+	}
+	_, id, found := strings.Cut(s.SID, ":")
+	if !found {
+		return 0, false
+	}
+	vmID, err := strconv.ParseUint(id, 10, 64)
+	return vmID, err == nil
+}
+
+// ClusterStatus is an entry of the cluster status. Entries of type "node"
+// carry the address the node uses for cluster communication.
+type ClusterStatus struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	IP   string `json:"ip,omitempty"`
 }
 
 type Version struct {
@@ -43,23 +260,131 @@ type Version struct {
 type Service struct {
 	ID     uint64
 	Name   string
+	Status string
+	Type   string
 	IPs    []IP
-	Config map[string]string
+	Tags   []string
+	// Pool is the resource pool the guest belongs to, if any
+	Pool string
+	// Onboot and Protected are the onboot and protection flags of the guest config
+	Onboot    bool
+	Protected bool
+	// Cluster is the name of the cluster the guest was found in, empty
+	// unless additional clusters are configured
+	Cluster string
+	// NodeAddress is the address of the node of a guest published through a
+	// node port, empty for other guests or when it is unknown
+	NodeAddress string
+	// CPU, Mem and MaxMem are the resource usage of the guest at scan time
+	CPU    float64
+	Mem    uint64
+	MaxMem uint64
+	// HAState and HANode are the HA manager state of the guest and the node
+	// it is assigned to, both empty for guests not managed by HA
+	HAState string
+	HANode  string
+	Config  map[string]string
+	// LabelError is set when the labels of the guest could not be fully parsed
+	LabelError error
 }
 
 type IP struct {
 	Address     string `json:"ip-address,omitempty"`
 	AddressType string `json:"ip-address-type,omitempty"`
 	Prefix      uint64 `json:"prefix,omitempty"`
+	// Interface is the name of the guest interface holding the address, if known
+	Interface string `json:"-"`
 }
 
 func NewService(id uint64, name string, config map[string]string) Service {
-	return Service{ID: id, Name: name, Config: config, IPs: make([]IP, 0)}
+	return Service{ID: id, Name: name, Config: config, IPs: make([]IP, 0), Tags: make([]string, 0)}
+}
+
+// IsRunning reports whether the guest backing the service is running.
+// Services without a known status are assumed to be running.
+func (s Service) IsRunning() bool {
+	return s.Status == "" || s.Status == StatusRunning
+}
+
+// ParseTags splits a Proxmox tag list into individual tags.
+// Proxmox stores tags separated by semicolons, but commas and spaces are accepted as well.
+func ParseTags(tags string) []string {
+	result := make([]string, 0)
+	fields := strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
+	for _, tag := range fields {
+		result = append(result, strings.ToLower(tag))
+	}
+	return result
+}
+
+// WithLabelMarker returns a copy of the config whose description only keeps
+// the lines below the given marker line, so prose above it is never parsed
+// as labels. Without a marker, or when the description does not contain it,
+// the whole description is kept.
+func (pc *ParsedConfig) WithLabelMarker(marker string) *ParsedConfig {
+	marker = strings.TrimSpace(marker)
+	if marker == "" {
+		return pc
+	}
+
+	lines := strings.Split(pc.Description, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == marker {
+			section := *pc
+			section.Description = strings.Join(lines[i+1:], "\n")
+			return &section
+		}
+	}
+	return pc
+}
+
+// HasLabelMarker reports whether the description contains the given marker line
+func (pc *ParsedConfig) HasLabelMarker(marker string) bool {
+	marker = strings.TrimSpace(marker)
+	if marker == "" {
+		return false
+	}
+	for _, line := range strings.Split(pc.Description, "\n") {
+		if strings.TrimSpace(line) == marker {
+			return true
+		}
+	}
+	return false
 }
 
-func (pc *ParsedConfig) GetTraefikMap() map[string]string {
-	const separator = "="
+// GetTraefikMap extracts all labels starting with the given prefix from the description.
+// A structured YAML/JSON label block takes precedence when present; otherwise each
+// line is split on the first occurrence of separator, so values may contain it.
+// When the block cannot be parsed, the line-based labels are returned with the error.
+func (pc *ParsedConfig) GetTraefikMap(prefix, separator string) (map[string]string, error) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	if separator == "" {
+		separator = DefaultLabelSeparator
+	}
+
+	if content, format, found := extractLabelBlock(pc.Description); found {
+		block, err := parseLabelBlock(content, format)
+		if err != nil {
+			return pc.getLineLabels(prefix, separator), err
+		}
+
+		m := make(map[string]string)
+		for key, value := range block.labels {
+			if strings.HasPrefix(key, prefix) {
+				m[key] = value
+			}
+		}
+		return m, nil
+	}
+
+	return pc.getLineLabels(prefix, separator), nil
+}
 
+func (pc *ParsedConfig) getLineLabels(prefix, separator string) map[string]string {
 	m := make(map[string]string)
 	lines := strings.Split(pc.Description, "\n")
 	for _, line := range lines {
@@ -71,17 +396,46 @@ func (pc *ParsedConfig) GetTraefikMap() map[string]string {
 		key = strings.Trim(key, "\" ")
 		value = strings.Trim(value, "\" ")
 
-		if strings.HasPrefix(key, "traefik.") {
+		if strings.HasPrefix(key, prefix) {
 			m[key] = value
 		}
 	}
 	return m
 }
 
+// GetContainerIPs returns the addresses of the interfaces of a container,
+// leaving out the loopback interface
+func GetContainerIPs(interfaces []ContainerInterface) []IP {
+	ips := make([]IP, 0)
+	for _, iface := range interfaces {
+		if iface.Name == "lo" {
+			continue
+		}
+		for _, cidr := range []string{iface.Inet, iface.Inet6} {
+			if cidr == "" {
+				continue
+			}
+			address, prefix, _ := strings.Cut(cidr, "/")
+			ip := IP{Address: address, AddressType: "ipv4", Interface: iface.Name}
+			if strings.Contains(address, ":") {
+				ip.AddressType = "ipv6"
+			}
+			if p, err := strconv.ParseUint(prefix, 10, 64); err == nil {
+				ip.Prefix = p
+			}
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
 func (pai *ParsedAgentInterfaces) GetIPs() []IP {
 	ips := make([]IP, 0)
 	for _, r := range pai.Result {
-		ips = append(ips, r.IPAddresses...)
+		for _, ip := range r.IPAddresses {
+			ip.Interface = r.Name
+			ips = append(ips, ip)
+		}
 	}
 	return ips
 }
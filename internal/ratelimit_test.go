@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Reserve(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(10, 2)
+
+	if limiter.reserve(now) != 0 || limiter.reserve(now) != 0 {
+		t.Fatal("Expected the burst to be available immediately")
+	}
+	if delay := limiter.reserve(now); delay != 100*time.Millisecond {
+		t.Errorf("Expected to wait 100ms for the next token, got %s", delay)
+	}
+	if delay := limiter.reserve(now.Add(100 * time.Millisecond)); delay != 0 {
+		t.Errorf("Expected a token after 100ms, got a delay of %s", delay)
+	}
+	if delay := limiter.reserve(now.Add(time.Hour)); delay != 0 {
+		t.Errorf("Expected a token after an idle period, got a delay of %s", delay)
+	}
+	if limiter.tokens > limiter.burst {
+		t.Errorf("Expected tokens to be capped at the burst, got %v", limiter.tokens)
+	}
+}
+
+func TestProxmoxClient_SetRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"release":"8.1"}}`))
+	}))
+	defer server.Close()
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, LogLevelInfo)
+	if err := client.SetRateLimit(20, 2); err != nil {
+		t.Fatalf("SetRateLimit() error = %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := client.GetVersion(context.Background()); err != nil {
+			t.Fatalf("GetVersion() error = %v", err)
+		}
+	}
+	// Two requests of burst, then four at 20 requests per second
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("Expected the burst of calls to be throttled, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	client.SetRateLimit(0.1, 1)
+	client.GetVersion(ctx)
+	if _, err := client.GetVersion(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected waiting for a token to respect the context, got %v", err)
+	}
+
+	if err := client.SetRateLimit(0, 0); err != nil || client.limiter != nil {
+		t.Errorf("Expected a rate of 0 to disable the limit, got %v", err)
+	}
+	if err := client.SetRateLimit(-1, 1); err == nil {
+		t.Error("Expected an error for a negative rate")
+	}
+}
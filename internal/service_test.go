@@ -87,7 +87,10 @@ func TestParsedConfig_GetTraefikMap(t *testing.T) {
 		Description: "traefik.enable=true\ntraefik.http.routers.test.rule=Host(`test.example.com`)",
 	}
 	
-	m := pc.GetTraefikMap()
+	m, err := pc.GetTraefikMap(DefaultLabelPrefix, DefaultLabelSeparator)
+	if err != nil {
+		t.Fatalf("GetTraefikMap() error = %v", err)
+	}
 	
 	if len(m) != 2 {
 		t.Errorf("Expected 2 config items, got %d", len(m))
@@ -104,10 +107,9 @@ func TestParsedConfig_GetTraefikMap(t *testing.T) {
 
 func TestParsedAgentInterfaces_GetIPs(t *testing.T) {
 	pai := ParsedAgentInterfaces{
-		Result: []struct {
-			IPAddresses []IP `json:"ip-addresses"`
-		}{
+		Result: []AgentInterface{
 			{
+				Name: "eth0",
 				IPAddresses: []IP{
 					{Address: "192.168.1.1", AddressType: "ipv4", Prefix: 24},
 					{Address: "10.0.0.1", AddressType: "ipv4", Prefix: 16},
@@ -129,4 +131,186 @@ func TestParsedAgentInterfaces_GetIPs(t *testing.T) {
 	if ips[1].Address != "10.0.0.1" {
 		t.Errorf("Expected second IP to be 10.0.0.1, got %s", ips[1].Address)
 	}
-} 
\ No newline at end of file
+
+	if ips[0].Interface != "eth0" || ips[1].Interface != "eth0" {
+		t.Errorf("Expected the IPs to be tagged with interface eth0, got %q and %q", ips[0].Interface, ips[1].Interface)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     string
+		expected []string
+	}{
+		{name: "Empty", tags: "", expected: []string{}},
+		{name: "Semicolon separated", tags: "prod;edge", expected: []string{"prod", "edge"}},
+		{name: "Mixed separators and case", tags: "Prod, edge;web", expected: []string{"prod", "edge", "web"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := ParseTags(tt.tags)
+			if len(tags) != len(tt.expected) {
+				t.Fatalf("Expected %d tags, got %d (%v)", len(tt.expected), len(tags), tags)
+			}
+			for i := range tags {
+				if tags[i] != tt.expected[i] {
+					t.Errorf("Expected tag %d to be %s, got %s", i, tt.expected[i], tags[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParsedConfig_GetTraefikMapCustomPrefix(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "traefik.enable=true\ntraefik-internal.enable=true\ntraefik-internal.http.routers.app.rule=Host(`app.internal`)",
+	}
+
+	m, err := pc.GetTraefikMap("traefik-internal.", DefaultLabelSeparator)
+	if err != nil {
+		t.Fatalf("GetTraefikMap() error = %v", err)
+	}
+
+	if len(m) != 2 {
+		t.Errorf("Expected 2 config items, got %d", len(m))
+	}
+
+	if _, exists := m["traefik.enable"]; exists {
+		t.Error("Expected labels with the default prefix to be ignored")
+	}
+
+	if m["traefik-internal.http.routers.app.rule"] != "Host(`app.internal`)" {
+		t.Errorf("Expected correct router rule, got %s", m["traefik-internal.http.routers.app.rule"])
+	}
+}
+
+func TestParsedConfig_GetTraefikMapSeparator(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		separator   string
+		key         string
+		expected    string
+	}{
+		{
+			name:        "Value containing the separator",
+			description: "traefik.http.routers.a.rule=PathPrefix(`/a=b`)",
+			separator:   "=",
+			key:         "traefik.http.routers.a.rule",
+			expected:    "PathPrefix(`/a=b`)",
+		},
+		{
+			name:        "Colon separator",
+			description: "traefik.enable: true",
+			separator:   ":",
+			key:         "traefik.enable",
+			expected:    "true",
+		},
+		{
+			name:        "Space separator",
+			description: "traefik.http.routers.a.rule Host(`a.example.com`)",
+			separator:   " ",
+			key:         "traefik.http.routers.a.rule",
+			expected:    "Host(`a.example.com`)",
+		},
+		{
+			name:        "Empty separator uses default",
+			description: "traefik.enable=true",
+			separator:   "",
+			key:         "traefik.enable",
+			expected:    "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := ParsedConfig{Description: tt.description}
+			m, err := pc.GetTraefikMap(DefaultLabelPrefix, tt.separator)
+			if err != nil {
+				t.Fatalf("GetTraefikMap() error = %v", err)
+			}
+			if m[tt.key] != tt.expected {
+				t.Errorf("Expected %s=%s, got %q", tt.key, tt.expected, m[tt.key])
+			}
+		})
+	}
+}
+
+func TestNewParsedConfig(t *testing.T) {
+	pc := NewParsedConfig(map[string]interface{}{
+		"description": "traefik.enable=true",
+		"net0":        "name=eth0,bridge=vmbr0,ip=10.0.0.5/24,ip6=dhcp",
+		"net2":        "name=eth2,bridge=vmbr1,ip=dhcp,ip6=fd00::5/64",
+		"onboot":      float64(1),
+	})
+
+	if pc.Description != "traefik.enable=true" {
+		t.Errorf("Expected description to be set, got %q", pc.Description)
+	}
+	if pc.Values["onboot"] != "1" {
+		t.Errorf("Expected onboot=1, got %q", pc.Values["onboot"])
+	}
+	if !pc.IsOnboot() || pc.IsProtected() {
+		t.Errorf("Expected the guest to start on boot without protection, got onboot=%v protected=%v", pc.IsOnboot(), pc.IsProtected())
+	}
+
+	ips := pc.GetNetworkIPs()
+	if len(ips) != 2 {
+		t.Fatalf("Expected 2 static IPs, got %d (%v)", len(ips), ips)
+	}
+	if ips[0].Address != "10.0.0.5" || ips[0].Prefix != 24 || ips[0].AddressType != "ipv4" || ips[0].Interface != "eth0" {
+		t.Errorf("Unexpected first IP %+v", ips[0])
+	}
+	if ips[1].Address != "fd00::5" || ips[1].AddressType != "ipv6" || ips[1].Interface != "eth2" {
+		t.Errorf("Unexpected second IP %+v", ips[1])
+	}
+}
+
+func TestParseStaticIPs(t *testing.T) {
+	ips := ParseStaticIPs("10.0.0.5, fd00::5")
+	if len(ips) != 2 {
+		t.Fatalf("Expected 2 IPs, got %d", len(ips))
+	}
+	if ips[0].Address != "10.0.0.5" || ips[0].AddressType != "ipv4" {
+		t.Errorf("Unexpected first IP %+v", ips[0])
+	}
+	if ips[1].Address != "fd00::5" || ips[1].AddressType != "ipv6" {
+		t.Errorf("Unexpected second IP %+v", ips[1])
+	}
+}
+
+func TestParsedConfig_WithLabelMarker(t *testing.T) {
+	description := "Notes about the app: set max_connections=100 before traefik.upgrade=later\n" +
+		"traefik.http.routers.old.rule=Host(`old.example.com`)\n" +
+		"--- traefik ---\n" +
+		"traefik.enable=true\n" +
+		"traefik.http.routers.app.rule=Host(`app.example.com`)"
+	pc := &ParsedConfig{Description: description}
+
+	m, err := pc.WithLabelMarker("--- traefik ---").GetTraefikMap(DefaultLabelPrefix, DefaultLabelSeparator)
+	if err != nil {
+		t.Fatalf("GetTraefikMap() error = %v", err)
+	}
+	if len(m) != 2 {
+		t.Errorf("Expected only the labels below the marker, got %v", m)
+	}
+	if _, exists := m["traefik.http.routers.old.rule"]; exists {
+		t.Error("Expected labels above the marker to be ignored")
+	}
+	if pc.Description != description {
+		t.Error("Expected the original config to be left untouched")
+	}
+
+	if got := pc.WithLabelMarker(""); got != pc {
+		t.Error("Expected an empty marker to keep the whole description")
+	}
+	if got := pc.WithLabelMarker("--- other ---"); got.Description != description {
+		t.Error("Expected a missing marker to keep the whole description")
+	}
+
+	if !pc.HasLabelMarker("--- traefik ---") || pc.HasLabelMarker("--- other ---") || pc.HasLabelMarker("") {
+		t.Error("Expected HasLabelMarker to only report the marker line of the description")
+	}
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ReadTokenFile reads an API token secret from a file, such as a mounted
+// secret, ignoring surrounding whitespace
+func ReadTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// SetTokenFile makes the client re-read its token from path whenever the
+// API rejects it with 401, so a rotated token is used without a restart.
+// The current token is left unchanged until then.
+func (c *ProxmoxClient) SetTokenFile(path string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.tokenFile = path
+}
+
+// currentToken returns the token to authenticate the next request with
+func (c *ProxmoxClient) currentToken() string {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.Token
+}
+
+// isTokenRejected reports whether the API answered with 401
+func isTokenRejected(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized
+}
+
+// reloadToken re-reads the token file after the API rejected the token,
+// reporting whether a different token was loaded and the request is worth
+// retrying
+func (c *ProxmoxClient) reloadToken() bool {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if c.tokenFile == "" {
+		return false
+	}
+
+	token, err := ReadTokenFile(c.tokenFile)
+	if err != nil {
+		c.Logger.Errorf("API token was rejected and could not be reloaded: %v", err)
+		return false
+	}
+	if token == c.Token {
+		c.Logger.Warnf("API token was rejected and %s still holds the same token", c.tokenFile)
+		return false
+	}
+
+	c.Token = token
+	c.Logger.Redact(token)
+	c.Logger.Infof("API token was rejected, reloaded it from %s", c.tokenFile)
+	return true
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func newTokenTestServer(t *testing.T, validToken string) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Authorization") != "PVEAPIToken=test@pam!test="+validToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "authentication failure")
+			return
+		}
+		fmt.Fprint(w, `{"data":{"release":"8.1"}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestProxmoxClient_ReloadTokenFile(t *testing.T) {
+	server, requests := newTokenTestServer(t, "new-token")
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("old-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := ReadTokenFile(path)
+	if err != nil || token != "old-token" {
+		t.Fatalf("ReadTokenFile() = %q, %v", token, err)
+	}
+	client := NewProxmoxClient(server.URL, "test@pam!test", token, true, LogLevelError)
+	client.SetTokenFile(path)
+
+	// The token is rotated behind the provider's back
+	if err := os.WriteFile(path, []byte("new-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := client.GetVersion(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the request to succeed after reloading the token, got %v", err)
+	}
+	if version.Release != "8.1" {
+		t.Errorf("Unexpected release %q", version.Release)
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("Expected the rejected request to be retried once, got %d requests", got)
+	}
+	if client.Token != "new-token" {
+		t.Errorf("Expected the client to keep the reloaded token, got %q", client.Token)
+	}
+
+	// Later requests use the new token right away
+	if _, err := client.GetVersion(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("Expected a single request with the new token, got %d requests", got)
+	}
+}
+
+func TestProxmoxClient_ReloadTokenFileUnchanged(t *testing.T) {
+	server, requests := newTokenTestServer(t, "new-token")
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("old-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewProxmoxClient(server.URL, "test@pam!test", "old-token", true, LogLevelError)
+	client.SetTokenFile(path)
+
+	_, err := client.GetVersion(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a 401 error, got %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("Expected no retry with an unchanged token file, got %d requests", got)
+	}
+}
+
+func TestProxmoxClient_LiteralTokenNotReloaded(t *testing.T) {
+	server, requests := newTokenTestServer(t, "new-token")
+	client := NewProxmoxClient(server.URL, "test@pam!test", "old-token", true, LogLevelError)
+
+	_, err := client.GetVersion(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.IsAuthError() {
+		t.Fatalf("Expected an authentication error, got %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("Expected no retry with a literal token, got %d requests", got)
+	}
+}
+
+func TestReadTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte(" \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadTokenFile(empty); err == nil {
+		t.Error("Expected an error for an empty token file")
+	}
+	if _, err := ReadTokenFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing token file")
+	}
+}
@@ -0,0 +1,387 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseYAML parses the subset of YAML used by configuration files into
+// map[string]interface{}, []interface{} and scalar values, ready to be
+// marshaled to JSON: nested mappings, block lists (- a) including lists of
+// mappings, flow lists ([a, b]) and mappings ({a: b}), quoted and plain
+// scalars, and literal (|) and folded (>) block scalars. Anchors, tags and
+// multiple documents are not supported.
+func ParseYAML(content string) (interface{}, error) {
+	return newYAMLParser(content).parse()
+}
+
+// yamlParser walks the lines of a YAML document
+type yamlParser struct {
+	lines []string
+	pos   int
+	// rawScalars keeps plain scalars as strings, as label values are
+	rawScalars bool
+	// keyLines, when set, receives the line of each mapping key by its
+	// dotted path
+	keyLines map[string]int
+	path     string
+}
+
+func newYAMLParser(content string) *yamlParser {
+	return &yamlParser{lines: strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")}
+}
+
+// parse parses the whole document
+func (p *yamlParser) parse() (interface{}, error) {
+	for n, line := range p.lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.Contains(line[:len(line)-len(strings.TrimLeft(line, " \t"))], "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", n+1)
+		}
+	}
+
+	indent, text, ok := p.peek()
+	if ok && text == "---" {
+		p.pos++
+		indent, _, ok = p.peek()
+	}
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+
+	value, err := p.parseNode(indent)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, ok := p.peek(); ok {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.pos+1)
+	}
+	return value, nil
+}
+
+// peek skips blank and comment lines and returns the indentation and the
+// text of the current line, without its comment
+func (p *yamlParser) peek() (int, string, bool) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		text := stripYAMLComment(strings.TrimSpace(line))
+		if text == "" {
+			continue
+		}
+		return len(line) - len(strings.TrimLeft(line, " ")), text, true
+	}
+	return 0, "", false
+}
+
+// parseNode parses the list or mapping starting at the current line
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	_, text, _ := p.peek()
+	if isYAMLListItem(text) {
+		return p.parseList(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseList(indent int) (interface{}, error) {
+	items := make([]interface{}, 0)
+	for {
+		lineIndent, text, ok := p.peek()
+		if !ok || lineIndent < indent || !isYAMLListItem(text) {
+			return items, nil
+		}
+		if lineIndent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", p.pos+1)
+		}
+
+		rest := strings.TrimLeft(text[1:], " ")
+		var item interface{}
+		var err error
+		switch {
+		case rest == "":
+			p.pos++
+			item, err = p.parseChild(indent)
+		case isYAMLMappingEntry(rest):
+			// "- key: value" starts a mapping aligned with its first key
+			column := indent + len(text) - len(rest)
+			p.lines[p.pos] = strings.Repeat(" ", column) + rest
+			item, err = p.parseMapping(column)
+		default:
+			p.pos++
+			item, err = p.parseValue(rest, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for {
+		lineIndent, text, ok := p.peek()
+		if !ok || lineIndent < indent {
+			return m, nil
+		}
+		if lineIndent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", p.pos+1)
+		}
+
+		key, rest, found := splitYAMLMappingEntry(text)
+		if !found {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", p.pos+1)
+		}
+		if _, exists := m[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key %q", p.pos+1, key)
+		}
+		path := p.path
+		p.path = joinLabelPath(path, key)
+		if p.keyLines != nil {
+			p.keyLines[p.path] = p.pos + 1
+		}
+		p.pos++
+
+		var value interface{}
+		var err error
+		if rest == "" {
+			// A list may start at the indentation of its key
+			if childIndent, childText, ok := p.peek(); ok && childIndent == indent && isYAMLListItem(childText) {
+				value, err = p.parseList(indent)
+			} else {
+				value, err = p.parseChild(indent)
+			}
+		} else {
+			value, err = p.parseValue(rest, indent)
+		}
+		p.path = path
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+}
+
+// parseChild parses the node nested below a line with the given
+// indentation, nil when the next line is not indented further
+func (p *yamlParser) parseChild(indent int) (interface{}, error) {
+	childIndent, _, ok := p.peek()
+	if !ok || childIndent <= indent {
+		return nil, nil
+	}
+	return p.parseNode(childIndent)
+}
+
+// parseValue parses the value following a key or a list dash
+func (p *yamlParser) parseValue(value string, indent int) (interface{}, error) {
+	if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+		return p.parseBlockScalar(value, indent), nil
+	}
+	parsed, err := parseYAMLFlow(value, p.rawScalars)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.pos, err)
+	}
+	return parsed, nil
+}
+
+// parseBlockScalar reads the lines indented below a | or > indicator. A
+// "-" after the indicator strips the final newline.
+func (p *yamlParser) parseBlockScalar(header string, indent int) string {
+	lines := make([]string, 0)
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		lineIndent := len(line) - len(strings.TrimLeft(line, " "))
+		if blockIndent < 0 {
+			blockIndent = lineIndent
+		}
+		if lineIndent <= indent || lineIndent < blockIndent {
+			break
+		}
+		lines = append(lines, line[blockIndent:])
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var value string
+	if header[0] == '|' {
+		value = strings.Join(lines, "\n")
+	} else {
+		value = strings.Join(lines, " ")
+	}
+	if value != "" && !strings.HasSuffix(header, "-") {
+		value += "\n"
+	}
+	return value
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func isYAMLMappingEntry(text string) bool {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return false
+	}
+	_, _, found := splitYAMLMappingEntry(text)
+	return found
+}
+
+// splitYAMLMappingEntry splits "key: value" at the first colon followed by
+// a space or the end of the line outside of quotes
+func splitYAMLMappingEntry(text string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			key := strings.TrimSpace(text[:i])
+			if key == "" {
+				return "", "", false
+			}
+			return unquoteYAML(key), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// stripYAMLComment removes a comment starting with " #" outside of quotes
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimSpace(text[:i])
+		}
+	}
+	return text
+}
+
+// parseYAMLFlow parses a scalar or a flow list or mapping, keeping plain
+// scalars as strings when raw is set
+func parseYAMLFlow(value string, raw bool) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(value, "["):
+		if !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("unterminated flow list %s", value)
+		}
+		items := make([]interface{}, 0)
+		for _, part := range splitYAMLFlow(value[1 : len(value)-1]) {
+			item, err := parseYAMLFlow(part, raw)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case strings.HasPrefix(value, "{"):
+		if !strings.HasSuffix(value, "}") {
+			return nil, fmt.Errorf("unterminated flow mapping %s", value)
+		}
+		m := make(map[string]interface{})
+		for _, part := range splitYAMLFlow(value[1 : len(value)-1]) {
+			key, rest, found := splitYAMLMappingEntry(part)
+			if !found {
+				return nil, fmt.Errorf("expected \"key: value\" in flow mapping, got %s", part)
+			}
+			item, err := parseYAMLFlow(rest, raw)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = item
+		}
+		return m, nil
+	}
+	return parseYAMLScalar(value, raw)
+}
+
+// splitYAMLFlow splits the content of a flow collection at the commas
+// outside of quotes and nested collections
+func splitYAMLFlow(content string) []string {
+	parts := make([]string, 0)
+	var quote byte
+	depth := 0
+	start := 0
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(content[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(content[start:]); last != "" || len(parts) > 0 {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+// parseYAMLScalar converts a scalar to a string, bool, number or nil. With
+// raw set, plain scalars other than the empty one are kept as strings.
+func parseYAMLScalar(value string, raw bool) (interface{}, error) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", value)
+		}
+		return s, nil
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	if raw {
+		if value == "" {
+			return nil, nil
+		}
+		return value, nil
+	}
+
+	switch value {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i, nil
+	}
+	if strings.IndexAny(value, "0123456789") >= 0 && strings.Trim(value, "0123456789.eE+-") == "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f, nil
+		}
+	}
+	return value, nil
+}
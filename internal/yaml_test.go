@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	content := `---
+# Shared objects
+http:
+  middlewares:
+    error-pages:
+      errors:
+        status: ["500-599", "404"]
+        service: error-pages # served by the file provider
+        query: "/{status}.html"
+  services:
+    error-pages:
+      loadBalancer:
+        passHostHeader: true
+        servers:
+          - url: http://10.0.0.20:8080
+            weight: 2
+          - url: 'http://10.0.0.21:8080'
+tls:
+  options:
+    modern:
+      minVersion: VersionTLS13
+      sniStrict: false
+      alpnProtocols:
+      - h2
+      - http/1.1
+  certificates:
+    - certFile: |
+        line one
+        line two
+      stores: {}
+`
+	document, err := ParseYAML(content)
+	if err != nil {
+		t.Fatalf("ParseYAML() error = %v", err)
+	}
+	got, err := json.Marshal(document)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	expected := `{"http":{"middlewares":{"error-pages":{"errors":{"query":"/{status}.html","service":"error-pages","status":["500-599","404"]}}},` +
+		`"services":{"error-pages":{"loadBalancer":{"passHostHeader":true,"servers":[{"url":"http://10.0.0.20:8080","weight":2},{"url":"http://10.0.0.21:8080"}]}}}},` +
+		`"tls":{"certificates":[{"certFile":"line one\nline two\n","stores":{}}],"options":{"modern":{"alpnProtocols":["h2","http/1.1"],"minVersion":"VersionTLS13","sniStrict":false}}}}`
+	if string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "Tab indentation", content: "http:\n\trouters: {}"},
+		{name: "Missing colon", content: "http:\n  routers\n"},
+		{name: "Unexpected indentation", content: "http:\n  routers: {}\n    services: {}\n"},
+		{name: "Duplicate key", content: "http: {}\nhttp: {}\n"},
+		{name: "Unterminated flow list", content: "status: [500, 404\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseYAML(tt.content); err == nil {
+				t.Errorf("Expected an error for %q", tt.content)
+			}
+		})
+	}
+}
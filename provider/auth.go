@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// tokenDocsURL documents the user@realm!tokenname format of API token IDs
+const tokenDocsURL = "https://pve.proxmox.com/wiki/User_Management#pveum_tokens"
+
+// AuthError is returned by New when the Proxmox API rejects the configured
+// token at startup. Its message names the credential that is most likely
+// wrong, based on the status and the answer of the API.
+type AuthError struct {
+	StatusCode int
+	TokenID    string
+	// TokenFile is the apiTokenFile the secret was read from, if any
+	TokenFile string
+	Err       *internal.APIError
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("authentication failed (status %d): %s, see %s: %v", e.StatusCode, e.hint(), tokenDocsURL, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// hint tells which credential to check
+func (e *AuthError) hint() string {
+	secret := "apiToken"
+	if e.TokenFile != "" {
+		secret = fmt.Sprintf("apiTokenFile %s", e.TokenFile)
+	}
+
+	message := strings.ToLower(e.Err.Status + " " + e.Err.Body)
+	switch {
+	case e.StatusCode == http.StatusForbidden:
+		return fmt.Sprintf("token %q was accepted but lacks permissions, give it the role described in the README on / (with privilege separation, the token needs the ACL itself)", e.TokenID)
+	case strings.Contains(message, "no such token") || strings.Contains(message, "no such user"):
+		return fmt.Sprintf("token %q does not exist, check that apiTokenId is the full token ID user@realm!tokenname as listed under Datacenter > Permissions > API Tokens", e.TokenID)
+	case strings.Contains(message, "invalid token value"):
+		return fmt.Sprintf("the secret of token %q is wrong, check that %s holds the secret shown when the token was created", e.TokenID, secret)
+	default:
+		return fmt.Sprintf("check that apiTokenId %q is the full token ID user@realm!tokenname and that %s holds its secret, not the password of the user", e.TokenID, secret)
+	}
+}
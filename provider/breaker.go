@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"sync"
+	"time"
+)
+
+// nodeBreaker skips nodes whose scans keep failing. Once a node failed
+// threshold polls in a row it is left out for a cooldown, after which a
+// single scan probes it: a success closes the breaker, a failure reopens it
+// for twice as long, up to maxCooldown.
+type nodeBreaker struct {
+	mu          sync.Mutex
+	threshold   int
+	cooldown    time.Duration
+	maxCooldown time.Duration
+	nodes       map[string]nodeBreakerState
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+type nodeBreakerState struct {
+	failures  int
+	openUntil time.Time
+}
+
+func newNodeBreaker(threshold int, cooldown, maxCooldown time.Duration) *nodeBreaker {
+	return &nodeBreaker{
+		threshold:   threshold,
+		cooldown:    cooldown,
+		maxCooldown: maxCooldown,
+		nodes:       make(map[string]nodeBreakerState),
+		now:         time.Now,
+	}
+}
+
+// allow reports whether the node is scanned in this poll, returning the
+// time its breaker closes at when it is skipped
+func (b *nodeBreaker) allow(nodeName string) (bool, time.Time) {
+	if b == nil {
+		return true, time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.nodes[nodeName]
+	if state.failures < b.threshold || !b.now().Before(state.openUntil) {
+		return true, time.Time{}
+	}
+	return false, state.openUntil
+}
+
+// recordSuccess closes the breaker of the node, reporting whether it was open
+func (b *nodeBreaker) recordSuccess(nodeName string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, exists := b.nodes[nodeName]
+	delete(b.nodes, nodeName)
+	return exists && state.failures >= b.threshold
+}
+
+// recordFailure counts a failed scan of the node, returning the cooldown it
+// is now skipped for, 0 while it is below the threshold
+func (b *nodeBreaker) recordFailure(nodeName string) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.nodes[nodeName]
+	state.failures++
+	var cooldown time.Duration
+	if state.failures >= b.threshold {
+		cooldown = b.cooldown
+		for i := b.threshold; i < state.failures && cooldown < b.maxCooldown; i++ {
+			cooldown *= 2
+		}
+		if cooldown > b.maxCooldown {
+			cooldown = b.maxCooldown
+		}
+		state.openUntil = b.now().Add(cooldown)
+	}
+	b.nodes[nodeName] = state
+	return cooldown
+}
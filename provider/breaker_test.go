@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestNodeBreaker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newNodeBreaker(2, time.Minute, 3*time.Minute)
+	b.now = func() time.Time { return now }
+
+	if cooldown := b.recordFailure("pve1"); cooldown != 0 {
+		t.Errorf("Expected no cooldown below the threshold, got %v", cooldown)
+	}
+	if allowed, _ := b.allow("pve1"); !allowed {
+		t.Error("Expected the node to be scanned below the threshold")
+	}
+
+	// The cooldown doubles with every failed probe, up to the maximum
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		if cooldown := b.recordFailure("pve1"); cooldown != expected {
+			t.Errorf("Expected a cooldown of %v, got %v", expected, cooldown)
+		}
+		if allowed, _ := b.allow("pve1"); allowed {
+			t.Error("Expected the node to be skipped during the cooldown")
+		}
+		now = now.Add(expected)
+		if allowed, _ := b.allow("pve1"); !allowed {
+			t.Error("Expected the node to be probed once the cooldown is over")
+		}
+	}
+
+	if !b.recordSuccess("pve1") {
+		t.Error("Expected a successful probe to report the node as recovered")
+	}
+	if cooldown := b.recordFailure("pve1"); cooldown != 0 {
+		t.Errorf("Expected the failure count to start over after a recovery, got a cooldown of %v", cooldown)
+	}
+	if b.recordSuccess("pve1") {
+		t.Error("Expected a node below the threshold not to be reported as recovered")
+	}
+
+	var disabled *nodeBreaker
+	disabled.recordFailure("pve1")
+	if allowed, _ := disabled.allow("pve1"); !allowed {
+		t.Error("Expected a nil breaker to scan every node")
+	}
+}
+
+func TestGetServiceMapNodeBreaker(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var flakyScans atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && strings.HasPrefix(r.URL.Path, "/api2/json/nodes/flaky/") {
+			if strings.HasSuffix(r.URL.Path, "/qemu") {
+				flakyScans.Add(1)
+			}
+			http.Error(w, "node unreachable", http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case "/api2/json/nodes":
+			fmt.Fprint(w, `{"data":[{"node":"pve1"},{"node":"flaky"}]}`)
+		case "/api2/json/nodes/pve1/qemu":
+			fmt.Fprint(w, `{"data":[{"vmid":100,"name":"app","status":"running"}]}`)
+		case "/api2/json/nodes/pve1/qemu/100/config":
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true"}}`)
+		case "/api2/json/nodes/flaky/qemu":
+			flakyScans.Add(1)
+			fmt.Fprint(w, `{"data":[{"vmid":200,"name":"other","status":"running"}]}`)
+		case "/api2/json/nodes/flaky/qemu/200/config":
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true"}}`)
+		default:
+			fmt.Fprint(w, `{"data":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	pc, err := newParserConfig(server.URL, "test@pam!test", "test-token")
+	if err != nil {
+		t.Fatalf("newParserConfig() error = %v", err)
+	}
+	client := newClient(pc)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := newNodeBreaker(2, time.Minute, 10*time.Minute)
+	breaker.now = func() time.Time { return now }
+	opts := scanOptions{disableGuestAgent: true, nodeBreaker: breaker, logger: internal.NewLogger("error")}
+
+	poll := func() map[string][]internal.Service {
+		t.Helper()
+		servicesMap, err := getServiceMap(client, context.Background(), opts)
+		if err != nil {
+			t.Fatalf("getServiceMap() error = %v", err)
+		}
+		if len(servicesMap["pve1"]) != 1 {
+			t.Errorf("Expected the healthy node to be scanned every poll, got %v", servicesMap)
+		}
+		return servicesMap
+	}
+
+	// Two failures open the breaker, the third poll leaves the node out
+	poll()
+	poll()
+	before := flakyScans.Load()
+	poll()
+	if scans := flakyScans.Load() - before; scans != 0 {
+		t.Errorf("Expected the failing node to be skipped during the cooldown, got %d scans", scans)
+	}
+
+	// Once the cooldown is over the node is probed and recovers
+	failing.Store(false)
+	now = now.Add(time.Minute)
+	if services := poll()["flaky"]; len(services) != 1 {
+		t.Errorf("Expected the recovered node to be scanned, got %v", services)
+	}
+	now = now.Add(time.Second)
+	before = flakyScans.Load()
+	poll()
+	if scans := flakyScans.Load() - before; scans != 1 {
+		t.Errorf("Expected the recovered node to be scanned every poll, got %d scans", scans)
+	}
+}
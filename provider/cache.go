@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// configCache keeps the last fetched guest configs keyed by node and VMID.
+// The VM/container lists carry no config digest, so an entry is reused while
+// the guest's list entry is unchanged and the entry is younger than the TTL.
+// Edits to the notes alone show up once the entry expires, at most one TTL
+// later.
+type configCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]configCacheEntry
+}
+
+type configCacheEntry struct {
+	signature string
+	config    *internal.ParsedConfig
+	fetchedAt time.Time
+}
+
+func newConfigCache(ttl time.Duration) *configCache {
+	return &configCache{
+		ttl:     ttl,
+		entries: make(map[string]configCacheEntry),
+	}
+}
+
+func (c *configCache) get(key, signature string) (*internal.ParsedConfig, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists || entry.signature != signature || time.Since(entry.fetchedAt) >= c.ttl {
+		return nil, false
+	}
+	return entry.config, true
+}
+
+func (c *configCache) put(key, signature string, config *internal.ParsedConfig) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = configCacheEntry{
+		signature: signature,
+		config:    config,
+		fetchedAt: time.Now(),
+	}
+}
+
+// guestSignature summarizes the list entry of a guest; a change means its
+// config must be refetched
+func guestSignature(name, status, tags string) string {
+	return strings.Join([]string{name, status, tags}, "|")
+}
+
+// getGuestConfig returns the cached guest config when still valid, otherwise
+// fetches it within the per-guest timeout and stores it in the cache
+func getGuestConfig(ctx context.Context, opts scanOptions, key, signature string, fetch func(ctx context.Context) (*internal.ParsedConfig, error)) (*internal.ParsedConfig, error) {
+	if config, cached := opts.configCache.get(key, signature); cached {
+		opts.logger.Debugf("Using cached config for %s", key)
+		return config, nil
+	}
+
+	guestCtx, cancel := opts.withGuestTimeout(ctx)
+	defer cancel()
+
+	config, err := fetch(guestCtx)
+	if err != nil {
+		return nil, err
+	}
+	opts.configCache.put(key, signature, config)
+	return config, nil
+}
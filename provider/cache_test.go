@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestConfigCache(t *testing.T) {
+	cache := newConfigCache(time.Minute)
+	config := internal.NewParsedConfig(map[string]interface{}{"description": "traefik.enable=true"})
+
+	if _, cached := cache.get("pve1/qemu/100", "a"); cached {
+		t.Error("Expected empty cache to miss")
+	}
+
+	cache.put("pve1/qemu/100", "a", config)
+	if got, cached := cache.get("pve1/qemu/100", "a"); !cached || got != config {
+		t.Error("Expected cache hit for unchanged signature")
+	}
+	if _, cached := cache.get("pve1/qemu/100", "b"); cached {
+		t.Error("Expected cache miss for changed signature")
+	}
+
+	expired := newConfigCache(0)
+	expired.put("pve1/qemu/100", "a", config)
+	if _, cached := expired.get("pve1/qemu/100", "a"); cached {
+		t.Error("Expected cache miss for expired entry")
+	}
+}
+
+func TestScanServicesUsesConfigCache(t *testing.T) {
+	userData := "#cloud-config\ntraefik_labels:\n  - traefik.http.services.vm.loadbalancer.server.port=8080\n"
+	responses := map[string]string{
+		"/nodes/pve1/qemu":                    `{"data":[{"vmid":100,"name":"vm","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config":         `{"data":{"description":"traefik.enable=true","ide2":"local-lvm:vm-100-cloudinit,media=cdrom"}}`,
+		"/nodes/pve1/qemu/100/cloudinit/dump": fmt.Sprintf(`{"data":%q}`, userData),
+	}
+	client, requested := newTestProxmoxServer(t, responses)
+	opts := scanOptions{cloudInitLabels: true, configCache: newConfigCache(time.Minute)}
+
+	countRequests := func(path string) int {
+		count := 0
+		for _, requestedPath := range *requested {
+			if requestedPath == "/api2/json"+path {
+				count++
+			}
+		}
+		return count
+	}
+
+	for i := 0; i < 2; i++ {
+		services, err := scanServices(client, context.Background(), "pve1", opts)
+		if err != nil {
+			t.Fatalf("scanServices() error = %v", err)
+		}
+		if len(services) != 1 || services[0].Config["traefik.http.services.vm.loadbalancer.server.port"] != "8080" {
+			t.Errorf("Expected the cloud-init labels to be merged, got %+v", services)
+		}
+	}
+	if count := countRequests("/nodes/pve1/qemu/100/config"); count != 1 {
+		t.Errorf("Expected config to be fetched once, got %d fetches", count)
+	}
+	if count := countRequests("/nodes/pve1/qemu/100/cloudinit/dump"); count != 1 {
+		t.Errorf("Expected user-data to be fetched once, got %d", count)
+	}
+
+	// A changed list entry triggers an immediate refetch
+	responses["/nodes/pve1/qemu"] = `{"data":[{"vmid":100,"name":"vm","status":"running","tags":"web"}]}`
+	responses["/nodes/pve1/qemu/100/config"] = `{"data":{"description":"traefik.enable=false","ide2":"local-lvm:vm-100-cloudinit,media=cdrom"}}`
+	services, err := scanServices(client, context.Background(), "pve1", opts)
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if count := countRequests("/nodes/pve1/qemu/100/config"); count != 2 {
+		t.Errorf("Expected config to be refetched after the list entry changed, got %d fetches", count)
+	}
+	if len(services) != 1 || services[0].Config["traefik.enable"] != "false" {
+		t.Errorf("Expected the edited label to show up, got %+v", services)
+	}
+}
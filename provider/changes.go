@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// Kinds of serviceChange
+const (
+	serviceAdded   = "added"
+	serviceRemoved = "removed"
+	serviceChanged = "changed"
+)
+
+// guestKey identifies a guest: VMIDs are unique in a cluster, but not
+// across the clusters scanned
+type guestKey struct {
+	cluster string
+	id      uint64
+}
+
+// guestSnapshot is what the provider saw of a guest during a poll
+type guestSnapshot struct {
+	cluster   string
+	node      string
+	name      string
+	guestType string
+	// signature covers everything the configuration is built from, a
+	// different signature meaning the guest changed
+	signature string
+}
+
+// serviceChange is a guest that appeared, disappeared or changed between two polls
+type serviceChange struct {
+	kind  string
+	id    uint64
+	guest guestSnapshot
+}
+
+// snapshotServices indexes the scanned guests by cluster and VMID
+func snapshotServices(servicesMap map[string][]internal.Service) map[guestKey]guestSnapshot {
+	snapshot := make(map[guestKey]guestSnapshot)
+	for nodeName, services := range servicesMap {
+		for _, service := range services {
+			signature, _ := json.Marshal(struct {
+				Node      string
+				Name      string
+				Status    string
+				IPs       []internal.IP
+				Labels    map[string]string
+				Pool      string
+				Onboot    bool
+				Protected bool
+			}{nodeName, service.Name, service.Status, service.IPs, service.Config, service.Pool, service.Onboot, service.Protected})
+			snapshot[guestKey{cluster: service.Cluster, id: service.ID}] = guestSnapshot{cluster: service.Cluster, node: nodeName, name: service.Name, guestType: service.Type, signature: string(signature)}
+		}
+	}
+	return snapshot
+}
+
+// diffServices returns the guests added, removed or changed from one poll
+// to the next, ordered by VMID and cluster
+func diffServices(previous, current map[guestKey]guestSnapshot) []serviceChange {
+	changes := make([]serviceChange, 0)
+	for key, guest := range current {
+		old, existed := previous[key]
+		switch {
+		case !existed:
+			changes = append(changes, serviceChange{kind: serviceAdded, id: key.id, guest: guest})
+		case old.signature != guest.signature:
+			changes = append(changes, serviceChange{kind: serviceChanged, id: key.id, guest: guest})
+		}
+	}
+	for key, guest := range previous {
+		if _, exists := current[key]; !exists {
+			changes = append(changes, serviceChange{kind: serviceRemoved, id: key.id, guest: guest})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].id != changes[j].id {
+			return changes[i].id < changes[j].id
+		}
+		return changes[i].guest.cluster < changes[j].guest.cluster
+	})
+	return changes
+}
+
+// logServiceChanges logs every guest added, removed or changed since the
+// previous poll and remembers the current guests for the next one. Nothing
+// is logged for the first poll.
+func (p *Provider) logServiceChanges(servicesMap map[string][]internal.Service) {
+	current := snapshotServices(servicesMap)
+
+	p.mu.Lock()
+	previous := p.lastServices
+	p.lastServices = current
+	p.mu.Unlock()
+
+	if previous == nil {
+		return
+	}
+	for _, change := range diffServices(previous, current) {
+		logger := p.logger.With("node", change.guest.node).With("vmid", change.id).With("service", change.guest.name).With("type", change.guest.guestType)
+		if change.guest.cluster != "" {
+			logger = logger.With("cluster", change.guest.cluster)
+		}
+		logger.Infof("Service %s (ID: %d) on node %s %s", change.guest.name, change.id, change.guest.node, change.kind)
+	}
+}
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestProviderLogServiceChanges(t *testing.T) {
+	var buf bytes.Buffer
+	logger := internal.NewLogger("info")
+	logger.SetOutput(&buf)
+	p := &Provider{logger: logger}
+
+	first := map[string][]internal.Service{
+		"pve1": {
+			{ID: 100, Name: "web", Status: "running", Config: map[string]string{"traefik.enable": "true"}},
+			{ID: 101, Name: "db", Status: "running", Config: map[string]string{"traefik.enable": "true"}},
+			{ID: 102, Name: "cache", Status: "running", Config: map[string]string{"traefik.enable": "true"}},
+		},
+	}
+	p.logServiceChanges(first)
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be logged for the first poll, got %q", buf.String())
+	}
+
+	second := map[string][]internal.Service{
+		"pve1": {
+			{ID: 100, Name: "web", Status: "running", Config: map[string]string{"traefik.enable": "true"}},
+			{ID: 102, Name: "cache", Status: "running", Config: map[string]string{"traefik.enable": "true"},
+				IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}},
+		},
+		"pve2": {
+			{ID: 103, Name: "api", Status: "running", Config: map[string]string{"traefik.enable": "true"}},
+		},
+	}
+	p.logServiceChanges(second)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{
+		"[INFO] Service db (ID: 101) on node pve1 removed",
+		"[INFO] Service cache (ID: 102) on node pve1 changed",
+		"[INFO] Service api (ID: 103) on node pve2 added",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d transitions, got %q", len(expected), buf.String())
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, expected[i]) {
+			t.Errorf("Expected line %d to end with %q, got %q", i, expected[i], line)
+		}
+	}
+
+	buf.Reset()
+	p.logServiceChanges(second)
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be logged without changes, got %q", buf.String())
+	}
+}
+
+func TestDiffServicesMigration(t *testing.T) {
+	previous := snapshotServices(map[string][]internal.Service{"pve1": {{ID: 100, Name: "web"}}})
+	current := snapshotServices(map[string][]internal.Service{"pve2": {{ID: 100, Name: "web"}}})
+
+	changes := diffServices(previous, current)
+	if len(changes) != 1 || changes[0].kind != serviceChanged || changes[0].guest.node != "pve2" {
+		t.Errorf("Expected a migrated guest to be reported as changed on its new node, got %+v", changes)
+	}
+}
+
+func TestProviderGenerateOnceTimedOutPollKeepsServices(t *testing.T) {
+	var mu sync.Mutex
+	slow := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/version":
+			fmt.Fprint(w, `{"data":{"release":"8.1"}}`)
+		case "/api2/json/nodes":
+			fmt.Fprint(w, `{"data":[{"node":"pve1"},{"node":"pve2"}]}`)
+		case "/api2/json/nodes/pve1/qemu":
+			fmt.Fprint(w, `{"data":[{"vmid":100,"name":"app","status":"running"}]}`)
+		case "/api2/json/nodes/pve1/qemu/100/config":
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.5"}}`)
+		case "/api2/json/nodes/pve2/qemu":
+			mu.Lock()
+			isSlow := slow
+			mu.Unlock()
+			if isSlow {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(2 * time.Second):
+				}
+			}
+			fmt.Fprint(w, `{"data":[{"vmid":200,"name":"other","status":"running"}]}`)
+		case "/api2/json/nodes/pve2/qemu/200/config":
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.7"}}`)
+		default:
+			fmt.Fprint(w, `{"data":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.ApiEndpoint = server.URL
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.PollInterval = "5s"
+	config.PollTimeout = "200ms"
+
+	p, err := New(context.Background(), config, "test-provider")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var logs bytes.Buffer
+	p.logger.SetOutput(&logs)
+
+	poll := func(slowNode bool) {
+		mu.Lock()
+		slow = slowNode
+		mu.Unlock()
+		if _, err := p.GenerateOnce(context.Background()); err != nil {
+			t.Fatalf("GenerateOnce() error = %v", err)
+		}
+	}
+
+	poll(false)
+	// pve2 does not answer before the poll timeout
+	poll(true)
+	if strings.Contains(logs.String(), "removed") {
+		t.Errorf("Expected the guests of the slow node not to be reported as removed, got %s", logs.String())
+	}
+	poll(false)
+	if strings.Contains(logs.String(), "added") {
+		t.Errorf("Expected the guests of the slow node not to be reported as added again, got %s", logs.String())
+	}
+}
@@ -0,0 +1,203 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// primaryClusterName names the cluster of apiEndpoint once additional
+// clusters are configured
+const primaryClusterName = "primary"
+
+// clusterSpec is an additional cluster of the clusters option
+type clusterSpec struct {
+	name      string
+	endpoint  string
+	tokenID   string
+	token     string
+	tokenFile string
+}
+
+// parseClusters parses the clusters option, one cluster per line given as
+// space-separated key=value fields: name, endpoint, tokenId and either
+// token or tokenFile. Blank lines and lines starting with # are ignored.
+func parseClusters(value string) ([]clusterSpec, error) {
+	specs := make([]clusterSpec, 0)
+	seen := map[string]bool{primaryClusterName: true}
+	for n, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var spec clusterSpec
+		for _, field := range strings.Fields(line) {
+			key, val, found := strings.Cut(field, "=")
+			if !found {
+				return nil, fmt.Errorf("line %d: expected key=value fields, got %q", n+1, field)
+			}
+			switch key {
+			case "name":
+				spec.name = val
+			case "endpoint":
+				spec.endpoint = val
+			case "tokenId":
+				spec.tokenID = val
+			case "token":
+				spec.token = val
+			case "tokenFile":
+				spec.tokenFile = val
+			default:
+				return nil, fmt.Errorf("line %d: unknown field %q, expected name, endpoint, tokenId, token or tokenFile", n+1, key)
+			}
+		}
+
+		if spec.name == "" {
+			return nil, fmt.Errorf("line %d: name must be set", n+1)
+		}
+		if seen[spec.name] {
+			return nil, fmt.Errorf("line %d: cluster name %q is used twice or reserved", n+1, spec.name)
+		}
+		seen[spec.name] = true
+
+		endpoints := internal.SplitEndpoints(spec.endpoint)
+		if len(endpoints) == 0 {
+			return nil, fmt.Errorf("cluster %s: endpoint must be set", spec.name)
+		}
+		for _, endpoint := range endpoints {
+			if err := validateEndpoint(endpoint); err != nil {
+				return nil, fmt.Errorf("cluster %s: %w", spec.name, err)
+			}
+		}
+		if err := validateTokenID(spec.tokenID); err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", spec.name, err)
+		}
+		if (spec.token == "") == (spec.tokenFile == "") {
+			return nil, fmt.Errorf("cluster %s: exactly one of token and tokenFile must be set", spec.name)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// cluster is a Proxmox cluster scanned by the provider. Each cluster has its
+// own client, so its own token, endpoint failover and rate limit.
+type cluster struct {
+	name   string
+	client *internal.ProxmoxClient
+}
+
+// newClusters creates the clients of the additional clusters, sharing the
+// API options of the primary cluster
+func newClusters(config *Config, specs []clusterSpec, metrics *Metrics) ([]cluster, error) {
+	clusters := make([]cluster, 0, len(specs))
+	for _, spec := range specs {
+		clusterConfig := *config
+		clusterConfig.ApiEndpoint = spec.endpoint
+		clusterConfig.ApiTokenId = spec.tokenID
+		clusterConfig.ApiToken = spec.token
+		clusterConfig.ApiTokenFile = spec.tokenFile
+		client, err := newAPIClient(&clusterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", spec.name, err)
+		}
+		if err := client.Logger.SetFormat(config.LogFormat); err != nil {
+			return nil, fmt.Errorf("invalid configuration: logFormat: %w", err)
+		}
+		client.Logger = client.Logger.With("cluster", spec.name)
+		if metrics != nil {
+			client.ResponseObserver = metrics.observeAPIRequest
+		}
+		clusters = append(clusters, cluster{name: spec.name, client: client})
+	}
+	return clusters, nil
+}
+
+// clusterScan is the outcome of scanning one cluster
+type clusterScan struct {
+	servicesMap map[string][]internal.Service
+	err         error
+	duration    time.Duration
+}
+
+// scanClusters scans the cluster of apiEndpoint and the additional
+// clusters, at most clusterConcurrency at a time, and merges their guests.
+// A failing cluster is logged and left out, so the poll only fails when
+// every cluster failed. Node names must be unique across clusters: a node
+// named like one of an earlier cluster is left out with a warning.
+func (p *Provider) scanClusters(ctx context.Context) (map[string][]internal.Service, error) {
+	if len(p.clusters) == 0 {
+		return getServiceMap(p.client, ctx, p.scanOptions)
+	}
+
+	clusters := append([]cluster{{name: primaryClusterName, client: p.client}}, p.clusters...)
+	scans := make([]clusterScan, len(clusters))
+	slots := make(chan struct{}, p.clusterConcurrency)
+	var wg sync.WaitGroup
+	for i, c := range clusters {
+		wg.Add(1)
+		go func(i int, c cluster) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				scans[i] = clusterScan{err: ctx.Err()}
+				return
+			}
+
+			opts := p.scanOptions
+			opts.logger = c.client.Logger
+			if c.name == primaryClusterName {
+				opts.logger = opts.logger.With("cluster", c.name)
+			}
+			start := time.Now()
+			servicesMap, err := getServiceMap(c.client, ctx, opts)
+			scans[i] = clusterScan{servicesMap: servicesMap, err: err, duration: time.Since(start)}
+		}(i, c)
+	}
+	wg.Wait()
+
+	merged := make(map[string][]internal.Service)
+	owners := make(map[string]string)
+	succeeded := 0
+	var firstErr error
+	for i, scan := range scans {
+		name := clusters[i].name
+		logger := p.logger.With("cluster", name)
+		if scan.err != nil {
+			logger.Errorf("Error scanning cluster %s, leaving out its services: %v", name, scan.err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("cluster %s: %w", name, scan.err)
+			}
+			continue
+		}
+		succeeded++
+
+		servicesCount := 0
+		for _, nodeName := range sortedNodeNames(scan.servicesMap) {
+			if owner, exists := owners[nodeName]; exists {
+				logger.Warnf("Node %s of cluster %s has the same name as a node of cluster %s, leaving out its services", nodeName, name, owner)
+				continue
+			}
+			services := scan.servicesMap[nodeName]
+			for j := range services {
+				services[j].Cluster = name
+			}
+			owners[nodeName] = name
+			merged[nodeName] = services
+			servicesCount += len(services)
+		}
+		logger.Debugf("Scanned cluster %s in %v: %d nodes, %d services", name, scan.duration.Round(time.Millisecond), len(scan.servicesMap), servicesCount)
+	}
+
+	if succeeded == 0 {
+		return nil, fmt.Errorf("error scanning every cluster: %w", firstErr)
+	}
+	return merged, nil
+}
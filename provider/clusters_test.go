@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProviderGenerateOnceClusters(t *testing.T) {
+	primary, _ := newTestProxmoxServer(t, map[string]string{
+		"/version":                    `{"data":{"release":"8.1"}}`,
+		"/nodes":                      `{"data":[{"node":"pve1"}]}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"app","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.5"}}`,
+	})
+	dr, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes":                      `{"data":[{"node":"dr1"},{"node":"pve1"}]}`,
+		"/nodes/dr1/qemu":             `{"data":[{"vmid":100,"name":"db","status":"running"}]}`,
+		"/nodes/dr1/qemu/100/config":  `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.1.0.5"}}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":101,"name":"shadow","status":"running"}]}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.1.0.6"}}`,
+	})
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "cluster unavailable", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	config := CreateConfig()
+	config.ApiEndpoint = strings.TrimSuffix(primary.BaseURL, "/api2/json")
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.Clusters = "name=dr endpoint=" + strings.TrimSuffix(dr.BaseURL, "/api2/json") + " tokenId=dr@pam!test token=dr-token\n" +
+		"# the lab cluster is down\n" +
+		"name=lab endpoint=" + broken.URL + " tokenId=lab@pam!test token=lab-token"
+	config.ClusterConcurrency = "2"
+
+	p, err := New(context.Background(), config, "test-provider")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if len(p.clusters) != 2 || p.clusterConcurrency != 2 {
+		t.Fatalf("Expected 2 additional clusters scanned 2 at a time, got %d and %d", len(p.clusters), p.clusterConcurrency)
+	}
+
+	servicesMap, err := p.scanClusters(context.Background())
+	if err != nil {
+		t.Fatalf("scanClusters() error = %v", err)
+	}
+	if len(servicesMap) != 2 {
+		t.Fatalf("Expected nodes pve1 and dr1, got %v", servicesMap)
+	}
+	if services := servicesMap["pve1"]; len(services) != 1 || services[0].Name != "app" || services[0].Cluster != primaryClusterName {
+		t.Errorf("Expected pve1 to be the node of the primary cluster, got %+v", services)
+	}
+	if services := servicesMap["dr1"]; len(services) != 1 || services[0].Name != "db" || services[0].Cluster != "dr" {
+		t.Errorf("Expected dr1 to be the node of the dr cluster, got %+v", services)
+	}
+
+	configuration, err := p.GenerateOnce(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateOnce() error = %v", err)
+	}
+	for _, name := range []string{"app-100", "db-100"} {
+		if _, exists := configuration.HTTP.Routers[name]; !exists {
+			t.Errorf("Expected router %s despite the failing cluster, got %v", name, configuration.HTTP.Routers)
+		}
+	}
+	if _, exists := configuration.HTTP.Routers["shadow-101"]; exists {
+		t.Error("Expected the guests of a node named like one of an earlier cluster to be left out")
+	}
+}
+
+func TestScanClustersEveryClusterFails(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "cluster unavailable", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	config := CreateConfig()
+	config.ApiEndpoint = broken.URL
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	specs, err := parseClusters("name=dr endpoint=" + broken.URL + " tokenId=dr@pam!test token=dr-token")
+	if err != nil {
+		t.Fatalf("parseClusters() error = %v", err)
+	}
+	clusters, err := newClusters(config, specs, nil)
+	if err != nil {
+		t.Fatalf("newClusters() error = %v", err)
+	}
+	client, err := newAPIClient(config)
+	if err != nil {
+		t.Fatalf("newAPIClient() error = %v", err)
+	}
+
+	p := &Provider{client: client, clusters: clusters, clusterConcurrency: 1, logger: client.Logger}
+	if _, err := p.scanClusters(context.Background()); err == nil || !strings.Contains(err.Error(), "error scanning every cluster") {
+		t.Errorf("Expected an error once every cluster failed, got %v", err)
+	}
+}
+
+func TestParseClustersErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "Not key=value", value: "name=dr https://dr:8006", expected: "expected key=value fields"},
+		{name: "Unknown field", value: "name=dr endpoint=https://dr:8006 tokenId=a@pam!t token=s secret=x", expected: `unknown field "secret"`},
+		{name: "Missing name", value: "endpoint=https://dr:8006 tokenId=a@pam!t token=s", expected: "name must be set"},
+		{name: "Reserved name", value: "name=primary endpoint=https://dr:8006 tokenId=a@pam!t token=s", expected: "used twice or reserved"},
+		{name: "Duplicate name", value: "name=dr endpoint=https://dr:8006 tokenId=a@pam!t token=s\nname=dr endpoint=https://dr2:8006 tokenId=a@pam!t token=s", expected: "line 2: cluster name \"dr\""},
+		{name: "Missing endpoint", value: "name=dr tokenId=a@pam!t token=s", expected: "cluster dr: endpoint must be set"},
+		{name: "Invalid token ID", value: "name=dr endpoint=https://dr:8006 tokenId=a token=s", expected: "cluster dr:"},
+		{name: "Token and token file", value: "name=dr endpoint=https://dr:8006 tokenId=a@pam!t token=s tokenFile=/run/secret", expected: "exactly one of token and tokenFile"},
+		{name: "No token", value: "name=dr endpoint=https://dr:8006 tokenId=a@pam!t", expected: "exactly one of token and tokenFile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseClusters(tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// controlGuest is a guest whose labels define cluster-wide objects, with
+// the node it was found on
+type controlGuest struct {
+	nodeName string
+	service  internal.Service
+}
+
+// isControlGuest reports whether the guest is named controlGuestName or
+// carries controlGuestTag
+func (o generateOptions) isControlGuest(service internal.Service) bool {
+	if o.controlGuestName != "" && strings.EqualFold(service.Name, o.controlGuestName) {
+		return true
+	}
+	if o.controlGuestTag != "" {
+		for _, tag := range service.Tags {
+			if strings.EqualFold(tag, o.controlGuestTag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isControlGuestEnabled reports whether a control guest also publishes its
+// own routers and services, which takes an explicit enable label whatever
+// exposedByDefault says
+func isControlGuestEnabled(service internal.Service, opts generateOptions) bool {
+	return service.Config[opts.labelPrefix+"enable"] == "true"
+}
+
+// addControlGuestObjects adds the middlewares, TLS stores and options and
+// servers transports of the control guests. They replace the objects of the
+// same name defined by other guests, whose owners are given; among control
+// guests the lowest VMID wins.
+func addControlGuestObjects(config *dynamic.Configuration, owners map[string]uint64, guests []controlGuest, opts generateOptions) {
+	controlOwners := make(map[string]uint64)
+	for _, guest := range guests {
+		service := guest.service
+		opts := opts.forGuest(guest.nodeName, service)
+		claim := func(name string) bool {
+			if !claimDefinition(controlOwners, name, service, opts) {
+				return false
+			}
+			if owner, exists := owners[name]; exists && owner != service.ID {
+				opts.logger.Warnf("Ignoring %s of guest %d, it is defined by control guest %s (ID: %d)", name, owner, service.Name, service.ID)
+			}
+			return true
+		}
+
+		for middlewareName, middleware := range getMiddlewares(service, opts) {
+			if claim("middleware " + middlewareName) {
+				config.HTTP.Middlewares[middlewareName] = middleware
+			}
+		}
+		for storeName, store := range getTLSStores(service, opts) {
+			if claim("TLS store " + storeName) {
+				config.TLS.Stores[storeName] = store
+			}
+		}
+		for optionsName, options := range getTLSOptions(service, opts) {
+			if claim("TLS options " + optionsName) {
+				config.TLS.Options[optionsName] = options
+			}
+		}
+		for transportName, transport := range getServersTransports(service, opts) {
+			if claim("servers transport " + transportName) {
+				config.HTTP.ServersTransports[transportName] = transport
+			}
+		}
+	}
+}
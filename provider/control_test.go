@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGenerateConfigurationControlGuest(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "web",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                    "true",
+					"traefik.http.routers.web.middlewares":              "secure",
+					"traefik.http.middlewares.secure.headers.framedeny": "false",
+				},
+			},
+			{
+				ID:   900,
+				Name: "traefik-control",
+				IPs:  []internal.IP{{Address: "10.0.0.9", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.http.routers.control.rule":                            "Host(`control.example.com`)",
+					"traefik.http.middlewares.secure.headers.framedeny":            "true",
+					"traefik.tls.options.modern.minversion":                        "VersionTLS12",
+					"traefik.http.serverstransports.selfsigned.insecureSkipVerify": "true",
+				},
+			},
+		},
+		"pve2": {
+			{
+				ID:   901,
+				Name: "edge-control",
+				Tags: []string{"traefik-control"},
+				IPs:  []internal.IP{{Address: "10.0.1.9", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                 "true",
+					"traefik.http.routers.edge.rule": "Host(`edge.example.com`)",
+					"traefik.http.middlewares.gzip.compress.minresponsebodybytes": "1024",
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix, exposedByDefault: true})
+	if _, exists := config.HTTP.Routers["control"]; !exists {
+		t.Fatalf("Expected guests to be published normally without control guests, got %v", sortedRouterNames(config.HTTP.Routers))
+	}
+
+	opts := generateOptions{
+		labelPrefix:      internal.DefaultLabelPrefix,
+		exposedByDefault: true,
+		controlGuestName: "traefik-control",
+		controlGuestTag:  "traefik-control",
+	}
+	config = generateConfiguration(servicesMap, opts)
+
+	// The control guest defines the global objects, replacing those of other guests
+	secure := config.HTTP.Middlewares["secure"]
+	if secure == nil || secure.Headers == nil || !secure.Headers.FrameDeny {
+		t.Errorf("Expected the secure middleware of the control guest, got %+v", secure)
+	}
+	if options, exists := config.TLS.Options["modern"]; !exists || options.MinVersion != "VersionTLS12" {
+		t.Errorf("Expected the TLS options of the control guest, got %+v", config.TLS.Options)
+	}
+	if transport := config.HTTP.ServersTransports["selfsigned"]; transport == nil || !transport.InsecureSkipVerify {
+		t.Errorf("Expected the servers transport of the control guest, got %+v", transport)
+	}
+	if _, exists := config.HTTP.Middlewares["gzip"]; !exists {
+		t.Errorf("Expected the middleware of the guest tagged as control guest, got %v", config.HTTP.Middlewares)
+	}
+
+	// Its routers and services are skipped unless it is explicitly enabled
+	if _, exists := config.HTTP.Routers["control"]; exists {
+		t.Error("Expected the routers of the control guest to be skipped")
+	}
+	if _, exists := config.HTTP.Services["traefik-control-900"]; exists {
+		t.Error("Expected the services of the control guest to be skipped")
+	}
+	if _, exists := config.HTTP.Routers["edge"]; !exists {
+		t.Errorf("Expected the enabled control guest to keep its routers, got %v", sortedRouterNames(config.HTTP.Routers))
+	}
+	if router := config.HTTP.Routers["web"]; router == nil || len(router.Middlewares) != 1 || router.Middlewares[0] != "secure" {
+		t.Errorf("Expected guests to reference the global middleware, got %+v", router)
+	}
+}
@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// getServerURLs returns the server URLs of a guest's load balancer. With
+// dualStack, a guest with usable addresses of both families gets the best
+// IPv4 and the best IPv6 address, each picked like the single address would
+// be among the addresses of its family. Otherwise, and when the guest sets
+// an explicit server url that is not a template or an explicit ip, there is
+// one server.
+func getServerURLs(service internal.Service, serviceName string, nodeName string, opts generateOptions) []string {
+	serverURL := getServiceURL(service, serviceName, nodeName, opts)
+	if !opts.dualStack || !opts.allowIPv6 {
+		return []string{serverURL}
+	}
+	serverPrefix := fmt.Sprintf("%shttp.services.%s.loadbalancer.server", opts.labelPrefix, serviceName)
+	if url, exists := service.Config[serverPrefix+".url"]; exists && !isServerURLTemplate(url) {
+		return []string{serverURL}
+	}
+	if _, exists := service.Config[serverPrefix+".ip"]; exists {
+		return []string{serverURL}
+	}
+	if _, found := getNodePort(service, serviceName, opts); found {
+		return []string{serverURL}
+	}
+
+	ipv4 := make([]internal.IP, 0)
+	ipv6 := make([]internal.IP, 0)
+	for _, ip := range service.IPs {
+		if !isUsableIP(ip, opts) {
+			continue
+		}
+		if isIPv6(ip) {
+			ipv6 = append(ipv6, ip)
+		} else {
+			ipv4 = append(ipv4, ip)
+		}
+	}
+	if len(ipv4) == 0 || len(ipv6) == 0 {
+		return []string{serverURL}
+	}
+
+	urls := make([]string, 0, 2)
+	for _, ips := range [][]internal.IP{ipv4, ipv6} {
+		family := service
+		family.IPs = ips
+		familyOpts := opts
+		// The selector may not pick any address of a family; its first one is used then
+		if _, found := selectGuestIP(family, opts); !found {
+			familyOpts.ipSelector = firstIPSelector{}
+		}
+		urls = append(urls, getServiceURL(family, serviceName, nodeName, familyOpts))
+	}
+	return urls
+}
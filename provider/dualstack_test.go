@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGenerateConfigurationDualStack(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"web","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.http.services.web.loadbalancer.server.port=8080"}}`,
+		"/nodes/pve1/qemu/100/agent/network-get-interfaces": `{"data":{"result":[` +
+			`{"name":"eth0","ip-addresses":[{"ip-address":"2001:db8::5","ip-address-type":"ipv6","prefix":64},{"ip-address":"10.0.0.5","ip-address-type":"ipv4","prefix":24}]},` +
+			`{"name":"eth1","ip-addresses":[{"ip-address":"192.168.1.5","ip-address-type":"ipv4","prefix":24},{"ip-address":"fd00::5","ip-address-type":"ipv6","prefix":64}]}]}}`,
+	})
+
+	services, err := scanServices(client, context.Background(), "pve1", scanOptions{})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	servicesMap := map[string][]internal.Service{"pve1": services}
+
+	tests := []struct {
+		name     string
+		opts     generateOptions
+		expected []string
+	}{
+		{
+			name:     "Single address",
+			opts:     generateOptions{allowIPv6: true},
+			expected: []string{"http://[2001:db8::5]:8080"},
+		},
+		{
+			name:     "One server per family",
+			opts:     generateOptions{allowIPv6: true, dualStack: true},
+			expected: []string{"http://10.0.0.5:8080", "http://[2001:db8::5]:8080"},
+		},
+		{
+			name:     "Preferences apply within each family",
+			opts:     generateOptions{allowIPv6: true, dualStack: true, preferInterfaces: []string{"eth1"}},
+			expected: []string{"http://192.168.1.5:8080", "http://[fd00::5]:8080"},
+		},
+		{
+			name:     "IPv6 disabled",
+			opts:     generateOptions{allowIPv6: false, dualStack: true},
+			expected: []string{"http://10.0.0.5:8080"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.labelPrefix = internal.DefaultLabelPrefix
+			config := generateConfiguration(servicesMap, tt.opts)
+			servers := config.HTTP.Services["web"].LoadBalancer.Servers
+			if len(servers) != len(tt.expected) {
+				t.Fatalf("Expected servers %v, got %+v", tt.expected, servers)
+			}
+			for i, server := range servers {
+				if server.URL != tt.expected[i] {
+					t.Errorf("Expected server %d to be %s, got %s", i, tt.expected[i], server.URL)
+				}
+			}
+		})
+	}
+}
+
+func TestGetServerURLsDualStackExplicitServer(t *testing.T) {
+	service := internal.Service{
+		ID:   100,
+		Name: "web",
+		IPs: []internal.IP{
+			{Address: "10.0.0.5", AddressType: "ipv4"},
+			{Address: "2001:db8::5", AddressType: "ipv6"},
+		},
+		Config: map[string]string{"traefik.http.services.web.loadbalancer.server.ip": "10.0.0.9"},
+	}
+	opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, allowIPv6: true, dualStack: true}
+	if urls := getServerURLs(service, "web", "pve1", opts); len(urls) != 1 || urls[0] != "http://10.0.0.9:80" {
+		t.Errorf("Expected the explicit server ip only, got %v", urls)
+	}
+}
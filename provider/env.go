@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"os"
+)
+
+// envOverrides lists the environment variables that take precedence over
+// the fields of the Config passed to New, so one plugin configuration can
+// be deployed to several environments
+var envOverrides = []struct {
+	name  string
+	field func(config *Config) *string
+}{
+	{"PROXMOX_API_ENDPOINT", func(config *Config) *string { return &config.ApiEndpoint }},
+	{"PROXMOX_API_TOKEN_ID", func(config *Config) *string { return &config.ApiTokenId }},
+	{"PROXMOX_API_TOKEN", func(config *Config) *string { return &config.ApiToken }},
+	{"PROXMOX_API_TOKEN_FILE", func(config *Config) *string { return &config.ApiTokenFile }},
+	{"PROXMOX_API_VALIDATE_SSL", func(config *Config) *string { return &config.ApiValidateSSL }},
+	{"PROXMOX_API_LOGGING", func(config *Config) *string { return &config.ApiLogging }},
+	{"PROXMOX_LOG_FORMAT", func(config *Config) *string { return &config.LogFormat }},
+	{"PROXMOX_POLL_INTERVAL", func(config *Config) *string { return &config.PollInterval }},
+	{"PROXMOX_POLL_TIMEOUT", func(config *Config) *string { return &config.PollTimeout }},
+	{"PROXMOX_INCLUDE_NODES", func(config *Config) *string { return &config.IncludeNodes }},
+	{"PROXMOX_EXCLUDE_NODES", func(config *Config) *string { return &config.ExcludeNodes }},
+	{"PROXMOX_CONSTRAINT_TAGS", func(config *Config) *string { return &config.ConstraintTags }},
+	{"PROXMOX_POOLS", func(config *Config) *string { return &config.Pools }},
+}
+
+// applyEnvOverrides returns a copy of config with the fields of the
+// environment variables in envOverrides that are set to a non-empty value
+// replaced, and the names of those variables. The passed config is left
+// unchanged.
+func applyEnvOverrides(config *Config) (*Config, []string) {
+	if config == nil {
+		return nil, nil
+	}
+
+	resolved := *config
+	var overridden []string
+	for _, override := range envOverrides {
+		value, exists := os.LookupEnv(override.name)
+		if !exists || value == "" {
+			continue
+		}
+		*override.field(&resolved) = value
+		overridden = append(overridden, override.name)
+	}
+	return &resolved, overridden
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	t.Setenv("PROXMOX_POLL_INTERVAL", "1m")
+	t.Setenv("PROXMOX_API_ENDPOINT", "https://pve.staging.example.com:8006")
+	t.Setenv("PROXMOX_INCLUDE_NODES", "")
+
+	config := CreateConfig()
+	config.ApiEndpoint = "https://pve.example.com:8006"
+	config.IncludeNodes = "pve1"
+
+	resolved, overridden := applyEnvOverrides(config)
+	if resolved.PollInterval != "1m" {
+		t.Errorf("Expected the poll interval of the environment, got %s", resolved.PollInterval)
+	}
+	if resolved.ApiEndpoint != "https://pve.staging.example.com:8006" {
+		t.Errorf("Expected the API endpoint of the environment, got %s", resolved.ApiEndpoint)
+	}
+	if resolved.IncludeNodes != "pve1" {
+		t.Errorf("Expected an empty variable to keep the configured value, got %q", resolved.IncludeNodes)
+	}
+	if strings.Join(overridden, ",") != "PROXMOX_API_ENDPOINT,PROXMOX_POLL_INTERVAL" {
+		t.Errorf("Expected the overridden variables to be reported, got %v", overridden)
+	}
+	if config.PollInterval != "30s" || config.ApiEndpoint != "https://pve.example.com:8006" {
+		t.Errorf("Expected the passed config to be left unchanged, got %+v", config)
+	}
+
+	if resolved, _ := applyEnvOverrides(nil); resolved != nil {
+		t.Errorf("Expected a nil config to stay nil, got %+v", resolved)
+	}
+}
+
+func TestNewEnvOverrides(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/version": `{"data":{"release":"8.1"}}`,
+	})
+
+	config := CreateConfig()
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+
+	// The endpoint only comes from the environment
+	t.Setenv("PROXMOX_API_ENDPOINT", strings.TrimSuffix(client.BaseURL, "/api2/json"))
+	t.Setenv("PROXMOX_POLL_INTERVAL", "45s")
+	p, err := New(context.Background(), config, "test")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.pollInterval != 45*time.Second {
+		t.Errorf("Expected the poll interval of the environment, got %v", p.pollInterval)
+	}
+
+	// Overrides are validated like the configuration
+	t.Setenv("PROXMOX_POLL_INTERVAL", "1s")
+	if _, err := New(context.Background(), config, "test"); err == nil || !strings.Contains(err.Error(), "at least 5 seconds") {
+		t.Errorf("Expected the overridden poll interval to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// getFailoverIPs returns the addresses of a guest with proxmox.failover in
+// priority order. The label lists the interfaces to use, primary first, or
+// is "true" to put the preferInterface addresses first and then all others.
+// It returns nil when the guest does not use failover or has an explicit
+// server address.
+func getFailoverIPs(service internal.Service, serviceName string, opts generateOptions) []internal.IP {
+	value, exists := service.Config[opts.labelPrefix+"proxmox.failover"]
+	if !exists || value == "" || value == "false" {
+		return nil
+	}
+	serverPrefix := fmt.Sprintf("%shttp.services.%s.loadbalancer.server", opts.labelPrefix, serviceName)
+	if url, exists := service.Config[serverPrefix+".url"]; exists && !isServerURLTemplate(url) {
+		return nil
+	}
+	if _, exists := service.Config[serverPrefix+".ip"]; exists {
+		return nil
+	}
+	if _, found := getNodePort(service, serviceName, opts); found {
+		return nil
+	}
+
+	interfaces := opts.preferInterfaces
+	if value != "true" {
+		interfaces = splitList(value)
+	}
+
+	ips := make([]internal.IP, 0)
+	seen := make(map[string]bool)
+	add := func(ip internal.IP) {
+		if isUsableIP(ip, opts) && !seen[ip.Address] {
+			seen[ip.Address] = true
+			ips = append(ips, ip)
+		}
+	}
+	for _, name := range interfaces {
+		for _, ip := range service.IPs {
+			if ip.Interface == name {
+				add(ip)
+			}
+		}
+	}
+	if value == "true" {
+		for _, ip := range service.IPs {
+			add(ip)
+		}
+	}
+	return ips
+}
+
+// addFailoverService publishes one load balancer per address of a guest,
+// named <service>-<vmid>-<index>, and chains them with failover services:
+// Traefik sends all requests to the first address and only moves on to the
+// next one while the health check marks the previous ones down.
+func addFailoverService(config *dynamic.Configuration, serviceName string, service internal.Service, nodeName string, loadBalancer *dynamic.ServersLoadBalancer, ips []internal.IP, opts generateOptions) {
+	if _, exists := config.HTTP.Services[serviceName]; exists {
+		opts.logger.Warnf("Service %s (ID: %d): service %s is already defined by another guest, ignoring this guest", service.Name, service.ID, serviceName)
+		return
+	}
+	if loadBalancer.HealthCheck == nil {
+		opts.logger.Warnf("Service %s (ID: %d): proxmox.failover needs %shttp.services.%s.loadbalancer.healthcheck.path to detect a failed address, requests will only use %s",
+			service.Name, service.ID, opts.labelPrefix, serviceName, ips[0].Address)
+	}
+
+	children := make([]string, 0, len(ips))
+	for i, ip := range ips {
+		single := service
+		single.IPs = []internal.IP{ip}
+		child := *loadBalancer
+		child.Servers = []dynamic.Server{{URL: getServiceURL(single, serviceName, nodeName, opts)}}
+
+		name := fmt.Sprintf("%s-%d-%d", serviceName, service.ID, i)
+		config.HTTP.Services[name] = &dynamic.Service{LoadBalancer: &child}
+		children = append(children, name)
+	}
+
+	// Chain from the last address, so each failover falls back to the next
+	fallback := children[len(children)-1]
+	for i := len(children) - 2; i > 0; i-- {
+		name := fmt.Sprintf("%s-%d-failover-%d", serviceName, service.ID, i)
+		config.HTTP.Services[name] = &dynamic.Service{
+			Failover: &dynamic.Failover{
+				Service:  children[i],
+				Fallback: fallback,
+				// Lets the parent failover see when this whole chain is down
+				HealthCheck: &dynamic.HealthCheck{},
+			},
+		}
+		fallback = name
+	}
+	config.HTTP.Services[serviceName] = &dynamic.Service{
+		Failover: &dynamic.Failover{
+			Service:  children[0],
+			Fallback: fallback,
+		},
+	}
+}
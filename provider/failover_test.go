@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGetFailoverIPs(t *testing.T) {
+	ips := []internal.IP{
+		{Address: "10.2.0.5", AddressType: "ipv4", Interface: "eth2"},
+		{Address: "10.0.0.5", AddressType: "ipv4", Interface: "eth0"},
+		{Address: "10.1.0.5", AddressType: "ipv4", Interface: "eth1"},
+	}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		opts     generateOptions
+		expected []string
+	}{
+		{name: "No label", labels: map[string]string{}},
+		{name: "Disabled", labels: map[string]string{"traefik.proxmox.failover": "false"}},
+		{
+			name:     "Interface order",
+			labels:   map[string]string{"traefik.proxmox.failover": "eth1, eth0"},
+			expected: []string{"10.1.0.5", "10.0.0.5"},
+		},
+		{
+			name:     "Preferred interfaces first",
+			labels:   map[string]string{"traefik.proxmox.failover": "true"},
+			opts:     generateOptions{preferInterfaces: []string{"eth0"}},
+			expected: []string{"10.0.0.5", "10.2.0.5", "10.1.0.5"},
+		},
+		{
+			name: "Explicit server address",
+			labels: map[string]string{
+				"traefik.proxmox.failover":                         "true",
+				"traefik.http.services.web.loadbalancer.server.ip": "10.9.0.5",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.labelPrefix = internal.DefaultLabelPrefix
+			service := internal.Service{ID: 100, Name: "web", Config: tt.labels, IPs: ips}
+
+			got := getFailoverIPs(service, "web", opts)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, got)
+			}
+			for i, address := range tt.expected {
+				if got[i].Address != address {
+					t.Errorf("Expected address %d to be %s, got %s", i, address, got[i].Address)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationFailover(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "web",
+				Config: map[string]string{
+					"traefik.enable":                                          "true",
+					"traefik.proxmox.failover":                                "eth0,eth1,eth2",
+					"traefik.http.routers.web.rule":                           "Host(`web.example.com`)",
+					"traefik.http.services.web.loadbalancer.server.port":      "8080",
+					"traefik.http.services.web.loadbalancer.healthcheck.path": "/health",
+				},
+				IPs: []internal.IP{
+					{Address: "10.1.0.5", AddressType: "ipv4", Interface: "eth1"},
+					{Address: "10.0.0.5", AddressType: "ipv4", Interface: "eth0"},
+					{Address: "10.2.0.5", AddressType: "ipv4", Interface: "eth2"},
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	if router := config.HTTP.Routers["web"]; router == nil || router.Service != "web" {
+		t.Fatalf("Expected the router to target web, got %+v", router)
+	}
+	top := config.HTTP.Services["web"]
+	if top == nil || top.Failover == nil || top.Failover.Service != "web-100-0" || top.Failover.Fallback != "web-100-failover-1" {
+		t.Fatalf("Expected web to fail over from web-100-0 to web-100-failover-1, got %+v", top)
+	}
+	nested := config.HTTP.Services["web-100-failover-1"]
+	if nested == nil || nested.Failover == nil || nested.Failover.Service != "web-100-1" || nested.Failover.Fallback != "web-100-2" {
+		t.Fatalf("Expected web-100-failover-1 to fail over from web-100-1 to web-100-2, got %+v", nested)
+	}
+	if nested.Failover.HealthCheck == nil {
+		t.Error("Expected the nested failover to propagate its health")
+	}
+
+	expected := map[string]string{"web-100-0": "http://10.0.0.5:8080", "web-100-1": "http://10.1.0.5:8080", "web-100-2": "http://10.2.0.5:8080"}
+	for name, url := range expected {
+		child := config.HTTP.Services[name]
+		if child == nil || child.LoadBalancer == nil || len(child.LoadBalancer.Servers) != 1 {
+			t.Fatalf("Expected a load balancer %s with one server, got %+v", name, child)
+		}
+		if child.LoadBalancer.Servers[0].URL != url {
+			t.Errorf("Expected %s to use %s, got %s", name, url, child.LoadBalancer.Servers[0].URL)
+		}
+		if child.LoadBalancer.HealthCheck == nil || child.LoadBalancer.HealthCheck.Path != "/health" {
+			t.Errorf("Expected %s to keep the health check, got %+v", name, child.LoadBalancer.HealthCheck)
+		}
+	}
+}
+
+func TestGenerateConfigurationFailoverSingleAddress(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:     100,
+				Name:   "web",
+				Config: map[string]string{"traefik.enable": "true", "traefik.proxmox.failover": "eth0,eth1"},
+				IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4", Interface: "eth0"}},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	// Without service labels the service is named after the guest
+	service := config.HTTP.Services["web-100"]
+	if service == nil || service.LoadBalancer == nil || service.Failover != nil {
+		t.Fatalf("Expected a plain load balancer with a single address, got %+v", service)
+	}
+}
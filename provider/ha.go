@@ -0,0 +1,48 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// HA manager states that matter for routing
+const (
+	haStateStarted = "started"
+	// haStateIgnored resources are left alone by the HA manager, like guests without HA
+	haStateIgnored = "ignored"
+)
+
+// getGuestHA maps the VMID of every guest managed by HA to its HA status
+func getGuestHA(client *internal.ProxmoxClient, ctx context.Context) (map[uint64]internal.HAStatus, error) {
+	status, err := client.GetHAStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	guestHA := make(map[uint64]internal.HAStatus)
+	for _, entry := range status {
+		if vmID, ok := entry.VMID(); ok {
+			guestHA[vmID] = entry
+		}
+	}
+	return guestHA, nil
+}
+
+// applyHAStatus records the HA state of a guest scanned on a node
+func (o scanOptions) applyHAStatus(service *internal.Service) {
+	if status, exists := o.guestHA[service.ID]; exists {
+		service.HAState = status.State
+		service.HANode = status.Node
+	}
+}
+
+// isActiveHAInstance reports whether a guest may be routed to: guests not
+// managed by HA always, HA guests only on the node where the HA resource is
+// started, so a fenced or migrating copy gets no traffic
+func isActiveHAInstance(service internal.Service, nodeName string) bool {
+	if service.HAState == "" || service.HAState == haStateIgnored {
+		return true
+	}
+	return service.HAState == haStateStarted && service.HANode == nodeName
+}
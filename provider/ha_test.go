@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGetServiceMapHAStatus(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes":                     `{"data":[{"node":"pve1"},{"node":"pve2"}]}`,
+		"/nodes/pve1/qemu":           `{"data":[{"vmid":100,"name":"app","status":"running"},{"vmid":101,"name":"plain","status":"running"}]}`,
+		"/nodes/pve2/qemu":           `{"data":[{"vmid":100,"name":"app","status":"running"}]}`,
+		"/cluster/ha/status/current": `{"data":[{"id":"quorum","type":"quorum","node":"pve1"},{"id":"service:vm:100","type":"service","sid":"vm:100","node":"pve2","state":"started"}]}`,
+	})
+
+	servicesMap, err := getServiceMap(client, context.Background(), scanOptions{haAware: true, disableGuestAgent: true})
+	if err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+
+	for _, service := range servicesMap["pve1"] {
+		switch service.ID {
+		case 100:
+			if service.HAState != "started" || service.HANode != "pve2" {
+				t.Errorf("Expected the HA state of VM 100, got %q on %q", service.HAState, service.HANode)
+			}
+		case 101:
+			if service.HAState != "" || service.HANode != "" {
+				t.Errorf("Expected no HA state for a guest without HA, got %q on %q", service.HAState, service.HANode)
+			}
+		}
+	}
+}
+
+func TestGenerateConfigurationHAActiveInstance(t *testing.T) {
+	newService := func(id uint64, name, ip, haState, haNode string) internal.Service {
+		return internal.Service{
+			ID:      id,
+			Name:    name,
+			IPs:     []internal.IP{{Address: ip, AddressType: "ipv4"}},
+			HAState: haState,
+			HANode:  haNode,
+			Config: map[string]string{
+				"traefik.enable": "true",
+				"traefik.http.services." + name + ".loadbalancer.server.port": "8080",
+			},
+		}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			newService(100, "app", "10.0.0.1", "fence", "pve2"),
+			newService(101, "plain", "10.0.0.3", "", ""),
+			newService(102, "ignored", "10.0.0.4", "ignored", "pve2"),
+		},
+		"pve2": {
+			newService(100, "app", "10.0.0.2", "started", "pve2"),
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	app := config.HTTP.Services["app"]
+	if app == nil || len(app.LoadBalancer.Servers) != 1 || app.LoadBalancer.Servers[0].URL != "http://10.0.0.2:8080" {
+		t.Errorf("Expected only the active HA instance as backend, got %+v", app)
+	}
+	for _, name := range []string{"plain", "ignored"} {
+		if _, exists := config.HTTP.Services[name]; !exists {
+			t.Errorf("Expected guest %s to be unaffected by HA filtering", name)
+		}
+	}
+
+	if isActiveHAInstance(internal.Service{HAState: "migrate", HANode: "pve1"}, "pve1") {
+		t.Error("Expected a migrating HA guest to get no traffic")
+	}
+}
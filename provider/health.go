@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/traefik/genconf/dynamic"
+)
+
+// selfServiceName names the router and service of the provider's own
+// health endpoints
+const selfServiceName = "traefik-proxmox-provider"
+
+// PollStatus describes the outcome of a poll of the Proxmox API
+type PollStatus struct {
+	// Time is when the poll finished, zero when no poll ran yet
+	Time time.Time
+	// Err is the error of a failed poll, nil when it succeeded
+	Err error
+	// ServicesDiscovered is the number of guests found by a successful poll
+	ServicesDiscovered int
+	// LabelErrors is the number of guests of a successful poll whose labels
+	// could not be parsed, such as an invalid label block; LabelErrors of the
+	// provider details them
+	LabelErrors int
+}
+
+// LastPollStatus returns the outcome of the most recent poll
+func (p *Provider) LastPollStatus() PollStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastPoll
+}
+
+// LastSuccessfulPollStatus returns the outcome of the most recent successful poll
+func (p *Provider) LastSuccessfulPollStatus() PollStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastSuccessfulPoll
+}
+
+// recordPoll stores the outcome of a poll
+func (p *Provider) recordPoll(err error, servicesDiscovered int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastPoll = PollStatus{Time: time.Now(), Err: err, ServicesDiscovered: servicesDiscovered}
+	if err == nil {
+		p.lastSuccessfulPoll = p.lastPoll
+	}
+}
+
+// checkReady reports whether the last poll succeeded within two poll
+// intervals and discovered at least one service, with the reason otherwise
+func (p *Provider) checkReady(now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last := p.lastSuccessfulPoll
+	switch {
+	case last.Time.IsZero():
+		return fmt.Errorf("no successful poll yet")
+	case now.Sub(last.Time) > 2*p.pollInterval:
+		return fmt.Errorf("last successful poll was %v ago", now.Sub(last.Time).Round(time.Second))
+	case last.ServicesDiscovered == 0:
+		return fmt.Errorf("no services discovered")
+	}
+	return nil
+}
+
+// HealthHandler serves /healthz, which answers as long as the process is up,
+// and /readyz, which fails while the provider is not producing configuration.
+func (p *Provider) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if err := p.checkReady(time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// startHealthServer serves the health endpoints on the configured address
+func (p *Provider) startHealthServer() error {
+	listener, err := net.Listen("tcp", p.healthAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on health address %s: %w", p.healthAddress, err)
+	}
+
+	p.healthServer = &http.Server{
+		Addr:              listener.Addr().String(),
+		Handler:           p.HealthHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := p.healthServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.logger.Errorf("Health server stopped: %v", err)
+		}
+	}()
+	p.logger.Infof("Serving health endpoints on %s", listener.Addr())
+	return nil
+}
+
+// addSelfRouter adds a router and service for the health server of the
+// provider, so it shows up in the dashboard like the guests do
+func (p *Provider) addSelfRouter(config *dynamic.Configuration) {
+	if _, exists := config.HTTP.Routers[selfServiceName]; exists {
+		p.logger.Warnf("Router %s is already defined by a guest, not adding the provider router", selfServiceName)
+		return
+	}
+	if _, exists := config.HTTP.Services[selfServiceName]; exists {
+		p.logger.Warnf("Service %s is already defined by a guest, not adding the provider router", selfServiceName)
+		return
+	}
+
+	address := p.healthAddress
+	if p.healthServer != nil {
+		// The bound address, which resolves a random port such as ":0"
+		address = p.healthServer.Addr
+	}
+
+	config.HTTP.Routers[selfServiceName] = &dynamic.Router{
+		Service:  selfServiceName,
+		Rule:     p.selfRouterRule,
+		Priority: defaultRouterPriority(p.selfRouterRule),
+	}
+	config.HTTP.Services[selfServiceName] = &dynamic.Service{
+		LoadBalancer: &dynamic.ServersLoadBalancer{
+			PassHostHeader: boolPtr(true),
+			Servers:        []dynamic.Server{{URL: selfServerURL(address)}},
+		},
+	}
+}
+
+// selfServerURL returns the URL Traefik reaches the health server at. The
+// provider runs inside Traefik, so wildcard addresses become loopback ones.
+func selfServerURL(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return buildServerURL("http", address, "80")
+	}
+	switch ip := net.ParseIP(host); {
+	case host == "":
+		host = "127.0.0.1"
+	case ip != nil && ip.IsUnspecified() && ip.To4() == nil:
+		host = "::1"
+	case ip != nil && ip.IsUnspecified():
+		host = "127.0.0.1"
+	}
+	return buildServerURL("http", host, port)
+}
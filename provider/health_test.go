@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/traefik/genconf/dynamic"
+)
+
+func TestProviderHealthHandler(t *testing.T) {
+	p := &Provider{pollInterval: 30 * time.Second}
+	handler := p.HealthHandler()
+
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to be OK, got %d", code)
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to fail before the first poll, got %d", code)
+	}
+
+	p.recordPoll(nil, 0)
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to fail without discovered services, got %d", code)
+	}
+
+	p.recordPoll(nil, 3)
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected /readyz to be OK after a successful poll, got %d", code)
+	}
+
+	// A failed poll keeps the provider ready until the last success is too old
+	p.recordPoll(errors.New("connection refused"), 0)
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected /readyz to stay OK right after a failed poll, got %d", code)
+	}
+	if err := p.checkReady(time.Now().Add(time.Minute + time.Second)); err == nil {
+		t.Error("Expected the provider not to be ready two poll intervals after the last success")
+	}
+}
+
+func TestProviderSelfRouter(t *testing.T) {
+	generate := func(healthAddress, rule string) *dynamic.Configuration {
+		config := CreateConfig()
+		config.FixtureFile = "../examples/fixture.json"
+		config.HealthAddress = healthAddress
+		config.SelfRouterRule = rule
+		p, err := New(context.Background(), config, "test")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		configuration, err := p.GenerateOnce(context.Background())
+		if err != nil {
+			t.Fatalf("GenerateOnce() error = %v", err)
+		}
+		return configuration
+	}
+
+	configuration := generate(":8082", "")
+	if _, exists := configuration.HTTP.Routers[selfServiceName]; exists {
+		t.Error("Expected no provider router without selfRouterRule")
+	}
+	if _, exists := configuration.HTTP.Services[selfServiceName]; exists {
+		t.Error("Expected no provider service without selfRouterRule")
+	}
+
+	configuration = generate(":8082", "Host(`proxmox-provider.localhost`)")
+	router := configuration.HTTP.Routers[selfServiceName]
+	if router == nil || router.Rule != "Host(`proxmox-provider.localhost`)" || router.Service != selfServiceName {
+		t.Fatalf("Expected the provider router, got %+v", router)
+	}
+	service := configuration.HTTP.Services[selfServiceName]
+	if service == nil || service.LoadBalancer == nil || len(service.LoadBalancer.Servers) != 1 {
+		t.Fatalf("Expected the provider service with one server, got %+v", service)
+	}
+	if url := service.LoadBalancer.Servers[0].URL; url != "http://127.0.0.1:8082" {
+		t.Errorf("Expected the health server URL, got %s", url)
+	}
+
+	config := CreateConfig()
+	config.FixtureFile = "../examples/fixture.json"
+	config.SelfRouterRule = "Host(`proxmox-provider.localhost`)"
+	if _, err := New(context.Background(), config, "test"); err == nil {
+		t.Error("Expected an error for selfRouterRule without healthAddress")
+	}
+}
+
+func TestSelfServerURL(t *testing.T) {
+	tests := map[string]string{
+		":8082":          "http://127.0.0.1:8082",
+		"0.0.0.0:8082":   "http://127.0.0.1:8082",
+		"[::]:8082":      "http://[::1]:8082",
+		"10.0.0.2:8082":  "http://10.0.0.2:8082",
+		"localhost:8082": "http://localhost:8082",
+	}
+	for address, expected := range tests {
+		if url := selfServerURL(address); url != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, address, url)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// Names of the built-in selectors of the ipSelector option
+const (
+	ipSelectorFirst     = "first"
+	ipSelectorCIDR      = "cidr"
+	ipSelectorInterface = "interface"
+)
+
+// IPSelector picks the backend address of a guest among its usable IPs,
+// given in the order Proxmox reports them. It reports false when none of
+// them fits, in which case the hostname fallback is used.
+type IPSelector interface {
+	SelectIP(ips []internal.IP) (internal.IP, bool)
+}
+
+// firstIPSelector picks the first address, the default
+type firstIPSelector struct{}
+
+func (firstIPSelector) SelectIP(ips []internal.IP) (internal.IP, bool) {
+	if len(ips) == 0 {
+		return internal.IP{}, false
+	}
+	return ips[0], true
+}
+
+// cidrIPSelector picks the first address inside the networks, trying the
+// networks in order
+type cidrIPSelector struct {
+	networks []*net.IPNet
+}
+
+func (s cidrIPSelector) SelectIP(ips []internal.IP) (internal.IP, bool) {
+	for _, network := range s.networks {
+		for _, ip := range ips {
+			if parsed := net.ParseIP(ip.Address); parsed != nil && network.Contains(parsed) {
+				return ip, true
+			}
+		}
+	}
+	return internal.IP{}, false
+}
+
+// interfaceIPSelector picks the first address of the interfaces, trying the
+// interfaces in order
+type interfaceIPSelector struct {
+	interfaces []string
+}
+
+func (s interfaceIPSelector) SelectIP(ips []internal.IP) (internal.IP, bool) {
+	for _, name := range s.interfaces {
+		for _, ip := range ips {
+			if ip.Interface == name {
+				return ip, true
+			}
+		}
+	}
+	return internal.IP{}, false
+}
+
+// parseIPSelector builds the selector named by the ipSelector option:
+// "first", "cidr:<network>,..." or "interface:<name>,..."
+func parseIPSelector(value string) (IPSelector, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(value), ":")
+	switch strings.TrimSpace(name) {
+	case "", ipSelectorFirst:
+		if strings.TrimSpace(args) != "" {
+			return nil, fmt.Errorf("%s takes no arguments, got %q", ipSelectorFirst, value)
+		}
+		return firstIPSelector{}, nil
+	case ipSelectorCIDR:
+		networks := make([]*net.IPNet, 0)
+		for _, cidr := range splitList(args) {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+			}
+			networks = append(networks, network)
+		}
+		if len(networks) == 0 {
+			return nil, fmt.Errorf("%s needs at least one network, e.g. %s:10.0.0.0/8", ipSelectorCIDR, ipSelectorCIDR)
+		}
+		return cidrIPSelector{networks: networks}, nil
+	case ipSelectorInterface:
+		interfaces := splitList(args)
+		if len(interfaces) == 0 {
+			return nil, fmt.Errorf("%s needs at least one interface, e.g. %s:eth0", ipSelectorInterface, ipSelectorInterface)
+		}
+		return interfaceIPSelector{interfaces: interfaces}, nil
+	default:
+		return nil, fmt.Errorf("unknown selector %q, expected %s, %s or %s", name, ipSelectorFirst, ipSelectorCIDR, ipSelectorInterface)
+	}
+}
+
+// selectGuestIP returns the address the IP selector picks among the usable
+// IPs of a guest
+func selectGuestIP(service internal.Service, opts generateOptions) (internal.IP, bool) {
+	usable := make([]internal.IP, 0, len(service.IPs))
+	for _, ip := range service.IPs {
+		if isUsableIP(ip, opts) {
+			usable = append(usable, ip)
+		}
+	}
+
+	selector := opts.ipSelector
+	if selector == nil {
+		selector = firstIPSelector{}
+	}
+	return selector.SelectIP(usable)
+}
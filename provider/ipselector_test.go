@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestIPSelectors(t *testing.T) {
+	ips := []internal.IP{
+		{Address: "100.64.0.5", AddressType: "ipv4", Interface: "tailscale0"},
+		{Address: "10.0.0.5", AddressType: "ipv4", Interface: "eth0"},
+		{Address: "192.168.1.5", AddressType: "ipv4", Interface: "eth1"},
+		{Address: "fd00::5", AddressType: "ipv6", Interface: "eth1"},
+	}
+
+	tests := []struct {
+		name     string
+		selector string
+		ips      []internal.IP
+		expected string
+		found    bool
+	}{
+		{name: "First", selector: "first", ips: ips, expected: "100.64.0.5", found: true},
+		{name: "Empty option is first", selector: "", ips: ips, expected: "100.64.0.5", found: true},
+		{name: "First without addresses", selector: "first", ips: nil, found: false},
+		{name: "CIDR", selector: "cidr:192.168.0.0/16", ips: ips, expected: "192.168.1.5", found: true},
+		{name: "CIDR networks in order", selector: "cidr:fd00::/8, 10.0.0.0/8", ips: ips, expected: "fd00::5", found: true},
+		{name: "CIDR without a match", selector: "cidr:172.16.0.0/12", ips: ips, found: false},
+		{name: "Interface", selector: "interface:eth1", ips: ips, expected: "192.168.1.5", found: true},
+		{name: "Interfaces in order", selector: "interface:eth2,eth0", ips: ips, expected: "10.0.0.5", found: true},
+		{name: "Interface without a match", selector: "interface:eth2", ips: ips, found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := parseIPSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("parseIPSelector(%q) error = %v", tt.selector, err)
+			}
+			ip, found := selector.SelectIP(tt.ips)
+			if found != tt.found {
+				t.Fatalf("Expected found=%v, got %v (%+v)", tt.found, found, ip)
+			}
+			if ip.Address != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, ip.Address)
+			}
+		})
+	}
+}
+
+func TestParseIPSelectorInvalid(t *testing.T) {
+	for _, value := range []string{"lowest", "first:eth0", "cidr", "cidr:10.0.0.5", "interface:", "interface: , "} {
+		if _, err := parseIPSelector(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestGetServiceURLIPSelector(t *testing.T) {
+	service := internal.Service{
+		ID:   100,
+		Name: "app",
+		IPs: []internal.IP{
+			{Address: "100.64.0.5", AddressType: "ipv4", Interface: "tailscale0"},
+			{Address: "10.0.0.5", AddressType: "ipv4", Interface: "eth0"},
+		},
+		Config: map[string]string{"traefik.enable": "true"},
+	}
+
+	selector, err := parseIPSelector("cidr:10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("parseIPSelector() error = %v", err)
+	}
+	opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, ipSelector: selector}
+	if url := getServiceURL(service, "app", "pve1", opts); url != "http://10.0.0.5:80" {
+		t.Errorf("Expected the address in 10.0.0.0/8, got %s", url)
+	}
+
+	// Preferences are checked before the selector
+	opts.preferInterfaces = []string{"tailscale0"}
+	if url := getServiceURL(service, "app", "pve1", opts); url != "http://100.64.0.5:80" {
+		t.Errorf("Expected the preferred interface to win, got %s", url)
+	}
+
+	// Without a matching address the hostname is used
+	selector, err = parseIPSelector("cidr:172.16.0.0/12")
+	if err != nil {
+		t.Fatalf("parseIPSelector() error = %v", err)
+	}
+	opts = generateOptions{labelPrefix: internal.DefaultLabelPrefix, ipSelector: selector}
+	if url := getServiceURL(service, "app", "pve1", opts); url != "http://app.pve1:80" {
+		t.Errorf("Expected the hostname fallback, got %s", url)
+	}
+	if hasRoutableAddress(service, opts) {
+		t.Error("Expected no routable address when the selector matches none")
+	}
+}
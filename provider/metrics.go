@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the poll duration histogram buckets, in seconds
+var pollDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics collects scan health metrics and exposes them in the Prometheus
+// text exposition format. It implements http.Handler so it can be mounted
+// on any mux or scraped through a registry that accepts text collectors.
+type Metrics struct {
+	mu                 sync.Mutex
+	lastSuccessfulPoll time.Time
+	pollCount          uint64
+	pollSum            float64
+	pollBuckets        []uint64
+	servicesDiscovered int
+	nodeScanErrors     map[string]uint64
+	apiRequests        map[string]uint64
+}
+
+// NewMetrics creates an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		pollBuckets:    make([]uint64, len(pollDurationBuckets)),
+		nodeScanErrors: make(map[string]uint64),
+		apiRequests:    make(map[string]uint64),
+	}
+}
+
+// observePoll records the duration of a poll and, when successful, its result
+func (m *Metrics) observePoll(duration time.Duration, servicesDiscovered int, success bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seconds := duration.Seconds()
+	m.pollCount++
+	m.pollSum += seconds
+	for i, bound := range pollDurationBuckets {
+		if seconds <= bound {
+			m.pollBuckets[i]++
+		}
+	}
+
+	if success {
+		m.lastSuccessfulPoll = time.Now()
+		m.servicesDiscovered = servicesDiscovered
+	}
+}
+
+// observeNodeScanError records a failed scan of a node
+func (m *Metrics) observeNodeScanError(nodeName string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodeScanErrors[nodeName]++
+}
+
+// observeAPIRequest records an API request by status code, 0 meaning no response
+func (m *Metrics) observeAPIRequest(statusCode int) {
+	if m == nil {
+		return
+	}
+	code := "error"
+	if statusCode > 0 {
+		code = strconv.Itoa(statusCode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apiRequests[code]++
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var lastPoll float64
+	if !m.lastSuccessfulPoll.IsZero() {
+		lastPoll = float64(m.lastSuccessfulPoll.UnixNano()) / float64(time.Second)
+	}
+
+	writeHeader(w, "traefik_proxmox_last_successful_poll_timestamp_seconds", "gauge", "Unix timestamp of the last successful poll.")
+	fmt.Fprintf(w, "traefik_proxmox_last_successful_poll_timestamp_seconds %s\n", formatFloat(lastPoll))
+
+	writeHeader(w, "traefik_proxmox_poll_duration_seconds", "histogram", "Duration of polls of the Proxmox API.")
+	for i, bound := range pollDurationBuckets {
+		fmt.Fprintf(w, "traefik_proxmox_poll_duration_seconds_bucket{le=\"%s\"} %d\n", formatFloat(bound), m.pollBuckets[i])
+	}
+	fmt.Fprintf(w, "traefik_proxmox_poll_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.pollCount)
+	fmt.Fprintf(w, "traefik_proxmox_poll_duration_seconds_sum %s\n", formatFloat(m.pollSum))
+	fmt.Fprintf(w, "traefik_proxmox_poll_duration_seconds_count %d\n", m.pollCount)
+
+	writeHeader(w, "traefik_proxmox_services_discovered", "gauge", "Number of services discovered during the last successful poll.")
+	fmt.Fprintf(w, "traefik_proxmox_services_discovered %d\n", m.servicesDiscovered)
+
+	writeHeader(w, "traefik_proxmox_node_scan_errors_total", "counter", "Number of failed node scans.")
+	for _, node := range sortedKeys(m.nodeScanErrors) {
+		fmt.Fprintf(w, "traefik_proxmox_node_scan_errors_total{node=%q} %d\n", node, m.nodeScanErrors[node])
+	}
+
+	writeHeader(w, "traefik_proxmox_api_requests_total", "counter", "Number of Proxmox API requests by status code.")
+	for _, code := range sortedKeys(m.apiRequests) {
+		_, err := fmt.Fprintf(w, "traefik_proxmox_api_requests_total{code=%q} %d\n", code, m.apiRequests[code])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
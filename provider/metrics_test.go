@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWritePrometheus(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observePoll(300*time.Millisecond, 4, true)
+	metrics.observePoll(2*time.Second, 0, false)
+	metrics.observeNodeScanError("pve2")
+	metrics.observeAPIRequest(200)
+	metrics.observeAPIRequest(200)
+	metrics.observeAPIRequest(500)
+	metrics.observeAPIRequest(0)
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	output := recorder.Body.String()
+
+	expected := []string{
+		`traefik_proxmox_poll_duration_seconds_bucket{le="0.25"} 0`,
+		`traefik_proxmox_poll_duration_seconds_bucket{le="0.5"} 1`,
+		`traefik_proxmox_poll_duration_seconds_bucket{le="+Inf"} 2`,
+		`traefik_proxmox_poll_duration_seconds_count 2`,
+		`traefik_proxmox_services_discovered 4`,
+		`traefik_proxmox_node_scan_errors_total{node="pve2"} 1`,
+		`traefik_proxmox_api_requests_total{code="200"} 2`,
+		`traefik_proxmox_api_requests_total{code="500"} 1`,
+		`traefik_proxmox_api_requests_total{code="error"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected metrics output to contain %q, got:\n%s", line, output)
+		}
+	}
+	if strings.Contains(output, "traefik_proxmox_last_successful_poll_timestamp_seconds 0\n") {
+		t.Error("Expected last successful poll timestamp to be set")
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var metrics *Metrics
+	metrics.observePoll(time.Second, 1, true)
+	metrics.observeNodeScanError("pve1")
+	metrics.observeAPIRequest(200)
+}
+
+func TestMetricsAPIRequests(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes": `{"data":[{"node":"pve1"}]}`,
+	})
+	metrics := NewMetrics()
+	client.ResponseObserver = metrics.observeAPIRequest
+
+	if _, err := getServiceMap(client, context.Background(), scanOptions{metrics: metrics}); err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+
+	if metrics.apiRequests["200"] != 3 {
+		t.Errorf("Expected 3 successful API requests, got %d", metrics.apiRequests["200"])
+	}
+}
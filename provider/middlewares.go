@@ -0,0 +1,200 @@
+package provider
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// Build middlewares declared with http.middlewares labels, such as
+// traefik.http.middlewares.<name>.headers.stsseconds=31536000. The segments
+// after the name follow the Traefik dynamic configuration and are matched
+// case-insensitively. Invalid labels are logged and skipped.
+func getMiddlewares(service internal.Service, opts generateOptions) map[string]*dynamic.Middleware {
+	middlewares := make(map[string]*dynamic.Middleware)
+	prefix := strings.ToLower(opts.labelPrefix + "http.middlewares.")
+
+	for _, key := range sortedLabelKeys(service.Config) {
+		if !strings.HasPrefix(strings.ToLower(key), prefix) {
+			continue
+		}
+		name, option, found := strings.Cut(key[len(prefix):], ".")
+		if !found || name == "" {
+			continue
+		}
+
+		middleware, exists := middlewares[name]
+		if !exists {
+			middleware = &dynamic.Middleware{}
+		}
+		if err := setLabelValue(reflect.ValueOf(middleware).Elem(), strings.Split(option, "."), service.Config[key]); err != nil {
+			opts.logger.Warnf("Service %s (ID: %d): ignoring label %s: %v", service.Name, service.ID, key, err)
+			continue
+		}
+		middlewares[name] = middleware
+	}
+
+	return middlewares
+}
+
+// Warn about routers referencing middlewares that no guest defines. Names
+// qualified with a provider (e.g. auth@file) are resolved by Traefik itself.
+func checkMiddlewareReferences(config *dynamic.Configuration, opts generateOptions) {
+	for _, routerName := range sortedRouterNames(config.HTTP.Routers) {
+		for _, name := range config.HTTP.Routers[routerName].Middlewares {
+			if strings.Contains(name, "@") {
+				continue
+			}
+			if _, exists := config.HTTP.Middlewares[name]; !exists && !opts.isStaticMiddleware(name) {
+				opts.logger.Warnf("Router %s references middleware %s, which is not defined on any guest", routerName, name)
+			}
+		}
+	}
+}
+
+// appendDefaultMiddlewares appends the defaultMiddlewares a router does not
+// list yet after its own middlewares, so they run last
+func appendDefaultMiddlewares(middlewares, defaults []string) []string {
+	for _, name := range defaults {
+		if !containsString(middlewares, name) {
+			middlewares = append(middlewares, name)
+		}
+	}
+	return middlewares
+}
+
+// setLabelValue stores a label value into the field of v designated by path,
+// allocating pointers and maps on the way and converting the value to the
+// field type. Lists are comma-separated.
+func setLabelValue(v reflect.Value, path []string, value string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setLabelValue(v.Elem(), path, value)
+	case reflect.Struct:
+		if len(path) == 0 {
+			return fmt.Errorf("missing option")
+		}
+		field, found := findLabelField(v, path[0])
+		if !found {
+			return fmt.Errorf("unknown option %q", path[0])
+		}
+		return setLabelValue(field, path[1:], value)
+	case reflect.Map:
+		if len(path) == 0 {
+			return fmt.Errorf("missing key")
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.ValueOf(path[0])
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := setLabelValue(elem, path[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	case reflect.Interface:
+		if len(path) == 0 {
+			v.Set(reflect.ValueOf(value))
+			return nil
+		}
+		nested, ok := v.Interface().(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+		}
+		nestedValue := reflect.ValueOf(nested)
+		if err := setLabelValue(nestedValue, path, value); err != nil {
+			return err
+		}
+		v.Set(nestedValue)
+		return nil
+	}
+
+	if len(path) > 0 {
+		return fmt.Errorf("unexpected option %q", path[0])
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", value)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		items := splitList(value)
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setLabelValue(slice.Index(i), nil, item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported option type %s", v.Type())
+	}
+	return nil
+}
+
+// findLabelField returns the struct field matching name, compared
+// case-insensitively against both the Go field name and its JSON name
+func findLabelField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if strings.EqualFold(field.Name, name) || strings.EqualFold(jsonName, name) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func sortedLabelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedRouterNames(routers map[string]*dynamic.Router) []string {
+	names := make([]string, 0, len(routers))
+	for name := range routers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
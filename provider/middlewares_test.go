@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGetMiddlewares(t *testing.T) {
+	service := internal.Service{
+		ID:   100,
+		Name: "shared",
+		Config: map[string]string{
+			"traefik.http.middlewares.secure-headers.headers.stsSeconds":                        "31536000",
+			"traefik.http.middlewares.secure-headers.headers.framedeny":                         "true",
+			"traefik.http.middlewares.secure-headers.headers.customresponseheaders.X-Served-By": "proxmox",
+			"traefik.http.middlewares.strip.stripprefix.prefixes":                               "/api, /v1",
+			"traefik.http.middlewares.limit.ratelimit.average":                                  "100",
+			"traefik.http.middlewares.limit.ratelimit.sourcecriterion.ipstrategy.depth":         "2",
+			"traefik.http.middlewares.broken.headers.stsseconds":                                "forever",
+			"traefik.http.middlewares.unknown.nosuchmiddleware.option":                          "x",
+		},
+	}
+
+	middlewares := getMiddlewares(service, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	headers := middlewares["secure-headers"]
+	if headers == nil || headers.Headers == nil {
+		t.Fatalf("Expected the secure-headers middleware, got %v", middlewares)
+	}
+	if headers.Headers.STSSeconds != 31536000 || !headers.Headers.FrameDeny {
+		t.Errorf("Unexpected headers %+v", headers.Headers)
+	}
+	if headers.Headers.CustomResponseHeaders["X-Served-By"] != "proxmox" {
+		t.Errorf("Expected the custom response header to keep its case, got %v", headers.Headers.CustomResponseHeaders)
+	}
+
+	if strip := middlewares["strip"]; strip == nil || len(strip.StripPrefix.Prefixes) != 2 || strip.StripPrefix.Prefixes[1] != "/v1" {
+		t.Errorf("Unexpected stripprefix middleware %+v", strip)
+	}
+
+	limit := middlewares["limit"]
+	if limit == nil || limit.RateLimit.Average != 100 || limit.RateLimit.SourceCriterion.IPStrategy.Depth != 2 {
+		t.Errorf("Unexpected ratelimit middleware %+v", limit)
+	}
+
+	for _, name := range []string{"broken", "unknown"} {
+		if _, exists := middlewares[name]; exists {
+			t.Errorf("Expected invalid middleware %s to be skipped", name)
+		}
+	}
+}
+
+func TestGenerateConfigurationSharedMiddleware(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "middlewares",
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.http.middlewares.secure-headers.headers.framedeny": "true",
+				},
+			},
+		},
+		"pve2": {
+			{
+				ID:   200,
+				Name: "app",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                       "true",
+					"traefik.http.routers.app.rule":        "Host(`app.example.com`)",
+					"traefik.http.routers.app.middlewares": "secure-headers, auth@file",
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	middleware, exists := config.HTTP.Middlewares["secure-headers"]
+	if !exists || middleware.Headers == nil || !middleware.Headers.FrameDeny {
+		t.Fatalf("Expected the shared middleware to be generated, got %v", config.HTTP.Middlewares)
+	}
+	router := config.HTTP.Routers["app"]
+	if router == nil || len(router.Middlewares) != 2 || router.Middlewares[0] != "secure-headers" || router.Middlewares[1] != "auth@file" {
+		t.Errorf("Expected the router to reference the shared middleware, got %+v", router)
+	}
+}
+
+func TestRouterMiddlewareOrder(t *testing.T) {
+	blockConfig, err := (&internal.ParsedConfig{
+		Description: "### traefik-config\n" +
+			"traefik:\n" +
+			"  enable: true\n" +
+			"  http:\n" +
+			"    routers:\n" +
+			"      app:\n" +
+			"        rule: \"Host(`app.example.com`)\"\n" +
+			"        middlewares:\n" +
+			"          - ratelimit\n" +
+			"          - auth\n" +
+			"          - compress\n" +
+			"###",
+	}).GetTraefikMap(internal.DefaultLabelPrefix, internal.DefaultLabelSeparator)
+	if err != nil {
+		t.Fatalf("GetTraefikMap() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		config   map[string]string
+		expected []string
+	}{
+		{
+			name: "Auth before rate limit",
+			config: map[string]string{
+				"traefik.enable":                       "true",
+				"traefik.http.routers.app.rule":        "Host(`app.example.com`)",
+				"traefik.http.routers.app.middlewares": "auth, ratelimit,compress",
+			},
+			expected: []string{"auth", "ratelimit", "compress"},
+		},
+		{
+			name: "Rate limit before auth",
+			config: map[string]string{
+				"traefik.enable":                       "true",
+				"traefik.http.routers.app.rule":        "Host(`app.example.com`)",
+				"traefik.http.routers.app.middlewares": "compress,ratelimit , auth",
+			},
+			expected: []string{"compress", "ratelimit", "auth"},
+		},
+		{
+			name:     "Label block list",
+			config:   blockConfig,
+			expected: []string{"ratelimit", "auth", "compress"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servicesMap := map[string][]internal.Service{
+				"pve1": {{ID: 100, Name: "app", IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, Config: tt.config}},
+			}
+			config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+			middlewares := config.HTTP.Routers["app"].Middlewares
+			if strings.Join(middlewares, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected middlewares %v in this order, got %v", tt.expected, middlewares)
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationDefaultMiddlewares(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:     100,
+				Name:   "plain",
+				IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+			{
+				ID:   101,
+				Name: "auth",
+				IPs:  []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                        "true",
+					"traefik.http.routers.auth.rule":        "Host(`auth.example.com`)",
+					"traefik.http.routers.auth.middlewares": "sso@file, compress@file",
+				},
+			},
+		},
+	}
+	opts := generateOptions{
+		labelPrefix:        internal.DefaultLabelPrefix,
+		defaultMiddlewares: splitList("compress@file, secure-headers@file"),
+	}
+
+	config := generateConfiguration(servicesMap, opts)
+
+	plain := config.HTTP.Routers["plain-100"]
+	if plain == nil {
+		t.Fatalf("Expected a router for the plain guest, got %v", config.HTTP.Routers)
+	}
+	if got := strings.Join(plain.Middlewares, ","); got != "compress@file,secure-headers@file" {
+		t.Errorf("Expected the default middlewares on a router without middlewares, got %s", got)
+	}
+
+	// Defaults come after the router's own middlewares and are not repeated
+	if got := strings.Join(config.HTTP.Routers["auth"].Middlewares, ","); got != "sso@file,compress@file,secure-headers@file" {
+		t.Errorf("Expected the defaults after the router middlewares, got %s", got)
+	}
+}
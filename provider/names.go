@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"strings"
+
+	"github.com/traefik/genconf/dynamic"
+)
+
+// prefixObjectNames prepends prefix to the names of the HTTP and TCP routers
+// and services of config, so several provider instances can publish guests
+// with the same names. References to the renamed services follow; names
+// qualified with a provider (e.g. api@internal) and services defined
+// elsewhere are left alone.
+func prefixObjectNames(config *dynamic.Configuration, prefix string) {
+	if prefix == "" {
+		return
+	}
+
+	httpServices := make(map[string]*dynamic.Service, len(config.HTTP.Services))
+	for name, service := range config.HTTP.Services {
+		httpServices[prefix+name] = service
+	}
+	httpName := func(name string) string {
+		if strings.Contains(name, "@") {
+			return name
+		}
+		if _, exists := config.HTTP.Services[name]; !exists {
+			return name
+		}
+		return prefix + name
+	}
+	for _, service := range httpServices {
+		if service.Weighted != nil {
+			for i := range service.Weighted.Services {
+				service.Weighted.Services[i].Name = httpName(service.Weighted.Services[i].Name)
+			}
+		}
+		if service.Mirroring != nil {
+			service.Mirroring.Service = httpName(service.Mirroring.Service)
+			for i := range service.Mirroring.Mirrors {
+				service.Mirroring.Mirrors[i].Name = httpName(service.Mirroring.Mirrors[i].Name)
+			}
+		}
+		if service.Failover != nil {
+			service.Failover.Service = httpName(service.Failover.Service)
+			service.Failover.Fallback = httpName(service.Failover.Fallback)
+		}
+	}
+	httpRouters := make(map[string]*dynamic.Router, len(config.HTTP.Routers))
+	for name, router := range config.HTTP.Routers {
+		router.Service = httpName(router.Service)
+		httpRouters[prefix+name] = router
+	}
+	config.HTTP.Services = httpServices
+	config.HTTP.Routers = httpRouters
+
+	tcpServices := make(map[string]*dynamic.TCPService, len(config.TCP.Services))
+	for name, service := range config.TCP.Services {
+		tcpServices[prefix+name] = service
+	}
+	tcpName := func(name string) string {
+		if strings.Contains(name, "@") {
+			return name
+		}
+		if _, exists := config.TCP.Services[name]; !exists {
+			return name
+		}
+		return prefix + name
+	}
+	for _, service := range tcpServices {
+		if service.Weighted != nil {
+			for i := range service.Weighted.Services {
+				service.Weighted.Services[i].Name = tcpName(service.Weighted.Services[i].Name)
+			}
+		}
+	}
+	tcpRouters := make(map[string]*dynamic.TCPRouter, len(config.TCP.Routers))
+	for name, router := range config.TCP.Routers {
+		router.Service = tcpName(router.Service)
+		tcpRouters[prefix+name] = router
+	}
+	config.TCP.Services = tcpServices
+	config.TCP.Routers = tcpRouters
+}
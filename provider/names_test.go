@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+func TestPrefixObjectNames(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "web",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                   "true",
+					"traefik.http.routers.web.rule":                    "Host(`web.example.com`)",
+					"traefik.http.routers.api.rule":                    "Host(`api.example.com`)",
+					"traefik.http.routers.api.service":                 "api@internal",
+					"traefik.tcp.routers.db.rule":                      "HostSNI(`*`)",
+					"traefik.tcp.services.db.loadbalancer.server.port": "5432",
+				},
+			},
+		},
+	}
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+	prefixObjectNames(config, "proxmox-prod-")
+
+	router, exists := config.HTTP.Routers["proxmox-prod-web"]
+	if !exists {
+		t.Fatalf("Expected router proxmox-prod-web, got %v", sortedRouterNames(config.HTTP.Routers))
+	}
+	if router.Service != "proxmox-prod-web-100" {
+		t.Errorf("Expected the router to point to proxmox-prod-web-100, got %s", router.Service)
+	}
+	if _, exists := config.HTTP.Services["proxmox-prod-web-100"]; !exists {
+		t.Error("Expected service proxmox-prod-web-100")
+	}
+	if router := config.HTTP.Routers["proxmox-prod-api"]; router == nil || router.Service != "api@internal" {
+		t.Errorf("Expected services of other providers to be kept, got %+v", router)
+	}
+	if router := config.TCP.Routers["proxmox-prod-db"]; router == nil || router.Service != "proxmox-prod-db" {
+		t.Errorf("Expected the TCP router to point to proxmox-prod-db, got %+v", router)
+	}
+	if _, exists := config.TCP.Services["proxmox-prod-db"]; !exists {
+		t.Error("Expected TCP service proxmox-prod-db")
+	}
+}
+
+func TestPrefixObjectNamesWeighted(t *testing.T) {
+	config := &dynamic.Configuration{
+		HTTP: &dynamic.HTTPConfiguration{
+			Routers: map[string]*dynamic.Router{"app": {Service: "app"}},
+			Services: map[string]*dynamic.Service{
+				"app":      {Weighted: &dynamic.WeightedRoundRobin{Services: []dynamic.WRRService{{Name: "app-blue"}, {Name: "app-green@file"}}}},
+				"app-blue": {LoadBalancer: &dynamic.ServersLoadBalancer{}},
+			},
+		},
+		TCP: &dynamic.TCPConfiguration{},
+	}
+	prefixObjectNames(config, "dr-")
+
+	weighted := config.HTTP.Services["dr-app"].Weighted.Services
+	if weighted[0].Name != "dr-app-blue" || weighted[1].Name != "app-green@file" {
+		t.Errorf("Expected weighted references to follow the renamed services, got %+v", weighted)
+	}
+}
+
+func TestProviderGenerateOncePrefixNames(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/version":                    `{"data":{"release":"8.1"}}`,
+		"/nodes":                      `{"data":[{"node":"pve1"}]}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"web","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.5"}}`,
+	})
+
+	config := CreateConfig()
+	config.ApiEndpoint = strings.TrimSuffix(client.BaseURL, "/api2/json")
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.PollInterval = "5s"
+
+	for _, tt := range []struct {
+		prefixNames string
+		expected    string
+	}{
+		{prefixNames: "false", expected: "web-100"},
+		{prefixNames: "true", expected: "proxmox-prod-web-100"},
+	} {
+		config.PrefixNames = tt.prefixNames
+		p, err := New(context.Background(), config, "proxmox-prod")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		configuration, err := p.GenerateOnce(context.Background())
+		if err != nil {
+			t.Fatalf("GenerateOnce() error = %v", err)
+		}
+		if _, exists := configuration.HTTP.Routers[tt.expected]; !exists {
+			t.Errorf("prefixNames=%s: expected router %s, got %v", tt.prefixNames, tt.expected, sortedRouterNames(configuration.HTTP.Routers))
+		}
+		if _, exists := configuration.HTTP.Services[tt.expected]; !exists {
+			t.Errorf("prefixNames=%s: expected service %s", tt.prefixNames, tt.expected)
+		}
+	}
+
+	config.PrefixNames = "true"
+	if _, err := New(context.Background(), config, ""); err == nil {
+		t.Error("Expected an error for prefixNames without a provider name")
+	}
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// nodeHeaderName is the request header telling backends the node of their guest
+const nodeHeaderName = "X-Proxmox-Node"
+
+// vmidHeaderName is the request header telling backends the VMID of their guest
+const vmidHeaderName = "X-Proxmox-VMID"
+
+// nodeHeaderMiddlewareName names the headers middleware of a node
+func nodeHeaderMiddlewareName(nodeName string) string {
+	return "proxmox-node-" + nodeName
+}
+
+// addNodeHeader chains the headers middleware of the guest's node to a
+// router, defining the middleware once per node, so requests reach the
+// backend with the X-Proxmox-Node header
+func addNodeHeader(config *dynamic.Configuration, router *dynamic.Router, nodeName string, opts generateOptions) {
+	if !opts.nodeHeaderInjection {
+		return
+	}
+
+	name := nodeHeaderMiddlewareName(nodeName)
+	if _, exists := config.HTTP.Middlewares[name]; !exists {
+		config.HTTP.Middlewares[name] = &dynamic.Middleware{
+			Headers: &dynamic.Headers{
+				CustomRequestHeaders: map[string]string{nodeHeaderName: nodeName},
+			},
+		}
+	}
+	if !containsString(router.Middlewares, name) {
+		router.Middlewares = append(router.Middlewares, name)
+	}
+}
+
+// guestHeadersMiddlewareName names the headers middleware of a guest. The
+// node is part of the name since VMIDs are only unique within a cluster.
+func guestHeadersMiddlewareName(service internal.Service, nodeName string) string {
+	return fmt.Sprintf("proxmox-guest-%s-%d", nodeName, service.ID)
+}
+
+// addGuestHeaders chains the headers middleware of a guest to one of its
+// routers, defining it with the first router, so requests reach the backend
+// with the X-Proxmox-VMID and X-Proxmox-Node headers of the guest
+func addGuestHeaders(config *dynamic.Configuration, router *dynamic.Router, service internal.Service, nodeName string, opts generateOptions) {
+	if !opts.guestHeaderInjection {
+		return
+	}
+
+	name := guestHeadersMiddlewareName(service, nodeName)
+	if _, exists := config.HTTP.Middlewares[name]; !exists {
+		config.HTTP.Middlewares[name] = &dynamic.Middleware{
+			Headers: &dynamic.Headers{
+				CustomRequestHeaders: map[string]string{
+					vmidHeaderName: strconv.FormatUint(service.ID, 10),
+					nodeHeaderName: nodeName,
+				},
+			},
+		}
+	}
+	if !containsString(router.Middlewares, name) {
+		router.Middlewares = append(router.Middlewares, name)
+	}
+}
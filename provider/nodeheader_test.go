@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGenerateConfigurationNodeHeaderInjection(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:     100,
+				Name:   "web",
+				IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+			{
+				ID:     101,
+				Name:   "api",
+				IPs:    []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+		"pve2": {
+			{
+				ID:     200,
+				Name:   "db",
+				IPs:    []internal.IP{{Address: "10.0.0.7", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+	if len(config.HTTP.Middlewares) != 0 {
+		t.Errorf("Expected no middlewares without nodeHeaderInjection, got %v", config.HTTP.Middlewares)
+	}
+
+	opts := generateOptions{
+		labelPrefix:         internal.DefaultLabelPrefix,
+		nodeHeaderInjection: true,
+		defaultMiddlewares:  []string{"auth@file"},
+	}
+	config = generateConfiguration(servicesMap, opts)
+	if len(config.HTTP.Middlewares) != 2 {
+		t.Fatalf("Expected one middleware per node, got %v", config.HTTP.Middlewares)
+	}
+	for _, nodeName := range []string{"pve1", "pve2"} {
+		middleware := config.HTTP.Middlewares["proxmox-node-"+nodeName]
+		if middleware == nil || middleware.Headers == nil {
+			t.Fatalf("Expected a headers middleware for %s, got %+v", nodeName, middleware)
+		}
+		if value := middleware.Headers.CustomRequestHeaders["X-Proxmox-Node"]; value != nodeName {
+			t.Errorf("Expected X-Proxmox-Node to be %s, got %q", nodeName, value)
+		}
+	}
+
+	for routerName, nodeName := range map[string]string{"web-100": "pve1", "api-101": "pve1", "db-200": "pve2"} {
+		router := config.HTTP.Routers[routerName]
+		if router == nil {
+			t.Fatalf("Expected router %s, got %v", routerName, sortedRouterNames(config.HTTP.Routers))
+		}
+		expected := []string{"proxmox-node-" + nodeName, "auth@file"}
+		if len(router.Middlewares) != len(expected) || router.Middlewares[0] != expected[0] || router.Middlewares[1] != expected[1] {
+			t.Errorf("Expected router %s to have middlewares %v, got %v", routerName, expected, router.Middlewares)
+		}
+	}
+}
+
+func TestGenerateConfigurationGuestHeaderInjection(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "web",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                     "true",
+					"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+					"traefik.http.routers.web-admin.rule":                "Host(`admin.example.com`)",
+					"traefik.http.routers.web.service":                   "web",
+					"traefik.http.routers.web-admin.service":             "web",
+					"traefik.http.services.web.loadbalancer.server.port": "8080",
+				},
+			},
+		},
+		"pve2": {
+			{
+				ID:     200,
+				Name:   "db",
+				IPs:    []internal.IP{{Address: "10.0.0.7", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+	if len(config.HTTP.Middlewares) != 0 {
+		t.Errorf("Expected no middlewares without guestHeaderInjection, got %v", config.HTTP.Middlewares)
+	}
+
+	opts := generateOptions{
+		labelPrefix:          internal.DefaultLabelPrefix,
+		guestHeaderInjection: true,
+		defaultMiddlewares:   []string{"auth@file"},
+	}
+	config = generateConfiguration(servicesMap, opts)
+	if len(config.HTTP.Middlewares) != 2 {
+		t.Fatalf("Expected one middleware per guest, got %v", config.HTTP.Middlewares)
+	}
+	for name, headers := range map[string][2]string{"proxmox-guest-pve1-100": {"100", "pve1"}, "proxmox-guest-pve2-200": {"200", "pve2"}} {
+		middleware := config.HTTP.Middlewares[name]
+		if middleware == nil || middleware.Headers == nil {
+			t.Fatalf("Expected a headers middleware %s, got %+v", name, middleware)
+		}
+		if vmid := middleware.Headers.CustomRequestHeaders["X-Proxmox-VMID"]; vmid != headers[0] {
+			t.Errorf("Expected X-Proxmox-VMID of %s to be %s, got %q", name, headers[0], vmid)
+		}
+		if node := middleware.Headers.CustomRequestHeaders["X-Proxmox-Node"]; node != headers[1] {
+			t.Errorf("Expected X-Proxmox-Node of %s to be %s, got %q", name, headers[1], node)
+		}
+	}
+
+	for routerName, middlewareName := range map[string]string{"web": "proxmox-guest-pve1-100", "web-admin": "proxmox-guest-pve1-100", "db-200": "proxmox-guest-pve2-200"} {
+		router := config.HTTP.Routers[routerName]
+		if router == nil {
+			t.Fatalf("Expected router %s, got %v", routerName, sortedRouterNames(config.HTTP.Routers))
+		}
+		expected := []string{middlewareName, "auth@file"}
+		if len(router.Middlewares) != len(expected) || router.Middlewares[0] != expected[0] || router.Middlewares[1] != expected[1] {
+			t.Errorf("Expected router %s to have middlewares %v, got %v", routerName, expected, router.Middlewares)
+		}
+	}
+}
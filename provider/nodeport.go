@@ -0,0 +1,88 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// parseNodeAddresses parses the nodeAddresses option, comma-separated
+// node=address entries
+func parseNodeAddresses(value string) (map[string]string, error) {
+	addresses := make(map[string]string)
+	for _, entry := range splitList(value) {
+		node, address, found := strings.Cut(entry, "=")
+		node, address = strings.TrimSpace(node), strings.TrimSpace(address)
+		if !found || node == "" || address == "" {
+			return nil, fmt.Errorf("nodeAddresses entry %q must have the form node=address", entry)
+		}
+		addresses[node] = address
+	}
+	return addresses, nil
+}
+
+// getNodePort returns the traefik.proxmox.nodeport label of a guest, the
+// port forwarded to it on its node. An explicit server ip label of the
+// service takes precedence, so the node port does not apply then.
+func getNodePort(service internal.Service, serviceName string, opts generateOptions) (string, bool) {
+	port, exists := service.Config[opts.labelPrefix+"proxmox.nodeport"]
+	if !exists || !isValidPort(port) {
+		return "", false
+	}
+	if _, exists := service.Config[fmt.Sprintf("%shttp.services.%s.loadbalancer.server.ip", opts.labelPrefix, serviceName)]; exists {
+		return "", false
+	}
+	return strings.TrimSpace(port), true
+}
+
+// getNodeHost returns the address of the node of a guest published through
+// a node port, falling back to the node name when it is unknown
+func getNodeHost(service internal.Service, nodeName string, opts generateOptions) string {
+	if service.NodeAddress != "" {
+		return service.NodeAddress
+	}
+	opts.logger.Debugf("No address known for node %s, using its name for service %s (ID: %d)", nodeName, service.Name, service.ID)
+	return nodeName
+}
+
+// resolveNodeAddresses sets the node address of the guests with a nodeport
+// label, from nodeAddresses or else from the cluster status, which is only
+// read when a node is missing from nodeAddresses
+func resolveNodeAddresses(client *internal.ProxmoxClient, ctx context.Context, servicesMap map[string][]internal.Service, opts scanOptions) {
+	addresses := opts.nodeAddresses
+	statusRead := false
+	for _, nodeName := range sortedNodeNames(servicesMap) {
+		services := servicesMap[nodeName]
+		for i := range services {
+			if _, exists := services[i].Config[opts.labelPrefix+"proxmox.nodeport"]; !exists {
+				continue
+			}
+			if _, known := addresses[nodeName]; !known && !statusRead {
+				statusRead = true
+				addresses = getNodeAddresses(client, ctx, opts)
+			}
+			services[i].NodeAddress = addresses[nodeName]
+		}
+	}
+}
+
+// getNodeAddresses maps the nodes of the cluster status to their address,
+// the entries of nodeAddresses taking precedence
+func getNodeAddresses(client *internal.ProxmoxClient, ctx context.Context, opts scanOptions) map[string]string {
+	addresses := make(map[string]string)
+	status, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		opts.logger.Warnf("Error reading the cluster status, guests with a nodeport label on nodes missing from nodeAddresses use the node name: %v", err)
+	}
+	for _, entry := range status {
+		if entry.Type == "node" && entry.IP != "" {
+			addresses[entry.Name] = entry.IP
+		}
+	}
+	for node, address := range opts.nodeAddresses {
+		addresses[node] = address
+	}
+	return addresses
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGenerateConfigurationNodePort(t *testing.T) {
+	client, requested := newTestProxmoxServer(t, map[string]string{
+		"/nodes":                      `{"data":[{"node":"pve1"},{"node":"pve2"}]}`,
+		"/cluster/status":             `{"data":[{"id":"cluster","type":"cluster","name":"homelab"},{"id":"node/pve1","type":"node","name":"pve1","ip":"192.168.1.10"},{"id":"node/pve2","type":"node","name":"pve2","ip":"192.168.1.11"}]}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"nas","status":"running"},{"vmid":101,"name":"web","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.10.0.5\ntraefik.proxmox.nodeport=8080\ntraefik.http.services.nas.loadbalancer.server.port=5000"}}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.6"}}`,
+		"/nodes/pve2/lxc":             `{"data":[{"vmid":200,"name":"media","status":"running"}]}`,
+		"/nodes/pve2/lxc/200/config":  `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.10.0.7\ntraefik.proxmox.nodeport=9000"}}`,
+	})
+
+	opts := scanOptions{
+		labelPrefix:       internal.DefaultLabelPrefix,
+		disableGuestAgent: true,
+		nodeAddresses:     map[string]string{"pve2": "nat.example.com"},
+	}
+	servicesMap, err := getServiceMap(client, context.Background(), opts)
+	if err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+	expected := map[string]string{
+		// The node address of the cluster status replaces the guest IP, and the node port its port
+		"nas": "http://192.168.1.10:8080",
+		// Guests without the label keep their own address
+		"web-101": "http://10.0.0.6:80",
+		// Configured node addresses take precedence over the cluster status
+		"media-200": "http://nat.example.com:9000",
+	}
+	for name, url := range expected {
+		service := config.HTTP.Services[name]
+		if service == nil || len(service.LoadBalancer.Servers) != 1 || service.LoadBalancer.Servers[0].URL != url {
+			t.Errorf("Expected service %s to point to %s, got %+v", name, url, service)
+		}
+	}
+
+	statusRequests := 0
+	for _, path := range *requested {
+		if path == "/api2/json/cluster/status" {
+			statusRequests++
+		}
+	}
+	if statusRequests != 1 {
+		t.Errorf("Expected the cluster status to be read once, got %d requests", statusRequests)
+	}
+}
+
+func TestGetServiceMapNodePortConfiguredOnly(t *testing.T) {
+	client, requested := newTestProxmoxServer(t, map[string]string{
+		"/nodes":                      `{"data":[{"node":"pve1"}]}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"nas","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.nodeport=8080"}}`,
+	})
+
+	opts := scanOptions{labelPrefix: internal.DefaultLabelPrefix, disableGuestAgent: true, nodeAddresses: map[string]string{"pve1": "192.168.1.10"}}
+	servicesMap, err := getServiceMap(client, context.Background(), opts)
+	if err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+	if address := servicesMap["pve1"][0].NodeAddress; address != "192.168.1.10" {
+		t.Errorf("Expected the configured node address, got %q", address)
+	}
+	if containsString(*requested, "/api2/json/cluster/status") {
+		t.Error("Expected the cluster status not to be read when every node address is configured")
+	}
+}
+
+func TestParseNodeAddresses(t *testing.T) {
+	addresses, err := parseNodeAddresses("pve1=192.168.1.10, pve2 = nat.example.com")
+	if err != nil {
+		t.Fatalf("parseNodeAddresses() error = %v", err)
+	}
+	if len(addresses) != 2 || addresses["pve1"] != "192.168.1.10" || addresses["pve2"] != "nat.example.com" {
+		t.Errorf("Unexpected node addresses %v", addresses)
+	}
+
+	for _, value := range []string{"pve1", "pve1=", "=192.168.1.10"} {
+		if _, err := parseNodeAddresses(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// appPreset is the backend port and scheme of a well-known app
+type appPreset struct {
+	Port   string
+	Scheme string
+}
+
+// defaultAppPresets are the apps known to the traefik.proxmox.app label
+var defaultAppPresets = map[string]appPreset{
+	"gitea":         {Port: "3000", Scheme: "http"},
+	"grafana":       {Port: "3000", Scheme: "http"},
+	"homeassistant": {Port: "8123", Scheme: "http"},
+	"jellyfin":      {Port: "8096", Scheme: "http"},
+	"nextcloud":     {Port: "443", Scheme: "https"},
+	"pbs":           {Port: "8007", Scheme: "https"},
+	"portainer":     {Port: "9443", Scheme: "https"},
+	"uptimekuma":    {Port: "3001", Scheme: "http"},
+	"vaultwarden":   {Port: "80", Scheme: "http"},
+}
+
+// parseAppPresets merges the appPresets option, a comma-separated list of
+// name=port or name=port/scheme entries, over the default presets
+func parseAppPresets(value string) (map[string]appPreset, error) {
+	presets := make(map[string]appPreset, len(defaultAppPresets))
+	for name, preset := range defaultAppPresets {
+		presets[name] = preset
+	}
+
+	for _, entry := range splitList(value) {
+		name, target, found := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !found || name == "" {
+			return nil, fmt.Errorf("appPresets entry %q must have the form name=port or name=port/scheme", entry)
+		}
+		port, scheme, hasScheme := strings.Cut(strings.TrimSpace(target), "/")
+		if !hasScheme {
+			scheme = "http"
+		}
+		if !isValidPort(port) || (scheme != "http" && scheme != "https") {
+			return nil, fmt.Errorf("appPresets entry %q must have a port between 1 and 65535 and an http or https scheme", entry)
+		}
+		presets[name] = appPreset{Port: port, Scheme: scheme}
+	}
+	return presets, nil
+}
+
+// getAppPreset returns the preset named by the traefik.proxmox.app label of a guest
+func getAppPreset(service internal.Service, opts generateOptions) (appPreset, bool) {
+	app, exists := service.Config[opts.labelPrefix+"proxmox.app"]
+	if !exists {
+		return appPreset{}, false
+	}
+
+	preset, found := opts.presets()[strings.ToLower(strings.TrimSpace(app))]
+	return preset, found
+}
+
+// warnUnknownApp logs a guest whose traefik.proxmox.app label names no preset
+func warnUnknownApp(service internal.Service, opts generateOptions) {
+	app, exists := service.Config[opts.labelPrefix+"proxmox.app"]
+	if !exists {
+		return
+	}
+	if _, found := getAppPreset(service, opts); !found {
+		opts.logger.Warnf("Service %s (ID: %d): unknown app %q in %sproxmox.app, expected one of %s",
+			service.Name, service.ID, app, opts.labelPrefix, strings.Join(presetNames(opts.presets()), ", "))
+	}
+}
+
+// presets returns the configured app presets, or the defaults
+func (o generateOptions) presets() map[string]appPreset {
+	if o.appPresets == nil {
+		return defaultAppPresets
+	}
+	return o.appPresets
+}
+
+func presetNames(presets map[string]appPreset) []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
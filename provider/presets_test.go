@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGetServiceURLAppPreset(t *testing.T) {
+	presets, err := parseAppPresets("myapp=8443/https, gitea=3001")
+	if err != nil {
+		t.Fatalf("parseAppPresets() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		presets     map[string]appPreset
+		expectedUrl string
+	}{
+		{name: "No preset", labels: map[string]string{}, expectedUrl: "http://10.0.0.5:80"},
+		{name: "Default preset", labels: map[string]string{"traefik.proxmox.app": "gitea"}, expectedUrl: "http://10.0.0.5:3000"},
+		{name: "HTTPS preset", labels: map[string]string{"traefik.proxmox.app": "Nextcloud"}, expectedUrl: "https://10.0.0.5:443"},
+		{
+			name:        "Explicit port wins",
+			labels:      map[string]string{"traefik.proxmox.app": "nextcloud", "traefik.http.services.app.loadbalancer.server.port": "8443"},
+			expectedUrl: "https://10.0.0.5:8443",
+		},
+		{
+			name:        "Explicit scheme wins",
+			labels:      map[string]string{"traefik.proxmox.app": "nextcloud", "traefik.http.services.app.loadbalancer.server.scheme": "http"},
+			expectedUrl: "http://10.0.0.5:443",
+		},
+		{name: "Configured preset", labels: map[string]string{"traefik.proxmox.app": "myapp"}, presets: presets, expectedUrl: "https://10.0.0.5:8443"},
+		{name: "Overridden default", labels: map[string]string{"traefik.proxmox.app": "gitea"}, presets: presets, expectedUrl: "http://10.0.0.5:3001"},
+		{name: "Unknown app", labels: map[string]string{"traefik.proxmox.app": "unknown"}, expectedUrl: "http://10.0.0.5:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := internal.Service{ID: 100, Name: "app", Config: tt.labels, IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}}
+			opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, appPresets: tt.presets}
+			if url := getServiceURL(service, "app", "pve1", opts); url != tt.expectedUrl {
+				t.Errorf("Expected URL to be %s, got %s", tt.expectedUrl, url)
+			}
+		})
+	}
+}
+
+func TestParseAppPresets(t *testing.T) {
+	presets, err := parseAppPresets("")
+	if err != nil || len(presets) != len(defaultAppPresets) {
+		t.Errorf("Expected the default presets, got %v (%v)", presets, err)
+	}
+
+	for _, invalid := range []string{"myapp", "myapp=http", "myapp=70000", "myapp=8080/ftp", "=8080"} {
+		if _, err := parseAppPresets(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
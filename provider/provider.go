@@ -2,12 +2,21 @@
 package provider
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/NX211/traefik-proxmox-provider/internal"
@@ -18,20 +27,144 @@ import (
 
 // Config the plugin configuration.
 type Config struct {
-	PollInterval   string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
-	ApiEndpoint    string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
-	ApiTokenId     string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
-	ApiToken       string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
-	ApiLogging     string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
-	ApiValidateSSL string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	PollInterval           string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
+	PollJitter             string `json:"pollJitter" yaml:"pollJitter" toml:"pollJitter"`
+	Debounce               string `json:"debounce" yaml:"debounce" toml:"debounce"`
+	PollTimeout            string `json:"pollTimeout" yaml:"pollTimeout" toml:"pollTimeout"`
+	ApiEndpoint            string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
+	Clusters               string `json:"clusters" yaml:"clusters" toml:"clusters"`
+	ClusterConcurrency     string `json:"clusterConcurrency" yaml:"clusterConcurrency" toml:"clusterConcurrency"`
+	ApiTokenId             string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
+	ApiToken               string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
+	ApiTokenFile           string `json:"apiTokenFile" yaml:"apiTokenFile" toml:"apiTokenFile"`
+	ApiLogging             string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
+	LogFormat              string `json:"logFormat" yaml:"logFormat" toml:"logFormat"`
+	ApiValidateSSL         string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	IncludeNodes           string `json:"includeNodes" yaml:"includeNodes" toml:"includeNodes"`
+	ExcludeNodes           string `json:"excludeNodes" yaml:"excludeNodes" toml:"excludeNodes"`
+	ExcludeVMIDs           string `json:"excludeVMIDs" yaml:"excludeVMIDs" toml:"excludeVMIDs"`
+	ExcludeNamePatterns    string `json:"excludeNamePatterns" yaml:"excludeNamePatterns" toml:"excludeNamePatterns"`
+	ConstraintTags         string `json:"constraintTags" yaml:"constraintTags" toml:"constraintTags"`
+	Pools                  string `json:"pools" yaml:"pools" toml:"pools"`
+	HaAware                string `json:"haAware" yaml:"haAware" toml:"haAware"`
+	LabelPrefix            string `json:"labelPrefix" yaml:"labelPrefix" toml:"labelPrefix"`
+	LabelSeparator         string `json:"labelSeparator" yaml:"labelSeparator" toml:"labelSeparator"`
+	LabelMarker            string `json:"labelMarker" yaml:"labelMarker" toml:"labelMarker"`
+	LabelMarkerRequired    string `json:"labelMarkerRequired" yaml:"labelMarkerRequired" toml:"labelMarkerRequired"`
+	LabelBlockMode         string `json:"labelBlockMode" yaml:"labelBlockMode" toml:"labelBlockMode"`
+	IncludeStopped         string `json:"includeStopped" yaml:"includeStopped" toml:"includeStopped"`
+	ScanMode               string `json:"scanMode" yaml:"scanMode" toml:"scanMode"`
+	ExposeTypes            string `json:"exposeTypes" yaml:"exposeTypes" toml:"exposeTypes"`
+	RequireOnboot          string `json:"requireOnboot" yaml:"requireOnboot" toml:"requireOnboot"`
+	RequireProtection      string `json:"requireProtection" yaml:"requireProtection" toml:"requireProtection"`
+	StoppedService         string `json:"stoppedService" yaml:"stoppedService" toml:"stoppedService"`
+	Metrics                string `json:"metrics" yaml:"metrics" toml:"metrics"`
+	ScanTimeout            string `json:"scanTimeout" yaml:"scanTimeout" toml:"scanTimeout"`
+	ConfigCacheTTL         string `json:"configCacheTTL" yaml:"configCacheTTL" toml:"configCacheTTL"`
+	NodeFailureThreshold   string `json:"nodeFailureThreshold" yaml:"nodeFailureThreshold" toml:"nodeFailureThreshold"`
+	NodeCooldown           string `json:"nodeCooldown" yaml:"nodeCooldown" toml:"nodeCooldown"`
+	NodeMaxCooldown        string `json:"nodeMaxCooldown" yaml:"nodeMaxCooldown" toml:"nodeMaxCooldown"`
+	ValidateLabels         string `json:"validateLabels" yaml:"validateLabels" toml:"validateLabels"`
+	SkipInvalidGuests      string `json:"skipInvalidGuests" yaml:"skipInvalidGuests" toml:"skipInvalidGuests"`
+	AllowIPv6              string `json:"allowIPv6" yaml:"allowIPv6" toml:"allowIPv6"`
+	DualStack              string `json:"dualStack" yaml:"dualStack" toml:"dualStack"`
+	PreferInterface        string `json:"preferInterface" yaml:"preferInterface" toml:"preferInterface"`
+	PreferPrefixLen        string `json:"preferPrefixLen" yaml:"preferPrefixLen" toml:"preferPrefixLen"`
+	PreferReverseDNS       string `json:"preferReverseDNS" yaml:"preferReverseDNS" toml:"preferReverseDNS"`
+	IPSelector             string `json:"ipSelector" yaml:"ipSelector" toml:"ipSelector"`
+	MaxServersPerService   string `json:"maxServersPerService" yaml:"maxServersPerService" toml:"maxServersPerService"`
+	UserAgent              string `json:"userAgent" yaml:"userAgent" toml:"userAgent"`
+	ApiProxyURL            string `json:"apiProxyURL" yaml:"apiProxyURL" toml:"apiProxyURL"`
+	ApiBasePath            string `json:"apiBasePath" yaml:"apiBasePath" toml:"apiBasePath"`
+	ApiExtraHeaders        string `json:"apiExtraHeaders" yaml:"apiExtraHeaders" toml:"apiExtraHeaders"`
+	ApiLogBodyLimit        string `json:"apiLogBodyLimit" yaml:"apiLogBodyLimit" toml:"apiLogBodyLimit"`
+	ApiMaxIdleConns        string `json:"apiMaxIdleConns" yaml:"apiMaxIdleConns" toml:"apiMaxIdleConns"`
+	ApiMaxIdleConnsPerHost string `json:"apiMaxIdleConnsPerHost" yaml:"apiMaxIdleConnsPerHost" toml:"apiMaxIdleConnsPerHost"`
+	ApiIdleConnTimeout     string `json:"apiIdleConnTimeout" yaml:"apiIdleConnTimeout" toml:"apiIdleConnTimeout"`
+	ApiRateLimit           string `json:"apiRateLimit" yaml:"apiRateLimit" toml:"apiRateLimit"`
+	ApiRateBurst           string `json:"apiRateBurst" yaml:"apiRateBurst" toml:"apiRateBurst"`
+	NoIPBehavior           string `json:"noIPBehavior" yaml:"noIPBehavior" toml:"noIPBehavior"`
+	NoIPGracePeriod        string `json:"noIPGracePeriod" yaml:"noIPGracePeriod" toml:"noIPGracePeriod"`
+	NoAddressPolicy        string `json:"noAddressPolicy" yaml:"noAddressPolicy" toml:"noAddressPolicy"`
+	HostnameSuffix         string `json:"hostnameSuffix" yaml:"hostnameSuffix" toml:"hostnameSuffix"`
+	NodeAddresses          string `json:"nodeAddresses" yaml:"nodeAddresses" toml:"nodeAddresses"`
+	ResolveHostnameIPv6    string `json:"resolveHostnameIPv6" yaml:"resolveHostnameIPv6" toml:"resolveHostnameIPv6"`
+	HttpsRedirect          string `json:"httpsRedirect" yaml:"httpsRedirect" toml:"httpsRedirect"`
+	ExposedByDefault       string `json:"exposedByDefault" yaml:"exposedByDefault" toml:"exposedByDefault"`
+	HttpEntryPoint         string `json:"httpEntryPoint" yaml:"httpEntryPoint" toml:"httpEntryPoint"`
+	HttpsEntryPoint        string `json:"httpsEntryPoint" yaml:"httpsEntryPoint" toml:"httpsEntryPoint"`
+	DefaultRule            string `json:"defaultRule" yaml:"defaultRule" toml:"defaultRule"`
+	DefaultMiddlewares     string `json:"defaultMiddlewares" yaml:"defaultMiddlewares" toml:"defaultMiddlewares"`
+	NodeHeaderInjection    string `json:"nodeHeaderInjection" yaml:"nodeHeaderInjection" toml:"nodeHeaderInjection"`
+	GuestHeaderInjection   string `json:"guestHeaderInjection" yaml:"guestHeaderInjection" toml:"guestHeaderInjection"`
+	PrefixNames            string `json:"prefixNames" yaml:"prefixNames" toml:"prefixNames"`
+	AppPresets             string `json:"appPresets" yaml:"appPresets" toml:"appPresets"`
+	UseGuestAgent          string `json:"useGuestAgent" yaml:"useGuestAgent" toml:"useGuestAgent"`
+	CloudInitLabels        string `json:"cloudInitLabels" yaml:"cloudInitLabels" toml:"cloudInitLabels"`
+	HealthAddress          string `json:"healthAddress" yaml:"healthAddress" toml:"healthAddress"`
+	SelfRouterRule         string `json:"selfRouterRule" yaml:"selfRouterRule" toml:"selfRouterRule"`
+	FixtureFile            string `json:"fixtureFile" yaml:"fixtureFile" toml:"fixtureFile"`
+	StaticConfigFile       string `json:"staticConfigFile" yaml:"staticConfigFile" toml:"staticConfigFile"`
+	ControlGuestName       string `json:"controlGuestName" yaml:"controlGuestName" toml:"controlGuestName"`
+	ControlGuestTag        string `json:"controlGuestTag" yaml:"controlGuestTag" toml:"controlGuestTag"`
+	FailFast               string `json:"failFast" yaml:"failFast" toml:"failFast"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	return &Config{
-		PollInterval:   "30s", // Default to 30 seconds for polling
-		ApiValidateSSL: "true",
-		ApiLogging:     "info",
+		PollInterval:           "30s", // Default to 30 seconds for polling
+		PollJitter:             "0s",
+		Debounce:               "0s",
+		PollTimeout:            "0s", // No ceiling on a whole poll
+		ApiValidateSSL:         "true",
+		ClusterConcurrency:     "4",
+		ApiBasePath:            internal.DefaultAPIBasePath,
+		ApiLogging:             "info",
+		LogFormat:              internal.LogFormatText,
+		LabelPrefix:            internal.DefaultLabelPrefix,
+		LabelSeparator:         internal.DefaultLabelSeparator,
+		LabelMarkerRequired:    "false",
+		LabelBlockMode:         labelBlockLenient,
+		IncludeStopped:         "false",
+		ScanMode:               scanModeAll,
+		ExposeTypes:            "",
+		RequireOnboot:          "false",
+		RequireProtection:      "false",
+		HaAware:                "false",
+		Metrics:                "false",
+		ScanTimeout:            "10s", // Bound each per-guest API call
+		ConfigCacheTTL:         "0s",  // Config caching disabled by default
+		NodeFailureThreshold:   "0",   // Node circuit breaker disabled by default
+		NodeCooldown:           "30s",
+		NodeMaxCooldown:        "10m",
+		ValidateLabels:         "true",
+		SkipInvalidGuests:      "false",
+		AllowIPv6:              "true",
+		DualStack:              "false",
+		NoIPBehavior:           noIPBehaviorHostname,
+		NoIPGracePeriod:        "0s",
+		NoAddressPolicy:        noAddressPolicyHostname,
+		DefaultRule:            defaultRuleHost,
+		UseGuestAgent:          "true",
+		CloudInitLabels:        "false",
+		ApiMaxIdleConns:        "100",
+		ApiMaxIdleConnsPerHost: "10",
+		ApiIdleConnTimeout:     "90s",
+		ApiRateLimit:           "0", // Unlimited
+		ApiRateBurst:           "1",
+		HttpsRedirect:          "false",
+		ResolveHostnameIPv6:    "false",
+		ExposedByDefault:       "false",
+		PreferReverseDNS:       "false",
+		IPSelector:             ipSelectorFirst,
+		MaxServersPerService:   "0",
+		PrefixNames:            "false",
+		NodeHeaderInjection:    "false",
+		GuestHeaderInjection:   "false",
+		HttpEntryPoint:         defaultHTTPEntryPoint,
+		HttpsEntryPoint:        defaultHTTPSEntryPoint,
+		FailFast:               "true",
 	}
 }
 
@@ -39,16 +172,187 @@ func CreateConfig() *Config {
 type Provider struct {
 	name         string
 	pollInterval time.Duration
+	pollJitter   time.Duration
+	debounce     time.Duration
+	pollTimeout  time.Duration
 	client       *internal.ProxmoxClient
-	cancel       func()
+	// clusters are the clusters scanned besides the one of client
+	clusters           []cluster
+	clusterConcurrency int
+	scanOptions        scanOptions
+	genOptions         generateOptions
+	logger             *internal.Logger
+	metrics            *Metrics
+	cancel             func()
+	// running tracks the poll goroutine, so Stop can wait for it
+	running sync.WaitGroup
+
+	healthAddress string
+	healthServer  *http.Server
+	// selfRouterRule routes to the health server when set
+	selfRouterRule string
+	// staticConfigFile is merged into every generated configuration
+	staticConfigFile string
+
+	// connected is false while the initial connection tolerated by
+	// failFast=false has not succeeded yet
+	connected bool
+	// lastPushed is the JSON of the last configuration sent to Traefik
+	lastPushed []byte
+
+	mu                 sync.Mutex
+	labelErrors        []GuestLabelError
+	labelDiagnostics   []GuestDiagnostic
+	lastPoll           PollStatus
+	lastSuccessfulPoll PollStatus
+	// lastServices are the guests of the previous poll, to log what changed
+	lastServices map[guestKey]guestSnapshot
+	// staticConfig is the last configuration read from staticConfigFile
+	staticConfig *dynamic.Configuration
+}
+
+// GuestLabelError describes a guest whose labels could not be parsed
+type GuestLabelError struct {
+	Node string
+	ID   uint64
+	Name string
+	Err  error
+}
+
+func (e GuestLabelError) Error() string {
+	return fmt.Sprintf("guest %s (ID: %d) on node %s: %v", e.Name, e.ID, e.Node, e.Err)
+}
+
+// scanOptions controls which parts of the cluster are scanned
+type scanOptions struct {
+	includeNodes   []nodePattern
+	excludeNodes   []nodePattern
+	constraintTags []string
+	labelPrefix    string
+	labelSeparator string
+	labelMarker    string
+	pools          []string
+	resolvePools   bool
+	guestPools     map[uint64]string
+	// labelMarkerRequired ignores the notes of guests without the label marker
+	labelMarkerRequired bool
+	// strictLabelBlocks drops all labels of a guest whose label block is invalid
+	strictLabelBlocks bool
+	// haAware reads the HA manager status to find the active copy of HA guests
+	haAware           bool
+	guestHA           map[uint64]internal.HAStatus
+	includeStopped    bool
+	disableGuestAgent bool
+	// nodeAddresses are the configured addresses of the nodes, used for
+	// guests with a nodeport label
+	nodeAddresses map[string]string
+	// cloudInitLabels reads labels from the cloud-init user-data of VMs too
+	cloudInitLabels bool
+	logger          *internal.Logger
+	metrics         *Metrics
+	scanTimeout     time.Duration
+	configCache     *configCache
+
+	// excludeVMIDs and excludeNamePatterns drop guests whatever their labels
+	excludeVMIDs        map[uint64]bool
+	excludeNamePatterns []nodePattern
+	// scanMode limits the scan to VMs or containers, saving the other listing
+	scanMode string
+	// nodeBreaker skips nodes whose scans keep failing, nil meaning disabled
+	nodeBreaker *nodeBreaker
+}
+
+// generateOptions controls how the dynamic configuration is built from labels
+type generateOptions struct {
+	labelPrefix    string
+	stoppedService string
+	validateLabels bool
+	// skipInvalidGuests leaves out guests whose labels have error diagnostics
+	skipInvalidGuests bool
+	allowIPv6         bool
+	defaultRule       string
+	// dualStack publishes the best IPv4 and IPv6 address of dual-stack guests
+	dualStack bool
+	// exposeTypes limits the published guests to these guest types, all
+	// scanned guests being published when empty
+	exposeTypes []string
+	// requireOnboot and requireProtection only publish guests with the
+	// onboot or protection flag set
+	requireOnboot     bool
+	requireProtection bool
+	// staticConfig holds the objects of staticConfigFile of the current poll
+	staticConfig *dynamic.Configuration
+	// hostnameSuffix replaces the node name in the hostname fallback
+	hostnameSuffix string
+	// resolveHostnameIPv6 replaces the hostname fallback with its AAAA address
+	resolveHostnameIPv6 bool
+	// resolveIPv6 looks up the IPv6 address of a hostname, nil meaning a DNS lookup
+	resolveIPv6 func(host string) (string, bool)
+	// controlGuestName and controlGuestTag select the control guests, whose
+	// labels define cluster-wide objects
+	controlGuestName string
+	controlGuestTag  string
+	// preferInterfaces are the guest interfaces whose addresses are used first
+	preferInterfaces []string
+	// preferPrefixLen prefers addresses with this prefix length, 0 meaning any
+	preferPrefixLen uint64
+	// preferReverseDNS prefers addresses with a PTR record
+	preferReverseDNS bool
+	// ipSelector picks among the usable IPs once no preference applies, nil meaning the first
+	ipSelector IPSelector
+	// defaultMiddlewares are appended to the middlewares of every router
+	defaultMiddlewares []string
+	// nodeHeaderInjection adds the X-Proxmox-Node header to the requests of every router
+	nodeHeaderInjection bool
+	// guestHeaderInjection adds the X-Proxmox-VMID and X-Proxmox-Node
+	// headers of the guest to the requests of its routers
+	guestHeaderInjection bool
+	// namePrefix is prepended to the names of the routers and services
+	namePrefix string
+	// maxServers caps the servers of each load balancer, 0 meaning unlimited
+	maxServers int
+	// exposedByDefault exposes guests unless they set enable=false
+	exposedByDefault bool
+	// httpsRedirect adds a redirect router to HTTPS routers by default
+	httpsRedirect   bool
+	httpEntryPoint  string
+	httpsEntryPoint string
+	ipReadiness     *ipReadiness
+	// noAddressPolicy decides what happens to running guests without a
+	// routable IP whose fallback hostname does not resolve
+	noAddressPolicy string
+	// resolveHost reports whether a hostname resolves, nil meaning a DNS lookup
+	resolveHost func(host string) bool
+	// appPresets are the apps known to the proxmox.app label, nil meaning the defaults
+	appPresets map[string]appPreset
+	// portProbe checks whether a candidate port is open, nil meaning a TCP dial
+	portProbe func(host, port string) bool
+	// reverseLookup reports whether an address has a PTR record, nil meaning a DNS lookup
+	reverseLookup func(address string) bool
+	logger        *internal.Logger
 }
 
-// New creates a new Provider plugin.
+// New creates a new Provider plugin. The environment variables of
+// envOverrides take precedence over the fields of config.
 func New(ctx context.Context, config *Config, name string) (*Provider, error) {
+	config, overridden := applyEnvOverrides(config)
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	includeNodes, err := parseNodePatterns("includeNodes", config.IncludeNodes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	excludeNodes, err := parseNodePatterns("excludeNodes", config.ExcludeNodes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	excludeNamePatterns, err := parseNodePatterns("excludeNamePatterns", config.ExcludeNamePatterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	pi, err := time.ParseDuration(config.PollInterval)
 	if err != nil {
 		return nil, fmt.Errorf("invalid poll interval: %w", err)
@@ -59,27 +363,324 @@ func New(ctx context.Context, config *Config, name string) (*Provider, error) {
 		return nil, fmt.Errorf("poll interval must be at least 5 seconds, got %v", pi)
 	}
 
-	pc, err := newParserConfig(
-		config.ApiEndpoint,
-		config.ApiTokenId,
-		config.ApiToken,
-	)
+	var pollJitter time.Duration
+	if config.PollJitter != "" {
+		pollJitter, err = time.ParseDuration(config.PollJitter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid poll jitter: %w", err)
+		}
+		if pollJitter < 0 || pollJitter >= pi {
+			return nil, fmt.Errorf("poll jitter must be between 0 and the poll interval %v, got %v", pi, pollJitter)
+		}
+	}
+
+	var debounce time.Duration
+	if config.Debounce != "" {
+		debounce, err = time.ParseDuration(config.Debounce)
+		if err != nil {
+			return nil, fmt.Errorf("invalid debounce: %w", err)
+		}
+		if debounce < 0 {
+			return nil, fmt.Errorf("debounce must not be negative, got %v", debounce)
+		}
+	}
+
+	var pollTimeout time.Duration
+	if config.PollTimeout != "" {
+		pollTimeout, err = time.ParseDuration(config.PollTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid poll timeout: %w", err)
+		}
+		if pollTimeout < 0 {
+			return nil, fmt.Errorf("poll timeout must not be negative, got %v", pollTimeout)
+		}
+	}
+
+	var scanTimeout time.Duration
+	if config.ScanTimeout != "" {
+		scanTimeout, err = time.ParseDuration(config.ScanTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scan timeout: %w", err)
+		}
+		if scanTimeout < 0 {
+			return nil, fmt.Errorf("scan timeout must not be negative, got %v", scanTimeout)
+		}
+	}
+
+	var cache *configCache
+	if config.ConfigCacheTTL != "" {
+		ttl, err := time.ParseDuration(config.ConfigCacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config cache TTL: %w", err)
+		}
+		if ttl > 0 {
+			cache = newConfigCache(ttl)
+		}
+	}
+
+	var breaker *nodeBreaker
+	if value := strings.TrimSpace(config.NodeFailureThreshold); value != "" && value != "0" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid configuration: nodeFailureThreshold must be a positive number or 0 to disable, got %q", config.NodeFailureThreshold)
+		}
+		cooldown, err := time.ParseDuration(config.NodeCooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node cooldown: %w", err)
+		}
+		maxCooldown, err := time.ParseDuration(config.NodeMaxCooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node max cooldown: %w", err)
+		}
+		if cooldown <= 0 || maxCooldown < cooldown {
+			return nil, fmt.Errorf("node cooldown must be positive and at most the node max cooldown %v, got %v", maxCooldown, cooldown)
+		}
+		breaker = newNodeBreaker(threshold, cooldown, maxCooldown)
+	}
+
+	var readiness *ipReadiness
+	switch config.NoIPBehavior {
+	case "", noIPBehaviorHostname:
+		if config.NoIPGracePeriod != "" {
+			grace, err := time.ParseDuration(config.NoIPGracePeriod)
+			if err != nil {
+				return nil, fmt.Errorf("invalid no-IP grace period: %w", err)
+			}
+			if grace > 0 {
+				readiness = newIPReadiness(noIPBehaviorHostname, grace)
+			}
+		}
+	case noIPBehaviorOmit:
+		readiness = newIPReadiness(noIPBehaviorOmit, 0)
+	default:
+		return nil, fmt.Errorf("invalid configuration: noIPBehavior must be %q or %q, got %q", noIPBehaviorHostname, noIPBehaviorOmit, config.NoIPBehavior)
+	}
+
+	noAddressPolicy := strings.TrimSpace(config.NoAddressPolicy)
+	switch noAddressPolicy {
+	case "":
+		noAddressPolicy = noAddressPolicyHostname
+	case noAddressPolicyHostname, noAddressPolicySkip, noAddressPolicyError:
+	default:
+		return nil, fmt.Errorf("invalid configuration: noAddressPolicy must be %q, %q or %q, got %q", noAddressPolicyHostname, noAddressPolicySkip, noAddressPolicyError, config.NoAddressPolicy)
+	}
+
+	if config.LabelMarkerRequired == "true" && strings.TrimSpace(config.LabelMarker) == "" {
+		return nil, fmt.Errorf("invalid configuration: labelMarkerRequired needs a labelMarker")
+	}
+
+	labelBlockMode := strings.ToLower(strings.TrimSpace(config.LabelBlockMode))
+	switch labelBlockMode {
+	case "", labelBlockLenient, labelBlockStrict:
+	default:
+		return nil, fmt.Errorf("invalid configuration: labelBlockMode must be %q or %q, got %q", labelBlockLenient, labelBlockStrict, config.LabelBlockMode)
+	}
+
+	scanMode := strings.ToLower(strings.TrimSpace(config.ScanMode))
+	switch scanMode {
+	case "":
+		scanMode = scanModeAll
+	case scanModeAll, scanModeVMs, scanModeContainers:
+	default:
+		return nil, fmt.Errorf("invalid configuration: scanMode must be %q, %q or %q, got %q", scanModeAll, scanModeVMs, scanModeContainers, config.ScanMode)
+	}
+
+	exposeTypes, err := parseExposeTypes(config.ExposeTypes, scanMode)
 	if err != nil {
-		return nil, fmt.Errorf("invalid parser config: %w", err)
+		return nil, fmt.Errorf("invalid configuration: exposeTypes: %w", err)
 	}
 
-	pc.LogLevel = config.ApiLogging
-	pc.ValidateSSL = config.ApiValidateSSL == "true"
-	client := newClient(pc)
+	var client *internal.ProxmoxClient
+	if config.FixtureFile != "" {
+		client, err = internal.NewFixtureClient(config.FixtureFile, config.ApiLogging)
+	} else {
+		client, err = newAPIClient(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Logger.SetFormat(config.LogFormat); err != nil {
+		return nil, fmt.Errorf("invalid configuration: logFormat: %w", err)
+	}
+	if config.FixtureFile != "" {
+		client.Logger.Infof("Reading the cluster state from fixture %s", config.FixtureFile)
+	}
+	if len(overridden) > 0 {
+		client.Logger.Infof("Configuration overridden by the environment: %s", strings.Join(overridden, ", "))
+	}
+
+	var metrics *Metrics
+	if config.Metrics == "true" {
+		metrics = NewMetrics()
+		client.ResponseObserver = metrics.observeAPIRequest
+	}
+
+	clusterSpecs, err := parseClusters(config.Clusters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: clusters: %w", err)
+	}
+	if len(clusterSpecs) > 0 && config.FixtureFile != "" {
+		return nil, fmt.Errorf("invalid configuration: clusters cannot be combined with fixtureFile")
+	}
+	clusters, err := newClusters(config, clusterSpecs, metrics)
+	if err != nil {
+		return nil, err
+	}
+	clusterConcurrency := 1
+	if value := strings.TrimSpace(config.ClusterConcurrency); value != "" {
+		clusterConcurrency, err = strconv.Atoi(value)
+		if err != nil || clusterConcurrency < 1 {
+			return nil, fmt.Errorf("invalid configuration: clusterConcurrency must be a positive number, got %q", config.ClusterConcurrency)
+		}
+	}
+
+	// Without failFast the poll loop keeps trying, so only one check is needed
+	versionAttempts := startupVersionAttempts
+	if config.FailFast == "false" {
+		versionAttempts = 1
+	}
+
+	connected := true
+	if err := logVersionWithRetry(client, ctx, versionAttempts, startupVersionBackoff); err != nil {
+		var apiErr *internal.APIError
+		if errors.As(err, &apiErr) && apiErr.IsAuthError() {
+			return nil, &AuthError{StatusCode: apiErr.StatusCode, TokenID: config.ApiTokenId, TokenFile: config.ApiTokenFile, Err: apiErr}
+		}
+		if config.FailFast != "false" {
+			return nil, fmt.Errorf("failed to get Proxmox version: %w", err)
+		}
+		// The poll loop keeps trying to connect
+		client.Logger.Errorf("Failed to connect to Proxmox, retrying in the background: %v", err)
+		connected = false
+	}
+
+	var preferPrefixLen uint64
+	if config.PreferPrefixLen != "" {
+		preferPrefixLen, err = strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(config.PreferPrefixLen), "/"), 10, 64)
+		if err != nil || preferPrefixLen == 0 || preferPrefixLen > 128 {
+			return nil, fmt.Errorf("invalid configuration: preferPrefixLen must be a prefix length between 1 and 128, got %q", config.PreferPrefixLen)
+		}
+	}
+
+	ipSelector, err := parseIPSelector(config.IPSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: ipSelector: %w", err)
+	}
+
+	excludeVMIDs, err := parseVMIDs(config.ExcludeVMIDs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: excludeVMIDs: %w", err)
+	}
+
+	maxServers := 0
+	if value := strings.TrimSpace(config.MaxServersPerService); value != "" {
+		maxServers, err = strconv.Atoi(value)
+		if err != nil || maxServers < 0 {
+			return nil, fmt.Errorf("invalid configuration: maxServersPerService must be a positive number or 0 for unlimited, got %q", config.MaxServersPerService)
+		}
+	}
+
+	appPresets, err := parseAppPresets(config.AppPresets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	nodeAddresses, err := parseNodeAddresses(config.NodeAddresses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
 
-	if err := logVersion(client, ctx); err != nil {
-		return nil, fmt.Errorf("failed to get Proxmox version: %w", err)
+	var namePrefix string
+	if config.PrefixNames == "true" {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid configuration: prefixNames needs a provider name")
+		}
+		namePrefix = strings.TrimSpace(name) + "-"
 	}
 
+	var staticConfig *dynamic.Configuration
+	if config.StaticConfigFile != "" {
+		staticConfig, err = loadStaticConfig(config.StaticConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+
+	labelPrefix := normalizeLabelPrefix(config.LabelPrefix)
+
 	return &Provider{
-		name:         name,
-		pollInterval: pi,
-		client:       client,
+		name:               name,
+		pollInterval:       pi,
+		pollJitter:         pollJitter,
+		debounce:           debounce,
+		pollTimeout:        pollTimeout,
+		healthAddress:      strings.TrimSpace(config.HealthAddress),
+		selfRouterRule:     strings.TrimSpace(config.SelfRouterRule),
+		staticConfigFile:   config.StaticConfigFile,
+		staticConfig:       staticConfig,
+		connected:          connected,
+		client:             client,
+		clusters:           clusters,
+		clusterConcurrency: clusterConcurrency,
+		scanOptions: scanOptions{
+			includeNodes:        includeNodes,
+			excludeNodes:        excludeNodes,
+			excludeVMIDs:        excludeVMIDs,
+			excludeNamePatterns: excludeNamePatterns,
+			constraintTags:      internal.ParseTags(config.ConstraintTags),
+			pools:               splitList(config.Pools),
+			resolvePools:        strings.Contains(config.DefaultRule, "{pool}"),
+			labelPrefix:         labelPrefix,
+			labelSeparator:      config.LabelSeparator,
+			labelMarker:         config.LabelMarker,
+			labelMarkerRequired: config.LabelMarkerRequired == "true",
+			strictLabelBlocks:   labelBlockMode == labelBlockStrict,
+			includeStopped:      config.IncludeStopped == "true",
+			scanMode:            scanMode,
+			disableGuestAgent:   config.UseGuestAgent == "false",
+			cloudInitLabels:     config.CloudInitLabels == "true",
+			haAware:             config.HaAware == "true",
+			nodeAddresses:       nodeAddresses,
+			logger:              client.Logger,
+			metrics:             metrics,
+			scanTimeout:         scanTimeout,
+			configCache:         cache,
+			nodeBreaker:         breaker,
+		},
+		genOptions: generateOptions{
+			labelPrefix:          labelPrefix,
+			stoppedService:       strings.TrimSpace(config.StoppedService),
+			validateLabels:       config.ValidateLabels != "false",
+			skipInvalidGuests:    config.SkipInvalidGuests == "true",
+			allowIPv6:            config.AllowIPv6 != "false",
+			dualStack:            config.DualStack == "true",
+			exposeTypes:          exposeTypes,
+			requireOnboot:        config.RequireOnboot == "true",
+			requireProtection:    config.RequireProtection == "true",
+			defaultRule:          config.DefaultRule,
+			defaultMiddlewares:   splitList(config.DefaultMiddlewares),
+			nodeHeaderInjection:  config.NodeHeaderInjection == "true",
+			guestHeaderInjection: config.GuestHeaderInjection == "true",
+			namePrefix:           namePrefix,
+			hostnameSuffix:       strings.TrimSpace(config.HostnameSuffix),
+			resolveHostnameIPv6:  config.ResolveHostnameIPv6 == "true",
+			preferInterfaces:     splitList(config.PreferInterface),
+			preferPrefixLen:      preferPrefixLen,
+			preferReverseDNS:     config.PreferReverseDNS == "true",
+			ipSelector:           ipSelector,
+			maxServers:           maxServers,
+			exposedByDefault:     config.ExposedByDefault == "true",
+			httpsRedirect:        config.HttpsRedirect == "true",
+			httpEntryPoint:       strings.TrimSpace(config.HttpEntryPoint),
+			httpsEntryPoint:      strings.TrimSpace(config.HttpsEntryPoint),
+			appPresets:           appPresets,
+			ipReadiness:          readiness,
+			noAddressPolicy:      noAddressPolicy,
+			controlGuestName:     strings.TrimSpace(config.ControlGuestName),
+			controlGuestTag:      strings.TrimSpace(config.ControlGuestTag),
+			logger:               client.Logger,
+		},
+		logger:  client.Logger,
+		metrics: metrics,
 	}, nil
 }
 
@@ -90,13 +691,21 @@ func (p *Provider) Init() error {
 
 // Provide creates and send dynamic configuration.
 func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
+	if p.healthAddress != "" {
+		if err := p.startHealthServer(); err != nil {
+			return err
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	p.cancel = cancel
 
+	p.running.Add(1)
 	go func() {
+		defer p.running.Done()
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("Recovered from panic in provider: %v", err)
+				p.logger.Errorf("Recovered from panic in provider: %v", err)
 			}
 		}()
 
@@ -107,97 +716,688 @@ func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
 }
 
 func (p *Provider) loadConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) {
-	ticker := time.NewTicker(p.pollInterval)
-	defer ticker.Stop()
+	// A per-instance seed keeps instances started together from sharing offsets
+	random := rand.New(rand.NewSource(time.Now().UnixNano())).Float64
+
+	if !p.connected && !p.waitForConnection(ctx, random) {
+		return
+	}
 
 	// Initial configuration
 	if err := p.updateConfiguration(ctx, cfgChan); err != nil {
-		log.Printf("Error during initial configuration: %v", err)
+		p.logger.Errorf("Error during initial configuration: %v", err)
+	}
+
+	// The timer is reset after each poll so every delay gets its own jitter
+	timer := time.NewTimer(nextPollDelay(p.pollInterval, p.pollJitter, random))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if err := p.updateConfiguration(ctx, cfgChan); err != nil {
+				p.logger.Errorf("Error updating configuration: %v", err)
+			}
+			timer.Reset(nextPollDelay(p.pollInterval, p.pollJitter, random))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// waitForConnection retries the initial connection with exponential
+// backoff until it succeeds, reporting false when ctx is done first
+func (p *Provider) waitForConnection(ctx context.Context, random func() float64) bool {
+	for attempt := 0; ; attempt++ {
+		delay := reconnectDelay(attempt, p.pollInterval, random)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+
+		err := logVersion(p.client, ctx)
+		if err == nil {
+			p.connected = true
+			return true
+		}
+		p.logger.Warnf("Proxmox is still unreachable after %d attempts: %v", attempt+1, err)
+	}
+}
+
+// reconnectDelay returns the delay before reconnection attempt n: a base
+// of one second doubling with each attempt up to the poll interval, of
+// which a random half is waited, so restarted instances do not retry in step
+func reconnectDelay(attempt int, pollInterval time.Duration, random func() float64) time.Duration {
+	base := pollInterval
+	if attempt < 30 && time.Second<<uint(attempt) < pollInterval {
+		base = time.Second << uint(attempt)
+	}
+	return base/2 + time.Duration(random()*float64(base/2))
+}
+
+// nextPollDelay returns the poll interval shifted by a random offset in
+// [-jitter, +jitter], random returning a number in [0, 1)
+func nextPollDelay(interval, jitter time.Duration, random func() float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	offset := time.Duration((random()*2 - 1) * float64(jitter))
+	return interval + offset
+}
+
+func (p *Provider) updateConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) error {
+	start := time.Now()
+	result, err := p.scan(ctx)
+	if err != nil {
+		p.recordScanError(err, time.Since(start))
+		return err
+	}
+
+	result, data, err := p.debounceConfiguration(ctx, result, p.scan)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Stopped while waiting for the labels to settle
+			return nil
+		}
+		return err
+	}
+	p.recordScan(result)
+	p.lastPushed = data
+
+	// Traefik may stop reading once the provider is stopped
+	select {
+	case cfgChan <- &dynamic.JSONPayload{Configuration: result.configuration}:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// debounceConfiguration holds back a configuration that differs from the
+// last pushed one until the labels settle: after each quiet period of
+// p.debounce the cluster is scanned again, until two scans agree. Waiting
+// stops after a poll interval, so guests changing on every scan are still
+// pushed. It returns the scan to push and the JSON of its configuration.
+// The re-scans are not recorded as polls, only the scan pushed is.
+func (p *Provider) debounceConfiguration(ctx context.Context, result *scanResult, scan func(context.Context) (*scanResult, error)) (*scanResult, []byte, error) {
+	data, err := json.Marshal(result.configuration)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding configuration: %w", err)
+	}
+	if p.debounce <= 0 || p.lastPushed == nil || bytes.Equal(data, p.lastPushed) {
+		return result, data, nil
+	}
+
+	for waited := time.Duration(0); waited < p.pollInterval; waited += p.debounce {
+		p.logger.Debugf("Configuration changed, waiting %v for the labels to settle", p.debounce)
+		timer := time.NewTimer(p.debounce)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		next, err := scan(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			p.logger.Warnf("Error re-scanning during debounce, pushing the last scan: %v", err)
+			return result, data, nil
+		}
+		nextData, err := json.Marshal(next.configuration)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error encoding configuration: %w", err)
+		}
+
+		settled := bytes.Equal(nextData, data)
+		result, data = next, nextData
+		if settled {
+			break
+		}
+	}
+	return result, data, nil
+}
+
+// GenerateOnce performs a single scan of the cluster and returns the
+// configuration the provider would send, without starting the polling loop.
+func (p *Provider) GenerateOnce(ctx context.Context) (*dynamic.Configuration, error) {
+	start := time.Now()
+	result, err := p.scan(ctx)
+	if err != nil {
+		p.recordScanError(err, time.Since(start))
+		return nil, err
+	}
+	p.recordScan(result)
+	return result.configuration, nil
+}
+
+// scanResult is a scan of the cluster and the configuration generated from it
+type scanResult struct {
+	configuration *dynamic.Configuration
+	servicesMap   map[string][]internal.Service
+	servicesCount int
+	labelErrors   []GuestLabelError
+	// diagnostics are the label diagnostics of the enabled guests
+	diagnostics []GuestDiagnostic
+	duration    time.Duration
+	// timedOut is set when the poll timeout cut the scan short
+	timedOut bool
+}
+
+// scan scans the cluster and generates its configuration. It leaves the
+// poll status, metrics and service changes alone, so that scans which are
+// not pushed, such as debounce re-scans, are not recorded.
+func (p *Provider) scan(ctx context.Context) (*scanResult, error) {
+	// The poll timeout cuts the scan short, keeping the guests scanned so far
+	scanCtx := ctx
+	if p.pollTimeout > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(ctx, p.pollTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	servicesMap, err := p.scanClusters(scanCtx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting service map: %w", err)
+	}
+
+	result := &scanResult{
+		servicesMap: servicesMap,
+		labelErrors: make([]GuestLabelError, 0),
+		diagnostics: make([]GuestDiagnostic, 0),
+		duration:    time.Since(start),
+		timedOut:    scanCtx.Err() != nil && ctx.Err() == nil,
+	}
+	for _, nodeName := range sortedNodeNames(servicesMap) {
+		services := sortedServices(servicesMap[nodeName])
+		result.servicesCount += len(services)
+		for _, service := range services {
+			if service.LabelError != nil {
+				result.labelErrors = append(result.labelErrors, GuestLabelError{Node: nodeName, ID: service.ID, Name: service.Name, Err: service.LabelError})
+			}
+			if !isServiceEnabled(service, p.genOptions) {
+				continue
+			}
+			for _, diagnostic := range diagnoseLabels(service.Config, p.genOptions.labelPrefix) {
+				result.diagnostics = append(result.diagnostics, GuestDiagnostic{Node: nodeName, ID: service.ID, Name: service.Name, Diagnostic: diagnostic})
+			}
+		}
+	}
+
+	genOptions := p.genOptions
+	genOptions.staticConfig = p.refreshStaticConfig()
+	result.configuration = generateConfiguration(servicesMap, genOptions)
+	if p.selfRouterRule != "" {
+		p.addSelfRouter(result.configuration)
+	}
+	prefixObjectNames(result.configuration, p.genOptions.namePrefix)
+	mergeStaticConfig(result.configuration, genOptions.staticConfig, p.logger)
+	return result, nil
+}
+
+// recordScan records the scan whose configuration is sent as a poll: its
+// metrics, the poll status, the label errors and the service changes
+func (p *Provider) recordScan(result *scanResult) {
+	if result.timedOut {
+		p.logger.Warnf("Poll timed out after %v, publishing the %d services discovered so far", p.pollTimeout, result.servicesCount)
+	}
+	p.metrics.observePoll(result.duration, result.servicesCount, true)
+	p.recordPoll(nil, result.servicesCount)
+
+	p.mu.Lock()
+	p.labelErrors = result.labelErrors
+	p.labelDiagnostics = result.diagnostics
+	p.lastPoll.LabelErrors = len(result.labelErrors)
+	p.lastSuccessfulPoll.LabelErrors = len(result.labelErrors)
+	p.mu.Unlock()
+
+	// The guests not reached before the poll timeout are missing, not
+	// removed, so the previous snapshot is kept for the next full poll
+	if result.timedOut {
+		p.logger.Debugf("Not logging service changes of a timed out poll")
+		return
+	}
+	p.logServiceChanges(result.servicesMap)
+}
+
+// recordScanError records a failed poll
+func (p *Provider) recordScanError(err error, duration time.Duration) {
+	p.metrics.observePoll(duration, 0, false)
+	p.recordPoll(err, 0)
+}
+
+// LabelErrors returns the guests whose labels could not be parsed during the last successful scan.
+func (p *Provider) LabelErrors() []GuestLabelError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]GuestLabelError(nil), p.labelErrors...)
+}
+
+// LabelDiagnostics returns the label diagnostics of the enabled guests found during the last successful scan.
+func (p *Provider) LabelDiagnostics() []GuestDiagnostic {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]GuestDiagnostic(nil), p.labelDiagnostics...)
+}
+
+// Metrics returns the scan health metrics collector, or nil when metrics are disabled.
+func (p *Provider) Metrics() *Metrics {
+	return p.metrics
+}
+
+// Stop to stop the provider and the related go routines.
+func (p *Provider) Stop() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	// Let an in-flight update return before reporting the provider stopped
+	var err error
+	done := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		err = fmt.Errorf("timed out after %v waiting for the configuration update to finish", stopTimeout)
+	}
+
+	if p.healthServer != nil {
+		if closeErr := p.healthServer.Close(); closeErr != nil {
+			return closeErr
+		}
+	}
+	return err
+}
+
+// stopTimeout bounds how long Stop waits for an in-flight update
+const stopTimeout = 10 * time.Second
+
+// startupVersionAttempts and startupVersionBackoff bound the retries of the
+// version check in New
+const (
+	startupVersionAttempts = 3
+	startupVersionBackoff  = 500 * time.Millisecond
+)
+
+// ParserConfig represents the configuration for the Proxmox API client
+type ParserConfig struct {
+	ApiEndpoint string
+	TokenId     string
+	Token       string
+	LogLevel    string
+	ValidateSSL bool
+}
+
+func newParserConfig(apiEndpoint, tokenID, token string) (ParserConfig, error) {
+	if apiEndpoint == "" || tokenID == "" || token == "" {
+		return ParserConfig{}, errors.New("missing mandatory values: apiEndpoint, tokenID or token")
+	}
+	return ParserConfig{
+		ApiEndpoint: apiEndpoint,
+		TokenId:     tokenID,
+		Token:       token,
+		LogLevel:    "info",
+		ValidateSSL: true,
+	}, nil
+}
+
+func newClient(pc ParserConfig) *internal.ProxmoxClient {
+	return internal.NewProxmoxClient(pc.ApiEndpoint, pc.TokenId, pc.Token, pc.ValidateSSL, pc.LogLevel)
+}
+
+// newAPIClient creates the client of the Proxmox API from the configuration
+func newAPIClient(config *Config) (*internal.ProxmoxClient, error) {
+	token := config.ApiToken
+	if config.ApiTokenFile != "" {
+		var err error
+		token, err = internal.ReadTokenFile(config.ApiTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: apiTokenFile: %w", err)
+		}
+	}
+
+	pc, err := newParserConfig(
+		config.ApiEndpoint,
+		config.ApiTokenId,
+		token,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parser config: %w", err)
+	}
+
+	pc.LogLevel = config.ApiLogging
+	pc.ValidateSSL = config.ApiValidateSSL == "true"
+	client := newClient(pc)
+	if config.ApiTokenFile != "" {
+		client.SetTokenFile(config.ApiTokenFile)
+	}
+	if config.UserAgent != "" {
+		client.UserAgent = config.UserAgent
+	}
+	if config.ApiLogBodyLimit != "" {
+		limit, err := stringToInt(config.ApiLogBodyLimit)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid configuration: apiLogBodyLimit must be a non-negative number of bytes, got %q", config.ApiLogBodyLimit)
+		}
+		client.LogBodyLimit = limit
+	}
+	if err := configureConnectionPool(client, config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := configureRateLimit(client, config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if config.ApiProxyURL != "" {
+		if err := client.SetProxy(config.ApiProxyURL); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+	if config.ApiBasePath != "" {
+		if err := client.SetBasePath(config.ApiBasePath); err != nil {
+			return nil, fmt.Errorf("invalid configuration: apiBasePath: %w", err)
+		}
+	}
+	if config.ApiExtraHeaders != "" {
+		headers, err := parseExtraHeaders(config.ApiExtraHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+		if err := client.SetExtraHeaders(headers); err != nil {
+			return nil, fmt.Errorf("invalid configuration: apiExtraHeaders: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+func logVersion(client *internal.ProxmoxClient, ctx context.Context) error {
+	version, err := client.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
+	client.Logger.Infof("Connected to Proxmox VE version %s", version.Release)
+	return nil
+}
+
+// logVersionWithRetry checks the version up to attempts times, doubling the
+// backoff between attempts, so startup survives an API that is briefly
+// unavailable, e.g. right after a Proxmox upgrade. Authentication errors are
+// not retried. The last error is returned once all attempts failed.
+func logVersionWithRetry(client *internal.ProxmoxClient, ctx context.Context, attempts int, backoff time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		err := logVersion(client, ctx)
+		if err == nil {
+			return nil
+		}
+		var apiErr *internal.APIError
+		if attempt >= attempts || (errors.As(err, &apiErr) && apiErr.IsAuthError()) {
+			return err
+		}
+
+		client.Logger.Warnf("Proxmox version check failed (attempt %d/%d), retrying in %s: %v", attempt, attempts, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func getServiceMap(client *internal.ProxmoxClient, ctx context.Context, opts scanOptions) (map[string][]internal.Service, error) {
+	servicesMap := make(map[string][]internal.Service)
+
+	nodes, err := client.GetNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning nodes: %w", err)
+	}
+
+	if len(opts.pools) > 0 || opts.resolvePools {
+		guestPools, err := getGuestPools(client, ctx)
+		if err != nil {
+			// Without pool memberships the pools filter cannot be applied safely
+			if len(opts.pools) > 0 {
+				return nil, fmt.Errorf("error scanning pools: %w", err)
+			}
+			opts.logger.Warnf("Error scanning pools, guests are handled without pool: %v", err)
+		}
+		opts.guestPools = guestPools
+	}
+
+	if opts.haAware {
+		guestHA, err := getGuestHA(client, ctx)
+		if err != nil {
+			// Without the HA status every guest is handled as not managed by HA
+			opts.logger.Warnf("Error reading the HA status, guests are handled without HA: %v", err)
+		}
+		opts.guestHA = guestHA
+	}
+
+	for _, nodeStatus := range nodes {
+		if ctx.Err() != nil {
+			// Out of time, the remaining nodes are left out
+			break
+		}
+		if !opts.isNodeAllowed(nodeStatus.Node) {
+			opts.logger.Debugf("Skipping node %s because it is filtered out by includeNodes/excludeNodes", nodeStatus.Node)
+			continue
+		}
+
+		if allowed, until := opts.nodeBreaker.allow(nodeStatus.Node); !allowed {
+			opts.logger.Debugf("Skipping node %s until %s after repeated scan failures", nodeStatus.Node, until.Format(time.RFC3339))
+			continue
+		}
+
+		services, err := scanServices(client, ctx, nodeStatus.Node, opts.forNode(nodeStatus.Node))
+		if err != nil {
+			opts.metrics.observeNodeScanError(nodeStatus.Node)
+
+			var partialErr *PartialScanError
+			if !errors.As(err, &partialErr) {
+				opts.logger.Errorf("Error scanning services on node %s: %v", nodeStatus.Node, err)
+				// A scan cut short by the poll timeout says nothing about the node
+				if ctx.Err() == nil {
+					if cooldown := opts.nodeBreaker.recordFailure(nodeStatus.Node); cooldown > 0 {
+						opts.logger.Warnf("Node %s keeps failing, skipping it for %v", nodeStatus.Node, cooldown)
+					}
+				}
+				continue
+			}
+			opts.logger.Warnf("Partially scanned node %s, keeping %d services: %v", nodeStatus.Node, len(services), err)
+		}
+		if opts.nodeBreaker.recordSuccess(nodeStatus.Node) {
+			opts.logger.Infof("Node %s recovered, scanning it again every poll", nodeStatus.Node)
+		}
+		servicesMap[nodeStatus.Node] = services
+	}
+	resolveNodeAddresses(client, ctx, servicesMap, opts)
+	return servicesMap, nil
+}
+
+// getGuestPools maps the VMID of every guest belonging to a pool to the pool ID
+func getGuestPools(client *internal.ProxmoxClient, ctx context.Context) (map[uint64]string, error) {
+	pools, err := client.GetPools(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	for {
-		select {
-		case <-ticker.C:
-			if err := p.updateConfiguration(ctx, cfgChan); err != nil {
-				log.Printf("Error updating configuration: %v", err)
+	guestPools := make(map[uint64]string)
+	for _, pool := range pools {
+		members, err := client.GetPoolMembers(ctx, pool.PoolID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting members of pool %s: %w", pool.PoolID, err)
+		}
+		for _, member := range members {
+			if member.IsGuest() {
+				guestPools[member.VMID] = pool.PoolID
 			}
-		case <-ctx.Done():
-			return
 		}
 	}
+	return guestPools, nil
 }
 
-func (p *Provider) updateConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) error {
-	servicesMap, err := getServiceMap(p.client, ctx)
-	if err != nil {
-		return fmt.Errorf("error getting service map: %w", err)
-	}
+// forNode returns the options for scanning a node, logging with its name
+func (o scanOptions) forNode(nodeName string) scanOptions {
+	o.logger = o.logger.With("node", nodeName)
+	return o
+}
 
-	configuration := generateConfiguration(servicesMap)
-	cfgChan <- &dynamic.JSONPayload{Configuration: configuration}
-	return nil
+// forGuest returns the options for scanning a guest, logging with its VMID, name and type
+func (o scanOptions) forGuest(vmID uint64, name string, guestType string) scanOptions {
+	o.logger = o.logger.With("vmid", vmID).With("service", name).With("type", guestType)
+	return o
 }
 
-// Stop to stop the provider and the related go routines.
-func (p *Provider) Stop() error {
-	if p.cancel != nil {
-		p.cancel()
+// Modes of the labelBlockMode option
+const (
+	// labelBlockLenient keeps the valid labels of an invalid label block
+	labelBlockLenient = "lenient"
+	// labelBlockStrict drops all labels of a guest with an invalid label block
+	labelBlockStrict = "strict"
+)
+
+// parseGuestLabels reads the labels of a guest from its notes. Problems of
+// its label block, whether it does not parse or has keys that are not
+// Traefik labels, are returned with the labels, which are empty in strict
+// mode so the guest is left out while other guests are unaffected.
+func (o scanOptions) parseGuestLabels(config *internal.ParsedConfig) (map[string]string, error) {
+	section := o.labelSection(config)
+	labels, err := section.GetTraefikMap(o.labelPrefix, o.labelSeparator)
+	if err == nil {
+		err = section.ValidateLabelBlock(o.labelPrefix, validateLabelKey)
 	}
-	return nil
+	if err != nil && o.strictLabelBlocks {
+		return make(map[string]string), err
+	}
+	return labels, err
 }
 
-// ParserConfig represents the configuration for the Proxmox API client
-type ParserConfig struct {
-	ApiEndpoint string
-	TokenId     string
-	Token       string
-	LogLevel    string
-	ValidateSSL bool
+// labelSection returns the part of the notes of a guest labels are read
+// from: the lines below the labelMarker, or nothing when the marker is
+// missing and labelMarkerRequired is set
+func (o scanOptions) labelSection(config *internal.ParsedConfig) *internal.ParsedConfig {
+	if o.labelMarkerRequired && !config.HasLabelMarker(o.labelMarker) {
+		section := *config
+		section.Description = ""
+		return &section
+	}
+	return config.WithLabelMarker(o.labelMarker)
 }
 
-func newParserConfig(apiEndpoint, tokenID, token string) (ParserConfig, error) {
-	if apiEndpoint == "" || tokenID == "" || token == "" {
-		return ParserConfig{}, errors.New("missing mandatory values: apiEndpoint, tokenID or token")
+// isPoolAllowed reports whether a guest in the given pool is scanned.
+// Without a pools filter every guest is allowed, including those in no pool.
+func (o scanOptions) isPoolAllowed(pool string) bool {
+	return len(o.pools) == 0 || containsString(o.pools, pool)
+}
+
+// isGuestExcluded reports whether a guest is dropped by excludeVMIDs or
+// excludeNamePatterns, which apply before its labels are read
+func (o scanOptions) isGuestExcluded(vmID uint64, name string) bool {
+	return o.excludeVMIDs[vmID] || matchesAnyNodePattern(o.excludeNamePatterns, name)
+}
+
+// parseVMIDs parses a comma-separated list of VMIDs
+func parseVMIDs(value string) (map[uint64]bool, error) {
+	vmIDs := make(map[uint64]bool)
+	for _, entry := range splitList(value) {
+		vmID, err := strconv.ParseUint(entry, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a VMID", entry)
+		}
+		vmIDs[vmID] = true
 	}
-	return ParserConfig{
-		ApiEndpoint: apiEndpoint,
-		TokenId:     tokenID,
-		Token:       token,
-		LogLevel:    "info",
-		ValidateSSL: true,
-	}, nil
+	return vmIDs, nil
 }
 
-func newClient(pc ParserConfig) *internal.ProxmoxClient {
-	return internal.NewProxmoxClient(pc.ApiEndpoint, pc.TokenId, pc.Token, pc.ValidateSSL, pc.LogLevel)
+// isNodeAllowed reports whether a node passes the include/exclude filters.
+// An empty include list allows all nodes; the exclude list always wins.
+func (o scanOptions) isNodeAllowed(nodeName string) bool {
+	if matchesAnyNodePattern(o.excludeNodes, nodeName) {
+		return false
+	}
+	return len(o.includeNodes) == 0 || matchesAnyNodePattern(o.includeNodes, nodeName)
 }
 
-func logVersion(client *internal.ProxmoxClient, ctx context.Context) error {
-	version, err := client.GetVersion(ctx)
-	if err != nil {
-		return err
+// matchesAnyNodePattern reports whether nodeName matches one of the patterns
+func matchesAnyNodePattern(patterns []nodePattern, nodeName string) bool {
+	for _, pattern := range patterns {
+		if pattern.match(nodeName) {
+			return true
+		}
 	}
-	log.Printf("Connected to Proxmox VE version %s", version.Release)
-	return nil
+	return false
 }
 
-func getServiceMap(client *internal.ProxmoxClient, ctx context.Context) (map[string][]internal.Service, error) {
-	servicesMap := make(map[string][]internal.Service)
+// nodePattern is a compiled entry of a node or guest name filter
+type nodePattern struct {
+	glob   string
+	regexp *regexp.Regexp
+}
 
-	nodes, err := client.GetNodes(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error scanning nodes: %w", err)
+func (p nodePattern) match(name string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
 	}
+	// The glob was validated when it was parsed
+	matched, _ := path.Match(p.glob, name)
+	return matched
+}
 
-	for _, nodeStatus := range nodes {
-		services, err := scanServices(client, ctx, nodeStatus.Node)
-		if err != nil {
-			log.Printf("Error scanning services on node %s: %v", nodeStatus.Node, err)
+// parseNodePatterns compiles the entries of a node or guest name filter
+// once, when the configuration is read: a regular expression between
+// slashes matching the whole name, such as /pve-(edge|core)-[0-9]+/, or a
+// glob pattern such as pve-edge-*, which is a literal name when it has no
+// wildcards
+func parseNodePatterns(option, value string) ([]nodePattern, error) {
+	patterns := make([]nodePattern, 0)
+	for _, entry := range splitList(value) {
+		if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			re, err := regexp.Compile("^(?:" + entry[1:len(entry)-1] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%s entry %q is not a valid pattern: %w", option, entry, err)
+			}
+			patterns = append(patterns, nodePattern{regexp: re})
 			continue
 		}
-		servicesMap[nodeStatus.Node] = services
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, fmt.Errorf("%s entry %q is not a valid pattern: %w", option, entry, err)
+		}
+		patterns = append(patterns, nodePattern{glob: entry})
 	}
-	return servicesMap, nil
+	return patterns, nil
+}
+
+// matchesConstraintTags reports whether a guest carries at least one of the
+// configured constraint tags. Without constraint tags every guest matches.
+func (o scanOptions) matchesConstraintTags(tags []string) bool {
+	if len(o.constraintTags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if containsString(o.constraintTags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// withGuestTimeout derives a context bounding a single per-guest operation.
+// A zero scan timeout only inherits the deadline of the parent context.
+func (o scanOptions) withGuestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.scanTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.scanTimeout)
 }
 
 func getIPsOfService(client *internal.ProxmoxClient, ctx context.Context, nodeName string, vmID uint64) (ips []internal.IP, err error) {
@@ -208,72 +1408,283 @@ func getIPsOfService(client *internal.ProxmoxClient, ctx context.Context, nodeNa
 	return interfaces.GetIPs(), nil
 }
 
-func scanServices(client *internal.ProxmoxClient, ctx context.Context, nodeName string) (services []internal.Service, err error) {
+// PartialScanError is returned by scanServices when either the VMs or the
+// containers of a node could not be listed. The guests that were scanned
+// successfully are still returned alongside it.
+type PartialScanError struct {
+	Node string
+	Err  error
+}
+
+func (e *PartialScanError) Error() string {
+	return fmt.Sprintf("partial scan of node %s: %v", e.Node, e.Err)
+}
+
+func (e *PartialScanError) Unwrap() error {
+	return e.Err
+}
+
+// Scan modes of the scanMode option
+const (
+	scanModeAll        = "all"
+	scanModeVMs        = "vms"
+	scanModeContainers = "containers"
+)
+
+// parseExposeTypes parses the guest types of the exposeTypes option. Types
+// the scan mode does not list are rejected when no other type is exposed,
+// since no guest could ever be published.
+func parseExposeTypes(value string, scanMode string) ([]string, error) {
+	types := splitList(strings.ToLower(value))
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	scanned := false
+	for _, guestType := range types {
+		switch guestType {
+		case internal.GuestTypeVM:
+			scanned = scanned || scanMode != scanModeContainers
+		case internal.GuestTypeContainer:
+			scanned = scanned || scanMode != scanModeVMs
+		default:
+			return nil, fmt.Errorf("unknown guest type %q, use %q or %q", guestType, internal.GuestTypeVM, internal.GuestTypeContainer)
+		}
+	}
+	if !scanned {
+		return nil, fmt.Errorf("%s guests are not scanned with scanMode %q", strings.Join(types, ", "), scanMode)
+	}
+	return types, nil
+}
+
+func scanServices(client *internal.ProxmoxClient, ctx context.Context, nodeName string, opts scanOptions) (services []internal.Service, err error) {
 	// Scan virtual machines
-	vms, err := client.GetVirtualMachines(ctx, nodeName)
-	if err != nil {
-		return nil, fmt.Errorf("error scanning VMs on node %s: %w", nodeName, err)
+	var vms []internal.VirtualMachine
+	var vmErr error
+	if opts.scanMode != scanModeContainers {
+		vms, err = client.GetVirtualMachines(ctx, nodeName)
+		if err != nil {
+			vmErr = fmt.Errorf("error scanning VMs on node %s: %w", nodeName, err)
+		}
 	}
 
 	for _, vm := range vms {
-		log.Printf("Scanning VM %s/%s (%d): %s", nodeName, vm.Name, vm.VMID, vm.Status)
-		
-		if vm.Status == "running" {
-			config, err := client.GetVMConfig(ctx, nodeName, vm.VMID)
+		if ctx.Err() != nil {
+			break
+		}
+		opts := opts.forGuest(vm.VMID, vm.Name, internal.GuestTypeVM)
+		opts.logger.Debugf("Scanning VM %s/%s (%d): %s", nodeName, vm.Name, vm.VMID, vm.Status)
+
+		if opts.isGuestExcluded(vm.VMID, vm.Name) {
+			opts.logger.Debugf("Skipping VM %s (%d) because it is excluded by excludeVMIDs/excludeNamePatterns", vm.Name, vm.VMID)
+			continue
+		}
+
+		tags := internal.ParseTags(vm.Tags)
+		if !opts.matchesConstraintTags(tags) {
+			opts.logger.Debugf("Skipping VM %s (%d) because it has no matching constraint tag", vm.Name, vm.VMID)
+			continue
+		}
+		pool := opts.guestPools[vm.VMID]
+		if !opts.isPoolAllowed(pool) {
+			opts.logger.Debugf("Skipping VM %s (%d) because it is not in one of the configured pools", vm.Name, vm.VMID)
+			continue
+		}
+
+		if vm.Status == internal.StatusRunning || opts.includeStopped {
+			config, err := getGuestConfig(ctx, opts, fmt.Sprintf("%s/qemu/%d", nodeName, vm.VMID), guestSignature(vm.Name, vm.Status, vm.Tags), func(ctx context.Context) (*internal.ParsedConfig, error) {
+				config, err := client.GetVMConfig(ctx, nodeName, vm.VMID)
+				if err == nil && opts.cloudInitLabels && config.HasCloudInit() {
+					config.CloudInitUserData, err = client.GetVMCloudInitUserData(ctx, nodeName, vm.VMID)
+					if err != nil {
+						opts.logger.Warnf("Error getting cloud-init user-data of VM %s (%d), using the labels of its notes only: %v", vm.Name, vm.VMID, err)
+						err = nil
+					}
+				}
+				return config, err
+			})
 			if err != nil {
-				log.Printf("Error getting VM config for %d: %v", vm.VMID, err)
+				opts.logger.Errorf("Error getting VM config for %d: %v", vm.VMID, err)
 				continue
 			}
-			
-			traefikConfig := config.GetTraefikMap()
-			log.Printf("VM %s (%d) traefik config: %v", vm.Name, vm.VMID, traefikConfig)
-			
+
+			traefikConfig, err := opts.parseGuestLabels(config)
+			if err != nil {
+				opts.logger.Errorf("Error parsing label block for VM %s (%d): %v", vm.Name, vm.VMID, err)
+			}
+			if opts.cloudInitLabels {
+				// Labels of the description take precedence
+				for key, value := range config.GetCloudInitLabels(opts.labelPrefix, opts.labelSeparator) {
+					if _, exists := traefikConfig[key]; !exists {
+						traefikConfig[key] = value
+					}
+				}
+			}
+			opts.logger.Debugf("VM %s (%d) traefik config: %v", vm.Name, vm.VMID, traefikConfig)
+
 			service := internal.NewService(vm.VMID, vm.Name, traefikConfig)
-			
-			ips, err := getIPsOfService(client, ctx, nodeName, vm.VMID)
-			if err == nil {
-				service.IPs = ips
+			service.LabelError = err
+			service.Status = vm.Status
+			service.Type = internal.GuestTypeVM
+			service.Tags = tags
+			service.Pool = pool
+			service.Onboot, service.Protected = config.IsOnboot(), config.IsProtected()
+			opts.applyHAStatus(&service)
+			service.CPU, service.Mem, service.MaxMem = vm.CPU, vm.Mem, vm.MaxMem
+			if service.IsRunning() && isBoolLabelEnabled(traefikConfig, opts.labelPrefix+"proxmox.autoweight") {
+				refreshGuestStatus(client, ctx, nodeName, &service, opts)
+			}
+
+			// An explicit address takes precedence over the guest agent,
+			// which is only reachable while the VM is running
+			if staticIPs, exists := traefikConfig[opts.labelPrefix+"proxmox.ip"]; exists {
+				service.IPs = internal.ParseStaticIPs(staticIPs)
+			} else if service.IsRunning() && !opts.disableGuestAgent {
+				guestCtx, cancel := opts.withGuestTimeout(ctx)
+				ips, err := getIPsOfService(client, guestCtx, nodeName, vm.VMID)
+				cancel()
+				var apiErr *internal.APIError
+				switch {
+				case err == nil:
+					service.IPs = ips
+				case errors.Is(err, context.DeadlineExceeded):
+					opts.logger.Warnf("Timed out getting IPs of VM %s (%d) after %v, falling back to hostname", vm.Name, vm.VMID, opts.scanTimeout)
+				case errors.As(err, &apiErr) && apiErr.IsGuestAgentNotRunning():
+					// Expected for VMs without an agent
+					opts.logger.Debugf("Guest agent of VM %s (%d) is not running, falling back to hostname", vm.Name, vm.VMID)
+				default:
+					opts.logger.Errorf("Error getting IPs of VM %s (%d), falling back to hostname: %v", vm.Name, vm.VMID, err)
+				}
+			}
+
+			// A guest whose lookups were cut short by the poll deadline is left out
+			if ctx.Err() != nil {
+				break
 			}
-			
 			services = append(services, service)
 		}
 	}
 
 	// Scan containers
-	cts, err := client.GetContainers(ctx, nodeName)
-	if err != nil {
-		return nil, fmt.Errorf("error scanning containers on node %s: %w", nodeName, err)
+	var cts []internal.Container
+	var ctErr error
+	if opts.scanMode != scanModeVMs {
+		cts, err = client.GetContainers(ctx, nodeName)
+		if err != nil {
+			ctErr = fmt.Errorf("error scanning containers on node %s: %w", nodeName, err)
+		}
 	}
 
 	for _, ct := range cts {
-		log.Printf("Scanning container %s/%s (%d): %s", nodeName, ct.Name, ct.VMID, ct.Status)
-		
-		if ct.Status == "running" {
-			config, err := client.GetContainerConfig(ctx, nodeName, ct.VMID)
+		if ctx.Err() != nil {
+			break
+		}
+		opts := opts.forGuest(ct.VMID, ct.Name, internal.GuestTypeContainer)
+		opts.logger.Debugf("Scanning container %s/%s (%d): %s", nodeName, ct.Name, ct.VMID, ct.Status)
+
+		if opts.isGuestExcluded(ct.VMID, ct.Name) {
+			opts.logger.Debugf("Skipping container %s (%d) because it is excluded by excludeVMIDs/excludeNamePatterns", ct.Name, ct.VMID)
+			continue
+		}
+
+		tags := internal.ParseTags(ct.Tags)
+		if !opts.matchesConstraintTags(tags) {
+			opts.logger.Debugf("Skipping container %s (%d) because it has no matching constraint tag", ct.Name, ct.VMID)
+			continue
+		}
+		pool := opts.guestPools[ct.VMID]
+		if !opts.isPoolAllowed(pool) {
+			opts.logger.Debugf("Skipping container %s (%d) because it is not in one of the configured pools", ct.Name, ct.VMID)
+			continue
+		}
+
+		if ct.Status == internal.StatusRunning || opts.includeStopped {
+			config, err := getGuestConfig(ctx, opts, fmt.Sprintf("%s/lxc/%d", nodeName, ct.VMID), guestSignature(ct.Name, ct.Status, ct.Tags), func(ctx context.Context) (*internal.ParsedConfig, error) {
+				return client.GetContainerConfig(ctx, nodeName, ct.VMID)
+			})
 			if err != nil {
-				log.Printf("Error getting container config for %d: %v", ct.VMID, err)
+				opts.logger.Errorf("Error getting container config for %d: %v", ct.VMID, err)
 				continue
 			}
-			
-			traefikConfig := config.GetTraefikMap()
-			log.Printf("Container %s (%d) traefik config: %v", ct.Name, ct.VMID, traefikConfig)
-			
+
+			traefikConfig, err := opts.parseGuestLabels(config)
+			if err != nil {
+				opts.logger.Errorf("Error parsing label block for container %s (%d): %v", ct.Name, ct.VMID, err)
+			}
+			opts.logger.Debugf("Container %s (%d) traefik config: %v", ct.Name, ct.VMID, traefikConfig)
+
 			service := internal.NewService(ct.VMID, ct.Name, traefikConfig)
-			
+			service.LabelError = err
+			service.Status = ct.Status
+			service.Type = internal.GuestTypeContainer
+			service.Tags = tags
+			service.Pool = pool
+			service.Onboot, service.Protected = config.IsOnboot(), config.IsProtected()
+			opts.applyHAStatus(&service)
+			service.CPU, service.Mem, service.MaxMem = ct.CPU, ct.Mem, ct.MaxMem
+			if service.IsRunning() && isBoolLabelEnabled(traefikConfig, opts.labelPrefix+"proxmox.autoweight") {
+				refreshGuestStatus(client, ctx, nodeName, &service, opts)
+			}
+
 			// Try to get container IPs if possible
-			ips, err := getIPsOfService(client, ctx, nodeName, ct.VMID)
-			if err == nil {
-				service.IPs = ips
+			if staticIPs, exists := traefikConfig[opts.labelPrefix+"proxmox.ip"]; exists {
+				service.IPs = internal.ParseStaticIPs(staticIPs)
+			} else if service.IsRunning() && !opts.disableGuestAgent {
+				guestCtx, cancel := opts.withGuestTimeout(ctx)
+				interfaces, err := client.GetContainerNetworkInterfaces(guestCtx, nodeName, ct.VMID)
+				cancel()
+				var apiErr *internal.APIError
+				switch {
+				case err == nil:
+					service.IPs = internal.GetContainerIPs(interfaces)
+				case errors.Is(err, context.DeadlineExceeded):
+					opts.logger.Warnf("Timed out getting IPs of container %s (%d) after %v, falling back to its network config", ct.Name, ct.VMID, opts.scanTimeout)
+				case errors.As(err, &apiErr) && apiErr.IsNotImplemented():
+					// Expected on Proxmox VE versions without the interfaces endpoint
+					opts.logger.Debugf("Interfaces of container %s (%d) cannot be listed, falling back to its network config", ct.Name, ct.VMID)
+				default:
+					opts.logger.Errorf("Error getting IPs of container %s (%d), falling back to its network config: %v", ct.Name, ct.VMID, err)
+				}
+			}
+
+			// Fall back to the static addresses of the container network config
+			if len(service.IPs) == 0 {
+				service.IPs = config.GetNetworkIPs()
+			}
+
+			// A guest whose lookups were cut short by the poll deadline is left out
+			if ctx.Err() != nil {
+				break
 			}
-			
 			services = append(services, service)
 		}
 	}
 
+	switch {
+	case vmErr != nil && ctErr != nil:
+		return nil, fmt.Errorf("%w; %v", vmErr, ctErr)
+	case vmErr != nil:
+		return services, &PartialScanError{Node: nodeName, Err: vmErr}
+	case ctErr != nil:
+		return services, &PartialScanError{Node: nodeName, Err: ctErr}
+	}
 	return services, nil
 }
 
-func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.Configuration {
+// refreshGuestStatus replaces the resource usage from the guest list with
+// the current status of the guest, keeping the list values on failure
+func refreshGuestStatus(client *internal.ProxmoxClient, ctx context.Context, nodeName string, service *internal.Service, opts scanOptions) {
+	guestCtx, cancel := opts.withGuestTimeout(ctx)
+	defer cancel()
+	status, err := client.GetGuestStatus(guestCtx, nodeName, service.Type, service.ID)
+	if err != nil {
+		opts.logger.Warnf("Error getting status of guest %s (%d), using the last listed usage: %v", service.Name, service.ID, err)
+		return
+	}
+	service.CPU, service.Mem, service.MaxMem = status.CPU, status.Mem, status.MaxMem
+}
+
+func generateConfiguration(servicesMap map[string][]internal.Service, opts generateOptions) *dynamic.Configuration {
 	config := &dynamic.Configuration{
 		HTTP: &dynamic.HTTPConfiguration{
 			Routers:           make(map[string]*dynamic.Router),
@@ -295,108 +1706,356 @@ func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.C
 		},
 	}
 
-	// Loop through all node service maps
-	for nodeName, services := range servicesMap {
+	// VMID of the guest defining each middleware, TLS store and options and
+	// servers transport
+	owners := make(map[string]uint64)
+	controlGuests := make([]controlGuest, 0)
+
+	// Loop through all node service maps in a stable order, so that
+	// identical input always produces identical configuration
+	for _, nodeName := range sortedNodeNames(servicesMap) {
 		// Loop through all services in this node
-		for _, service := range services {
+		for _, service := range sortedServices(servicesMap[nodeName]) {
+			// Log lines about this guest carry its node, VMID and name
+			opts := opts.forGuest(nodeName, service)
+
+			// The cluster-wide objects of control guests are added once all
+			// guests have been processed, so they take precedence
+			isControl := opts.isControlGuest(service)
+			if isControl {
+				controlGuests = append(controlGuests, controlGuest{nodeName: nodeName, service: service})
+				if !isControlGuestEnabled(service, opts) {
+					opts.logger.Debugf("Using service %s (ID: %d) as a control guest only, it is not enabled by %senable=true", service.Name, service.ID, opts.labelPrefix)
+					continue
+				}
+			}
+
+			if len(opts.exposeTypes) > 0 && !containsString(opts.exposeTypes, service.Type) {
+				opts.logger.Debugf("Skipping service %s (ID: %d) because its guest type %s is not in exposeTypes", service.Name, service.ID, service.Type)
+				continue
+			}
+			if opts.requireOnboot && !service.Onboot {
+				opts.logger.Debugf("Skipping service %s (ID: %d) because it does not start on boot and requireOnboot is set", service.Name, service.ID)
+				continue
+			}
+			if opts.requireProtection && !service.Protected {
+				opts.logger.Debugf("Skipping service %s (ID: %d) because it is not protected and requireProtection is set", service.Name, service.ID)
+				continue
+			}
+
 			// Skip disabled services
-			if len(service.Config) == 0 || !isBoolLabelEnabled(service.Config, "traefik.enable") {
-				log.Printf("Skipping service %s (ID: %d) because traefik.enable is not true", service.Name, service.ID)
+			if !isServiceEnabled(service, opts) {
+				opts.logger.Debugf("Skipping service %s (ID: %d) because it is not enabled by %senable", service.Name, service.ID, opts.labelPrefix)
+				continue
+			}
+
+			if !isActiveHAInstance(service, nodeName) {
+				opts.logger.Debugf("Skipping service %s (ID: %d) on node %s because its HA resource is %s on node %s", service.Name, service.ID, nodeName, service.HAState, service.HANode)
 				continue
 			}
-			
+
+			warnUnknownApp(service, opts)
+
+			if opts.validateLabels || opts.skipInvalidGuests {
+				diagnostics := diagnoseLabels(service.Config, opts.labelPrefix)
+				if opts.validateLabels {
+					for _, diagnostic := range diagnostics {
+						opts.logger.Warnf("Service %s (ID: %d): %s", service.Name, service.ID, diagnostic)
+					}
+				}
+				if opts.skipInvalidGuests && hasDiagnosticErrors(diagnostics) {
+					opts.logger.Warnf("Skipping service %s (ID: %d) because its labels have errors", service.Name, service.ID)
+					continue
+				}
+			}
+
+			// Cluster-wide middlewares, TLS stores and options and servers
+			// transports, the guest with the lowest VMID wins
+			if !isControl {
+				for middlewareName, middleware := range getMiddlewares(service, opts) {
+					if claimDefinition(owners, "middleware "+middlewareName, service, opts) {
+						config.HTTP.Middlewares[middlewareName] = middleware
+					}
+				}
+				for storeName, store := range getTLSStores(service, opts) {
+					if claimDefinition(owners, "TLS store "+storeName, service, opts) {
+						config.TLS.Stores[storeName] = store
+					}
+				}
+				for optionsName, options := range getTLSOptions(service, opts) {
+					if claimDefinition(owners, "TLS options "+optionsName, service, opts) {
+						config.TLS.Options[optionsName] = options
+					}
+				}
+				for transportName, transport := range getServersTransports(service, opts) {
+					if claimDefinition(owners, "servers transport "+transportName, service, opts) {
+						config.HTTP.ServersTransports[transportName] = transport
+					}
+				}
+			}
+
+			// Running guests without a routable IP may be held back until they get one
+			if service.IsRunning() {
+				key := readinessKey(nodeName, service)
+				if hasRoutableAddress(service, opts) {
+					opts.ipReadiness.markReady(key)
+				} else if opts.ipReadiness.shouldOmit(key, time.Now()) {
+					opts.logger.Infof("Skipping service %s (ID: %d) because it has no routable IP yet", service.Name, service.ID)
+					continue
+				} else if opts.omitUnresolvedHostname(service, nodeName) {
+					continue
+				}
+			}
+
 			// Extract router and service names from labels
 			routerPrefixMap := make(map[string]bool)
 			servicePrefixMap := make(map[string]bool)
-			
+
+			routersPrefix := opts.labelPrefix + "http.routers."
+			servicesPrefix := opts.labelPrefix + "http.services."
 			for k := range service.Config {
-				if strings.HasPrefix(k, "traefik.http.routers.") {
-					parts := strings.Split(k, ".")
-					if len(parts) > 3 {
-						routerPrefixMap[parts[3]] = true
+				if strings.HasPrefix(k, routersPrefix) {
+					if name, _, found := strings.Cut(strings.TrimPrefix(k, routersPrefix), "."); found && name != "" {
+						routerPrefixMap[name] = true
 					}
 				}
-				if strings.HasPrefix(k, "traefik.http.services.") {
-					parts := strings.Split(k, ".")
-					if len(parts) > 3 {
-						servicePrefixMap[parts[3]] = true
+				if strings.HasPrefix(k, servicesPrefix) {
+					if name, _, found := strings.Cut(strings.TrimPrefix(k, servicesPrefix), "."); found && name != "" {
+						servicePrefixMap[name] = true
 					}
 				}
 			}
-			
-			// Default to service ID if no names found
-			defaultID := fmt.Sprintf("%s-%d", service.Name, service.ID)
-			
+
+			// Guests only declaring TCP routers and services get no default HTTP router
+			if addTCPConfiguration(config, service, nodeName, opts) && len(routerPrefixMap) == 0 && len(servicePrefixMap) == 0 {
+				continue
+			}
+
 			// Convert maps to slices
 			routerNames := mapKeysToSlice(routerPrefixMap)
 			serviceNames := mapKeysToSlice(servicePrefixMap)
-			
+
 			// Use defaults if no names found
+			defaultRouter, defaultService := getDefaultNames(service, opts)
 			if len(routerNames) == 0 {
-				routerNames = []string{defaultID}
+				routerNames = []string{defaultRouter}
 			}
 			if len(serviceNames) == 0 {
-				serviceNames = []string{defaultID}
+				serviceNames = []string{defaultService}
 			}
-			
+
+			// Stopped guests are routed to the placeholder service when one is configured
+			useStoppedService := !service.IsRunning() && opts.stoppedService != ""
+
 			// Create services
 			for _, serviceName := range serviceNames {
+				if useStoppedService {
+					continue
+				}
+
 				// Configure load balancer options
 				loadBalancer := &dynamic.ServersLoadBalancer{
 					PassHostHeader: boolPtr(true), // Default is true
 					Servers:        []dynamic.Server{},
 				}
-				
+
 				// Apply service options
-				applyServiceOptions(loadBalancer, service, serviceName)
-				
+				applyServiceOptions(loadBalancer, service, serviceName, opts)
+
+				// Attach the shared insecure transport to HTTPS backends flagged as insecure
+				if loadBalancer.ServersTransport == "" && isInsecureHTTPSService(service, serviceName, opts) {
+					config.HTTP.ServersTransports[insecureServersTransportName] = &dynamic.ServersTransport{
+						InsecureSkipVerify: true,
+					}
+					loadBalancer.ServersTransport = insecureServersTransportName
+				}
+
 				// Add server URL(s)
-				serverURL := getServiceURL(service, serviceName, nodeName)
-				loadBalancer.Servers = append(loadBalancer.Servers, dynamic.Server{
-					URL: serverURL,
-				})
-				
-				config.HTTP.Services[serviceName] = &dynamic.Service{
-					LoadBalancer: loadBalancer,
+				for _, serverURL := range getServerURLs(service, serviceName, nodeName, opts) {
+					loadBalancer.Servers = append(loadBalancer.Servers, dynamic.Server{
+						URL: serverURL,
+					})
+				}
+
+				if ips := getFailoverIPs(service, serviceName, opts); len(ips) > 1 {
+					addFailoverService(config, serviceName, service, nodeName, loadBalancer, ips, opts)
+					continue
+				}
+
+				if isBoolLabelEnabled(service.Config, opts.labelPrefix+"proxmox.autoweight") {
+					addWeightedService(config, serviceName, service, loadBalancer, opts)
+					continue
 				}
+
+				addLoadBalancerService(config, serviceName, service, loadBalancer, opts)
 			}
-			
+
 			// Create routers
 			for _, routerName := range routerNames {
 				// Get router rule
-				rule := getRouterRule(service, routerName)
-				
-				// Find target service (prefer explicit mapping)
-				targetService := serviceNames[0]
-				serviceLabel := fmt.Sprintf("traefik.http.routers.%s.service", routerName)
-				if val, exists := service.Config[serviceLabel]; exists {
-					targetService = val
+				rule := getRouterRule(service, routerName, opts)
+
+				// Find target service
+				targetService := opts.stoppedService
+				if !useStoppedService {
+					var found bool
+					targetService, found = getRouterTargetService(service, routerName, serviceNames, opts)
+					if !found {
+						opts.logger.Warnf("Service %s (ID: %d): router %s matches none of the services %s, set %shttp.routers.%s.service to choose one",
+							service.Name, service.ID, routerName, strings.Join(serviceNames, ", "), opts.labelPrefix, routerName)
+						continue
+					}
 				}
-				
+
 				// Create basic router
 				router := &dynamic.Router{
 					Service:  targetService,
 					Rule:     rule,
-					Priority: 1, // Default priority
+					Priority: defaultRouterPriority(rule),
 				}
-				
+
 				// Apply additional router options from labels
-				applyRouterOptions(router, service, routerName)
-				
+				applyRouterOptions(router, service, routerName, opts)
+				addNodeHeader(config, router, nodeName, opts)
+				addGuestHeaders(config, router, service, nodeName, opts)
+				router.Middlewares = appendDefaultMiddlewares(router.Middlewares, opts.defaultMiddlewares)
+
+				if httpsRedirectEnabled(service, opts) {
+					addHTTPSRedirect(config, routerName, router, opts)
+				}
+
 				config.HTTP.Routers[routerName] = router
 			}
-			
-			log.Printf("Created router and service for %s (ID: %d)", service.Name, service.ID)
+
+			opts.logger.Debugf("Created router and service for %s (ID: %d)", service.Name, service.ID)
 		}
 	}
-	
+
+	addControlGuestObjects(config, owners, controlGuests, opts)
+
+	// Servers of merged guests add up, so the cap applies once all guests
+	// have been processed
+	limitServers(config, opts)
+
+	// Routers may use middlewares defined on other guests, so references are
+	// only checked once all guests have been processed
+	checkMiddlewareReferences(config, opts)
+
 	return config
 }
 
+// forGuest returns the options for generating the configuration of a guest,
+// logging with its node, VMID, name and type
+func (o generateOptions) forGuest(nodeName string, service internal.Service) generateOptions {
+	o.logger = o.logger.With("node", nodeName).With("vmid", service.ID).With("service", service.Name).With("type", service.Type)
+	return o
+}
+
+// addLoadBalancerService publishes the load balancer of a guest. Guests
+// declaring the same service name are merged into a single load balancer
+// with one server per guest; the options of the first guest apply.
+func addLoadBalancerService(config *dynamic.Configuration, serviceName string, service internal.Service, loadBalancer *dynamic.ServersLoadBalancer, opts generateOptions) {
+	existing, exists := config.HTTP.Services[serviceName]
+	if !exists {
+		config.HTTP.Services[serviceName] = &dynamic.Service{
+			LoadBalancer: loadBalancer,
+		}
+		return
+	}
+	if existing.LoadBalancer == nil {
+		opts.logger.Warnf("Service %s (ID: %d): service %s is already defined as a weighted service by another guest, ignoring this guest", service.Name, service.ID, serviceName)
+		return
+	}
+
+	for _, server := range loadBalancer.Servers {
+		if !containsServer(existing.LoadBalancer.Servers, server.URL) {
+			existing.LoadBalancer.Servers = append(existing.LoadBalancer.Servers, server)
+		}
+	}
+}
+
+// limitServers truncates the HTTP and TCP load balancers with more than
+// maxServers servers, keeping the first ones in the generation order
+func limitServers(config *dynamic.Configuration, opts generateOptions) {
+	if opts.maxServers <= 0 {
+		return
+	}
+
+	names := make([]string, 0, len(config.HTTP.Services))
+	for name := range config.HTTP.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lb := config.HTTP.Services[name].LoadBalancer
+		if lb == nil || len(lb.Servers) <= opts.maxServers {
+			continue
+		}
+		opts.logger.Warnf("Service %s has %d servers, keeping the first %d (maxServersPerService)", name, len(lb.Servers), opts.maxServers)
+		lb.Servers = lb.Servers[:opts.maxServers]
+	}
+
+	names = make([]string, 0, len(config.TCP.Services))
+	for name := range config.TCP.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lb := config.TCP.Services[name].LoadBalancer
+		if lb == nil || len(lb.Servers) <= opts.maxServers {
+			continue
+		}
+		opts.logger.Warnf("TCP service %s has %d servers, keeping the first %d (maxServersPerService)", name, len(lb.Servers), opts.maxServers)
+		lb.Servers = lb.Servers[:opts.maxServers]
+	}
+}
+
+// Helper to check whether a load balancer already has a server URL
+func containsServer(servers []dynamic.Server, url string) bool {
+	for _, server := range servers {
+		if server.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to get the router and service names of a guest without router or
+// service labels: the proxmox.routername and proxmox.servicename labels, so
+// renaming the guest keeps its Traefik objects, else <name>-<vmid>
+func getDefaultNames(service internal.Service, opts generateOptions) (string, string) {
+	defaultID := fmt.Sprintf("%s-%d", service.Name, service.ID)
+	routerName, serviceName := defaultID, defaultID
+	if val := strings.TrimSpace(service.Config[opts.labelPrefix+"proxmox.routername"]); val != "" {
+		routerName = val
+	}
+	if val := strings.TrimSpace(service.Config[opts.labelPrefix+"proxmox.servicename"]); val != "" {
+		serviceName = val
+	}
+	return routerName, serviceName
+}
+
+// Helper to find the service a router points to: the explicit service label,
+// the service named like the router, or the only service of the guest.
+// It reports false when the guest has several services and none applies.
+func getRouterTargetService(service internal.Service, routerName string, serviceNames []string, opts generateOptions) (string, bool) {
+	serviceLabel := fmt.Sprintf("%shttp.routers.%s.service", opts.labelPrefix, routerName)
+	if val, exists := service.Config[serviceLabel]; exists {
+		return val, true
+	}
+	if containsString(serviceNames, routerName) {
+		return routerName, true
+	}
+	if len(serviceNames) == 1 {
+		return serviceNames[0], true
+	}
+	return "", false
+}
+
 // Apply router configuration options from labels
-func applyRouterOptions(router *dynamic.Router, service internal.Service, routerName string) {
-	prefix := fmt.Sprintf("traefik.http.routers.%s", routerName)
-	
+func applyRouterOptions(router *dynamic.Router, service internal.Service, routerName string, opts generateOptions) {
+	prefix := fmt.Sprintf("%shttp.routers.%s", opts.labelPrefix, routerName)
+
 	// Handle EntryPoints
 	if entrypoints, exists := service.Config[prefix+".entrypoints"]; exists {
 		// Backward compatibility with singular form
@@ -404,19 +2063,20 @@ func applyRouterOptions(router *dynamic.Router, service internal.Service, router
 	} else if entrypoint, exists := service.Config[prefix+".entrypoint"]; exists {
 		router.EntryPoints = []string{entrypoint}
 	}
-	
-	// Handle Middlewares
+
+	// Handle Middlewares, keeping the listed order since Traefik applies
+	// them in sequence
 	if middlewares, exists := service.Config[prefix+".middlewares"]; exists {
-		router.Middlewares = strings.Split(middlewares, ",")
+		router.Middlewares = splitList(middlewares)
 	}
-	
+
 	// Handle Priority
 	if priority, exists := service.Config[prefix+".priority"]; exists {
 		if p, err := stringToInt(priority); err == nil {
 			router.Priority = p
 		}
 	}
-	
+
 	// Handle TLS
 	tls := handleRouterTLS(service, prefix)
 	if tls != nil {
@@ -425,33 +2085,35 @@ func applyRouterOptions(router *dynamic.Router, service internal.Service, router
 }
 
 // Apply service configuration options from labels
-func applyServiceOptions(lb *dynamic.ServersLoadBalancer, service internal.Service, serviceName string) {
-	prefix := fmt.Sprintf("traefik.http.services.%s.loadbalancer", serviceName)
-	
-	// Handle PassHostHeader
+func applyServiceOptions(lb *dynamic.ServersLoadBalancer, service internal.Service, serviceName string, opts generateOptions) {
+	prefix := fmt.Sprintf("%shttp.services.%s.loadbalancer", opts.labelPrefix, serviceName)
+
+	// Handle PassHostHeader, keeping the default of true when the label is absent or invalid
 	if passHostHeader, exists := service.Config[prefix+".passhostheader"]; exists {
 		if val, err := stringToBool(passHostHeader); err == nil {
 			lb.PassHostHeader = &val
+		} else {
+			opts.logger.Warnf("Ignoring invalid passhostheader value for service %s: %v", serviceName, err)
 		}
 	}
-	
+
 	// Handle HealthCheck
 	if healthcheckPath, exists := service.Config[prefix+".healthcheck.path"]; exists {
 		hc := &dynamic.ServerHealthCheck{
 			Path: healthcheckPath,
 		}
-		
+
 		if interval, exists := service.Config[prefix+".healthcheck.interval"]; exists {
 			hc.Interval = interval
 		}
-		
+
 		if timeout, exists := service.Config[prefix+".healthcheck.timeout"]; exists {
 			hc.Timeout = timeout
 		}
-		
+
 		lb.HealthCheck = hc
 	}
-	
+
 	// Handle Sticky Sessions
 	if cookieName, exists := service.Config[prefix+".sticky.cookie.name"]; exists {
 		sticky := &dynamic.Sticky{
@@ -459,28 +2121,284 @@ func applyServiceOptions(lb *dynamic.ServersLoadBalancer, service internal.Servi
 				Name: cookieName,
 			},
 		}
-		
+
 		if secure, exists := service.Config[prefix+".sticky.cookie.secure"]; exists {
 			if val, err := stringToBool(secure); err == nil {
 				sticky.Cookie.Secure = val
 			}
 		}
-		
+
 		if httpOnly, exists := service.Config[prefix+".sticky.cookie.httponly"]; exists {
 			if val, err := stringToBool(httpOnly); err == nil {
 				sticky.Cookie.HTTPOnly = val
 			}
 		}
-		
+
 		lb.Sticky = sticky
 	}
-	
-	// Handle ResponseForwarding
+
+	// Handle ResponseForwarding, leaving out a malformed flush interval
 	if flushInterval, exists := service.Config[prefix+".responseforwarding.flushinterval"]; exists {
-		lb.ResponseForwarding = &dynamic.ResponseForwarding{
-			FlushInterval: flushInterval,
+		if isValidFlushInterval(flushInterval) {
+			lb.ResponseForwarding = &dynamic.ResponseForwarding{
+				FlushInterval: strings.TrimSpace(flushInterval),
+			}
+		} else {
+			opts.logger.Warnf("Ignoring invalid responseforwarding.flushinterval value %q for service %s, expected a duration such as 100ms", flushInterval, serviceName)
+		}
+	}
+
+	// Handle ServersTransport reference
+	if transport, exists := service.Config[prefix+".serverstransport"]; exists {
+		lb.ServersTransport = transport
+	}
+}
+
+// Helper to check a flush interval the way Traefik parses durations: a Go
+// duration such as 100ms, or a number of seconds. Negative values flush
+// immediately after each write.
+func isValidFlushInterval(value string) bool {
+	value = strings.TrimSpace(value)
+	if _, err := time.ParseDuration(value); err == nil {
+		return true
+	}
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err == nil
+}
+
+// insecureServersTransportName is the servers transport shared by all services
+// using the loadbalancer.server.insecure label. A fixed name keeps repeated polls
+// from generating duplicate transports.
+const insecureServersTransportName = "proxmox-insecure"
+
+// Reports whether a service talks HTTPS to its backend and skips certificate verification
+func isInsecureHTTPSService(service internal.Service, serviceName string, opts generateOptions) bool {
+	prefix := fmt.Sprintf("%shttp.services.%s.loadbalancer.server", opts.labelPrefix, serviceName)
+
+	insecure, exists := service.Config[prefix+".insecure"]
+	if !exists {
+		return false
+	}
+	if val, err := stringToBool(insecure); err != nil || !val {
+		return false
+	}
+
+	if url, exists := service.Config[prefix+".url"]; exists {
+		return strings.HasPrefix(strings.ToLower(url), "https://")
+	}
+	if getServiceScheme(service, serviceName, opts) == "https" {
+		return true
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if service.Config[prefix+".scheme."+family] == "https" {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to get the backend scheme: the scheme label, the scheme of the app
+// preset or http
+func getServiceScheme(service internal.Service, serviceName string, opts generateOptions) string {
+	schemeLabel := fmt.Sprintf("%shttp.services.%s.loadbalancer.server.scheme", opts.labelPrefix, serviceName)
+	if scheme, exists := service.Config[schemeLabel]; exists {
+		if scheme == "https" {
+			return "https"
+		}
+		return "http"
+	}
+	if preset, found := getAppPreset(service, opts); found {
+		return preset.Scheme
+	}
+	return "http"
+}
+
+// Helper to get the scheme of a server URL: the scheme label of the address
+// family of the host, such as server.scheme.ipv6, then the general scheme.
+// Hostnames only use the general scheme.
+func getServerScheme(service internal.Service, serviceName string, host string, opts generateOptions) string {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if ip == nil {
+		return getServiceScheme(service, serviceName, opts)
+	}
+	family := "ipv4"
+	if ip.To4() == nil {
+		family = "ipv6"
+	}
+
+	schemeLabel := fmt.Sprintf("%shttp.services.%s.loadbalancer.server.scheme.%s", opts.labelPrefix, serviceName, family)
+	if scheme, exists := service.Config[schemeLabel]; exists {
+		if scheme == "https" {
+			return "https"
+		}
+		return "http"
+	}
+	return getServiceScheme(service, serviceName, opts)
+}
+
+// Build servers transports declared with serverstransports labels.
+// Option names are matched case-insensitively, e.g. insecureSkipVerify or insecureskipverify.
+func getServersTransports(service internal.Service, opts generateOptions) map[string]*dynamic.ServersTransport {
+	transports := make(map[string]*dynamic.ServersTransport)
+	prefix := strings.ToLower(opts.labelPrefix + "http.serverstransports.")
+
+	for key, value := range service.Config {
+		if !strings.HasPrefix(strings.ToLower(key), prefix) {
+			continue
+		}
+		name, option, found := strings.Cut(key[len(prefix):], ".")
+		if !found || name == "" {
+			continue
+		}
+
+		transport, exists := transports[name]
+		if !exists {
+			transport = &dynamic.ServersTransport{}
+			transports[name] = transport
+		}
+
+		switch strings.ToLower(option) {
+		case "insecureskipverify":
+			if val, err := stringToBool(value); err == nil {
+				transport.InsecureSkipVerify = val
+			}
+		case "rootcas":
+			transport.RootCAs = splitList(value)
+		case "servername":
+			transport.ServerName = value
+		case "maxidleconnsperhost":
+			if val, err := stringToInt(value); err == nil {
+				transport.MaxIdleConnsPerHost = val
+			}
+		case "disablehttp2":
+			if val, err := stringToBool(value); err == nil {
+				transport.DisableHTTP2 = val
+			}
+		case "peercerturi":
+			transport.PeerCertURI = value
+		}
+	}
+
+	return transports
+}
+
+// Build TLS stores declared with tls.stores labels
+func getTLSStores(service internal.Service, opts generateOptions) map[string]tls.Store {
+	stores := make(map[string]tls.Store)
+	prefix := strings.ToLower(opts.labelPrefix + "tls.stores.")
+
+	for key, value := range service.Config {
+		if !strings.HasPrefix(strings.ToLower(key), prefix) {
+			continue
+		}
+		name, option, found := strings.Cut(key[len(prefix):], ".")
+		if !found || name == "" {
+			continue
+		}
+
+		store := stores[name]
+		switch strings.ToLower(option) {
+		case "defaultcertificate.certfile":
+			if store.DefaultCertificate == nil {
+				store.DefaultCertificate = &tls.Certificate{}
+			}
+			store.DefaultCertificate.CertFile = value
+		case "defaultcertificate.keyfile":
+			if store.DefaultCertificate == nil {
+				store.DefaultCertificate = &tls.Certificate{}
+			}
+			store.DefaultCertificate.KeyFile = value
+		case "defaultgeneratedcert.resolver":
+			if store.DefaultGeneratedCert == nil {
+				store.DefaultGeneratedCert = &tls.GeneratedCert{}
+			}
+			store.DefaultGeneratedCert.Resolver = value
+		case "defaultgeneratedcert.domain.main":
+			if store.DefaultGeneratedCert == nil {
+				store.DefaultGeneratedCert = &tls.GeneratedCert{}
+			}
+			if store.DefaultGeneratedCert.Domain == nil {
+				store.DefaultGeneratedCert.Domain = &types.Domain{}
+			}
+			store.DefaultGeneratedCert.Domain.Main = value
+		case "defaultgeneratedcert.domain.sans":
+			if store.DefaultGeneratedCert == nil {
+				store.DefaultGeneratedCert = &tls.GeneratedCert{}
+			}
+			if store.DefaultGeneratedCert.Domain == nil {
+				store.DefaultGeneratedCert.Domain = &types.Domain{}
+			}
+			store.DefaultGeneratedCert.Domain.SANs = splitList(value)
+		default:
+			continue
+		}
+		stores[name] = store
+	}
+
+	return stores
+}
+
+// Build TLS options declared with tls.options labels
+func getTLSOptions(service internal.Service, opts generateOptions) map[string]tls.Options {
+	options := make(map[string]tls.Options)
+	prefix := strings.ToLower(opts.labelPrefix + "tls.options.")
+
+	for key, value := range service.Config {
+		if !strings.HasPrefix(strings.ToLower(key), prefix) {
+			continue
+		}
+		name, option, found := strings.Cut(key[len(prefix):], ".")
+		if !found || name == "" {
+			continue
 		}
+
+		tlsOptions := options[name]
+		switch strings.ToLower(option) {
+		case "minversion":
+			tlsOptions.MinVersion = value
+		case "maxversion":
+			tlsOptions.MaxVersion = value
+		case "ciphersuites":
+			tlsOptions.CipherSuites = splitList(value)
+		case "curvepreferences":
+			tlsOptions.CurvePreferences = splitList(value)
+		case "clientauth.cafiles":
+			tlsOptions.ClientAuth.CAFiles = splitList(value)
+		case "clientauth.clientauthtype":
+			tlsOptions.ClientAuth.ClientAuthType = value
+		case "snistrict":
+			if val, err := stringToBool(value); err == nil {
+				tlsOptions.SniStrict = val
+			}
+		case "preferserverciphersuites":
+			if val, err := stringToBool(value); err == nil {
+				tlsOptions.PreferServerCipherSuites = val
+			}
+		case "alpnprotocols":
+			tlsOptions.ALPNProtocols = splitList(value)
+		default:
+			continue
+		}
+		options[name] = tlsOptions
+	}
+
+	return options
+}
+
+// Reports whether the guest may define the named middleware, TLS store or options.
+// Definitions are cluster-wide, so when several guests declare the same name
+// the guest with the lowest VMID wins regardless of the scan order.
+func claimDefinition(owners map[string]uint64, name string, service internal.Service, opts generateOptions) bool {
+	owner, exists := owners[name]
+	if exists && owner < service.ID {
+		opts.logger.Warnf("Ignoring %s of %s (ID: %d), it is already defined by guest %d", name, service.Name, service.ID, owner)
+		return false
+	}
+	if exists && owner != service.ID {
+		opts.logger.Warnf("Ignoring %s of guest %d, it is also defined by %s (ID: %d)", name, owner, service.Name, service.ID)
 	}
+	owners[name] = service.ID
+	return true
 }
 
 // Handle TLS configuration
@@ -492,29 +2410,29 @@ func handleRouterTLS(service internal.Service, prefix string) *dynamic.RouterTLS
 			tlsEnabled = true
 		}
 	}
-	
+
 	// If specific TLS settings exist, TLS is implicitly enabled
 	certResolver, hasCertResolver := service.Config[prefix+".tls.certresolver"]
 	domains, hasDomains := service.Config[prefix+".tls.domains"]
 	options, hasOptions := service.Config[prefix+".tls.options"]
-	
+
 	if !tlsEnabled && !hasCertResolver && !hasDomains && !hasOptions {
 		return nil
 	}
-	
+
 	// Create TLS config
 	tlsConfig := &dynamic.RouterTLSConfig{}
-	
+
 	// Add cert resolver if specified
 	if hasCertResolver {
 		tlsConfig.CertResolver = certResolver
 	}
-	
+
 	// Add options if specified
 	if hasOptions {
 		tlsConfig.Options = options
 	}
-	
+
 	// Add domains if specified
 	if hasDomains {
 		// Split domains by comma
@@ -527,72 +2445,312 @@ func handleRouterTLS(service internal.Service, prefix string) *dynamic.RouterTLS
 			tlsConfig.Domains = append(tlsConfig.Domains, domainConfig)
 		}
 	}
-	
+
 	return tlsConfig
 }
 
 // Helper to get service URL with correct port
-func getServiceURL(service internal.Service, serviceName string, nodeName string) string {
-	// Check for direct URL override
-	urlLabel := fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.url", serviceName)
-	if url, exists := service.Config[urlLabel]; exists {
-		return url
+func getServiceURL(service internal.Service, serviceName string, nodeName string, opts generateOptions) string {
+	// Check for direct URL override, unless it is a template
+	urlLabel := fmt.Sprintf("%shttp.services.%s.loadbalancer.server.url", opts.labelPrefix, serviceName)
+	urlTemplate, hasURL := service.Config[urlLabel]
+	if hasURL && !isServerURLTemplate(urlTemplate) {
+		return urlTemplate
 	}
 
-	// Default protocol and port
-	protocol := "http"
+	host := getServiceHost(service, serviceName, nodeName, opts)
+
+	// Default port of the scheme, or of the app preset
+	protocol := getServerScheme(service, serviceName, host, opts)
+	if scheme, _, found := strings.Cut(urlTemplate, "://"); hasURL && found {
+		protocol = strings.ToLower(scheme)
+	}
 	port := "80"
-	
-	// Check for HTTPS protocol setting
-	httpsLabel := fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.scheme", serviceName)
-	if scheme, exists := service.Config[httpsLabel]; exists && scheme == "https" {
-		protocol = "https"
-		// Update default port for HTTPS
+	if preset, found := getAppPreset(service, opts); found {
+		port = preset.Port
+	} else if protocol == "https" {
 		port = "443"
 	}
-	
+
 	// Look for service-specific port
-	portLabel := fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port", serviceName)
+	portLabel := fmt.Sprintf("%shttp.services.%s.loadbalancer.server.port", opts.labelPrefix, serviceName)
 	if val, exists := service.Config[portLabel]; exists {
 		port = val
 	}
 
+	// A node port replaces the port of the guest, otherwise candidate ports
+	// are probed when port checking is enabled for the guest
+	portsLabel := fmt.Sprintf("%shttp.services.%s.loadbalancer.server.ports", opts.labelPrefix, serviceName)
+	if nodePort, found := getNodePort(service, serviceName, opts); found {
+		port = nodePort
+	} else if val, exists := service.Config[portsLabel]; exists && isBoolLabelEnabled(service.Config, opts.labelPrefix+"proxmox.portcheck") {
+		if candidates := splitList(val); len(candidates) > 0 {
+			port = selectOpenPort(host, candidates, service, opts)
+		}
+	}
+
+	if hasURL {
+		return expandServerURL(urlTemplate, host, port, service.Name)
+	}
+	return buildServerURL(protocol, host, port)
+}
+
+// expandServerURL replaces the {ip}, {port} and {name} placeholders of a
+// server url label with the discovered host, which is bracketed when it is
+// an IPv6 address, the resolved port and the guest name
+func expandServerURL(urlTemplate, host, port, name string) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return strings.NewReplacer("{ip}", host, "{port}", port, "{name}", name).Replace(urlTemplate)
+}
+
+// isServerURLTemplate reports whether a server url label is a template
+// expanded by expandServerURL rather than replacing the discovered address
+func isServerURLTemplate(url string) bool {
+	return strings.Contains(url, "{ip}") || strings.Contains(url, "{port}") || strings.Contains(url, "{name}")
+}
+
+// Helper to get the backend host: an explicit ip label, the node of a node
+// port, the first usable IP or the hostname
+func getServiceHost(service internal.Service, serviceName string, nodeName string, opts generateOptions) string {
 	// Look for service-specific ip
-	ipLabel := fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.ip", serviceName)
+	ipLabel := fmt.Sprintf("%shttp.services.%s.loadbalancer.server.ip", opts.labelPrefix, serviceName)
 	if val, exists := service.Config[ipLabel]; exists {
-		return fmt.Sprintf("%s://%s:%s", protocol, val, port)
+		return val
+	}
+	if _, found := getNodePort(service, serviceName, opts); found {
+		return getNodeHost(service, nodeName, opts)
 	}
-	
-	// Use IP if available, otherwise fall back to hostname
-	if len(service.IPs) > 0 {
-		// Create a list of server URLs from all IPs
+
+	return getGuestHost(service, nodeName, opts)
+}
+
+// Helper to get the address of a guest: the first usable IP of the preferred
+// interfaces, the usable IP picked by the IP selector or the hostname
+func getGuestHost(service internal.Service, nodeName string, opts generateOptions) string {
+	// Prefer the addresses of the configured interfaces, in order
+	for _, name := range opts.preferInterfaces {
+		for _, ip := range service.IPs {
+			if ip.Interface == name && isUsableIP(ip, opts) {
+				return ip.Address
+			}
+		}
+	}
+
+	// Then addresses with the preferred prefix length, e.g. /24 over a /32 VPN address
+	if opts.preferPrefixLen > 0 {
 		for _, ip := range service.IPs {
-			if ip.Address != "" {
-				return fmt.Sprintf("%s://%s:%s", protocol, ip.Address, port)
+			if ip.Prefix == opts.preferPrefixLen && isUsableIP(ip, opts) {
+				return ip.Address
 			}
 		}
 	}
-	
+
+	// Then addresses resolving back to a name
+	if opts.preferReverseDNS {
+		lookup := opts.reverseLookup
+		if lookup == nil {
+			lookup = hasReverseDNS
+		}
+		for _, ip := range service.IPs {
+			if isUsableIP(ip, opts) && lookup(ip.Address) {
+				return ip.Address
+			}
+		}
+	}
+
+	// Use the IP picked by the selector if available, otherwise fall back to hostname
+	if ip, found := selectGuestIP(service, opts); found {
+		return ip.Address
+	}
+
 	// Fall back to hostname
-	url := fmt.Sprintf("%s://%s.%s:%s", protocol, service.Name, nodeName, port)
-	log.Printf("No IPs found, using hostname URL %s for service %s (ID: %d)", url, service.Name, service.ID)
-	return url
+	host := getFallbackHostname(service, nodeName, opts)
+	if opts.resolveHostnameIPv6 {
+		resolve := opts.resolveIPv6
+		if resolve == nil {
+			resolve = lookupIPv6
+		}
+		if address, found := resolve(host); found {
+			opts.logger.Debugf("No IPs found, using %s resolved from hostname %s for service %s (ID: %d)", address, host, service.Name, service.ID)
+			return address
+		}
+		opts.logger.Warnf("No IPv6 address found for hostname %s of service %s (ID: %d), using the hostname", host, service.Name, service.ID)
+	}
+	opts.logger.Debugf("No IPs found, using hostname %s for service %s (ID: %d)", host, service.Name, service.ID)
+	return host
+}
+
+// hostnameLookupTimeout bounds each AAAA lookup of resolveHostnameIPv6
+const hostnameLookupTimeout = 500 * time.Millisecond
+
+// lookupIPv6 resolves the first IPv6 address of host
+func lookupIPv6(host string) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), hostnameLookupTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip6", host)
+	if err != nil || len(ips) == 0 {
+		return "", false
+	}
+	return ips[0].String(), true
+}
+
+// Helper to check whether an address may be used as a backend
+func isUsableIP(ip internal.IP, opts generateOptions) bool {
+	return ip.Address != "" && (opts.allowIPv6 || !isIPv6(ip))
+}
+
+// hostnameSuffixNone makes the hostname fallback the bare guest name
+const hostnameSuffixNone = "none"
+
+// Helper to build the hostname of a guest without a usable IP:
+// <name>.<node> by default, <name>.<suffix> with a hostname suffix, in which
+// {node} is replaced with the node name, or <name> alone for "none"
+func getFallbackHostname(service internal.Service, nodeName string, opts generateOptions) string {
+	suffix := strings.Trim(opts.hostnameSuffix, ".")
+	if suffix == "" {
+		return service.Name + "." + nodeName
+	}
+	if strings.EqualFold(suffix, hostnameSuffixNone) {
+		return service.Name
+	}
+	return service.Name + "." + strings.ReplaceAll(suffix, "{node}", nodeName)
+}
+
+// Helper to pick the first candidate port accepting TCP connections,
+// falling back to the first candidate when none of them does
+func selectOpenPort(host string, candidates []string, service internal.Service, opts generateOptions) string {
+	probe := opts.portProbe
+	if probe == nil {
+		probe = dialPort
+	}
+	for _, port := range candidates {
+		if probe(host, port) {
+			opts.logger.Debugf("Selected open port %s for service %s (ID: %d)", port, service.Name, service.ID)
+			return port
+		}
+	}
+	opts.logger.Warnf("None of the ports %s of service %s (ID: %d) is open, using %s", strings.Join(candidates, ","), service.Name, service.ID, candidates[0])
+	return candidates[0]
+}
+
+// reverseDNSTimeout bounds each PTR lookup of preferReverseDNS
+const reverseDNSTimeout = 500 * time.Millisecond
+
+// hasReverseDNS reports whether a PTR record exists for address
+func hasReverseDNS(address string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, address)
+	return err == nil && len(names) > 0
+}
+
+// portCheckTimeout bounds each dial of the proxmox.portcheck probe
+const portCheckTimeout = 500 * time.Millisecond
+
+// dialPort reports whether a TCP connection to host:port can be opened
+func dialPort(host, port string) bool {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), portCheckTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Helper to build a server URL, bracketing IPv6 addresses
+func buildServerURL(protocol, host, port string) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return fmt.Sprintf("%s://%s", protocol, net.JoinHostPort(host, port))
+}
+
+// Helper to check if an IP is an IPv6 address
+func isIPv6(ip internal.IP) bool {
+	if ip.AddressType != "" {
+		return ip.AddressType == "ipv6"
+	}
+	parsed := net.ParseIP(ip.Address)
+	return parsed != nil && parsed.To4() == nil
+}
+
+// Helper to compute the priority of a router without a priority label.
+// Like Traefik, longer and therefore usually more specific rules win.
+func defaultRouterPriority(rule string) int {
+	return len(rule)
 }
 
+// Default rule strategies for routers without a rule label
+const (
+	defaultRuleHost       = "host"
+	defaultRulePathPrefix = "pathprefix"
+)
+
 // Helper to get router rule
-func getRouterRule(service internal.Service, routerName string) string {
-	// Default rule
-	rule := fmt.Sprintf("Host(`%s`)", service.Name)
-	
+func getRouterRule(service internal.Service, routerName string, opts generateOptions) string {
 	// Look for router-specific rule
-	ruleLabel := fmt.Sprintf("traefik.http.routers.%s.rule", routerName)
+	ruleLabel := fmt.Sprintf("%shttp.routers.%s.rule", opts.labelPrefix, routerName)
 	if val, exists := service.Config[ruleLabel]; exists {
-		rule = val
+		return val
+	}
+
+	// Then a rule composed from the host and pathprefix labels
+	if rule, found := getComposedRule(service, routerName, opts); found {
+		return rule
+	}
+
+	// Routers declared in labels are reported as missing-rule by the
+	// validation diagnostics, the implicit router of a guest without router
+	// labels is not
+	rule := getDefaultRule(service, opts.defaultRule)
+	if len(labelNames(service.Config, opts.labelPrefix+"http.routers.")) == 0 {
+		opts.logger.Warnf("Service %s (ID: %d): router %s has no %s label, using default rule %s", service.Name, service.ID, routerName, ruleLabel, rule)
+	} else {
+		opts.logger.Debugf("Service %s (ID: %d): router %s has no %s label, using default rule %s", service.Name, service.ID, routerName, ruleLabel, rule)
 	}
-	
 	return rule
 }
 
+// Helper to build a router rule from the host and pathprefix labels of a
+// router, always in that order, e.g. Host(`a.example.com`) && PathPrefix(`/api`)
+func getComposedRule(service internal.Service, routerName string, opts generateOptions) (string, bool) {
+	prefix := fmt.Sprintf("%shttp.routers.%s", opts.labelPrefix, routerName)
+	matchers := make([]string, 0, 2)
+	if host := strings.TrimSpace(service.Config[prefix+".host"]); host != "" {
+		matchers = append(matchers, fmt.Sprintf("Host(`%s`)", host))
+	}
+	if pathPrefix := strings.TrimSpace(service.Config[prefix+".pathprefix"]); pathPrefix != "" {
+		if !strings.HasPrefix(pathPrefix, "/") {
+			pathPrefix = "/" + pathPrefix
+		}
+		matchers = append(matchers, fmt.Sprintf("PathPrefix(`%s`)", pathPrefix))
+	}
+	if len(matchers) == 0 {
+		return "", false
+	}
+	return strings.Join(matchers, " && "), true
+}
+
+// Helper to build the rule of a router without a rule label. Besides the
+// host and pathprefix strategies, any other value is a template in which
+// {name}, {id} and {pool} are replaced with the guest name, VMID and pool.
+func getDefaultRule(service internal.Service, strategy string) string {
+	switch strings.ToLower(strategy) {
+	case "", defaultRuleHost:
+		return fmt.Sprintf("Host(`%s`)", service.Name)
+	case defaultRulePathPrefix:
+		return fmt.Sprintf("PathPrefix(`/%s`)", service.Name)
+	default:
+		return strings.NewReplacer(
+			"{name}", service.Name,
+			"{id}", fmt.Sprintf("%d", service.ID),
+			"{pool}", service.Pool,
+		).Replace(strategy)
+	}
+}
+
 // Helper to convert string to int
 func stringToInt(s string) (int, error) {
 	var i int
@@ -620,9 +2778,63 @@ func mapKeysToSlice(m map[string]bool) []string {
 	for k := range m {
 		result = append(result, k)
 	}
+	sort.Strings(result)
+	return result
+}
+
+// Helper to get the node names of a services map in sorted order
+func sortedNodeNames(servicesMap map[string][]internal.Service) []string {
+	names := make([]string, 0, len(servicesMap))
+	for name := range servicesMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Helper to get a copy of services sorted by VMID
+func sortedServices(services []internal.Service) []internal.Service {
+	sorted := append([]internal.Service(nil), services...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// Helper to ensure a label prefix is set and ends with a dot
+func normalizeLabelPrefix(prefix string) string {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return internal.DefaultLabelPrefix
+	}
+	if !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return prefix
+}
+
+// Helper to split a comma-separated list, trimming blanks and dropping empty entries
+func splitList(s string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
 	return result
 }
 
+// Helper to check if a slice contains a string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func boolPtr(v bool) *bool {
 	return &v
 }
@@ -637,21 +2849,166 @@ func validateConfig(config *Config) error {
 		return errors.New("poll interval must be set")
 	}
 
-	if config.ApiEndpoint == "" {
+	if strings.TrimSpace(config.SelfRouterRule) != "" && strings.TrimSpace(config.HealthAddress) == "" {
+		return errors.New("selfRouterRule needs healthAddress to be set")
+	}
+
+	// A fixture replaces the API, so no endpoint or token is needed
+	if config.FixtureFile != "" {
+		return nil
+	}
+
+	endpoints := internal.SplitEndpoints(config.ApiEndpoint)
+	if len(endpoints) == 0 {
 		return errors.New("API endpoint must be set")
 	}
 
+	for _, endpoint := range endpoints {
+		if err := validateEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+
+	config.ApiTokenId = strings.TrimSpace(config.ApiTokenId)
 	if config.ApiTokenId == "" {
 		return errors.New("API token ID must be set")
 	}
+	if err := validateTokenID(config.ApiTokenId); err != nil {
+		return err
+	}
 
-	if config.ApiToken == "" {
+	config.ApiTokenFile = strings.TrimSpace(config.ApiTokenFile)
+	if config.ApiToken != "" && config.ApiTokenFile != "" {
+		return errors.New("API token and API token file are mutually exclusive")
+	}
+	if config.ApiToken == "" && config.ApiTokenFile == "" {
 		return errors.New("API token must be set")
 	}
 
 	return nil
 }
 
+// parseExtraHeaders parses a list of "Key: Value" headers separated by
+// newlines or commas
+func parseExtraHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == ',' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, val, found := strings.Cut(entry, ":")
+		key = strings.TrimSpace(key)
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("apiExtraHeaders entry %q must have the form Key: Value", entry)
+		}
+		headers[key] = strings.TrimSpace(val)
+	}
+	return headers, nil
+}
+
+// configureConnectionPool applies the keep-alive settings of the API
+// transport, keeping the client defaults for unset options
+func configureConnectionPool(client *internal.ProxmoxClient, config *Config) error {
+	maxIdleConns := internal.DefaultMaxIdleConns
+	maxIdleConnsPerHost := internal.DefaultMaxIdleConnsPerHost
+	idleConnTimeout := internal.DefaultIdleConnTimeout
+
+	var err error
+	if config.ApiMaxIdleConns != "" {
+		if maxIdleConns, err = stringToInt(config.ApiMaxIdleConns); err != nil || maxIdleConns < 0 {
+			return fmt.Errorf("apiMaxIdleConns must be a non-negative number, got %q", config.ApiMaxIdleConns)
+		}
+	}
+	if config.ApiMaxIdleConnsPerHost != "" {
+		if maxIdleConnsPerHost, err = stringToInt(config.ApiMaxIdleConnsPerHost); err != nil || maxIdleConnsPerHost < 0 {
+			return fmt.Errorf("apiMaxIdleConnsPerHost must be a non-negative number, got %q", config.ApiMaxIdleConnsPerHost)
+		}
+	}
+	if config.ApiIdleConnTimeout != "" {
+		if idleConnTimeout, err = time.ParseDuration(config.ApiIdleConnTimeout); err != nil || idleConnTimeout < 0 {
+			return fmt.Errorf("apiIdleConnTimeout must be a non-negative duration, got %q", config.ApiIdleConnTimeout)
+		}
+	}
+
+	return client.SetConnectionPool(maxIdleConns, maxIdleConnsPerHost, idleConnTimeout)
+}
+
+// configureRateLimit applies the request rate limit of the API client,
+// an unset or zero apiRateLimit leaving requests unlimited
+func configureRateLimit(client *internal.ProxmoxClient, config *Config) error {
+	if config.ApiRateLimit == "" {
+		return nil
+	}
+	rate, err := strconv.ParseFloat(config.ApiRateLimit, 64)
+	if err != nil || rate < 0 {
+		return fmt.Errorf("apiRateLimit must be a non-negative number of requests per second, got %q", config.ApiRateLimit)
+	}
+
+	burst := 1
+	if config.ApiRateBurst != "" {
+		if burst, err = stringToInt(config.ApiRateBurst); err != nil || burst < 1 {
+			return fmt.Errorf("apiRateBurst must be a positive number of requests, got %q", config.ApiRateBurst)
+		}
+	}
+
+	return client.SetRateLimit(rate, burst)
+}
+
+// validateTokenID checks that an API token ID has the user@realm!tokenname
+// shape, so a malformed ID fails at startup instead of with a 401 from
+// Proxmox. Realms and token names are not restricted further.
+func validateTokenID(tokenID string) error {
+	if strings.Contains(tokenID, "=") {
+		return fmt.Errorf("API token ID %q must not contain '=', set the token secret in apiToken instead", tokenID)
+	}
+	if strings.ContainsAny(tokenID, " \t\r\n") {
+		return fmt.Errorf("API token ID %q must not contain whitespace", tokenID)
+	}
+
+	user, tokenName, found := strings.Cut(tokenID, "!")
+	if !found {
+		return fmt.Errorf("API token ID %q is missing the token name, expected user@realm!tokenname (e.g. root@pam!traefik)", tokenID)
+	}
+	if tokenName == "" {
+		return fmt.Errorf("API token ID %q has an empty token name after '!', expected user@realm!tokenname", tokenID)
+	}
+
+	userName, realm, found := strings.Cut(user, "@")
+	if !found || userName == "" || realm == "" {
+		return fmt.Errorf("API token ID %q must start with user@realm, e.g. root@pam!traefik", tokenID)
+	}
+	return nil
+}
+
+// validateEndpoint checks that the API endpoint is an absolute http(s) URL
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("API endpoint %q is not a valid URL: %w", endpoint, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("API endpoint %q must start with http:// or https://", endpoint)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("API endpoint %q must include a host", endpoint)
+	}
+
+	return nil
+}
+
+// isServiceEnabled reports whether a guest is exposed: with exposedByDefault
+// unless it sets enable=false, otherwise only when it sets enable=true
+func isServiceEnabled(service internal.Service, opts generateOptions) bool {
+	value, exists := service.Config[opts.labelPrefix+"enable"]
+	if opts.exposedByDefault {
+		return !exists || value != "false"
+	}
+	return exists && value == "true"
+}
+
 func isBoolLabelEnabled(labels map[string]string, label string) bool {
 	val, exists := labels[label]
 	return exists && val == "true"
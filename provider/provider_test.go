@@ -1,10 +1,21 @@
 package provider
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
 )
 
 func TestProviderConfig(t *testing.T) {
@@ -99,6 +110,27 @@ func TestProviderValidateConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Token file instead of token",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiTokenFile: "/run/secrets/proxmox-token",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Token and token file",
+			config: &Config{
+				PollInterval: "5s",
+				ApiEndpoint:  "https://proxmox.example.com",
+				ApiTokenId:   "test@pam!test",
+				ApiToken:     "test-token",
+				ApiTokenFile: "/run/secrets/proxmox-token",
+			},
+			wantErr: true,
+		},
 		{
 			name:    "Nil config",
 			config:  nil,
@@ -148,6 +180,42 @@ func TestProviderValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "Endpoint without scheme",
+			config: &Config{
+				PollInterval:   "5s",
+				ApiEndpoint:    "proxmox.example.com:8006",
+				ApiTokenId:     "test@pam!test",
+				ApiToken:       "test-token",
+				ApiValidateSSL: "true",
+				ApiLogging:     "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Endpoint with unsupported scheme",
+			config: &Config{
+				PollInterval:   "5s",
+				ApiEndpoint:    "ftp://proxmox.example.com",
+				ApiTokenId:     "test@pam!test",
+				ApiToken:       "test-token",
+				ApiValidateSSL: "true",
+				ApiLogging:     "info",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Endpoint with trailing slash",
+			config: &Config{
+				PollInterval:   "5s",
+				ApiEndpoint:    "https://proxmox.example.com:8006/",
+				ApiTokenId:     "test@pam!test",
+				ApiToken:       "test-token",
+				ApiValidateSSL: "true",
+				ApiLogging:     "info",
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -160,6 +228,71 @@ func TestProviderValidateConfig(t *testing.T) {
 	}
 }
 
+func TestValidateTokenID(t *testing.T) {
+	for _, valid := range []string{"root@pam!traefik", "traefik@pve!prod_01", "svc.user@ad-corp.example!traefik-ro"} {
+		if err := validateTokenID(valid); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", valid, err)
+		}
+	}
+
+	tests := []struct {
+		tokenID string
+		message string
+	}{
+		{tokenID: "root@pam", message: "missing the token name"},
+		{tokenID: "root@pam!", message: "empty token name"},
+		{tokenID: "root@pam!traefik=0b1c2d3e", message: "must not contain '='"},
+		{tokenID: "root!traefik", message: "user@realm"},
+		{tokenID: "@pam!traefik", message: "user@realm"},
+		{tokenID: "root@pam !traefik", message: "whitespace"},
+	}
+	for _, tt := range tests {
+		err := validateTokenID(tt.tokenID)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("validateTokenID(%q) = %v, want an error mentioning %q", tt.tokenID, err, tt.message)
+		}
+	}
+
+	config := &Config{
+		PollInterval: "5s",
+		ApiEndpoint:  "https://proxmox.example.com",
+		ApiTokenId:   " root@pam!traefik\n",
+		ApiToken:     "test-token",
+	}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+	if config.ApiTokenId != "root@pam!traefik" {
+		t.Errorf("Expected the token ID to be trimmed, got %q", config.ApiTokenId)
+	}
+}
+
+func TestParseExtraHeaders(t *testing.T) {
+	headers, err := parseExtraHeaders("CF-Access-Client-Id: abc.access\nCF-Access-Client-Secret: s3cr3t:part, X-Env: prod")
+	if err != nil {
+		t.Fatalf("parseExtraHeaders() error = %v", err)
+	}
+	expected := map[string]string{
+		"CF-Access-Client-Id":     "abc.access",
+		"CF-Access-Client-Secret": "s3cr3t:part",
+		"X-Env":                   "prod",
+	}
+	if len(headers) != len(expected) {
+		t.Errorf("Expected %d headers, got %v", len(expected), headers)
+	}
+	for key, value := range expected {
+		if headers[key] != value {
+			t.Errorf("Expected %s: %s, got %q", key, value, headers[key])
+		}
+	}
+
+	for _, invalid := range []string{"no-colon", ": value", "Bad Key: value"} {
+		if _, err := parseExtraHeaders(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
 func TestProviderParserConfig(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -320,10 +453,2677 @@ func TestGetServiceURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			url := getServiceURL(tt.service, tt.serviceName, tt.nodeName)
+			url := getServiceURL(tt.service, tt.serviceName, tt.nodeName, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
 			if url != tt.expectedUrl {
 				t.Errorf("Expected URL to be %s, got %s", tt.expectedUrl, url)
 			}
 		})
 	}
 }
+
+func TestScanOptionsIsNodeAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     scanOptions
+		nodeName string
+		expected bool
+	}{
+		{
+			name:     "No filters",
+			opts:     scanOptions{},
+			nodeName: "pve1",
+			expected: true,
+		},
+		{
+			name:     "Included node",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "pve1, pve2")},
+			nodeName: "pve2",
+			expected: true,
+		},
+		{
+			name:     "Not included node",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "pve1, pve2")},
+			nodeName: "pve3",
+			expected: false,
+		},
+		{
+			name:     "Excluded node",
+			opts:     scanOptions{excludeNodes: mustParseNodePatterns(t, "pve3")},
+			nodeName: "pve3",
+			expected: false,
+		},
+		{
+			name:     "Both lists set, node only included",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "pve1, pve2"), excludeNodes: mustParseNodePatterns(t, "pve2")},
+			nodeName: "pve1",
+			expected: true,
+		},
+		{
+			name:     "Both lists set, exclude wins",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "pve1, pve2"), excludeNodes: mustParseNodePatterns(t, "pve2")},
+			nodeName: "pve2",
+			expected: false,
+		},
+		{
+			name:     "Included by glob",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "pve-edge-*")},
+			nodeName: "pve-edge-01",
+			expected: true,
+		},
+		{
+			name:     "Not included by glob",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "pve-edge-*")},
+			nodeName: "pve-core-02",
+			expected: false,
+		},
+		{
+			name:     "Literal name is not a prefix",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "pve1")},
+			nodeName: "pve10",
+			expected: false,
+		},
+		{
+			name:     "Included by regex",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "/pve-(edge|core)-[0-9]+/")},
+			nodeName: "pve-core-02",
+			expected: true,
+		},
+		{
+			name:     "Regex is anchored",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "/pve-edge-[0-9]+/")},
+			nodeName: "old-pve-edge-01",
+			expected: false,
+		},
+		{
+			name:     "Excluded by glob",
+			opts:     scanOptions{includeNodes: mustParseNodePatterns(t, "pve-*"), excludeNodes: mustParseNodePatterns(t, "pve-core-??")},
+			nodeName: "pve-core-02",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.isNodeAllowed(tt.nodeName); got != tt.expected {
+				t.Errorf("isNodeAllowed(%s) = %v, want %v", tt.nodeName, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseNodePatterns(t *testing.T) {
+	for _, valid := range []string{"", "pve1,pve2", "pve-edge-*, pve-core-0?", "/pve-(edge|core)-[0-9]+/"} {
+		if _, err := parseNodePatterns("includeNodes", valid); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"pve-[", "/pve-(edge/"} {
+		if _, err := parseNodePatterns("includeNodes", invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+
+	config := CreateConfig()
+	config.ApiEndpoint = "https://proxmox.example.com"
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.PollInterval = "5s"
+	config.ExcludeNodes = "/pve-(edge/"
+	if _, err := New(context.Background(), config, "test-provider"); err == nil || !strings.Contains(err.Error(), "excludeNodes") {
+		t.Errorf("Expected an invalid regular expression to fail the configuration, got %v", err)
+	}
+}
+
+// mustParseNodePatterns compiles node filter entries for a test
+func mustParseNodePatterns(t *testing.T, value string) []nodePattern {
+	t.Helper()
+	patterns, err := parseNodePatterns("includeNodes", value)
+	if err != nil {
+		t.Fatalf("parseNodePatterns(%q) error = %v", value, err)
+	}
+	return patterns
+}
+
+// newTestProxmoxServer starts a fake Proxmox API answering with the given
+// JSON bodies keyed by path, and records every requested path.
+func newTestProxmoxServer(t *testing.T, responses map[string]string) (*internal.ProxmoxClient, *[]string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+
+		if body, exists := responses[strings.TrimPrefix(r.URL.Path, "/api2/json")]; exists {
+			fmt.Fprint(w, body)
+			return
+		}
+		fmt.Fprint(w, `{"data":[]}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client := internal.NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, "info")
+	return client, &requested
+}
+
+func TestGetServiceMapSkipsFilteredNodes(t *testing.T) {
+	client, requested := newTestProxmoxServer(t, map[string]string{
+		"/nodes": `{"data":[{"node":"pve1"},{"node":"pve2"},{"node":"pve3"}]}`,
+	})
+	opts := scanOptions{
+		includeNodes: mustParseNodePatterns(t, "pve1, pve2"),
+		excludeNodes: mustParseNodePatterns(t, "pve2"),
+	}
+
+	servicesMap, err := getServiceMap(client, context.Background(), opts)
+	if err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+
+	if _, exists := servicesMap["pve1"]; !exists {
+		t.Error("Expected node pve1 to be scanned")
+	}
+	if len(servicesMap) != 1 {
+		t.Errorf("Expected 1 scanned node, got %d", len(servicesMap))
+	}
+	for _, path := range *requested {
+		if strings.HasPrefix(path, "/api2/json/nodes/pve2") || strings.HasPrefix(path, "/api2/json/nodes/pve3") {
+			t.Errorf("Expected filtered nodes to never be queried, got request to %s", path)
+		}
+	}
+}
+
+func TestScanOptionsMatchesConstraintTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     scanOptions
+		tags     []string
+		expected bool
+	}{
+		{name: "No constraints", opts: scanOptions{}, tags: nil, expected: true},
+		{name: "One matching tag", opts: scanOptions{constraintTags: []string{"prod", "edge"}}, tags: []string{"edge"}, expected: true},
+		{name: "No matching tag", opts: scanOptions{constraintTags: []string{"prod", "edge"}}, tags: []string{"dev"}, expected: false},
+		{name: "Untagged guest", opts: scanOptions{constraintTags: []string{"prod"}}, tags: []string{}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.matchesConstraintTags(tt.tags); got != tt.expected {
+				t.Errorf("matchesConstraintTags(%v) = %v, want %v", tt.tags, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationCustomLabelPrefix(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			internal.Service{
+				ID:   100,
+				Name: "app",
+				Config: map[string]string{
+					"traefik-internal.enable":                                        "true",
+					"traefik-internal.http.routers.app.rule":                         "Host(`app.internal`)",
+					"traefik-internal.http.services.appsvc.loadbalancer.server.port": "8080",
+					"traefik-internal.http.services.appsvc.loadbalancer.server.ip":   "10.0.0.5",
+				},
+			},
+			internal.Service{
+				ID:     101,
+				Name:   "other",
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: normalizeLabelPrefix("traefik-internal")})
+
+	router, exists := config.HTTP.Routers["app"]
+	if !exists {
+		t.Fatal("Expected router app to be created")
+	}
+	if router.Rule != "Host(`app.internal`)" {
+		t.Errorf("Expected router rule Host(`app.internal`), got %s", router.Rule)
+	}
+	if router.Service != "appsvc" {
+		t.Errorf("Expected router service appsvc, got %s", router.Service)
+	}
+
+	service, exists := config.HTTP.Services["appsvc"]
+	if !exists {
+		t.Fatal("Expected service appsvc to be created")
+	}
+	if service.LoadBalancer.Servers[0].URL != "http://10.0.0.5:8080" {
+		t.Errorf("Expected server URL http://10.0.0.5:8080, got %s", service.LoadBalancer.Servers[0].URL)
+	}
+
+	if _, exists := config.HTTP.Routers["other-101"]; exists {
+		t.Error("Expected guest using the default prefix to be skipped")
+	}
+}
+
+func TestScanServicesIncludeStopped(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"running-vm","status":"running"},{"vmid":101,"name":"stopped-vm","status":"stopped"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true"}}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":"traefik.enable=true"}}`,
+	})
+
+	tests := []struct {
+		name           string
+		includeStopped bool
+		expected       []string
+	}{
+		{name: "Running only", includeStopped: false, expected: []string{"running-vm"}},
+		{name: "Include stopped", includeStopped: true, expected: []string{"running-vm", "stopped-vm"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services, err := scanServices(client, context.Background(), "pve1", scanOptions{includeStopped: tt.includeStopped})
+			if err != nil {
+				t.Fatalf("scanServices() error = %v", err)
+			}
+			if len(services) != len(tt.expected) {
+				t.Fatalf("Expected %d services, got %d", len(tt.expected), len(services))
+			}
+			for i, service := range services {
+				if service.Name != tt.expected[i] {
+					t.Errorf("Expected service %d to be %s, got %s", i, tt.expected[i], service.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestScanServicesScanMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		scanMode string
+		expected []string
+		listed   []string
+	}{
+		{name: "All", scanMode: scanModeAll, expected: []string{"vm", "ct"}, listed: []string{"qemu", "lxc"}},
+		{name: "VMs only", scanMode: scanModeVMs, expected: []string{"vm"}, listed: []string{"qemu"}},
+		{name: "Containers only", scanMode: scanModeContainers, expected: []string{"ct"}, listed: []string{"lxc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requested := newTestProxmoxServer(t, map[string]string{
+				"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"vm","status":"running"}]}`,
+				"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true"}}`,
+				"/nodes/pve1/lxc":             `{"data":[{"vmid":200,"name":"ct","status":"running"}]}`,
+				"/nodes/pve1/lxc/200/config":  `{"data":{"description":"traefik.enable=true"}}`,
+			})
+
+			services, err := scanServices(client, context.Background(), "pve1", scanOptions{scanMode: tt.scanMode, disableGuestAgent: true})
+			if err != nil {
+				t.Fatalf("scanServices() error = %v", err)
+			}
+			if len(services) != len(tt.expected) {
+				t.Fatalf("Expected %d services, got %d", len(tt.expected), len(services))
+			}
+			for i, service := range services {
+				if service.Name != tt.expected[i] {
+					t.Errorf("Expected service %d to be %s, got %s", i, tt.expected[i], service.Name)
+				}
+			}
+
+			listed := make([]string, 0)
+			for _, path := range *requested {
+				if kind := strings.TrimPrefix(path, "/api2/json/nodes/pve1/"); kind == "qemu" || kind == "lxc" {
+					listed = append(listed, kind)
+				}
+			}
+			if strings.Join(listed, ",") != strings.Join(tt.listed, ",") {
+				t.Errorf("Expected the guest listings %v, got %v", tt.listed, listed)
+			}
+		})
+	}
+}
+
+func TestScanServicesGuestType(t *testing.T) {
+	client, requested := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":                    `{"data":[{"vmid":100,"name":"vm","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config":         `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.autoweight=true"}}`,
+		"/nodes/pve1/qemu/100/status/current": `{"data":{"cpu":0.5,"mem":1024,"maxmem":2048}}`,
+		"/nodes/pve1/lxc":                     `{"data":[{"vmid":200,"name":"ct","status":"running"}]}`,
+		"/nodes/pve1/lxc/200/config":          `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.autoweight=true"}}`,
+		"/nodes/pve1/lxc/200/status/current":  `{"data":{"cpu":0.5,"mem":1024,"maxmem":2048}}`,
+	})
+
+	var logs bytes.Buffer
+	logger := internal.NewLogger("debug")
+	logger.SetOutput(&logs)
+	if err := logger.SetFormat(internal.LogFormatJSON); err != nil {
+		t.Fatalf("SetFormat() error = %v", err)
+	}
+
+	opts := scanOptions{labelPrefix: internal.DefaultLabelPrefix, disableGuestAgent: true, logger: logger}
+	services, err := scanServices(client, context.Background(), "pve1", opts)
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("Expected 2 services, got %d", len(services))
+	}
+	if services[0].Type != internal.GuestTypeVM {
+		t.Errorf("Expected the VM to have type %s, got %q", internal.GuestTypeVM, services[0].Type)
+	}
+	if services[1].Type != internal.GuestTypeContainer {
+		t.Errorf("Expected the container to have type %s, got %q", internal.GuestTypeContainer, services[1].Type)
+	}
+
+	// The status of each guest is read from the API path of its type
+	for _, path := range []string{"/api2/json/nodes/pve1/qemu/100/status/current", "/api2/json/nodes/pve1/lxc/200/status/current"} {
+		if !containsString(*requested, path) {
+			t.Errorf("Expected %s to be requested, got %v", path, *requested)
+		}
+	}
+
+	for _, guestType := range []string{internal.GuestTypeVM, internal.GuestTypeContainer} {
+		if !strings.Contains(logs.String(), `"type":"`+guestType+`"`) {
+			t.Errorf("Expected log lines with type %s, got %s", guestType, logs.String())
+		}
+	}
+}
+
+func TestGenerateConfigurationExposeTypes(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:     100,
+				Name:   "vm",
+				Type:   internal.GuestTypeVM,
+				IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+			{
+				ID:     200,
+				Name:   "ct",
+				Type:   internal.GuestTypeContainer,
+				IPs:    []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		exposeTypes string
+		expected    []string
+	}{
+		{name: "All types", exposeTypes: "", expected: []string{"ct-200", "vm-100"}},
+		{name: "VMs only", exposeTypes: "qemu", expected: []string{"vm-100"}},
+		{name: "Containers only", exposeTypes: "lxc", expected: []string{"ct-200"}},
+		{name: "Both types", exposeTypes: "qemu, LXC", expected: []string{"ct-200", "vm-100"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exposeTypes, err := parseExposeTypes(tt.exposeTypes, scanModeAll)
+			if err != nil {
+				t.Fatalf("parseExposeTypes() error = %v", err)
+			}
+			config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix, exposeTypes: exposeTypes})
+			if routers := sortedRouterNames(config.HTTP.Routers); strings.Join(routers, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected routers %v, got %v", tt.expected, routers)
+			}
+			if len(config.HTTP.Services) != len(tt.expected) {
+				t.Errorf("Expected %d services, got %d", len(tt.expected), len(config.HTTP.Services))
+			}
+		})
+	}
+}
+
+func TestParseExposeTypesErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		exposeTypes string
+		scanMode    string
+	}{
+		{name: "Unknown type", exposeTypes: "vm", scanMode: scanModeAll},
+		{name: "Containers never scanned", exposeTypes: "lxc", scanMode: scanModeVMs},
+		{name: "VMs never scanned", exposeTypes: "qemu", scanMode: scanModeContainers},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseExposeTypes(tt.exposeTypes, tt.scanMode); err == nil {
+				t.Errorf("Expected an error for exposeTypes %q with scanMode %q", tt.exposeTypes, tt.scanMode)
+			}
+		})
+	}
+
+	// Exposing a type that is not scanned is fine while another one is
+	if _, err := parseExposeTypes("qemu,lxc", scanModeVMs); err != nil {
+		t.Errorf("Expected exposeTypes with a scanned type to be accepted, got %v", err)
+	}
+}
+
+func TestGenerateConfigurationRequireOnbootAndProtection(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"prod","status":"running"},{"vmid":101,"name":"scratch","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"onboot":1,"protection":1,"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.5"}}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.6"}}`,
+		"/nodes/pve1/lxc":             `{"data":[{"vmid":200,"name":"cache","status":"running"}]}`,
+		"/nodes/pve1/lxc/200/config":  `{"data":{"onboot":1,"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.7"}}`,
+	})
+
+	services, err := scanServices(client, context.Background(), "pve1", scanOptions{labelPrefix: internal.DefaultLabelPrefix, disableGuestAgent: true})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if len(services) != 3 {
+		t.Fatalf("Expected 3 services, got %d", len(services))
+	}
+	if !services[0].Onboot || !services[0].Protected {
+		t.Errorf("Expected prod to start on boot and be protected, got %+v", services[0])
+	}
+	if services[1].Onboot || services[1].Protected {
+		t.Errorf("Expected scratch to have neither flag, got %+v", services[1])
+	}
+	if !services[2].Onboot || services[2].Protected {
+		t.Errorf("Expected cache to start on boot only, got %+v", services[2])
+	}
+	servicesMap := map[string][]internal.Service{"pve1": services}
+
+	tests := []struct {
+		name     string
+		opts     generateOptions
+		expected []string
+	}{
+		{name: "No policy", opts: generateOptions{}, expected: []string{"cache-200", "prod-100", "scratch-101"}},
+		{name: "Require onboot", opts: generateOptions{requireOnboot: true}, expected: []string{"cache-200", "prod-100"}},
+		{name: "Require protection", opts: generateOptions{requireProtection: true}, expected: []string{"prod-100"}},
+		{name: "Require both", opts: generateOptions{requireOnboot: true, requireProtection: true}, expected: []string{"prod-100"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.labelPrefix = internal.DefaultLabelPrefix
+			config := generateConfiguration(servicesMap, tt.opts)
+			if routers := sortedRouterNames(config.HTTP.Routers); strings.Join(routers, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected routers %v, got %v", tt.expected, routers)
+			}
+		})
+	}
+}
+
+func TestScanServicesLabelMarkerRequired(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"marked","status":"running"},{"vmid":101,"name":"unmarked","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"Team wiki\n#traefik#\ntraefik.enable=true"}}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":"traefik.enable=true"}}`,
+	})
+
+	tests := []struct {
+		name     string
+		required bool
+		labeled  []bool
+	}{
+		{name: "Whole notes without the marker", required: false, labeled: []bool{true, true}},
+		{name: "Marker required", required: true, labeled: []bool{true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := scanOptions{labelMarker: "#traefik#", labelMarkerRequired: tt.required, disableGuestAgent: true}
+			services, err := scanServices(client, context.Background(), "pve1", opts)
+			if err != nil {
+				t.Fatalf("scanServices() error = %v", err)
+			}
+			if len(services) != len(tt.labeled) {
+				t.Fatalf("Expected %d services, got %d", len(tt.labeled), len(services))
+			}
+			for i, service := range services {
+				if labeled := service.Config["traefik.enable"] == "true"; labeled != tt.labeled[i] {
+					t.Errorf("Expected service %s to have labels: %v, got %v", service.Name, tt.labeled[i], service.Config)
+				}
+			}
+		})
+	}
+}
+
+func TestScanServicesLabelBlockMode(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu": `{"data":[{"vmid":100,"name":"valid","status":"running"},{"vmid":101,"name":"malformed","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"` +
+			"```yaml\\ntraefik:\\n  enable: true\\n  http:\\n    routers:\\n      app:\\n        rule: Host(`app`)\\n```" + `"}}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":"` +
+			"```yaml\\ntraefik:\\n  enable: true\\n  http:\\n    router:\\n      app:\\n        rule: Host(`app`)\\n```" + `"}}`,
+	})
+
+	tests := []struct {
+		name    string
+		strict  bool
+		enabled []bool
+	}{
+		{name: "Lenient", strict: false, enabled: []bool{true, true}},
+		{name: "Strict", strict: true, enabled: []bool{true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := scanOptions{labelPrefix: internal.DefaultLabelPrefix, strictLabelBlocks: tt.strict, disableGuestAgent: true}
+			services, err := scanServices(client, context.Background(), "pve1", opts)
+			if err != nil {
+				t.Fatalf("scanServices() error = %v", err)
+			}
+			if len(services) != 2 {
+				t.Fatalf("Expected 2 services, got %d", len(services))
+			}
+			if services[0].LabelError != nil {
+				t.Errorf("Expected no label error for the valid block, got %v", services[0].LabelError)
+			}
+			var blockErr *internal.LabelBlockError
+			if !errors.As(services[1].LabelError, &blockErr) || blockErr.Issues[0].Line != 6 || blockErr.Issues[0].Key != "traefik.http.router.app.rule" {
+				t.Errorf("Expected the malformed block to be reported with its line and key, got %v", services[1].LabelError)
+			}
+			for i, service := range services {
+				if enabled := service.Config["traefik.enable"] == "true"; enabled != tt.enabled[i] {
+					t.Errorf("Expected service %s to keep its labels: %v, got %v", service.Name, tt.enabled[i], service.Config)
+				}
+			}
+		})
+	}
+}
+
+func TestScanServicesExcludeGuests(t *testing.T) {
+	client, requested := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"app","status":"running"},{"vmid":101,"name":"pbs","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true"}}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":"traefik.enable=true"}}`,
+		"/nodes/pve1/lxc":             `{"data":[{"vmid":200,"name":"backup-ct","status":"running"},{"vmid":201,"name":"web","status":"running"}]}`,
+		"/nodes/pve1/lxc/200/config":  `{"data":{"description":"traefik.enable=true"}}`,
+		"/nodes/pve1/lxc/201/config":  `{"data":{"description":"traefik.enable=true"}}`,
+	})
+
+	excludeVMIDs, err := parseVMIDs("101, 999")
+	if err != nil {
+		t.Fatalf("parseVMIDs() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     scanOptions
+		expected []string
+	}{
+		{name: "No exclusions", expected: []string{"app", "pbs", "backup-ct", "web"}},
+		{name: "Excluded VMIDs", opts: scanOptions{excludeVMIDs: excludeVMIDs}, expected: []string{"app", "backup-ct", "web"}},
+		{name: "Excluded name patterns", opts: scanOptions{excludeNamePatterns: mustParseNodePatterns(t, "backup-*, /p.s/")}, expected: []string{"app", "web"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*requested = (*requested)[:0]
+			services, err := scanServices(client, context.Background(), "pve1", tt.opts)
+			if err != nil {
+				t.Fatalf("scanServices() error = %v", err)
+			}
+			if len(services) != len(tt.expected) {
+				t.Fatalf("Expected services %v, got %v", tt.expected, services)
+			}
+			for i, service := range services {
+				if service.Name != tt.expected[i] {
+					t.Errorf("Expected service %d to be %s, got %s", i, tt.expected[i], service.Name)
+				}
+			}
+			for _, path := range *requested {
+				if strings.HasSuffix(path, "/101/config") && tt.opts.excludeVMIDs[101] {
+					t.Errorf("Expected the config of excluded guests to never be fetched, got request to %s", path)
+				}
+			}
+		})
+	}
+
+	if _, err := parseVMIDs("100, web"); err == nil {
+		t.Error("Expected an error for an invalid VMID")
+	}
+}
+
+func TestScanServicesCloudInitLabels(t *testing.T) {
+	userData := "#cloud-config\ntraefik_labels:\n  - traefik.enable=true\n  - traefik.http.routers.app.rule=Host(`cloud.example.com`)\n  - traefik.http.services.app.loadbalancer.server.port=8080\n"
+	client, requested := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":                    `{"data":[{"vmid":100,"name":"app","status":"running"},{"vmid":101,"name":"legacy","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config":         `{"data":{"description":"traefik.http.routers.app.rule=Host(` + "`notes.example.com`" + `)","ide2":"local-lvm:vm-100-cloudinit,media=cdrom"}}`,
+		"/nodes/pve1/qemu/100/cloudinit/dump": fmt.Sprintf(`{"data":%q}`, userData),
+		"/nodes/pve1/qemu/101/config":         `{"data":{"description":"traefik.enable=true"}}`,
+	})
+
+	services, err := scanServices(client, context.Background(), "pve1", scanOptions{cloudInitLabels: true})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("Expected 2 services, got %d", len(services))
+	}
+
+	labels := services[0].Config
+	if labels["traefik.enable"] != "true" || labels["traefik.http.services.app.loadbalancer.server.port"] != "8080" {
+		t.Errorf("Expected the cloud-init labels to be merged, got %v", labels)
+	}
+	if rule := labels["traefik.http.routers.app.rule"]; rule != "Host(`notes.example.com`)" {
+		t.Errorf("Expected the description label to take precedence, got %s", rule)
+	}
+	for _, path := range *requested {
+		if path == "/api2/json/nodes/pve1/qemu/101/cloudinit/dump" {
+			t.Error("Expected no cloud-init request for a VM without a cloud-init drive")
+		}
+	}
+
+	services, err = scanServices(client, context.Background(), "pve1", scanOptions{})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if _, exists := services[0].Config["traefik.enable"]; exists {
+		t.Error("Expected cloud-init labels to be ignored unless enabled")
+	}
+}
+
+func TestScanServicesContainerIPs(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		expectedIP    string
+		expectedLevel string
+	}{
+		{
+			name:       "Interfaces listed",
+			status:     http.StatusOK,
+			body:       `{"data":[{"name":"lo","inet":"127.0.0.1/8"},{"name":"eth0","inet":"10.0.0.9/24","inet6":"fd00::9/64"}]}`,
+			expectedIP: "10.0.0.9",
+		},
+		{
+			// Older Proxmox VE versions lack the endpoint
+			name:          "Not implemented",
+			status:        http.StatusNotImplemented,
+			body:          `{"data":null}`,
+			expectedIP:    "10.0.0.5",
+			expectedLevel: "[DEBUG]",
+		},
+		{
+			name:          "Server error",
+			status:        http.StatusInternalServerError,
+			body:          `{"data":null,"message":"unable to read interfaces\n"}`,
+			expectedIP:    "10.0.0.5",
+			expectedLevel: "[ERROR]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api2/json/nodes/pve1/lxc":
+					fmt.Fprint(w, `{"data":[{"vmid":200,"name":"db","status":"running"}]}`)
+				case "/api2/json/nodes/pve1/lxc/200/config":
+					fmt.Fprint(w, `{"data":{"description":"traefik.enable=true","net0":"name=eth0,bridge=vmbr0,ip=10.0.0.5/24"}}`)
+				case "/api2/json/nodes/pve1/lxc/200/interfaces":
+					w.WriteHeader(tt.status)
+					fmt.Fprint(w, tt.body)
+				default:
+					fmt.Fprint(w, `{"data":[]}`)
+				}
+			}))
+			defer server.Close()
+
+			var logs bytes.Buffer
+			logger := internal.NewLogger("debug")
+			logger.SetOutput(&logs)
+			client := internal.NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, "info")
+			services, err := scanServices(client, context.Background(), "pve1", scanOptions{scanMode: scanModeContainers, logger: logger})
+			if err != nil {
+				t.Fatalf("scanServices() error = %v", err)
+			}
+			if len(services) != 1 || len(services[0].IPs) == 0 || services[0].IPs[0].Address != tt.expectedIP {
+				t.Fatalf("Expected IP %s, got %+v", tt.expectedIP, services)
+			}
+
+			var logged string
+			for _, line := range strings.Split(logs.String(), "\n") {
+				if strings.Contains(line, "IPs of container") || strings.Contains(line, "Interfaces of container") {
+					logged = line
+				}
+			}
+			if tt.expectedLevel == "" {
+				if logged != "" {
+					t.Errorf("Expected no IP lookup message, got %q", logged)
+				}
+			} else if !strings.Contains(logged, tt.expectedLevel) {
+				t.Errorf("Expected the IP lookup to be logged at %s, got %q", tt.expectedLevel, logged)
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationStoppedService(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			internal.Service{
+				ID:     101,
+				Name:   "app",
+				Status: "stopped",
+				Config: map[string]string{
+					"traefik.enable":                "true",
+					"traefik.http.routers.app.rule": "Host(`app.example.com`)",
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix, stoppedService: "maintenance@file"})
+	router, exists := config.HTTP.Routers["app"]
+	if !exists {
+		t.Fatal("Expected router app to be created for the stopped guest")
+	}
+	if router.Service != "maintenance@file" {
+		t.Errorf("Expected router service maintenance@file, got %s", router.Service)
+	}
+	if len(config.HTTP.Services) != 0 {
+		t.Errorf("Expected no services for the stopped guest, got %d", len(config.HTTP.Services))
+	}
+
+	config = generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+	if router := config.HTTP.Routers["app"]; router == nil || router.Service != "app-101" {
+		t.Errorf("Expected router to keep its own service without a stopped service, got %+v", router)
+	}
+}
+
+func TestNewNegativeScanTimeout(t *testing.T) {
+	config := CreateConfig()
+	config.ApiEndpoint = "https://proxmox.example.com"
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.PollInterval = "5s"
+	config.ScanTimeout = "-1s"
+
+	if _, err := New(context.Background(), config, "test-provider"); err == nil || !strings.Contains(err.Error(), "scan timeout must not be negative") {
+		t.Errorf("Expected an error for a negative scan timeout, got %v", err)
+	}
+}
+
+func TestScanServicesTimeoutSlowGuestAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/nodes/pve1/qemu":
+			fmt.Fprint(w, `{"data":[{"vmid":100,"name":"slow-vm","status":"running"}]}`)
+		case "/api2/json/nodes/pve1/qemu/100/config":
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true"}}`)
+		case "/api2/json/nodes/pve1/qemu/100/agent/network-get-interfaces":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+			fmt.Fprint(w, `{"data":{"result":[{"ip-addresses":[{"ip-address":"10.0.0.1"}]}]}}`)
+		default:
+			fmt.Fprint(w, `{"data":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	client := internal.NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, "info")
+	start := time.Now()
+	services, err := scanServices(client, context.Background(), "pve1", scanOptions{scanTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected scan to be bounded by the scan timeout, took %v", elapsed)
+	}
+	if len(services) != 1 {
+		t.Fatalf("Expected the slow guest to still be listed, got %d services", len(services))
+	}
+	if len(services[0].IPs) != 0 {
+		t.Errorf("Expected no IPs for the timed out guest, got %v", services[0].IPs)
+	}
+}
+
+func TestGenerateConfigurationServersTransport(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			internal.Service{
+				ID:   100,
+				Name: "app",
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.http.serverstransports.selfsigned.insecureSkipVerify": "true",
+					"traefik.http.serverstransports.selfsigned.rootcas":            "/certs/a.pem,/certs/b.pem",
+					"traefik.http.serverstransports.selfsigned.servername":         "backend.internal",
+					"traefik.http.services.app.loadbalancer.serverstransport":      "selfsigned",
+					"traefik.http.services.app.loadbalancer.server.scheme":         "https",
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	transport, exists := config.HTTP.ServersTransports["selfsigned"]
+	if !exists {
+		t.Fatal("Expected servers transport selfsigned to be created")
+	}
+	if !transport.InsecureSkipVerify {
+		t.Error("Expected insecureSkipVerify to be true")
+	}
+	if len(transport.RootCAs) != 2 || transport.RootCAs[1] != "/certs/b.pem" {
+		t.Errorf("Expected 2 root CAs, got %v", transport.RootCAs)
+	}
+	if transport.ServerName != "backend.internal" {
+		t.Errorf("Expected server name backend.internal, got %s", transport.ServerName)
+	}
+
+	service, exists := config.HTTP.Services["app"]
+	if !exists {
+		t.Fatal("Expected service app to be created")
+	}
+	if service.LoadBalancer.ServersTransport != "selfsigned" {
+		t.Errorf("Expected service to reference servers transport selfsigned, got %s", service.LoadBalancer.ServersTransport)
+	}
+}
+
+func TestGenerateConfigurationServersTransportConflict(t *testing.T) {
+	transportGuest := func(id uint64, name, serverName string) internal.Service {
+		return internal.Service{
+			ID:   id,
+			Name: name,
+			Config: map[string]string{
+				"traefik.enable": "true",
+				"traefik.http.serverstransports.selfsigned.servername": serverName,
+			},
+		}
+	}
+	// The guest of pve1 is processed first, the lowest VMID still wins
+	servicesMap := map[string][]internal.Service{
+		"pve1": {transportGuest(101, "late", "late.internal")},
+		"pve2": {transportGuest(100, "early", "early.internal")},
+	}
+
+	var logs bytes.Buffer
+	logger := internal.NewLogger("info")
+	logger.SetOutput(&logs)
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix, logger: logger})
+
+	transport, exists := config.HTTP.ServersTransports["selfsigned"]
+	if !exists || transport.ServerName != "early.internal" {
+		t.Errorf("Expected the servers transport of the lowest VMID, got %+v", transport)
+	}
+	if !strings.Contains(logs.String(), "Ignoring servers transport selfsigned of guest 101") {
+		t.Errorf("Expected the conflict to be logged, got %s", logs.String())
+	}
+}
+
+func TestApplyServiceOptionsPassHostHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]string
+		expected bool
+	}{
+		{
+			name:     "Default is true",
+			config:   map[string]string{},
+			expected: true,
+		},
+		{
+			name:     "Explicitly false",
+			config:   map[string]string{"traefik.http.services.s3.loadbalancer.passhostheader": "false"},
+			expected: false,
+		},
+		{
+			name:     "Invalid value keeps default",
+			config:   map[string]string{"traefik.http.services.s3.loadbalancer.passhostheader": "maybe"},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &dynamic.ServersLoadBalancer{PassHostHeader: boolPtr(true)}
+			applyServiceOptions(lb, internal.Service{Config: tt.config}, "s3", generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+			if lb.PassHostHeader == nil || *lb.PassHostHeader != tt.expected {
+				t.Errorf("Expected PassHostHeader to be %v, got %v", tt.expected, lb.PassHostHeader)
+			}
+		})
+	}
+}
+
+func TestApplyServiceOptionsFlushInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "Duration", value: "100ms", expected: "100ms"},
+		{name: "Seconds", value: "1", expected: "1"},
+		{name: "Immediate flush", value: "-1", expected: "-1"},
+		{name: "Invalid value is ignored", value: "fast", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := internal.Service{Config: map[string]string{
+				"traefik.http.services.events.loadbalancer.responseforwarding.flushinterval": tt.value,
+				"traefik.http.services.events.loadbalancer.healthcheck.path":                 "/health",
+			}}
+			lb := &dynamic.ServersLoadBalancer{}
+			applyServiceOptions(lb, service, "events", generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+			if tt.expected == "" {
+				if lb.ResponseForwarding != nil {
+					t.Errorf("Expected no response forwarding, got %+v", lb.ResponseForwarding)
+				}
+			} else if lb.ResponseForwarding == nil || lb.ResponseForwarding.FlushInterval != tt.expected {
+				t.Errorf("Expected flush interval %s, got %+v", tt.expected, lb.ResponseForwarding)
+			}
+			// The rest of the service is kept
+			if lb.HealthCheck == nil || lb.HealthCheck.Path != "/health" {
+				t.Errorf("Expected the other service options to be applied, got %+v", lb.HealthCheck)
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationInsecureHTTPSBackend(t *testing.T) {
+	tests := []struct {
+		name              string
+		config            map[string]string
+		expectedTransport string
+	}{
+		{
+			name: "HTTPS scheme with insecure flag",
+			config: map[string]string{
+				"traefik.http.services.app.loadbalancer.server.scheme":   "https",
+				"traefik.http.services.app.loadbalancer.server.insecure": "true",
+			},
+			expectedTransport: insecureServersTransportName,
+		},
+		{
+			name: "HTTPS URL with insecure flag",
+			config: map[string]string{
+				"traefik.http.services.app.loadbalancer.server.url":      "https://10.0.0.5:8443",
+				"traefik.http.services.app.loadbalancer.server.insecure": "true",
+			},
+			expectedTransport: insecureServersTransportName,
+		},
+		{
+			name: "HTTP scheme ignores insecure flag",
+			config: map[string]string{
+				"traefik.http.services.app.loadbalancer.server.insecure": "true",
+			},
+			expectedTransport: "",
+		},
+		{
+			name: "Explicit transport wins",
+			config: map[string]string{
+				"traefik.http.services.app.loadbalancer.server.scheme":    "https",
+				"traefik.http.services.app.loadbalancer.server.insecure":  "true",
+				"traefik.http.services.app.loadbalancer.serverstransport": "custom@file",
+			},
+			expectedTransport: "custom@file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config["traefik.enable"] = "true"
+			servicesMap := map[string][]internal.Service{
+				"pve1": {
+					internal.Service{ID: 100, Name: "app", Config: tt.config},
+					internal.Service{ID: 101, Name: "app", Config: tt.config},
+				},
+			}
+
+			config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+			if got := config.HTTP.Services["app"].LoadBalancer.ServersTransport; got != tt.expectedTransport {
+				t.Errorf("Expected servers transport %q, got %q", tt.expectedTransport, got)
+			}
+
+			_, generated := config.HTTP.ServersTransports[insecureServersTransportName]
+			if generated != (tt.expectedTransport == insecureServersTransportName) {
+				t.Errorf("Unexpected generated transports: %v", config.HTTP.ServersTransports)
+			}
+			if len(config.HTTP.ServersTransports) > 1 {
+				t.Errorf("Expected at most one generated transport, got %d", len(config.HTTP.ServersTransports))
+			}
+		})
+	}
+}
+
+func TestScanServicesIPPrecedence(t *testing.T) {
+	client, requested := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":                                  `{"data":[{"vmid":100,"name":"static-vm","status":"running"},{"vmid":101,"name":"agent-vm","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config":                       `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=192.168.1.50"}}`,
+		"/nodes/pve1/qemu/101/config":                       `{"data":{"description":"traefik.enable=true"}}`,
+		"/nodes/pve1/qemu/101/agent/network-get-interfaces": `{"data":{"result":[{"ip-addresses":[{"ip-address":"10.0.0.1"}]}]}}`,
+		"/nodes/pve1/lxc":                                   `{"data":[{"vmid":200,"name":"ct","status":"running"}]}`,
+		"/nodes/pve1/lxc/200/config":                        `{"data":{"description":"traefik.enable=true","net0":"name=eth0,bridge=vmbr0,ip=10.0.0.20/24"}}`,
+	})
+
+	services, err := scanServices(client, context.Background(), "pve1", scanOptions{labelPrefix: internal.DefaultLabelPrefix})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if len(services) != 3 {
+		t.Fatalf("Expected 3 services, got %d", len(services))
+	}
+
+	expected := map[string]string{
+		"static-vm": "192.168.1.50",
+		"agent-vm":  "10.0.0.1",
+		"ct":        "10.0.0.20",
+	}
+	for _, service := range services {
+		if len(service.IPs) == 0 || service.IPs[0].Address != expected[service.Name] {
+			t.Errorf("Expected %s to use IP %s, got %v", service.Name, expected[service.Name], service.IPs)
+		}
+	}
+
+	for _, path := range *requested {
+		if path == "/api2/json/nodes/pve1/qemu/100/agent/network-get-interfaces" {
+			t.Error("Expected the guest agent not to be queried when a static IP is set")
+		}
+	}
+}
+
+func TestScanServicesPartialFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/nodes":
+			fmt.Fprint(w, `{"data":[{"node":"pve1"},{"node":"pve2"}]}`)
+		case "/api2/json/nodes/pve1/qemu":
+			fmt.Fprint(w, `{"data":[{"vmid":100,"name":"vm","status":"running"}]}`)
+		case "/api2/json/nodes/pve1/qemu/100/config":
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true"}}`)
+		case "/api2/json/nodes/pve1/lxc", "/api2/json/nodes/pve2/qemu", "/api2/json/nodes/pve2/lxc":
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, `{"data":[]}`)
+		}
+	}))
+	defer server.Close()
+	client := internal.NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, "info")
+
+	services, err := scanServices(client, context.Background(), "pve1", scanOptions{})
+	var partialErr *PartialScanError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("Expected a PartialScanError, got %v", err)
+	}
+	if partialErr.Node != "pve1" {
+		t.Errorf("Expected partial error for node pve1, got %s", partialErr.Node)
+	}
+	if len(services) != 1 || services[0].Name != "vm" {
+		t.Errorf("Expected the VM to be kept despite the container failure, got %+v", services)
+	}
+
+	_, err = scanServices(client, context.Background(), "pve2", scanOptions{})
+	if err == nil || errors.As(err, &partialErr) {
+		t.Errorf("Expected a total failure for node pve2, got %v", err)
+	}
+
+	servicesMap, err := getServiceMap(client, context.Background(), scanOptions{})
+	if err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+	if len(servicesMap["pve1"]) != 1 {
+		t.Errorf("Expected partially scanned node to be kept, got %v", servicesMap)
+	}
+	if _, exists := servicesMap["pve2"]; exists {
+		t.Error("Expected failed node to be dropped")
+	}
+}
+
+func TestGetServiceMapPools(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes":                      `{"data":[{"node":"pve1"}]}`,
+		"/pools":                      `{"data":[{"poolid":"tenant-a"},{"poolid":"tenant-b"}]}`,
+		"/pools/tenant-a":             `{"data":{"members":[{"id":"qemu/100","type":"qemu","vmid":100,"node":"pve1"},{"id":"storage/pve1/local","type":"storage","node":"pve1"}]}}`,
+		"/pools/tenant-b":             `{"data":{"members":[{"id":"lxc/200","type":"lxc","vmid":200,"node":"pve1"}]}}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"a","status":"running"},{"vmid":101,"name":"none","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.5"}}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.6"}}`,
+		"/nodes/pve1/lxc":             `{"data":[{"vmid":200,"name":"b","status":"running"}]}`,
+		"/nodes/pve1/lxc/200/config":  `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.7"}}`,
+	})
+
+	servicesMap, err := getServiceMap(client, context.Background(), scanOptions{pools: []string{"tenant-b"}})
+	if err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+	if len(servicesMap["pve1"]) != 1 || servicesMap["pve1"][0].Name != "b" || servicesMap["pve1"][0].Pool != "tenant-b" {
+		t.Errorf("Expected only the guest of pool tenant-b, got %+v", servicesMap["pve1"])
+	}
+
+	servicesMap, err = getServiceMap(client, context.Background(), scanOptions{resolvePools: true})
+	if err != nil {
+		t.Fatalf("getServiceMap() error = %v", err)
+	}
+	pools := make(map[string]string)
+	for _, service := range servicesMap["pve1"] {
+		pools[service.Name] = service.Pool
+	}
+	if len(pools) != 3 || pools["a"] != "tenant-a" || pools["none"] != "" || pools["b"] != "tenant-b" {
+		t.Errorf("Expected all guests with their pools, got %v", pools)
+	}
+
+	rule := getDefaultRule(internal.Service{ID: 100, Name: "a", Pool: "tenant-a"}, "Host(`{name}.{pool}.example.com`)")
+	if rule != "Host(`a.tenant-a.example.com`)" {
+		t.Errorf("Expected the pool in the default rule, got %s", rule)
+	}
+}
+
+func TestScanServicesWithoutGuestAgent(t *testing.T) {
+	client, requested := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"vm","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true"}}`,
+		"/nodes/pve1/lxc":             `{"data":[{"vmid":200,"name":"ct","status":"running"}]}`,
+		"/nodes/pve1/lxc/200/config":  `{"data":{"description":"traefik.enable=true","net0":"name=eth0,ip=10.0.0.7/24"}}`,
+	})
+
+	services, err := scanServices(client, context.Background(), "pve1", scanOptions{disableGuestAgent: true})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("Expected 2 services, got %d", len(services))
+	}
+	for _, path := range *requested {
+		if strings.Contains(path, "/agent/") {
+			t.Errorf("Expected the guest agent not to be queried, got a request to %s", path)
+		}
+	}
+	if len(services[1].IPs) != 1 || services[1].IPs[0].Address != "10.0.0.7" {
+		t.Errorf("Expected the container to use its network config, got %v", services[1].IPs)
+	}
+}
+
+func TestNextPollDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		jitter   time.Duration
+		random   float64
+		expected time.Duration
+	}{
+		{name: "No jitter", jitter: 0, random: 0.9, expected: 30 * time.Second},
+		{name: "Lowest offset", jitter: 5 * time.Second, random: 0, expected: 25 * time.Second},
+		{name: "Middle offset", jitter: 5 * time.Second, random: 0.5, expected: 30 * time.Second},
+		{name: "High offset", jitter: 5 * time.Second, random: 0.75, expected: 32500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay := nextPollDelay(30*time.Second, tt.jitter, func() float64 { return tt.random })
+			if delay != tt.expected {
+				t.Errorf("Expected delay %v, got %v", tt.expected, delay)
+			}
+		})
+	}
+}
+
+func TestProviderGenerateOnce(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/version":                    `{"data":{"release":"8.1"}}`,
+		"/nodes":                      `{"data":[{"node":"pve1"}]}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"app","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.http.routers.app.rule=Host(` + "`app.example.com`" + `)\ntraefik.proxmox.ip=10.0.0.5"}}`,
+	})
+
+	config := CreateConfig()
+	config.ApiEndpoint = strings.TrimSuffix(client.BaseURL, "/api2/json")
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.PollInterval = "5s"
+
+	p, err := New(context.Background(), config, "test-provider")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	configuration, err := p.GenerateOnce(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateOnce() error = %v", err)
+	}
+	router, exists := configuration.HTTP.Routers["app"]
+	if !exists {
+		t.Fatal("Expected router app to be generated")
+	}
+	if router.Rule != "Host(`app.example.com`)" {
+		t.Errorf("Expected router rule Host(`app.example.com`), got %s", router.Rule)
+	}
+	if url := configuration.HTTP.Services["app-100"].LoadBalancer.Servers[0].URL; url != "http://10.0.0.5:80" {
+		t.Errorf("Expected server URL http://10.0.0.5:80, got %s", url)
+	}
+}
+
+func TestProviderGenerateOncePollTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/version":
+			fmt.Fprint(w, `{"data":{"release":"8.1"}}`)
+		case "/api2/json/nodes":
+			fmt.Fprint(w, `{"data":[{"node":"pve1"},{"node":"pve2"}]}`)
+		case "/api2/json/nodes/pve1/qemu":
+			fmt.Fprint(w, `{"data":[{"vmid":100,"name":"app","status":"running"},{"vmid":101,"name":"slow","status":"running"}]}`)
+		case "/api2/json/nodes/pve1/qemu/100/config":
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.5"}}`)
+		case "/api2/json/nodes/pve1/qemu/101/config":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.6"}}`)
+		case "/api2/json/nodes/pve2/qemu":
+			fmt.Fprint(w, `{"data":[{"vmid":200,"name":"other","status":"running"}]}`)
+		case "/api2/json/nodes/pve2/qemu/200/config":
+			fmt.Fprint(w, `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.7"}}`)
+		default:
+			fmt.Fprint(w, `{"data":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.ApiEndpoint = server.URL
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.PollInterval = "5s"
+	config.PollTimeout = "200ms"
+
+	p, err := New(context.Background(), config, "test-provider")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	start := time.Now()
+	configuration, err := p.GenerateOnce(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateOnce() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the poll to be bounded by the poll timeout, took %v", elapsed)
+	}
+
+	// The guest scanned before the deadline is kept, with a consistent router and service
+	router, exists := configuration.HTTP.Routers["app-100"]
+	if !exists || router.Service != "app-100" {
+		t.Fatalf("Expected router app-100 pointing to its service, got %v", configuration.HTTP.Routers)
+	}
+	if _, exists := configuration.HTTP.Services["app-100"]; !exists {
+		t.Errorf("Expected service app-100, got %v", configuration.HTTP.Services)
+	}
+	if len(configuration.HTTP.Routers) != 1 || len(configuration.HTTP.Services) != 1 {
+		t.Errorf("Expected the guests after the deadline to be left out, got %v and %v", configuration.HTTP.Routers, configuration.HTTP.Services)
+	}
+
+	config.PollTimeout = "-1s"
+	if _, err := New(context.Background(), config, "test-provider"); err == nil || !strings.Contains(err.Error(), "poll timeout") {
+		t.Errorf("Expected an error for a negative poll timeout, got %v", err)
+	}
+}
+
+func TestProviderLastPollStatus(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/version":                    `{"data":{"release":"8.1"}}`,
+		"/nodes":                      `{"data":[{"node":"pve1"}]}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"app","status":"running"},{"vmid":101,"name":"db","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true"}}`,
+		"/nodes/pve1/qemu/101/config": `{"data":{"description":""}}`,
+	})
+
+	config := CreateConfig()
+	config.ApiEndpoint = strings.TrimSuffix(client.BaseURL, "/api2/json")
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.PollInterval = "5s"
+
+	p, err := New(context.Background(), config, "test-provider")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if status := p.LastPollStatus(); !status.Time.IsZero() {
+		t.Errorf("Expected no poll status before the first poll, got %+v", status)
+	}
+
+	if _, err := p.GenerateOnce(context.Background()); err != nil {
+		t.Fatalf("GenerateOnce() error = %v", err)
+	}
+	status := p.LastPollStatus()
+	if status.Time.IsZero() || status.Err != nil || status.ServicesDiscovered != 2 {
+		t.Errorf("Expected a successful poll with 2 services, got %+v", status)
+	}
+
+	p.client.BaseURL = "http://127.0.0.1:0/api2/json"
+	if _, err := p.GenerateOnce(context.Background()); err == nil {
+		t.Fatal("Expected GenerateOnce() to fail")
+	}
+	failed := p.LastPollStatus()
+	if failed.Err == nil || failed.ServicesDiscovered != 0 || failed.Time.Before(status.Time) {
+		t.Errorf("Expected the failed poll to be recorded, got %+v", failed)
+	}
+	if last := p.LastSuccessfulPollStatus(); last != status {
+		t.Errorf("Expected the last successful poll to be kept, got %+v", last)
+	}
+}
+
+func TestGetServiceURLTemplate(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"app","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.http.services.app.loadbalancer.server.url=https://{ip}:8443"}}`,
+		"/nodes/pve1/qemu/100/agent/network-get-interfaces": `{"data":{"result":[` +
+			`{"name":"eth0","ip-addresses":[{"ip-address":"10.0.0.5","ip-address-type":"ipv4","prefix":24}]}]}}`,
+	})
+
+	services, err := scanServices(client, context.Background(), "pve1", scanOptions{labelPrefix: internal.DefaultLabelPrefix})
+	if err != nil {
+		t.Fatalf("scanServices() error = %v", err)
+	}
+	config := generateConfiguration(map[string][]internal.Service{"pve1": services}, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+	servers := config.HTTP.Services["app"].LoadBalancer.Servers
+	if len(servers) != 1 || servers[0].URL != "https://10.0.0.5:8443" {
+		t.Errorf("Expected the template to resolve against the agent address, got %+v", servers)
+	}
+
+	tests := []struct {
+		name     string
+		ips      []internal.IP
+		labels   map[string]string
+		expected string
+	}{
+		{name: "Port and path", ips: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, labels: map[string]string{"url": "https://{ip}:{port}/{name}", "port": "9443"}, expected: "https://10.0.0.5:9443/app"},
+		{name: "Default port of the template scheme", ips: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, labels: map[string]string{"url": "https://{ip}:{port}/app"}, expected: "https://10.0.0.5:443/app"},
+		{name: "IPv6 address", ips: []internal.IP{{Address: "fd00::5", AddressType: "ipv6"}}, labels: map[string]string{"url": "http://{ip}:8080"}, expected: "http://[fd00::5]:8080"},
+		{name: "Explicit ip", ips: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, labels: map[string]string{"url": "http://{ip}:8080", "ip": "10.0.0.9"}, expected: "http://10.0.0.9:8080"},
+		{name: "Hostname fallback", ips: nil, labels: map[string]string{"url": "http://{ip}:8080"}, expected: "http://app.pve1:8080"},
+		{name: "Static url", ips: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, labels: map[string]string{"url": "http://backend.example.com"}, expected: "http://backend.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]string{"traefik.enable": "true"}
+			for option, value := range tt.labels {
+				config["traefik.http.services.app.loadbalancer.server."+option] = value
+			}
+			service := internal.Service{ID: 100, Name: "app", IPs: tt.ips, Config: config}
+			opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, allowIPv6: true}
+			if url := getServiceURL(service, "app", "pve1", opts); url != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, url)
+			}
+		})
+	}
+
+	// A template keeps the address-based behaviors of the guest
+	service := internal.Service{
+		ID:     100,
+		Name:   "app",
+		IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}, {Address: "2001:db8::5", AddressType: "ipv6"}},
+		Config: map[string]string{"traefik.http.services.app.loadbalancer.server.url": "https://{ip}:8443"},
+	}
+	opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, allowIPv6: true, dualStack: true}
+	if urls := getServerURLs(service, "app", "pve1", opts); len(urls) != 2 || urls[0] != "https://10.0.0.5:8443" || urls[1] != "https://[2001:db8::5]:8443" {
+		t.Errorf("Expected one templated server per family, got %v", urls)
+	}
+	service.IPs = nil
+	if hasRoutableAddress(service, opts) {
+		t.Error("Expected a template without a discovered address not to be routable")
+	}
+
+	// Templates without {ip} are templates for every behavior too
+	for _, url := range []string{"https://app.example.com:{port}", "https://{name}.example.com"} {
+		if !isServerURLTemplate(url) {
+			t.Errorf("Expected %s to be a template", url)
+		}
+		service.Config["traefik.http.services.app.loadbalancer.server.url"] = url
+		if hasRoutableAddress(service, opts) {
+			t.Errorf("Expected template %s without a discovered address not to be routable", url)
+		}
+	}
+	if isServerURLTemplate("https://app.example.com:8443") {
+		t.Error("Expected a url without placeholders not to be a template")
+	}
+}
+
+func TestGetServiceURLSchemePerFamily(t *testing.T) {
+	ipv4 := []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}
+	ipv6 := []internal.IP{{Address: "fd00::5", AddressType: "ipv6"}}
+
+	tests := []struct {
+		name     string
+		ips      []internal.IP
+		labels   map[string]string
+		expected string
+	}{
+		{name: "IPv6 override", ips: ipv6, labels: map[string]string{"scheme.ipv6": "https"}, expected: "https://[fd00::5]:443"},
+		{name: "IPv4 without override", ips: ipv4, labels: map[string]string{"scheme.ipv6": "https"}, expected: "http://10.0.0.5:80"},
+		{name: "IPv4 override of the general scheme", ips: ipv4, labels: map[string]string{"scheme": "https", "scheme.ipv4": "http"}, expected: "http://10.0.0.5:80"},
+		{name: "General scheme", ips: ipv6, labels: map[string]string{"scheme": "https", "scheme.ipv4": "http"}, expected: "https://[fd00::5]:443"},
+		{name: "Explicit port", ips: ipv6, labels: map[string]string{"scheme.ipv6": "https", "port": "8443"}, expected: "https://[fd00::5]:8443"},
+		{name: "Hostname uses the general scheme", ips: nil, labels: map[string]string{"scheme.ipv6": "https"}, expected: "http://app.pve1:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]string{"traefik.enable": "true"}
+			for option, value := range tt.labels {
+				config["traefik.http.services.app.loadbalancer.server."+option] = value
+			}
+			service := internal.Service{ID: 100, Name: "app", IPs: tt.ips, Config: config}
+			opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, allowIPv6: true}
+			if url := getServiceURL(service, "app", "pve1", opts); url != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, url)
+			}
+		})
+	}
+
+	// Dual-stack guests get one server of each family, each with its scheme
+	service := internal.Service{
+		ID:     100,
+		Name:   "app",
+		IPs:    append(append([]internal.IP{}, ipv4...), ipv6...),
+		Config: map[string]string{"traefik.http.services.app.loadbalancer.server.scheme.ipv6": "https"},
+	}
+	opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, allowIPv6: true, dualStack: true}
+	urls := getServerURLs(service, "app", "pve1", opts)
+	if len(urls) != 2 || urls[0] != "http://10.0.0.5:80" || urls[1] != "https://[fd00::5]:443" {
+		t.Errorf("Expected an HTTP IPv4 and an HTTPS IPv6 server, got %v", urls)
+	}
+}
+
+func TestGetServiceURLIPv6(t *testing.T) {
+	ips := []internal.IP{
+		{Address: "fd00::5", AddressType: "ipv6"},
+		{Address: "10.0.0.5", AddressType: "ipv4"},
+	}
+
+	tests := []struct {
+		name        string
+		service     internal.Service
+		allowIPv6   bool
+		expectedUrl string
+	}{
+		{
+			name:        "IPv6 address is bracketed",
+			service:     internal.Service{IPs: ips, Config: map[string]string{}},
+			allowIPv6:   true,
+			expectedUrl: "http://[fd00::5]:80",
+		},
+		{
+			name:        "IPv6 disabled picks IPv4",
+			service:     internal.Service{IPs: ips, Config: map[string]string{}},
+			allowIPv6:   false,
+			expectedUrl: "http://10.0.0.5:80",
+		},
+		{
+			name:        "IPv6 detected without address type",
+			service:     internal.Service{IPs: []internal.IP{{Address: "fe80::1"}}, Config: map[string]string{}},
+			allowIPv6:   true,
+			expectedUrl: "http://[fe80::1]:80",
+		},
+		{
+			name: "IPv6 label with brackets",
+			service: internal.Service{Config: map[string]string{
+				"traefik.http.services.service.loadbalancer.server.ip":   "[fd00::9]",
+				"traefik.http.services.service.loadbalancer.server.port": "8080",
+			}},
+			allowIPv6:   true,
+			expectedUrl: "http://[fd00::9]:8080",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, allowIPv6: tt.allowIPv6}
+			if url := getServiceURL(tt.service, "service", "pve1", opts); url != tt.expectedUrl {
+				t.Errorf("Expected URL to be %s, got %s", tt.expectedUrl, url)
+			}
+		})
+	}
+}
+
+func TestGetServiceURLHostnameSuffix(t *testing.T) {
+	service := internal.Service{ID: 100, Name: "myvm", Config: map[string]string{}}
+
+	tests := []struct {
+		suffix      string
+		expectedUrl string
+	}{
+		{suffix: "", expectedUrl: "http://myvm.pve1:80"},
+		{suffix: "lan.example.com", expectedUrl: "http://myvm.lan.example.com:80"},
+		{suffix: ".lan.example.com.", expectedUrl: "http://myvm.lan.example.com:80"},
+		{suffix: "{node}.lan", expectedUrl: "http://myvm.pve1.lan:80"},
+		{suffix: "none", expectedUrl: "http://myvm:80"},
+	}
+
+	for _, tt := range tests {
+		opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, hostnameSuffix: tt.suffix}
+		if url := getServiceURL(service, "service", "pve1", opts); url != tt.expectedUrl {
+			t.Errorf("hostnameSuffix %q: expected URL %s, got %s", tt.suffix, tt.expectedUrl, url)
+		}
+	}
+}
+
+func TestGenerateConfigurationMultipleGuests(t *testing.T) {
+	labels := map[string]string{
+		"traefik.enable":                                     "true",
+		"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+		"traefik.http.services.web.loadbalancer.server.port": "8080",
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{ID: 101, Name: "web-b", Status: internal.StatusRunning, Config: labels, IPs: []internal.IP{{Address: "10.0.0.2", AddressType: "ipv4"}}},
+			{ID: 100, Name: "web-a", Status: internal.StatusRunning, Config: labels, IPs: []internal.IP{{Address: "10.0.0.1", AddressType: "ipv4"}}},
+		},
+		"pve2": {
+			{ID: 200, Name: "web-c", Status: internal.StatusRunning, Config: labels, IPs: []internal.IP{{Address: "10.0.0.3", AddressType: "ipv4"}}},
+			// Same address as web-a, e.g. a clone that kept its static IP
+			{ID: 201, Name: "web-d", Status: internal.StatusRunning, Config: labels, IPs: []internal.IP{{Address: "10.0.0.1", AddressType: "ipv4"}}},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	if len(config.HTTP.Services) != 1 || len(config.HTTP.Routers) != 1 {
+		t.Fatalf("Expected 1 service and 1 router, got %d and %d", len(config.HTTP.Services), len(config.HTTP.Routers))
+	}
+	servers := config.HTTP.Services["web"].LoadBalancer.Servers
+	expected := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8080"}
+	if len(servers) != len(expected) {
+		t.Fatalf("Expected %d servers, got %v", len(expected), servers)
+	}
+	for i, url := range expected {
+		if servers[i].URL != url {
+			t.Errorf("Expected server %d to be %s, got %s", i, url, servers[i].URL)
+		}
+	}
+	if config.HTTP.Routers["web"].Service != "web" {
+		t.Errorf("Expected the router to target web, got %s", config.HTTP.Routers["web"].Service)
+	}
+}
+
+func TestGenerateConfigurationMaxServersPerService(t *testing.T) {
+	labels := map[string]string{
+		"traefik.enable": "true",
+		"traefik.http.services.web.loadbalancer.server.port": "8080",
+		"traefik.tcp.services.db.loadbalancer.server.port":   "5432",
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{ID: 102, Name: "web-c", Config: labels, IPs: []internal.IP{{Address: "10.0.0.3", AddressType: "ipv4"}}},
+			{ID: 100, Name: "web-a", Config: labels, IPs: []internal.IP{{Address: "10.0.0.1", AddressType: "ipv4"}}},
+			{ID: 101, Name: "web-b", Config: labels, IPs: []internal.IP{{Address: "10.0.0.2", AddressType: "ipv4"}}},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		maxServers int
+		expected   []string
+	}{
+		{name: "Unlimited", expected: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{name: "Cap hit", maxServers: 2, expected: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "Cap not hit", maxServers: 5, expected: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix, maxServers: tt.maxServers})
+
+			servers := config.HTTP.Services["web"].LoadBalancer.Servers
+			tcpServers := config.TCP.Services["db"].LoadBalancer.Servers
+			if len(servers) != len(tt.expected) || len(tcpServers) != len(tt.expected) {
+				t.Fatalf("Expected %d servers, got %v and %v", len(tt.expected), servers, tcpServers)
+			}
+			for i, address := range tt.expected {
+				if url := "http://" + address + ":8080"; servers[i].URL != url {
+					t.Errorf("Expected server %d to be %s, got %s", i, url, servers[i].URL)
+				}
+				if tcpAddress := address + ":5432"; tcpServers[i].Address != tcpAddress {
+					t.Errorf("Expected TCP server %d to be %s, got %s", i, tcpAddress, tcpServers[i].Address)
+				}
+			}
+		})
+	}
+}
+
+func TestGetServiceURLPreferInterface(t *testing.T) {
+	service := internal.Service{
+		ID:     100,
+		Name:   "myvm",
+		Config: map[string]string{},
+		IPs: []internal.IP{
+			{Address: "127.0.0.1", AddressType: "ipv4", Interface: "lo"},
+			{Address: "10.0.0.5", AddressType: "ipv4", Interface: "eth0"},
+			{Address: "fd00::6", AddressType: "ipv6", Interface: "eth1"},
+			{Address: "192.168.1.6", AddressType: "ipv4", Interface: "eth1"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		prefer      []string
+		allowIPv6   bool
+		expectedUrl string
+	}{
+		{name: "No preference", expectedUrl: "http://127.0.0.1:80"},
+		{name: "Preferred interface", prefer: []string{"eth1"}, expectedUrl: "http://192.168.1.6:80"},
+		{name: "Preferred interface with IPv6", prefer: []string{"eth1"}, allowIPv6: true, expectedUrl: "http://[fd00::6]:80"},
+		{name: "First matching preference", prefer: []string{"wg0", "eth0", "eth1"}, expectedUrl: "http://10.0.0.5:80"},
+		{name: "No matching interface", prefer: []string{"wg0"}, expectedUrl: "http://127.0.0.1:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, preferInterfaces: tt.prefer, allowIPv6: tt.allowIPv6}
+			if url := getServiceURL(service, "service", "pve1", opts); url != tt.expectedUrl {
+				t.Errorf("Expected URL to be %s, got %s", tt.expectedUrl, url)
+			}
+		})
+	}
+}
+
+func TestGetServiceURLPreferPrefixLen(t *testing.T) {
+	service := internal.Service{
+		ID:     100,
+		Name:   "myvm",
+		Config: map[string]string{},
+		IPs: []internal.IP{
+			{Address: "10.8.0.5", AddressType: "ipv4", Prefix: 32, Interface: "wg0"},
+			{Address: "192.168.1.6", AddressType: "ipv4", Prefix: 24, Interface: "eth0"},
+			{Address: "172.16.0.6", AddressType: "ipv4", Prefix: 16, Interface: "eth1"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		opts        generateOptions
+		expectedUrl string
+	}{
+		{name: "No preference", expectedUrl: "http://10.8.0.5:80"},
+		{name: "Preferred prefix length", opts: generateOptions{preferPrefixLen: 24}, expectedUrl: "http://192.168.1.6:80"},
+		{name: "No matching prefix length", opts: generateOptions{preferPrefixLen: 28}, expectedUrl: "http://10.8.0.5:80"},
+		{name: "Interface wins over prefix length", opts: generateOptions{preferPrefixLen: 24, preferInterfaces: []string{"eth1"}}, expectedUrl: "http://172.16.0.6:80"},
+		{
+			name: "Reverse DNS",
+			opts: generateOptions{
+				preferReverseDNS: true,
+				reverseLookup:    func(address string) bool { return address == "172.16.0.6" },
+			},
+			expectedUrl: "http://172.16.0.6:80",
+		},
+		{
+			name: "No address resolves",
+			opts: generateOptions{
+				preferReverseDNS: true,
+				reverseLookup:    func(address string) bool { return false },
+			},
+			expectedUrl: "http://10.8.0.5:80",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.labelPrefix = internal.DefaultLabelPrefix
+			if url := getServiceURL(service, "service", "pve1", opts); url != tt.expectedUrl {
+				t.Errorf("Expected URL to be %s, got %s", tt.expectedUrl, url)
+			}
+		})
+	}
+}
+
+func TestGetServiceURLHostnameIPv6(t *testing.T) {
+	service := internal.Service{ID: 100, Name: "myvm", Config: map[string]string{}, IPs: []internal.IP{}}
+
+	tests := []struct {
+		name        string
+		service     internal.Service
+		opts        generateOptions
+		expectedUrl string
+	}{
+		{name: "Hostname fallback", service: service, expectedUrl: "http://myvm.pve1:80"},
+		{
+			name:    "AAAA record",
+			service: service,
+			opts: generateOptions{
+				resolveHostnameIPv6: true,
+				resolveIPv6: func(host string) (string, bool) {
+					if host != "myvm.pve1" {
+						return "", false
+					}
+					return "fd00::10", true
+				},
+			},
+			expectedUrl: "http://[fd00::10]:80",
+		},
+		{
+			name:    "No AAAA record",
+			service: service,
+			opts: generateOptions{
+				resolveHostnameIPv6: true,
+				resolveIPv6:         func(host string) (string, bool) { return "", false },
+			},
+			expectedUrl: "http://myvm.pve1:80",
+		},
+		{
+			name: "IPv6-only guest",
+			service: internal.Service{ID: 101, Name: "v6vm", Config: map[string]string{},
+				IPs: []internal.IP{{Address: "fd00::5", AddressType: "ipv6"}}},
+			opts:        generateOptions{allowIPv6: true},
+			expectedUrl: "http://[fd00::5]:80",
+		},
+		{
+			name: "IPv6 ip label",
+			service: internal.Service{ID: 102, Name: "label", IPs: []internal.IP{},
+				Config: map[string]string{"traefik.http.services.service.loadbalancer.server.ip": "fd00::6"}},
+			expectedUrl: "http://[fd00::6]:80",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.labelPrefix = internal.DefaultLabelPrefix
+			if url := getServiceURL(tt.service, "service", "pve1", opts); url != tt.expectedUrl {
+				t.Errorf("Expected URL to be %s, got %s", tt.expectedUrl, url)
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationExposedByDefault(t *testing.T) {
+	newService := func(id uint64, name string, labels map[string]string) internal.Service {
+		return internal.Service{ID: id, Name: name, IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, Config: labels}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			newService(100, "enabled", map[string]string{"traefik.enable": "true"}),
+			newService(101, "unlabeled", map[string]string{}),
+			newService(102, "optout", map[string]string{"traefik.enable": "false", "traefik.http.routers.optout.rule": "Host(`optout.example.com`)"}),
+			newService(103, "ruleonly", map[string]string{"traefik.http.routers.ruleonly.rule": "Host(`ruleonly.example.com`)"}),
+		},
+	}
+
+	tests := []struct {
+		name             string
+		exposedByDefault bool
+		expected         []string
+	}{
+		{name: "Opt-in", expected: []string{"enabled-100"}},
+		{name: "Exposed by default", exposedByDefault: true, expected: []string{"enabled-100", "ruleonly", "unlabeled-101"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix, exposedByDefault: tt.exposedByDefault})
+			names := make(map[string]bool)
+			for name := range config.HTTP.Routers {
+				names[name] = true
+			}
+			routers := mapKeysToSlice(names)
+			if strings.Join(routers, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected routers %v, got %v", tt.expected, routers)
+			}
+			if _, exists := config.HTTP.Routers["optout"]; exists {
+				t.Error("Expected the guest setting traefik.enable=false to be skipped")
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationStableOutput(t *testing.T) {
+	newService := func(id uint64, name, ip string) internal.Service {
+		return internal.Service{
+			ID:   id,
+			Name: name,
+			IPs:  []internal.IP{{Address: ip, AddressType: "ipv4"}},
+			Config: map[string]string{
+				"traefik.enable":                                              "true",
+				"traefik.http.routers.shared.rule":                            "Host(`shared.example.com`)",
+				"traefik.http.routers." + name + ".rule":                      "Host(`" + name + ".example.com`)",
+				"traefik.http.services.shared.loadbalancer.server.port":       "8080",
+				"traefik.http.services." + name + ".loadbalancer.server.port": "9090",
+				"traefik.http.routers.shared.service":                         "shared",
+			},
+		}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {newService(102, "c", "10.0.0.3"), newService(100, "a", "10.0.0.1")},
+		"pve2": {newService(101, "b", "10.0.0.2")},
+		"pve3": {newService(103, "d", "10.0.0.4")},
+	}
+
+	var expected []byte
+	for i := 0; i < 20; i++ {
+		config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+		output, err := json.Marshal(config)
+		if err != nil {
+			t.Fatalf("Failed to marshal configuration: %v", err)
+		}
+		if expected == nil {
+			expected = output
+			continue
+		}
+		if !bytes.Equal(output, expected) {
+			t.Fatalf("Expected identical output for identical input, got\n%s\nand\n%s", expected, output)
+		}
+	}
+}
+
+func TestGenerateConfigurationRouterPriority(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "site",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                      "true",
+					"traefik.http.routers.site.rule":      "Host(`example.com`)",
+					"traefik.http.routers.api.rule":       "Host(`example.com`) && PathPrefix(`/api`)",
+					"traefik.http.routers.admin.rule":     "Host(`example.com`) && PathPrefix(`/admin`)",
+					"traefik.http.routers.admin.priority": "5",
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+	site := config.HTTP.Routers["site"]
+	api := config.HTTP.Routers["api"]
+	if api.Priority <= site.Priority {
+		t.Errorf("Expected the more specific rule to win, got api=%d site=%d", api.Priority, site.Priority)
+	}
+	if site.Priority != len("Host(`example.com`)") {
+		t.Errorf("Expected the priority to be the rule length, got %d", site.Priority)
+	}
+	if admin := config.HTTP.Routers["admin"]; admin.Priority != 5 {
+		t.Errorf("Expected the priority label to be authoritative, got %d", admin.Priority)
+	}
+}
+
+func TestGenerateConfigurationRouterTargetService(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected map[string]string
+	}{
+		{
+			name: "Two routers and two services with one explicit mapping",
+			labels: map[string]string{
+				"traefik.http.routers.web.rule":                             "Host(`web.example.com`)",
+				"traefik.http.routers.admin.rule":                           "Host(`admin.example.com`)",
+				"traefik.http.routers.admin.service":                        "backoffice",
+				"traefik.http.services.web.loadbalancer.server.port":        "8080",
+				"traefik.http.services.backoffice.loadbalancer.server.port": "9090",
+			},
+			expected: map[string]string{"web": "web", "admin": "backoffice"},
+		},
+		{
+			name: "Single service is the default target",
+			labels: map[string]string{
+				"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+				"traefik.http.routers.api.rule":                      "Host(`api.example.com`)",
+				"traefik.http.services.app.loadbalancer.server.port": "8080",
+			},
+			expected: map[string]string{"web": "app", "api": "app"},
+		},
+		{
+			name: "Ambiguous router is skipped",
+			labels: map[string]string{
+				"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+				"traefik.http.routers.other.rule":                    "Host(`other.example.com`)",
+				"traefik.http.services.web.loadbalancer.server.port": "8080",
+				"traefik.http.services.api.loadbalancer.server.port": "9090",
+			},
+			expected: map[string]string{"web": "web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.labels["traefik.enable"] = "true"
+			servicesMap := map[string][]internal.Service{
+				"pve1": {{
+					ID:     100,
+					Name:   "app",
+					IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+					Config: tt.labels,
+				}},
+			}
+
+			config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+			if len(config.HTTP.Routers) != len(tt.expected) {
+				t.Errorf("Expected %d routers, got %d", len(tt.expected), len(config.HTTP.Routers))
+			}
+			for routerName, serviceName := range tt.expected {
+				router, exists := config.HTTP.Routers[routerName]
+				if !exists {
+					t.Errorf("Expected router %s to be generated", routerName)
+					continue
+				}
+				if router.Service != serviceName {
+					t.Errorf("Expected router %s to point to %s, got %s", routerName, serviceName, router.Service)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationNameOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected map[string]string
+	}{
+		{
+			name: "Both names pinned",
+			labels: map[string]string{
+				"traefik.proxmox.routername":  "wiki",
+				"traefik.proxmox.servicename": "wiki-backend",
+			},
+			expected: map[string]string{"wiki": "wiki-backend"},
+		},
+		{
+			name: "Service name pinned with a labelled router",
+			labels: map[string]string{
+				"traefik.proxmox.servicename":    "wiki-backend",
+				"traefik.http.routers.wiki.rule": "Host(`wiki.example.com`)",
+			},
+			expected: map[string]string{"wiki": "wiki-backend"},
+		},
+		{
+			name: "Router name pinned with a labelled service",
+			labels: map[string]string{
+				"traefik.proxmox.routername":                          "wiki",
+				"traefik.http.services.docs.loadbalancer.server.port": "8080",
+			},
+			expected: map[string]string{"wiki": "docs"},
+		},
+		{
+			name:     "No overrides",
+			labels:   map[string]string{},
+			expected: map[string]string{"app-100": "app-100"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.labels["traefik.enable"] = "true"
+			servicesMap := map[string][]internal.Service{
+				"pve1": {{
+					ID:     100,
+					Name:   "app",
+					IPs:    []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+					Config: tt.labels,
+				}},
+			}
+
+			config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+			if len(config.HTTP.Routers) != len(tt.expected) || len(config.HTTP.Services) != len(tt.expected) {
+				t.Fatalf("Expected %d routers and services, got %v and %v", len(tt.expected), config.HTTP.Routers, config.HTTP.Services)
+			}
+			for routerName, serviceName := range tt.expected {
+				router, exists := config.HTTP.Routers[routerName]
+				if !exists {
+					t.Errorf("Expected router %s to be generated", routerName)
+					continue
+				}
+				if router.Service != serviceName {
+					t.Errorf("Expected router %s to point to %s, got %s", routerName, serviceName, router.Service)
+				}
+				if _, exists := config.HTTP.Services[serviceName]; !exists {
+					t.Errorf("Expected service %s to be generated", serviceName)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationTLS(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   101,
+				Name: "other",
+				Config: map[string]string{
+					"traefik.enable":                        "true",
+					"traefik.tls.options.modern.minversion": "VersionTLS12",
+				},
+			},
+			{
+				ID:   100,
+				Name: "tls-owner",
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.tls.stores.default.defaultcertificate.certfile": "/certs/default.crt",
+					"traefik.tls.stores.default.defaultcertificate.keyfile":  "/certs/default.key",
+					"traefik.tls.options.modern.minVersion":                  "VersionTLS13",
+					"traefik.tls.options.modern.cipherSuites":                "TLS_AES_128_GCM_SHA256, TLS_AES_256_GCM_SHA384",
+					"traefik.tls.options.modern.sniStrict":                   "true",
+					"traefik.tls.options.mtls.clientauth.clientauthtype":     "RequireAndVerifyClientCert",
+					"traefik.tls.options.mtls.clientauth.cafiles":            "/certs/ca.pem",
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	store, exists := config.TLS.Stores["default"]
+	if !exists || store.DefaultCertificate == nil {
+		t.Fatalf("Expected the default store with a certificate, got %+v", config.TLS.Stores)
+	}
+	if store.DefaultCertificate.CertFile != "/certs/default.crt" || store.DefaultCertificate.KeyFile != "/certs/default.key" {
+		t.Errorf("Unexpected default certificate %+v", store.DefaultCertificate)
+	}
+
+	modern := config.TLS.Options["modern"]
+	if modern.MinVersion != "VersionTLS13" {
+		t.Errorf("Expected the guest with the lowest VMID to own the options, got min version %s", modern.MinVersion)
+	}
+	if len(modern.CipherSuites) != 2 || !modern.SniStrict {
+		t.Errorf("Unexpected options %+v", modern)
+	}
+	if mtls := config.TLS.Options["mtls"]; mtls.ClientAuth.ClientAuthType != "RequireAndVerifyClientCert" || len(mtls.ClientAuth.CAFiles) != 1 {
+		t.Errorf("Unexpected client auth %+v", mtls.ClientAuth)
+	}
+}
+
+func TestGetRouterRuleDefault(t *testing.T) {
+	service := internal.Service{ID: 100, Name: "wiki", Config: map[string]string{}}
+
+	tests := []struct {
+		strategy string
+		expected string
+	}{
+		{strategy: "", expected: "Host(`wiki`)"},
+		{strategy: "host", expected: "Host(`wiki`)"},
+		{strategy: "PathPrefix", expected: "PathPrefix(`/wiki`)"},
+		{strategy: "Host(`{name}.apps.example.com`) || Host(`vm{id}.example.com`)", expected: "Host(`wiki.apps.example.com`) || Host(`vm100.example.com`)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, defaultRule: tt.strategy}
+			if rule := getRouterRule(service, "wiki", opts); rule != tt.expected {
+				t.Errorf("Expected rule %s, got %s", tt.expected, rule)
+			}
+		})
+	}
+
+	service.Config["traefik.http.routers.wiki.rule"] = "Host(`wiki.example.com`)"
+	opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, defaultRule: defaultRulePathPrefix}
+	if rule := getRouterRule(service, "wiki", opts); rule != "Host(`wiki.example.com`)" {
+		t.Errorf("Expected the rule label to take precedence, got %s", rule)
+	}
+}
+
+func TestGetRouterRuleDefaultLogging(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]string
+		router   string
+		expected string
+	}{
+		{
+			name:     "Implicit router of an enable-only guest",
+			config:   map[string]string{"traefik.enable": "true"},
+			router:   "wiki-100",
+			expected: "[WARN]",
+		},
+		{
+			name:     "Declared router without rule",
+			config:   map[string]string{"traefik.enable": "true", "traefik.http.routers.wiki.entrypoints": "websecure"},
+			router:   "wiki",
+			expected: "[DEBUG]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := internal.NewLogger("debug")
+			logger.SetOutput(&logs)
+			service := internal.Service{ID: 100, Name: "wiki", Config: tt.config}
+			getRouterRule(service, tt.router, generateOptions{labelPrefix: internal.DefaultLabelPrefix, logger: logger})
+			if !strings.Contains(logs.String(), tt.expected) || !strings.Contains(logs.String(), "using default rule Host(`wiki`)") {
+				t.Errorf("Expected the default rule to be logged at %s, got %s", tt.expected, logs.String())
+			}
+		})
+	}
+}
+
+func TestGetRouterRuleComposed(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected string
+	}{
+		{
+			name:     "Host",
+			labels:   map[string]string{"traefik.http.routers.wiki.host": "wiki.example.com"},
+			expected: "Host(`wiki.example.com`)",
+		},
+		{
+			name:     "PathPrefix",
+			labels:   map[string]string{"traefik.http.routers.wiki.pathprefix": "/wiki"},
+			expected: "PathPrefix(`/wiki`)",
+		},
+		{
+			name: "Host and PathPrefix",
+			labels: map[string]string{
+				"traefik.http.routers.wiki.pathprefix": "/api",
+				"traefik.http.routers.wiki.host":       "wiki.example.com",
+			},
+			expected: "Host(`wiki.example.com`) && PathPrefix(`/api`)",
+		},
+		{
+			name:     "PathPrefix without a leading slash",
+			labels:   map[string]string{"traefik.http.routers.wiki.pathprefix": "api"},
+			expected: "PathPrefix(`/api`)",
+		},
+		{
+			name: "Rule takes precedence",
+			labels: map[string]string{
+				"traefik.http.routers.wiki.rule":       "Host(`docs.example.com`)",
+				"traefik.http.routers.wiki.host":       "wiki.example.com",
+				"traefik.http.routers.wiki.pathprefix": "/api",
+			},
+			expected: "Host(`docs.example.com`)",
+		},
+		{
+			name:     "Neither uses the default rule",
+			labels:   map[string]string{"traefik.http.routers.wiki.host": " "},
+			expected: "Host(`wiki`)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := internal.Service{ID: 100, Name: "wiki", Config: tt.labels}
+			opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix}
+			if rule := getRouterRule(service, "wiki", opts); rule != tt.expected {
+				t.Errorf("Expected rule %s, got %s", tt.expected, rule)
+			}
+		})
+	}
+}
+
+func TestGetServiceURLPortCheck(t *testing.T) {
+	service := internal.Service{
+		IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+		Config: map[string]string{
+			"traefik.proxmox.portcheck":                               "true",
+			"traefik.http.services.service.loadbalancer.server.ports": "8080, 3000,9000",
+			"traefik.http.services.service.loadbalancer.server.port":  "8443",
+		},
+	}
+
+	probed := make([]string, 0)
+	opts := generateOptions{
+		labelPrefix: internal.DefaultLabelPrefix,
+		portProbe: func(host, port string) bool {
+			probed = append(probed, net.JoinHostPort(host, port))
+			return port == "3000"
+		},
+	}
+
+	if url := getServiceURL(service, "service", "pve1", opts); url != "http://10.0.0.5:3000" {
+		t.Errorf("Expected the first open port to be selected, got %s", url)
+	}
+	if len(probed) != 2 || probed[0] != "10.0.0.5:8080" {
+		t.Errorf("Expected ports to be probed in order until one is open, got %v", probed)
+	}
+
+	opts.portProbe = func(host, port string) bool { return false }
+	if url := getServiceURL(service, "service", "pve1", opts); url != "http://10.0.0.5:8080" {
+		t.Errorf("Expected the first candidate when no port is open, got %s", url)
+	}
+
+	service.Config["traefik.proxmox.portcheck"] = "false"
+	opts.portProbe = func(host, port string) bool {
+		t.Errorf("Expected no probe without portcheck, got %s:%s", host, port)
+		return false
+	}
+	if url := getServiceURL(service, "service", "pve1", opts); url != "http://10.0.0.5:8443" {
+		t.Errorf("Expected the port label without portcheck, got %s", url)
+	}
+}
+
+func TestDialPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	if !dialPort(host, port) {
+		t.Error("Expected an open port to be detected")
+	}
+	listener.Close()
+	if dialPort(host, port) {
+		t.Error("Expected a closed port to be detected")
+	}
+}
+
+func TestProviderNewAuthenticationFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "authentication failure", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.ApiEndpoint = server.URL
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "wrong-token"
+
+	_, err := New(context.Background(), config, "test-provider")
+	if err == nil || !strings.Contains(err.Error(), `check that apiTokenId "test@pam!test" is the full token ID user@realm!tokenname and that apiToken holds its secret`) {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), tokenDocsURL) {
+		t.Errorf("Expected the error to link to the token documentation, got %v", err)
+	}
+
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || authErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected an AuthError with status 401, got %v", err)
+	}
+	var apiErr *internal.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the wrapped APIError to carry status 401, got %v", err)
+	}
+}
+
+func TestProviderNewAuthenticationFailedHints(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected string
+	}{
+		{name: "Unknown token", status: http.StatusUnauthorized, body: "no such token 'traefik' for user 'root@pam'", expected: `token "root@pam!traefik" does not exist, check that apiTokenId`},
+		{name: "Wrong secret", status: http.StatusUnauthorized, body: "invalid token value!", expected: `the secret of token "root@pam!traefik" is wrong, check that apiToken holds`},
+		{name: "Missing permissions", status: http.StatusForbidden, body: "Permission check failed", expected: `token "root@pam!traefik" was accepted but lacks permissions`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, tt.body, tt.status)
+			}))
+			defer server.Close()
+
+			config := CreateConfig()
+			config.ApiEndpoint = server.URL
+			config.ApiTokenId = "root@pam!traefik"
+			config.ApiToken = "secret"
+
+			_, err := New(context.Background(), config, "test-provider")
+			var authErr *AuthError
+			if !errors.As(err, &authErr) || authErr.StatusCode != tt.status {
+				t.Fatalf("Expected an AuthError with status %d, got %v", tt.status, err)
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected the error to contain %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestConfigureConnectionPool(t *testing.T) {
+	client := internal.NewProxmoxClient("https://proxmox.example.com:8006", "test@pam!test", "test-token", true, "info")
+	config := CreateConfig()
+	config.ApiMaxIdleConns = "20"
+	config.ApiMaxIdleConnsPerHost = "4"
+	config.ApiIdleConnTimeout = "2m"
+
+	if err := configureConnectionPool(client, config); err != nil {
+		t.Fatalf("configureConnectionPool() error = %v", err)
+	}
+	transport := client.HTTPClient.Transport.(*http.Transport)
+	if transport.MaxIdleConns != 20 || transport.MaxIdleConnsPerHost != 4 || transport.IdleConnTimeout != 2*time.Minute {
+		t.Errorf("Expected 20/4/2m, got %d/%d/%s", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	for _, invalid := range []*Config{
+		{ApiMaxIdleConns: "lots"},
+		{ApiMaxIdleConnsPerHost: "-1"},
+		{ApiIdleConnTimeout: "forever"},
+	} {
+		if err := configureConnectionPool(client, invalid); err == nil {
+			t.Errorf("Expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestProviderFixtureFile(t *testing.T) {
+	config := CreateConfig()
+	config.FixtureFile = "../examples/fixture.json"
+
+	p, err := New(context.Background(), config, "test")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	configuration, err := p.GenerateOnce(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateOnce() error = %v", err)
+	}
+
+	if len(configuration.HTTP.Routers) != 2 {
+		t.Errorf("Expected 2 routers, got %v", configuration.HTTP.Routers)
+	}
+	whoami := configuration.HTTP.Services["whoami"]
+	if whoami == nil || len(whoami.LoadBalancer.Servers) != 2 {
+		t.Errorf("Expected whoami to be balanced over 2 guests, got %+v", whoami)
+	}
+	grafana := configuration.HTTP.Services["grafana"]
+	if grafana == nil || grafana.LoadBalancer.Servers[0].URL != "http://10.0.0.20:3000" {
+		t.Errorf("Expected grafana at its container address, got %+v", grafana)
+	}
+}
+
+func TestProviderStop(t *testing.T) {
+	newProvider := func() *Provider {
+		config := CreateConfig()
+		config.FixtureFile = "../examples/fixture.json"
+		p, err := New(context.Background(), config, "test")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return p
+	}
+	stop := func(p *Provider) {
+		start := time.Now()
+		if err := p.Stop(); err != nil {
+			t.Errorf("Stop() error = %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected Stop to return promptly, took %v", elapsed)
+		}
+	}
+
+	t.Run("Consumer not reading", func(t *testing.T) {
+		p := newProvider()
+		if err := p.Provide(make(chan json.Marshaler)); err != nil {
+			t.Fatalf("Provide() error = %v", err)
+		}
+		// Give the initial update time to block on the send
+		time.Sleep(50 * time.Millisecond)
+		stop(p)
+	})
+
+	t.Run("Closed consumer", func(t *testing.T) {
+		p := newProvider()
+		cfgChan := make(chan json.Marshaler)
+		close(cfgChan)
+		if err := p.Provide(cfgChan); err != nil {
+			t.Fatalf("Provide() error = %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		stop(p)
+	})
+
+	t.Run("Not started", func(t *testing.T) {
+		stop(newProvider())
+	})
+}
+
+func TestReconnectDelay(t *testing.T) {
+	half := func() float64 { return 0.5 }
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 0, expected: 750 * time.Millisecond},
+		{attempt: 1, expected: 1500 * time.Millisecond},
+		{attempt: 3, expected: 6 * time.Second},
+		{attempt: 5, expected: 22500 * time.Millisecond},
+		{attempt: 100, expected: 22500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if delay := reconnectDelay(tt.attempt, 30*time.Second, half); delay != tt.expected {
+			t.Errorf("reconnectDelay(%d) = %v, expected %v", tt.attempt, delay, tt.expected)
+		}
+	}
+	if delay := reconnectDelay(0, 30*time.Second, func() float64 { return 0 }); delay != 500*time.Millisecond {
+		t.Errorf("Expected at least half of the base delay, got %v", delay)
+	}
+}
+
+func TestDebounceConfiguration(t *testing.T) {
+	withRule := func(rule string) *dynamic.Configuration {
+		return &dynamic.Configuration{HTTP: &dynamic.HTTPConfiguration{
+			Routers: map[string]*dynamic.Router{"app": {Rule: rule, Service: "app"}},
+		}}
+	}
+	pushed, _ := json.Marshal(withRule("Host(`a.example.com`)"))
+
+	// Each re-scan returns the next rule, the last one repeating
+	scans := func(rules ...string) (func(context.Context) (*scanResult, error), *int) {
+		calls := 0
+		return func(context.Context) (*scanResult, error) {
+			rule := rules[len(rules)-1]
+			if calls < len(rules) {
+				rule = rules[calls]
+			}
+			calls++
+			return &scanResult{configuration: withRule(rule)}, nil
+		}, &calls
+	}
+
+	p := &Provider{pollInterval: time.Second, debounce: 10 * time.Millisecond, lastPushed: pushed}
+	generate, calls := scans("Host(`abc.example.com`)", "Host(`abc.example.com`)")
+	result, _, err := p.debounceConfiguration(context.Background(), &scanResult{configuration: withRule("Host(`ab`)")}, generate)
+	if err != nil {
+		t.Fatalf("debounceConfiguration() error = %v", err)
+	}
+	if rule := result.configuration.HTTP.Routers["app"].Rule; rule != "Host(`abc.example.com`)" || *calls != 2 {
+		t.Errorf("Expected the settled rule after 2 re-scans, got %s after %d", rule, *calls)
+	}
+
+	// Unchanged configurations and a zero debounce are pushed right away
+	for _, p := range []*Provider{
+		{pollInterval: time.Second, debounce: 10 * time.Millisecond, lastPushed: pushed},
+		{pollInterval: time.Second, lastPushed: pushed},
+	} {
+		generate, calls := scans("Host(`other.example.com`)")
+		first := &scanResult{configuration: withRule("Host(`a.example.com`)")}
+		if p.debounce == 0 {
+			first = &scanResult{configuration: withRule("Host(`changed.example.com`)")}
+		}
+		result, _, err := p.debounceConfiguration(context.Background(), first, generate)
+		if err != nil || result != first || *calls != 0 {
+			t.Errorf("Expected no re-scan with debounce %v, got %d (%v)", p.debounce, *calls, err)
+		}
+	}
+
+	// Labels changing on every scan are pushed after a poll interval
+	p = &Provider{pollInterval: 30 * time.Millisecond, debounce: 10 * time.Millisecond, lastPushed: pushed}
+	generate, calls = scans("Host(`1`)", "Host(`2`)", "Host(`3`)", "Host(`4`)", "Host(`5`)")
+	if _, _, err := p.debounceConfiguration(context.Background(), &scanResult{configuration: withRule("Host(`0`)")}, generate); err != nil || *calls != 3 {
+		t.Errorf("Expected 3 re-scans within the poll interval, got %d (%v)", *calls, err)
+	}
+}
+
+func TestDebounceConfigurationStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Provider{pollInterval: time.Hour, debounce: time.Hour, lastPushed: []byte(`{"http":{}}`), cancel: cancel}
+	generate := func(context.Context) (*scanResult, error) {
+		t.Error("Expected no re-scan after Stop")
+		return nil, nil
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Stop()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := p.debounceConfiguration(ctx, &scanResult{configuration: &dynamic.Configuration{}}, generate)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the debounce to be canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Stop to interrupt the debounce")
+	}
+}
+
+func TestUpdateConfigurationDebounceRecordsOnePoll(t *testing.T) {
+	var mu sync.Mutex
+	configRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/version":
+			fmt.Fprint(w, `{"data":{"release":"8.1"}}`)
+		case "/api2/json/nodes":
+			fmt.Fprint(w, `{"data":[{"node":"pve1"}]}`)
+		case "/api2/json/nodes/pve1/qemu":
+			fmt.Fprint(w, `{"data":[{"vmid":100,"name":"app","status":"running"}]}`)
+		case "/api2/json/nodes/pve1/qemu/100/config":
+			// The first scan sees a half-edited rule
+			mu.Lock()
+			configRequests++
+			host := "app.example.com"
+			if configRequests == 1 {
+				host = "app.exa"
+			}
+			mu.Unlock()
+			fmt.Fprintf(w, `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.5\ntraefik.http.routers.app.rule=Host(`+"`%s`"+`)"}}`, host)
+		default:
+			fmt.Fprint(w, `{"data":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.ApiEndpoint = server.URL
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.PollInterval = "5s"
+	config.Debounce = "10ms"
+	config.Metrics = "true"
+
+	p, err := New(context.Background(), config, "test-provider")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	p.lastPushed = []byte(`{}`)
+
+	cfgChan := make(chan json.Marshaler, 1)
+	if err := p.updateConfiguration(context.Background(), cfgChan); err != nil {
+		t.Fatalf("updateConfiguration() error = %v", err)
+	}
+	mu.Lock()
+	if configRequests != 3 {
+		t.Errorf("Expected 2 debounce re-scans, got %d scans", configRequests)
+	}
+	mu.Unlock()
+	if count := p.Metrics().pollCount; count != 1 {
+		t.Errorf("Expected the debounced poll to be recorded once, got %d", count)
+	}
+
+	payload := (<-cfgChan).(*dynamic.JSONPayload)
+	if rule := payload.Configuration.HTTP.Routers["app"].Rule; rule != "Host(`app.example.com`)" {
+		t.Errorf("Expected the settled rule to be pushed, got %s", rule)
+	}
+}
+
+func TestLogVersionWithRetry(t *testing.T) {
+	var requests int
+	failures := 2
+	status := http.StatusBadGateway
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"data":{"release":"8.1"}}`))
+	}))
+	defer server.Close()
+	client := internal.NewProxmoxClient(server.URL, "test@pam!test", "test-token", true, internal.LogLevelError)
+
+	if err := logVersionWithRetry(client, context.Background(), 3, time.Millisecond); err != nil {
+		t.Fatalf("Expected the third attempt to succeed, got %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+
+	requests, failures = 0, 5
+	err := logVersionWithRetry(client, context.Background(), 3, time.Millisecond)
+	var apiErr *internal.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected the last error after exhausting the attempts, got %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+
+	requests, status = 0, http.StatusUnauthorized
+	if err := logVersionWithRetry(client, context.Background(), 3, time.Millisecond); err == nil {
+		t.Error("Expected an authentication error")
+	}
+	if requests != 1 {
+		t.Errorf("Expected authentication errors not to be retried, got %d requests", requests)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	requests, status = 0, http.StatusBadGateway
+	if err := logVersionWithRetry(client, ctx, 3, time.Hour); err == nil {
+		t.Error("Expected an error once the context is done")
+	}
+}
+
+func TestNewFailFast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	endpoint := server.URL
+	server.Close()
+
+	config := CreateConfig()
+	config.ApiEndpoint = endpoint
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.ApiLogging = "error"
+
+	if _, err := New(context.Background(), config, "test"); err == nil {
+		t.Fatal("Expected New to fail when Proxmox is unreachable")
+	}
+
+	config.FailFast = "false"
+	p, err := New(context.Background(), config, "test")
+	if err != nil {
+		t.Fatalf("Expected New to tolerate an unreachable Proxmox, got %v", err)
+	}
+	if p.connected {
+		t.Error("Expected the provider not to be connected yet")
+	}
+
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"release":"8.1"}}`))
+	}))
+	defer live.Close()
+	p.client.BaseURL = live.URL + "/api2/json"
+	p.pollInterval = 20 * time.Millisecond
+
+	if !p.waitForConnection(context.Background(), func() float64 { return 0 }) || !p.connected {
+		t.Error("Expected the provider to connect once Proxmox is reachable")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if p.waitForConnection(ctx, func() float64 { return 0 }) {
+		t.Error("Expected waiting for a connection to stop with the context")
+	}
+}
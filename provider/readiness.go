@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// Behaviors for running guests that have no routable IP yet
+const (
+	noIPBehaviorHostname = "hostname"
+	noIPBehaviorOmit     = "omit"
+)
+
+// Policies for running guests without a routable IP whose fallback
+// hostname does not resolve
+const (
+	// noAddressPolicyHostname emits the hostname without looking it up
+	noAddressPolicyHostname = "hostname"
+	noAddressPolicySkip     = "skip"
+	// noAddressPolicyError omits the guest like skip, logging an error
+	noAddressPolicyError = "error"
+)
+
+// ipReadiness decides whether a running guest without a routable IP is
+// emitted with the hostname fallback or omitted until a later poll. With the
+// hostname behavior, a guest is omitted while it has been without an IP for
+// less than the grace period, which covers guests that are still booting.
+type ipReadiness struct {
+	mu        sync.Mutex
+	behavior  string
+	grace     time.Duration
+	firstSeen map[string]time.Time
+}
+
+func newIPReadiness(behavior string, grace time.Duration) *ipReadiness {
+	return &ipReadiness{
+		behavior:  behavior,
+		grace:     grace,
+		firstSeen: make(map[string]time.Time),
+	}
+}
+
+// shouldOmit reports whether the guest identified by key is left out of the
+// configuration this cycle. A nil tracker always falls back to the hostname.
+func (r *ipReadiness) shouldOmit(key string, now time.Time) bool {
+	if r == nil {
+		return false
+	}
+	if r.behavior == noIPBehaviorOmit {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	since, exists := r.firstSeen[key]
+	if !exists {
+		since = now
+		r.firstSeen[key] = now
+	}
+	return now.Sub(since) < r.grace
+}
+
+// markReady forgets a guest once it has a routable address
+func (r *ipReadiness) markReady(key string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.firstSeen, key)
+}
+
+// hasRoutableAddress reports whether a backend address can be built for the
+// service without falling back to its hostname, either from a discovered IP
+// picked by the IP selector or from an explicit ip label or url label
+// without {ip}.
+func hasRoutableAddress(service internal.Service, opts generateOptions) bool {
+	if _, found := selectGuestIP(service, opts); found {
+		return true
+	}
+	if port, exists := service.Config[opts.labelPrefix+"proxmox.nodeport"]; exists && isValidPort(port) {
+		return true
+	}
+
+	servicesPrefix := opts.labelPrefix + "http.services."
+	for key, value := range service.Config {
+		if !strings.HasPrefix(key, servicesPrefix) {
+			continue
+		}
+		if strings.HasSuffix(key, ".loadbalancer.server.ip") ||
+			(strings.HasSuffix(key, ".loadbalancer.server.url") && !isServerURLTemplate(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func readinessKey(nodeName string, service internal.Service) string {
+	return fmt.Sprintf("%s/%d", nodeName, service.ID)
+}
+
+// omitUnresolvedHostname applies noAddressPolicy to a running guest without
+// a routable IP, reporting whether it is left out because its fallback
+// hostname does not resolve. The hostname policy never looks it up.
+func (o generateOptions) omitUnresolvedHostname(service internal.Service, nodeName string) bool {
+	if o.noAddressPolicy == "" || o.noAddressPolicy == noAddressPolicyHostname {
+		return false
+	}
+
+	host := getFallbackHostname(service, nodeName, o)
+	resolve := o.resolveHost
+	if resolve == nil {
+		resolve = hostnameResolves
+	}
+	if resolve(host) {
+		return false
+	}
+	if o.noAddressPolicy == noAddressPolicyError {
+		o.logger.Errorf("Service %s (ID: %d) has no routable IP and its hostname %s does not resolve, leaving it out", service.Name, service.ID, host)
+	} else {
+		o.logger.Infof("Skipping service %s (ID: %d) because it has no routable IP and its hostname %s does not resolve", service.Name, service.ID, host)
+	}
+	return true
+}
+
+// hostnameResolves reports whether host has an address, bounded like the
+// lookups of resolveHostnameIPv6
+func hostnameResolves(host string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), hostnameLookupTimeout)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupHost(ctx, host)
+	return err == nil && len(addresses) > 0
+}
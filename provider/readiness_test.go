@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestIPReadiness(t *testing.T) {
+	now := time.Now()
+
+	var fallback *ipReadiness
+	if fallback.shouldOmit("pve1/100", now) {
+		t.Error("Expected a nil tracker to use the hostname fallback")
+	}
+
+	omit := newIPReadiness(noIPBehaviorOmit, 0)
+	if !omit.shouldOmit("pve1/100", now) {
+		t.Error("Expected the omit behavior to leave the guest out")
+	}
+
+	grace := newIPReadiness(noIPBehaviorHostname, time.Minute)
+	if !grace.shouldOmit("pve1/100", now) {
+		t.Error("Expected a guest without an IP to be held back during the grace period")
+	}
+	if grace.shouldOmit("pve1/100", now.Add(2*time.Minute)) {
+		t.Error("Expected the hostname fallback once the grace period is over")
+	}
+
+	grace.markReady("pve1/100")
+	if !grace.shouldOmit("pve1/100", now.Add(3*time.Minute)) {
+		t.Error("Expected the grace period to restart after the guest had an IP")
+	}
+}
+
+func TestGenerateConfigurationWithoutIP(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:     100,
+				Name:   "booting",
+				Status: internal.StatusRunning,
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+			{
+				ID:     101,
+				Name:   "static",
+				Status: internal.StatusRunning,
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.http.services.static.loadbalancer.server.ip": "10.0.0.5",
+				},
+			},
+			{
+				ID:     102,
+				Name:   "ready",
+				Status: internal.StatusRunning,
+				IPs:    []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		readiness *ipReadiness
+		expected  []string
+	}{
+		{name: "Hostname fallback", readiness: nil, expected: []string{"booting-100", "static", "ready-102"}},
+		{name: "Omit", readiness: newIPReadiness(noIPBehaviorOmit, 0), expected: []string{"static", "ready-102"}},
+		{name: "Grace period", readiness: newIPReadiness(noIPBehaviorHostname, time.Hour), expected: []string{"static", "ready-102"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := generateConfiguration(servicesMap, generateOptions{
+				labelPrefix: internal.DefaultLabelPrefix,
+				ipReadiness: tt.readiness,
+			})
+			if len(config.HTTP.Services) != len(tt.expected) {
+				t.Errorf("Expected %d services, got %d", len(tt.expected), len(config.HTTP.Services))
+			}
+			for _, name := range tt.expected {
+				if _, exists := config.HTTP.Services[name]; !exists {
+					t.Errorf("Expected service %s to be generated", name)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateConfigurationNoAddressPolicy(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:     100,
+				Name:   "unresolved",
+				Status: internal.StatusRunning,
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+			{
+				ID:     101,
+				Name:   "resolved",
+				Status: internal.StatusRunning,
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+			{
+				ID:     102,
+				Name:   "ready",
+				Status: internal.StatusRunning,
+				IPs:    []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{"traefik.enable": "true"},
+			},
+		},
+	}
+	lookups := make([]string, 0)
+	resolveHost := func(host string) bool {
+		lookups = append(lookups, host)
+		return host == "resolved.pve1"
+	}
+
+	tests := []struct {
+		name     string
+		policy   string
+		expected []string
+		lookups  []string
+		logged   string
+	}{
+		{name: "Hostname", policy: noAddressPolicyHostname, expected: []string{"ready-102", "resolved-101", "unresolved-100"}},
+		{name: "Skip", policy: noAddressPolicySkip, expected: []string{"ready-102", "resolved-101"}, lookups: []string{"unresolved.pve1", "resolved.pve1"}, logged: "[INFO] Skipping service unresolved (ID: 100) because it has no routable IP and its hostname unresolved.pve1 does not resolve"},
+		{name: "Error", policy: noAddressPolicyError, expected: []string{"ready-102", "resolved-101"}, lookups: []string{"unresolved.pve1", "resolved.pve1"}, logged: "[ERROR] Service unresolved (ID: 100) has no routable IP and its hostname unresolved.pve1 does not resolve"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups = lookups[:0]
+			var buf bytes.Buffer
+			logger := internal.NewLogger("info")
+			logger.SetOutput(&buf)
+			opts := generateOptions{labelPrefix: internal.DefaultLabelPrefix, noAddressPolicy: tt.policy, resolveHost: resolveHost, logger: logger}
+
+			config := generateConfiguration(servicesMap, opts)
+			services := make([]string, 0)
+			for name := range config.HTTP.Services {
+				services = append(services, name)
+			}
+			sort.Strings(services)
+			if strings.Join(services, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected services %v, got %v", tt.expected, services)
+			}
+			if strings.Join(lookups, ",") != strings.Join(tt.lookups, ",") {
+				t.Errorf("Expected lookups %v, got %v", tt.lookups, lookups)
+			}
+			if tt.logged != "" && !strings.Contains(buf.String(), tt.logged) {
+				t.Errorf("Expected %q to be logged, got %q", tt.logged, buf.String())
+			}
+		})
+	}
+}
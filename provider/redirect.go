@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// Entry points of the HTTP to HTTPS redirection unless configured otherwise
+const (
+	defaultHTTPEntryPoint  = "web"
+	defaultHTTPSEntryPoint = "websecure"
+)
+
+// httpsRedirectMiddlewareName is the redirectScheme middleware shared by
+// all companion redirect routers
+const httpsRedirectMiddlewareName = "proxmox-https-redirect"
+
+// httpsRedirectEnabled reports whether HTTPS routers of the guest get a
+// redirect companion: the proxmox.httpsredirect label, else the provider default
+func httpsRedirectEnabled(service internal.Service, opts generateOptions) bool {
+	if val, exists := service.Config[opts.labelPrefix+"proxmox.httpsredirect"]; exists {
+		return val == "true"
+	}
+	return opts.httpsRedirect
+}
+
+func (o generateOptions) httpEntryPointName() string {
+	if o.httpEntryPoint == "" {
+		return defaultHTTPEntryPoint
+	}
+	return o.httpEntryPoint
+}
+
+func (o generateOptions) httpsEntryPointName() string {
+	if o.httpsEntryPoint == "" {
+		return defaultHTTPSEntryPoint
+	}
+	return o.httpsEntryPoint
+}
+
+// addHTTPSRedirect emits a router on the HTTP entry point that redirects
+// requests matching an HTTPS router to HTTPS. A router is HTTPS when it has
+// TLS enabled or listens on the HTTPS entry point; without entry points it
+// is bound to the HTTPS entry point so both routers do not compete for the
+// same requests. Routers already serving the HTTP entry point are left alone.
+func addHTTPSRedirect(config *dynamic.Configuration, routerName string, router *dynamic.Router, opts generateOptions) {
+	httpEntryPoint := opts.httpEntryPointName()
+	httpsEntryPoint := opts.httpsEntryPointName()
+
+	if router.TLS == nil && !containsString(router.EntryPoints, httpsEntryPoint) {
+		return
+	}
+	if len(router.EntryPoints) == 0 {
+		router.EntryPoints = []string{httpsEntryPoint}
+	}
+	if containsString(router.EntryPoints, httpEntryPoint) {
+		return
+	}
+
+	config.HTTP.Middlewares[httpsRedirectMiddlewareName] = &dynamic.Middleware{
+		RedirectScheme: &dynamic.RedirectScheme{
+			Scheme:    "https",
+			Permanent: true,
+		},
+	}
+	config.HTTP.Routers[routerName+"-redirect"] = &dynamic.Router{
+		EntryPoints: []string{httpEntryPoint},
+		Middlewares: []string{httpsRedirectMiddlewareName},
+		Service:     router.Service,
+		Rule:        router.Rule,
+		Priority:    router.Priority,
+	}
+}
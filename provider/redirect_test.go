@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGenerateConfigurationHTTPSRedirect(t *testing.T) {
+	newService := func(id uint64, name string, labels map[string]string) internal.Service {
+		labels["traefik.enable"] = "true"
+		return internal.Service{ID: id, Name: name, IPs: []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}}, Config: labels}
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			newService(100, "secure", map[string]string{
+				"traefik.proxmox.httpsredirect":    "true",
+				"traefik.http.routers.secure.rule": "Host(`secure.example.com`)",
+				"traefik.http.routers.secure.tls":  "true",
+			}),
+			newService(101, "plain", map[string]string{
+				"traefik.proxmox.httpsredirect":          "true",
+				"traefik.http.routers.plain.rule":        "Host(`plain.example.com`)",
+				"traefik.http.routers.plain.entrypoints": "web",
+			}),
+			newService(102, "optout", map[string]string{
+				"traefik.http.routers.optout.rule":        "Host(`optout.example.com`)",
+				"traefik.http.routers.optout.entrypoints": "websecure",
+			}),
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	secure := config.HTTP.Routers["secure"]
+	if len(secure.EntryPoints) != 1 || secure.EntryPoints[0] != "websecure" {
+		t.Errorf("Expected the HTTPS router to be bound to websecure, got %v", secure.EntryPoints)
+	}
+	redirect := config.HTTP.Routers["secure-redirect"]
+	if redirect == nil {
+		t.Fatalf("Expected a redirect router, got %v", config.HTTP.Routers)
+	}
+	if redirect.Rule != secure.Rule || redirect.Service != secure.Service || redirect.Priority != secure.Priority {
+		t.Errorf("Expected the redirect router to match the HTTPS router, got %+v", redirect)
+	}
+	if len(redirect.EntryPoints) != 1 || redirect.EntryPoints[0] != "web" {
+		t.Errorf("Expected the redirect router on web, got %v", redirect.EntryPoints)
+	}
+	if len(redirect.Middlewares) != 1 || redirect.Middlewares[0] != httpsRedirectMiddlewareName {
+		t.Errorf("Expected the redirect middleware, got %v", redirect.Middlewares)
+	}
+	middleware := config.HTTP.Middlewares[httpsRedirectMiddlewareName]
+	if middleware == nil || middleware.RedirectScheme == nil || middleware.RedirectScheme.Scheme != "https" || !middleware.RedirectScheme.Permanent {
+		t.Errorf("Expected a permanent redirectScheme middleware, got %+v", middleware)
+	}
+
+	if _, exists := config.HTTP.Routers["plain-redirect"]; exists {
+		t.Error("Expected no redirect for a plain HTTP router")
+	}
+	if _, exists := config.HTTP.Routers["optout-redirect"]; exists {
+		t.Error("Expected no redirect without the label or provider default")
+	}
+
+	// Provider default with custom entry points and a per-guest opt-out
+	servicesMap["pve1"][1].Config["traefik.proxmox.httpsredirect"] = "false"
+	servicesMap["pve1"][1].Config["traefik.http.routers.plain.entrypoints"] = "https"
+	config = generateConfiguration(servicesMap, generateOptions{
+		labelPrefix:     internal.DefaultLabelPrefix,
+		httpsRedirect:   true,
+		httpEntryPoint:  "http",
+		httpsEntryPoint: "https",
+	})
+	if redirect := config.HTTP.Routers["secure-redirect"]; redirect == nil || redirect.EntryPoints[0] != "http" {
+		t.Errorf("Expected a redirect router on the http entry point, got %+v", redirect)
+	}
+	if _, exists := config.HTTP.Routers["optout-redirect"]; exists {
+		t.Error("Expected no redirect for a router on an entry point other than the HTTPS one")
+	}
+	if _, exists := config.HTTP.Routers["plain-redirect"]; exists {
+		t.Error("Expected the label to opt out of the provider default")
+	}
+}
@@ -0,0 +1,274 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+	"github.com/traefik/genconf/dynamic/tls"
+)
+
+// loadStaticConfig reads a dynamic configuration file in the JSON or YAML
+// format of the Traefik file provider. Files ending in .json or starting
+// with "{" are read as JSON, others as YAML. Unknown keys are rejected so
+// that a typo does not silently drop an object.
+func loadStaticConfig(path string) (*dynamic.Configuration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read static config file: %w", err)
+	}
+
+	if !strings.EqualFold(filepath.Ext(path), ".json") && !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		document, err := internal.ParseYAML(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid static config file %s: %w", path, err)
+		}
+		data, err = json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static config file %s: %w", path, err)
+		}
+	}
+
+	var config dynamic.Configuration
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid static config file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// refreshStaticConfig reads the static config file again, keeping the last
+// configuration read when it became unreadable so a bad edit does not drop
+// the objects it declares
+func (p *Provider) refreshStaticConfig() *dynamic.Configuration {
+	if p.staticConfigFile == "" {
+		return nil
+	}
+
+	config, err := loadStaticConfig(p.staticConfigFile)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.logger.Errorf("Keeping the last static configuration: %v", err)
+		return p.staticConfig
+	}
+	p.staticConfig = config
+	return config
+}
+
+// isStaticMiddleware reports whether the static config file declares an
+// HTTP middleware
+func (o generateOptions) isStaticMiddleware(name string) bool {
+	return o.staticConfig != nil && o.staticConfig.HTTP != nil && o.staticConfig.HTTP.Middlewares[name] != nil
+}
+
+// mergeStaticConfig adds the objects of the static config file to the
+// generated configuration. Generated objects take precedence over static
+// ones of the same name, which are logged and left out.
+func mergeStaticConfig(config, static *dynamic.Configuration, logger *internal.Logger) {
+	if static == nil {
+		return
+	}
+
+	if static.HTTP != nil {
+		config.HTTP.Routers = mergeStaticHTTPRouters(config.HTTP.Routers, static.HTTP.Routers, logger)
+		config.HTTP.Services = mergeStaticHTTPServices(config.HTTP.Services, static.HTTP.Services, logger)
+		config.HTTP.Middlewares = mergeStaticHTTPMiddlewares(config.HTTP.Middlewares, static.HTTP.Middlewares, logger)
+		config.HTTP.Models = mergeStaticHTTPModels(config.HTTP.Models, static.HTTP.Models, logger)
+		config.HTTP.ServersTransports = mergeStaticServersTransports(config.HTTP.ServersTransports, static.HTTP.ServersTransports, logger)
+	}
+	if static.TCP != nil {
+		config.TCP.Routers = mergeStaticTCPRouters(config.TCP.Routers, static.TCP.Routers, logger)
+		config.TCP.Services = mergeStaticTCPServices(config.TCP.Services, static.TCP.Services, logger)
+		config.TCP.Middlewares = mergeStaticTCPMiddlewares(config.TCP.Middlewares, static.TCP.Middlewares, logger)
+	}
+	if static.UDP != nil {
+		config.UDP.Routers = mergeStaticUDPRouters(config.UDP.Routers, static.UDP.Routers, logger)
+		config.UDP.Services = mergeStaticUDPServices(config.UDP.Services, static.UDP.Services, logger)
+	}
+	if static.TLS != nil {
+		config.TLS.Options = mergeStaticTLSOptions(config.TLS.Options, static.TLS.Options, logger)
+		config.TLS.Stores = mergeStaticTLSStores(config.TLS.Stores, static.TLS.Stores, logger)
+		config.TLS.Certificates = append(config.TLS.Certificates, static.TLS.Certificates...)
+	}
+}
+
+// logStaticConflict logs a static object left out for a generated one of
+// the same name
+func logStaticConflict(logger *internal.Logger, kind, name string) {
+	logger.Warnf("Static %s %s is replaced by the one generated from guest labels", kind, name)
+}
+
+func mergeStaticHTTPRouters(generated, static map[string]*dynamic.Router, logger *internal.Logger) map[string]*dynamic.Router {
+	for name, router := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "HTTP router", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.Router)
+		}
+		generated[name] = router
+	}
+	return generated
+}
+
+func mergeStaticHTTPServices(generated, static map[string]*dynamic.Service, logger *internal.Logger) map[string]*dynamic.Service {
+	for name, service := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "HTTP service", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.Service)
+		}
+		generated[name] = service
+	}
+	return generated
+}
+
+func mergeStaticHTTPMiddlewares(generated, static map[string]*dynamic.Middleware, logger *internal.Logger) map[string]*dynamic.Middleware {
+	for name, middleware := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "HTTP middleware", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.Middleware)
+		}
+		generated[name] = middleware
+	}
+	return generated
+}
+
+func mergeStaticHTTPModels(generated, static map[string]*dynamic.Model, logger *internal.Logger) map[string]*dynamic.Model {
+	for name, model := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "HTTP model", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.Model)
+		}
+		generated[name] = model
+	}
+	return generated
+}
+
+func mergeStaticServersTransports(generated, static map[string]*dynamic.ServersTransport, logger *internal.Logger) map[string]*dynamic.ServersTransport {
+	for name, transport := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "servers transport", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.ServersTransport)
+		}
+		generated[name] = transport
+	}
+	return generated
+}
+
+func mergeStaticTCPRouters(generated, static map[string]*dynamic.TCPRouter, logger *internal.Logger) map[string]*dynamic.TCPRouter {
+	for name, router := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "TCP router", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.TCPRouter)
+		}
+		generated[name] = router
+	}
+	return generated
+}
+
+func mergeStaticTCPServices(generated, static map[string]*dynamic.TCPService, logger *internal.Logger) map[string]*dynamic.TCPService {
+	for name, service := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "TCP service", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.TCPService)
+		}
+		generated[name] = service
+	}
+	return generated
+}
+
+func mergeStaticTCPMiddlewares(generated, static map[string]*dynamic.TCPMiddleware, logger *internal.Logger) map[string]*dynamic.TCPMiddleware {
+	for name, middleware := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "TCP middleware", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.TCPMiddleware)
+		}
+		generated[name] = middleware
+	}
+	return generated
+}
+
+func mergeStaticUDPRouters(generated, static map[string]*dynamic.UDPRouter, logger *internal.Logger) map[string]*dynamic.UDPRouter {
+	for name, router := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "UDP router", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.UDPRouter)
+		}
+		generated[name] = router
+	}
+	return generated
+}
+
+func mergeStaticUDPServices(generated, static map[string]*dynamic.UDPService, logger *internal.Logger) map[string]*dynamic.UDPService {
+	for name, service := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "UDP service", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]*dynamic.UDPService)
+		}
+		generated[name] = service
+	}
+	return generated
+}
+
+func mergeStaticTLSOptions(generated, static map[string]tls.Options, logger *internal.Logger) map[string]tls.Options {
+	for name, options := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "TLS options", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]tls.Options)
+		}
+		generated[name] = options
+	}
+	return generated
+}
+
+func mergeStaticTLSStores(generated, static map[string]tls.Store, logger *internal.Logger) map[string]tls.Store {
+	for name, store := range static {
+		if _, exists := generated[name]; exists {
+			logStaticConflict(logger, "TLS store", name)
+			continue
+		}
+		if generated == nil {
+			generated = make(map[string]tls.Store)
+		}
+		generated[name] = store
+	}
+	return generated
+}
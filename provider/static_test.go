@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProviderGenerateOnceStaticConfig(t *testing.T) {
+	client, _ := newTestProxmoxServer(t, map[string]string{
+		"/version":                    `{"data":{"release":"8.1"}}`,
+		"/nodes":                      `{"data":[{"node":"pve1"}]}`,
+		"/nodes/pve1/qemu":            `{"data":[{"vmid":100,"name":"web","status":"running"}]}`,
+		"/nodes/pve1/qemu/100/config": `{"data":{"description":"traefik.enable=true\ntraefik.proxmox.ip=10.0.0.5\ntraefik.http.routers.web.middlewares=error-pages"}}`,
+	})
+
+	path := filepath.Join(t.TempDir(), "static.yml")
+	writeStatic := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write static config: %v", err)
+		}
+	}
+	writeStatic(`http:
+  middlewares:
+    error-pages:
+      errors:
+        status: ["500-599"]
+        service: error-pages
+        query: /{status}.html
+  services:
+    web-100:
+      loadBalancer:
+        servers:
+          - url: http://10.0.0.99:80
+    error-pages:
+      loadBalancer:
+        servers:
+          - url: http://10.0.0.20:8080
+tls:
+  options:
+    modern:
+      minVersion: VersionTLS13
+`)
+
+	config := CreateConfig()
+	config.ApiEndpoint = strings.TrimSuffix(client.BaseURL, "/api2/json")
+	config.ApiTokenId = "test@pam!test"
+	config.ApiToken = "test-token"
+	config.StaticConfigFile = path
+
+	p, err := New(context.Background(), config, "test")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var logs bytes.Buffer
+	p.logger.SetOutput(&logs)
+
+	configuration, err := p.GenerateOnce(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateOnce() error = %v", err)
+	}
+
+	router := configuration.HTTP.Routers["web"]
+	if router == nil || len(router.Middlewares) != 1 || router.Middlewares[0] != "error-pages" {
+		t.Fatalf("Expected the generated router to use the static middleware, got %+v", router)
+	}
+	if middleware := configuration.HTTP.Middlewares["error-pages"]; middleware == nil || middleware.Errors == nil || middleware.Errors.Service != "error-pages" {
+		t.Errorf("Expected the static middleware to be merged, got %+v", middleware)
+	}
+	if _, exists := configuration.HTTP.Services["error-pages"]; !exists {
+		t.Error("Expected the static service to be merged")
+	}
+	if _, exists := configuration.TLS.Options["modern"]; !exists {
+		t.Error("Expected the static TLS options to be merged")
+	}
+	if servers := configuration.HTTP.Services["web-100"].LoadBalancer.Servers; len(servers) != 1 || servers[0].URL != "http://10.0.0.5:80" {
+		t.Errorf("Expected the generated service to take precedence, got %+v", servers)
+	}
+	if !strings.Contains(logs.String(), "Static HTTP service web-100 is replaced") {
+		t.Errorf("Expected the name conflict to be logged, got %s", logs.String())
+	}
+	if strings.Contains(logs.String(), "which is not defined on any guest") {
+		t.Errorf("Expected no warning for a middleware of the static config file, got %s", logs.String())
+	}
+
+	// The file is read again every poll, a broken one keeps the last objects
+	writeStatic("http:\n  middlewares:\n    error-pages:\n      compress: {}\n")
+	configuration, err = p.GenerateOnce(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateOnce() error = %v", err)
+	}
+	if middleware := configuration.HTTP.Middlewares["error-pages"]; middleware == nil || middleware.Compress == nil {
+		t.Errorf("Expected the edited static middleware, got %+v", middleware)
+	}
+	if _, exists := configuration.HTTP.Services["error-pages"]; exists {
+		t.Error("Expected objects removed from the file to disappear")
+	}
+
+	writeStatic("http:\n  middlewares:\n\terror-pages: {}\n")
+	configuration, err = p.GenerateOnce(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateOnce() error = %v", err)
+	}
+	if middleware := configuration.HTTP.Middlewares["error-pages"]; middleware == nil || middleware.Compress == nil {
+		t.Errorf("Expected the last static configuration to be kept, got %+v", middleware)
+	}
+}
+
+func TestLoadStaticConfig(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "static.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"http":{"middlewares":{"compress":{"compress":{}}}}}`), 0o600); err != nil {
+		t.Fatalf("failed to write static config: %v", err)
+	}
+	config, err := loadStaticConfig(jsonPath)
+	if err != nil {
+		t.Fatalf("loadStaticConfig() error = %v", err)
+	}
+	if middleware := config.HTTP.Middlewares["compress"]; middleware == nil || middleware.Compress == nil {
+		t.Errorf("Expected the JSON middleware, got %+v", middleware)
+	}
+
+	// A misspelled key does not silently drop the object
+	typoPath := filepath.Join(dir, "typo.yml")
+	if err := os.WriteFile(typoPath, []byte("http:\n  middlewars:\n    compress:\n      compress: {}\n"), 0o600); err != nil {
+		t.Fatalf("failed to write static config: %v", err)
+	}
+	if _, err := loadStaticConfig(typoPath); err == nil || !strings.Contains(err.Error(), "middlewars") {
+		t.Errorf("Expected an error naming the unknown key, got %v", err)
+	}
+
+	if _, err := loadStaticConfig(filepath.Join(dir, "missing.yml")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,183 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// defaultTCPRule matches every TCP connection, TLS or not
+const defaultTCPRule = "HostSNI(`*`)"
+
+// addTCPConfiguration creates the TCP routers and services declared by the
+// tcp.* labels of a guest. It reports whether the guest declares any, so
+// guests only exposing TCP do not get a default HTTP router.
+func addTCPConfiguration(config *dynamic.Configuration, service internal.Service, nodeName string, opts generateOptions) bool {
+	routersPrefix := opts.labelPrefix + "tcp.routers."
+	servicesPrefix := opts.labelPrefix + "tcp.services."
+	routerNames := labelNames(service.Config, routersPrefix)
+	serviceNames := labelNames(service.Config, servicesPrefix)
+	if len(routerNames) == 0 && len(serviceNames) == 0 {
+		return false
+	}
+
+	// There is no placeholder for TCP, so stopped guests are left out
+	if !service.IsRunning() {
+		opts.logger.Debugf("Skipping TCP configuration of %s (ID: %d) because it is not running", service.Name, service.ID)
+		return true
+	}
+
+	available := make([]string, 0, len(serviceNames))
+	for _, serviceName := range serviceNames {
+		address, ok := getTCPServerAddress(service, serviceName, nodeName, opts)
+		if !ok {
+			opts.logger.Warnf("Service %s (ID: %d): TCP service %s needs %stcp.services.%s.loadbalancer.server.port or .address, skipping it",
+				service.Name, service.ID, serviceName, opts.labelPrefix, serviceName)
+			continue
+		}
+		available = append(available, serviceName)
+		addTCPServer(config, serviceName, address)
+	}
+
+	for _, routerName := range routerNames {
+		prefix := fmt.Sprintf("%stcp.routers.%s", opts.labelPrefix, routerName)
+
+		targetService, found := getTCPRouterTargetService(service, routerName, serviceNames, opts)
+		if !found {
+			opts.logger.Warnf("Service %s (ID: %d): TCP router %s matches none of the services %s, set %s.service to choose one",
+				service.Name, service.ID, routerName, strings.Join(serviceNames, ", "), prefix)
+			continue
+		}
+		if containsString(serviceNames, targetService) && !containsString(available, targetService) {
+			// The service of this guest was skipped, so the router would have no backend
+			continue
+		}
+
+		router := &dynamic.TCPRouter{
+			Service: targetService,
+			Rule:    defaultTCPRule,
+		}
+		if rule, exists := service.Config[prefix+".rule"]; exists {
+			router.Rule = rule
+		}
+		if entrypoints, exists := service.Config[prefix+".entrypoints"]; exists {
+			router.EntryPoints = splitList(entrypoints)
+		}
+		if middlewares, exists := service.Config[prefix+".middlewares"]; exists {
+			router.Middlewares = splitList(middlewares)
+		}
+		if priority, exists := service.Config[prefix+".priority"]; exists {
+			if p, err := strconv.Atoi(priority); err == nil {
+				router.Priority = p
+			}
+		}
+		router.TLS = handleTCPRouterTLS(service, routerName, prefix, opts)
+
+		config.TCP.Routers[routerName] = router
+	}
+
+	return true
+}
+
+// handleTCPRouterTLS builds the TLS settings of a TCP router. With tls=true
+// Traefik terminates TLS, using the cert resolver, options and domains
+// labels, and forwards plain TCP to the guest. Passthrough implies TLS,
+// since Traefik needs the SNI to route the connection, but leaves the
+// handshake to the guest, so the certificate labels do not apply.
+func handleTCPRouterTLS(service internal.Service, routerName string, prefix string, opts generateOptions) *dynamic.RouterTCPTLSConfig {
+	passthrough := service.Config[prefix+".tls.passthrough"] == "true"
+
+	tlsConfig := handleRouterTLS(service, prefix)
+	if passthrough {
+		ignored := make([]string, 0)
+		for _, suffix := range []string{".tls.certresolver", ".tls.options", ".tls.domains"} {
+			if _, exists := service.Config[prefix+suffix]; exists {
+				ignored = append(ignored, prefix+suffix)
+			}
+		}
+		if len(ignored) > 0 {
+			opts.logger.Warnf("Service %s (ID: %d): TCP router %s passes TLS through to the guest, ignoring %s",
+				service.Name, service.ID, routerName, strings.Join(ignored, ", "))
+		}
+		return &dynamic.RouterTCPTLSConfig{Passthrough: true}
+	}
+	if tlsConfig == nil {
+		return nil
+	}
+
+	return &dynamic.RouterTCPTLSConfig{
+		Options:      tlsConfig.Options,
+		CertResolver: tlsConfig.CertResolver,
+		Domains:      tlsConfig.Domains,
+	}
+}
+
+// Helper to get the address of a TCP server: the explicit address label, or
+// the guest host with the port label. It reports false without a port.
+func getTCPServerAddress(service internal.Service, serviceName string, nodeName string, opts generateOptions) (string, bool) {
+	prefix := fmt.Sprintf("%stcp.services.%s.loadbalancer.server", opts.labelPrefix, serviceName)
+	if address, exists := service.Config[prefix+".address"]; exists && address != "" {
+		return address, true
+	}
+
+	port, exists := service.Config[prefix+".port"]
+	if !exists || port == "" {
+		return "", false
+	}
+	host := getGuestHost(service, nodeName, opts)
+	return net.JoinHostPort(strings.Trim(host, "[]"), port), true
+}
+
+// addTCPServer adds a server to a TCP service, merging guests declaring the
+// same service name into a single load balancer
+func addTCPServer(config *dynamic.Configuration, serviceName string, address string) {
+	existing, exists := config.TCP.Services[serviceName]
+	if !exists || existing.LoadBalancer == nil {
+		config.TCP.Services[serviceName] = &dynamic.TCPService{
+			LoadBalancer: &dynamic.TCPServersLoadBalancer{
+				Servers: []dynamic.TCPServer{{Address: address}},
+			},
+		}
+		return
+	}
+	for _, server := range existing.LoadBalancer.Servers {
+		if server.Address == address {
+			return
+		}
+	}
+	existing.LoadBalancer.Servers = append(existing.LoadBalancer.Servers, dynamic.TCPServer{Address: address})
+}
+
+// Helper to find the service a TCP router points to, like getRouterTargetService
+func getTCPRouterTargetService(service internal.Service, routerName string, serviceNames []string, opts generateOptions) (string, bool) {
+	serviceLabel := fmt.Sprintf("%stcp.routers.%s.service", opts.labelPrefix, routerName)
+	if val, exists := service.Config[serviceLabel]; exists {
+		return val, true
+	}
+	if containsString(serviceNames, routerName) {
+		return routerName, true
+	}
+	if len(serviceNames) == 1 {
+		return serviceNames[0], true
+	}
+	return "", false
+}
+
+// Helper to collect the sorted names of the label keys below a prefix,
+// e.g. "app" for "traefik.tcp.routers.app.rule"
+func labelNames(labels map[string]string, prefix string) []string {
+	names := make(map[string]bool)
+	for k := range labels {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if name, _, found := strings.Cut(strings.TrimPrefix(k, prefix), "."); found && name != "" {
+			names[name] = true
+		}
+	}
+	return mapKeysToSlice(names)
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestGenerateConfigurationTCPPassthrough(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "gateway",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                        "true",
+					"traefik.tcp.routers.gateway.rule":                      "HostSNI(`gateway.example.com`)",
+					"traefik.tcp.routers.gateway.entrypoints":               "websecure",
+					"traefik.tcp.routers.gateway.tls.passthrough":           "true",
+					"traefik.tcp.services.gateway.loadbalancer.server.port": "443",
+				},
+			},
+			{
+				ID:   101,
+				Name: "database",
+				IPs:  []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                            "true",
+					"traefik.tcp.routers.db.service":                            "postgres",
+					"traefik.tcp.routers.db.tls":                                "true",
+					"traefik.tcp.services.postgres.loadbalancer.server.address": "db.internal:5432",
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	router := config.TCP.Routers["gateway"]
+	if router == nil {
+		t.Fatalf("Expected a TCP router, got %v", config.TCP.Routers)
+	}
+	if router.Rule != "HostSNI(`gateway.example.com`)" || router.Service != "gateway" {
+		t.Errorf("Unexpected TCP router %+v", router)
+	}
+	if router.TLS == nil || !router.TLS.Passthrough {
+		t.Errorf("Expected TLS passthrough to be enabled, got %+v", router.TLS)
+	}
+	if len(router.EntryPoints) != 1 || router.EntryPoints[0] != "websecure" {
+		t.Errorf("Expected the router on websecure, got %v", router.EntryPoints)
+	}
+
+	service := config.TCP.Services["gateway"]
+	if service == nil || service.LoadBalancer == nil || len(service.LoadBalancer.Servers) != 1 {
+		t.Fatalf("Expected a TCP service with one server, got %+v", service)
+	}
+	if address := service.LoadBalancer.Servers[0].Address; address != "10.0.0.5:443" {
+		t.Errorf("Expected server address 10.0.0.5:443, got %s", address)
+	}
+
+	db := config.TCP.Routers["db"]
+	if db == nil || db.Rule != defaultTCPRule || db.Service != "postgres" {
+		t.Fatalf("Expected a catch-all router for postgres, got %+v", db)
+	}
+	if db.TLS == nil || db.TLS.Passthrough {
+		t.Errorf("Expected TLS termination without passthrough, got %+v", db.TLS)
+	}
+	if address := config.TCP.Services["postgres"].LoadBalancer.Servers[0].Address; address != "db.internal:5432" {
+		t.Errorf("Expected the explicit address, got %s", address)
+	}
+
+	if len(config.HTTP.Routers) != 0 || len(config.HTTP.Services) != 0 {
+		t.Errorf("Expected no HTTP configuration for TCP-only guests, got %v and %v", config.HTTP.Routers, config.HTTP.Services)
+	}
+}
+
+func TestGenerateConfigurationTCPWithoutPort(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "gateway",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable": "true",
+					"traefik.tcp.routers.gateway.tls.passthrough": "true",
+				},
+			},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	if len(config.TCP.Routers) != 0 || len(config.TCP.Services) != 0 {
+		t.Errorf("Expected no TCP configuration without a port, got %v and %v", config.TCP.Routers, config.TCP.Services)
+	}
+}
+
+func TestGenerateConfigurationTCPTLSModes(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{
+				ID:   100,
+				Name: "mqtt",
+				IPs:  []internal.IP{{Address: "10.0.0.5", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                     "true",
+					"traefik.tcp.routers.mqtt.rule":                      "HostSNI(`mqtt.example.com`)",
+					"traefik.tcp.routers.mqtt.tls":                       "true",
+					"traefik.tcp.routers.mqtt.tls.certresolver":          "letsencrypt",
+					"traefik.tcp.services.mqtt.loadbalancer.server.port": "1883",
+				},
+			},
+			{
+				ID:   101,
+				Name: "gateway",
+				IPs:  []internal.IP{{Address: "10.0.0.6", AddressType: "ipv4"}},
+				Config: map[string]string{
+					"traefik.enable":                                        "true",
+					"traefik.tcp.routers.gateway.rule":                      "HostSNI(`gateway.example.com`)",
+					"traefik.tcp.routers.gateway.tls":                       "true",
+					"traefik.tcp.routers.gateway.tls.passthrough":           "true",
+					"traefik.tcp.routers.gateway.tls.certresolver":          "letsencrypt",
+					"traefik.tcp.services.gateway.loadbalancer.server.port": "443",
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	logger := internal.NewLogger("info")
+	logger.SetOutput(&buf)
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix, logger: logger})
+
+	terminated := config.TCP.Routers["mqtt"]
+	if terminated == nil || terminated.TLS == nil {
+		t.Fatalf("Expected a TCP router terminating TLS, got %+v", terminated)
+	}
+	if terminated.TLS.Passthrough || terminated.TLS.CertResolver != "letsencrypt" {
+		t.Errorf("Expected TLS termination with the letsencrypt resolver, got %+v", terminated.TLS)
+	}
+	if address := config.TCP.Services["mqtt"].LoadBalancer.Servers[0].Address; address != "10.0.0.5:1883" {
+		t.Errorf("Expected the plaintext backend 10.0.0.5:1883, got %s", address)
+	}
+
+	passedThrough := config.TCP.Routers["gateway"]
+	if passedThrough == nil || passedThrough.TLS == nil {
+		t.Fatalf("Expected a TCP router passing TLS through, got %+v", passedThrough)
+	}
+	if !passedThrough.TLS.Passthrough || passedThrough.TLS.CertResolver != "" {
+		t.Errorf("Expected passthrough without a cert resolver, got %+v", passedThrough.TLS)
+	}
+	if !strings.Contains(buf.String(), "TCP router gateway passes TLS through to the guest, ignoring traefik.tcp.routers.gateway.tls.certresolver") {
+		t.Errorf("Expected a warning about the ignored cert resolver, got %q", buf.String())
+	}
+	if strings.Contains(buf.String(), "router mqtt") {
+		t.Errorf("Expected no warning for the terminating router, got %q", buf.String())
+	}
+}
@@ -0,0 +1,204 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+// DiagnosticSeverity tells whether a label problem breaks the guest's configuration
+type DiagnosticSeverity string
+
+const (
+	// SeverityWarning marks labels that are ignored or fall back to a default
+	SeverityWarning DiagnosticSeverity = "warning"
+	// SeverityError marks labels producing a configuration that cannot work
+	SeverityError DiagnosticSeverity = "error"
+)
+
+// Diagnostic categories
+const (
+	DiagnosticUnknownKey     = "unknown-key"
+	DiagnosticMissingRule    = "missing-rule"
+	DiagnosticUnknownService = "unknown-service"
+	DiagnosticInvalidPort    = "invalid-port"
+)
+
+// Diagnostic is a problem found in the labels of a guest
+type Diagnostic struct {
+	Severity DiagnosticSeverity
+	Category string
+	// Key is the label the diagnostic is about
+	Key     string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("label %s: %s", d.Key, d.Message)
+}
+
+// GuestDiagnostic is a label diagnostic of a guest found during a scan
+type GuestDiagnostic struct {
+	Node string
+	ID   uint64
+	Name string
+	Diagnostic
+}
+
+func (d GuestDiagnostic) String() string {
+	return fmt.Sprintf("guest %s (ID: %d) on node %s: %s", d.Name, d.ID, d.Node, d.Diagnostic)
+}
+
+// Sections that may follow the label prefix, mapped to the object kinds they accept
+var knownLabelSections = map[string][]string{
+	"http": {"routers", "services", "middlewares", "serverstransports"},
+	"tcp":  {"routers", "services", "middlewares"},
+	"udp":  {"routers", "services"},
+	"tls":  {"stores", "options", "certificates"},
+}
+
+// Top-level keys that do not follow the <section>.<kind>.<name>.<option> shape
+var knownTopLevelLabels = map[string]bool{
+	"enable": true,
+}
+
+// ValidateLabels checks the labels of a single guest, using the default
+// traefik. prefix, and returns its diagnostics sorted by label key
+func ValidateLabels(labels map[string]string) []Diagnostic {
+	return diagnoseLabels(labels, internal.DefaultLabelPrefix)
+}
+
+// hasDiagnosticErrors reports whether any diagnostic is an error
+func hasDiagnosticErrors(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnoseLabels returns the diagnostics of the labels carrying the prefix:
+// unknown or malformed keys, HTTP routers without a rule, routers pointing
+// to a service the guest does not define and invalid ports
+func diagnoseLabels(labels map[string]string, prefix string) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0)
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		if warning := validateLabelKey(name); warning != "" {
+			diagnostics = append(diagnostics, Diagnostic{Severity: SeverityWarning, Category: DiagnosticUnknownKey, Key: key, Message: warning})
+			continue
+		}
+
+		if name == "proxmox.nodeport" && !isValidPort(labels[key]) {
+			diagnostics = append(diagnostics, Diagnostic{Severity: SeverityError, Category: DiagnosticInvalidPort, Key: key,
+				Message: fmt.Sprintf("node port %q must be a number between 1 and 65535", labels[key])})
+			continue
+		}
+
+		// Only <section>.<kind>.<name>.<option> labels have values to check
+		parts := strings.SplitN(name, ".", 4)
+		if _, isSection := knownLabelSections[parts[0]]; !isSection || len(parts) < 4 {
+			continue
+		}
+		section, kind, option := parts[0], parts[1], parts[3]
+		switch {
+		case option == "loadbalancer.server.port":
+			if !isValidPort(labels[key]) {
+				diagnostics = append(diagnostics, Diagnostic{Severity: SeverityError, Category: DiagnosticInvalidPort, Key: key,
+					Message: fmt.Sprintf("port %q must be a number between 1 and 65535", labels[key])})
+			}
+		case option == "loadbalancer.server.ports":
+			for _, port := range splitList(labels[key]) {
+				if !isValidPort(port) {
+					diagnostics = append(diagnostics, Diagnostic{Severity: SeverityError, Category: DiagnosticInvalidPort, Key: key,
+						Message: fmt.Sprintf("candidate port %q must be a number between 1 and 65535", port)})
+				}
+			}
+		case kind == "routers" && option == "service":
+			if diagnostic, found := diagnoseRouterService(labels, prefix, section, key); found {
+				diagnostics = append(diagnostics, diagnostic)
+			}
+		}
+	}
+
+	// HTTP routers without a rule, host or pathprefix get the default rule
+	routersPrefix := prefix + "http.routers."
+	for _, routerName := range labelNames(labels, routersPrefix) {
+		ruleKey := routersPrefix + routerName + ".rule"
+		_, hasHost := labels[routersPrefix+routerName+".host"]
+		_, hasPathPrefix := labels[routersPrefix+routerName+".pathprefix"]
+		if _, exists := labels[ruleKey]; !exists && !hasHost && !hasPathPrefix {
+			diagnostics = append(diagnostics, Diagnostic{Severity: SeverityWarning, Category: DiagnosticMissingRule, Key: ruleKey,
+				Message: fmt.Sprintf("router %s has no rule, the default rule applies", routerName)})
+		}
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool { return diagnostics[i].Key < diagnostics[j].Key })
+	return diagnostics
+}
+
+// diagnoseRouterService checks that a router's service label names a service
+// of the guest. Guests defining no service of that section may reference
+// services of other guests or providers, so they are not diagnosed.
+func diagnoseRouterService(labels map[string]string, prefix, section, key string) (Diagnostic, bool) {
+	target := labels[key]
+	serviceNames := labelNames(labels, prefix+section+".services.")
+	if len(serviceNames) == 0 || strings.Contains(target, "@") || containsString(serviceNames, target) {
+		return Diagnostic{}, false
+	}
+	return Diagnostic{Severity: SeverityError, Category: DiagnosticUnknownService, Key: key,
+		Message: fmt.Sprintf("service %q is not defined, expected one of %s", target, strings.Join(serviceNames, ", "))}, true
+}
+
+// isValidPort reports whether s is a TCP or UDP port number
+func isValidPort(s string) bool {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	return err == nil && port >= 1 && port <= 65535
+}
+
+func validateLabelKey(key string) string {
+	if knownTopLevelLabels[key] {
+		return ""
+	}
+
+	parts := strings.Split(key, ".")
+	for _, part := range parts {
+		if part == "" {
+			return "empty segment in label key"
+		}
+	}
+
+	// Provider-specific labels such as proxmox.ip
+	if parts[0] == "proxmox" {
+		if len(parts) < 2 {
+			return "missing option after proxmox"
+		}
+		return ""
+	}
+
+	kinds, exists := knownLabelSections[parts[0]]
+	if !exists {
+		return fmt.Sprintf("unknown section %q", parts[0])
+	}
+	if len(parts) < 2 {
+		return fmt.Sprintf("missing object kind after %s", parts[0])
+	}
+	if !containsString(kinds, parts[1]) {
+		return fmt.Sprintf("unknown %s object kind %q, expected one of %s", parts[0], parts[1], strings.Join(kinds, ", "))
+	}
+	if len(parts) < 4 {
+		return fmt.Sprintf("expected %s.%s.<name>.<option>", parts[0], parts[1])
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestValidateLabels(t *testing.T) {
+	labels := map[string]string{
+		"traefik.enable":                                     "true",
+		"traefik.proxmox.ip":                                 "10.0.0.5",
+		"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+		"traefik.http.services.web.loadbalancer.server.port": "8080",
+		"traefik.http.middlewares.auth.basicauth.users":      "user:hash",
+		"traefik.tcp.routers.db.rule":                        "HostSNI(`*`)",
+		"traefik.http.router.web.rule":                       "Host(`typo.example.com`)",
+		"traefik.htp.services.web.loadbalancer.server.port":  "8080",
+		"traefik.http.routers.web":                           "incomplete",
+		"traefik.http.routers..rule":                         "Host(`empty.example.com`)",
+		"traefik.enabled":                                    "true",
+		"other.label":                                        "ignored",
+	}
+
+	diagnostics := ValidateLabels(labels)
+
+	expected := []string{
+		"traefik.enabled",
+		"traefik.htp.services.web.loadbalancer.server.port",
+		"traefik.http.router.web.rule",
+		"traefik.http.routers..rule",
+		"traefik.http.routers.web",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %d: %v", len(expected), len(diagnostics), diagnostics)
+	}
+	for i, key := range expected {
+		if diagnostics[i].Key != key || diagnostics[i].Category != DiagnosticUnknownKey || !strings.HasPrefix(diagnostics[i].String(), "label "+key+":") {
+			t.Errorf("Expected diagnostic %d to be an unknown key warning about %s, got %+v", i, key, diagnostics[i])
+		}
+	}
+}
+
+func TestValidateLabelsDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		category string
+		severity DiagnosticSeverity
+		key      string
+	}{
+		{
+			name:     "Unknown key",
+			labels:   map[string]string{"traefik.http.router.web.rule": "Host(`web.example.com`)"},
+			category: DiagnosticUnknownKey,
+			severity: SeverityWarning,
+			key:      "traefik.http.router.web.rule",
+		},
+		{
+			name:     "Missing rule",
+			labels:   map[string]string{"traefik.http.routers.web.entrypoints": "websecure"},
+			category: DiagnosticMissingRule,
+			severity: SeverityWarning,
+			key:      "traefik.http.routers.web.rule",
+		},
+		{
+			name: "Unknown service",
+			labels: map[string]string{
+				"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+				"traefik.http.routers.web.service":                   "wbe",
+				"traefik.http.services.web.loadbalancer.server.port": "8080",
+			},
+			category: DiagnosticUnknownService,
+			severity: SeverityError,
+			key:      "traefik.http.routers.web.service",
+		},
+		{
+			name:     "Invalid port",
+			labels:   map[string]string{"traefik.http.services.web.loadbalancer.server.port": "80800"},
+			category: DiagnosticInvalidPort,
+			severity: SeverityError,
+			key:      "traefik.http.services.web.loadbalancer.server.port",
+		},
+		{
+			name:     "Invalid candidate port",
+			labels:   map[string]string{"traefik.tcp.services.db.loadbalancer.server.ports": "5432,pg"},
+			category: DiagnosticInvalidPort,
+			severity: SeverityError,
+			key:      "traefik.tcp.services.db.loadbalancer.server.ports",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := ValidateLabels(tt.labels)
+			if len(diagnostics) != 1 {
+				t.Fatalf("Expected one diagnostic, got %v", diagnostics)
+			}
+			d := diagnostics[0]
+			if d.Category != tt.category || d.Severity != tt.severity || d.Key != tt.key {
+				t.Errorf("Expected %s %s on %s, got %+v", tt.severity, tt.category, tt.key, d)
+			}
+		})
+	}
+
+	valid := map[string]string{
+		"traefik.enable":                                     "true",
+		"traefik.proxmox.ip":                                 "10.0.0.5",
+		"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+		"traefik.http.routers.web.service":                   "web",
+		"traefik.http.routers.api.rule":                      "Host(`api.example.com`)",
+		"traefik.http.routers.api.service":                   "api@internal",
+		"traefik.http.routers.docs.pathprefix":               "/docs",
+		"traefik.http.services.web.loadbalancer.server.port": "8080",
+		"traefik.tcp.routers.db.service":                     "postgres",
+	}
+	if diagnostics := ValidateLabels(valid); len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", diagnostics)
+	}
+}
+
+func TestGenerateConfigurationSkipInvalidGuests(t *testing.T) {
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{ID: 100, Name: "valid", Config: map[string]string{
+				"traefik.enable": "true",
+				"traefik.http.services.valid.loadbalancer.server.port": "8080",
+			}},
+			{ID: 101, Name: "invalid", Config: map[string]string{
+				"traefik.enable": "true",
+				"traefik.http.services.invalid.loadbalancer.server.port": "http",
+			}},
+		},
+	}
+
+	for _, skip := range []bool{false, true} {
+		config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix, skipInvalidGuests: skip})
+		if _, exists := config.HTTP.Services["valid"]; !exists {
+			t.Errorf("Expected the valid guest to be kept with skipInvalidGuests=%v", skip)
+		}
+		if _, exists := config.HTTP.Services["invalid"]; exists == skip {
+			t.Errorf("Expected the invalid guest to be kept only without skipInvalidGuests, skipInvalidGuests=%v", skip)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/traefik/genconf/dynamic"
+)
+
+// maxAutoWeight is the weight of an idle guest with proxmox.autoweight
+const maxAutoWeight = 100
+
+// autoWeight derives a load-balancer weight inversely proportional to the
+// CPU usage of a guest: 1 + round(99 * (1 - cpu)), so an idle guest gets 100
+// and a fully loaded one 1
+func autoWeight(service internal.Service) int {
+	cpu := math.Max(0, math.Min(1, service.CPU))
+	return 1 + int(math.Round(float64(maxAutoWeight-1)*(1-cpu)))
+}
+
+// addWeightedService publishes the load balancer of a guest as a child of
+// a weighted round-robin service named serviceName. Guests with
+// proxmox.autoweight sharing a service name are balanced by their weights.
+func addWeightedService(config *dynamic.Configuration, serviceName string, service internal.Service, loadBalancer *dynamic.ServersLoadBalancer, opts generateOptions) {
+	parent, exists := config.HTTP.Services[serviceName]
+	if !exists {
+		parent = &dynamic.Service{Weighted: &dynamic.WeightedRoundRobin{}}
+		config.HTTP.Services[serviceName] = parent
+	}
+	if parent.Weighted == nil {
+		opts.logger.Warnf("Service %s (ID: %d): service %s is already defined without proxmox.autoweight by another guest, ignoring this guest", service.Name, service.ID, serviceName)
+		return
+	}
+
+	childName := fmt.Sprintf("%s-%d", serviceName, service.ID)
+	config.HTTP.Services[childName] = &dynamic.Service{
+		LoadBalancer: loadBalancer,
+	}
+	weight := autoWeight(service)
+	parent.Weighted.Services = append(parent.Weighted.Services, dynamic.WRRService{
+		Name:   childName,
+		Weight: &weight,
+	})
+}
@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/NX211/traefik-proxmox-provider/internal"
+)
+
+func TestAutoWeight(t *testing.T) {
+	tests := []struct {
+		cpu      float64
+		expected int
+	}{
+		{cpu: 0, expected: 100},
+		{cpu: 0.5, expected: 51},
+		{cpu: 1, expected: 1},
+		{cpu: 1.7, expected: 1},
+		{cpu: -0.1, expected: 100},
+	}
+
+	for _, tt := range tests {
+		if weight := autoWeight(internal.Service{CPU: tt.cpu}); weight != tt.expected {
+			t.Errorf("autoWeight(cpu=%v) = %d, expected %d", tt.cpu, weight, tt.expected)
+		}
+	}
+}
+
+func TestGenerateConfigurationAutoWeight(t *testing.T) {
+	labels := map[string]string{
+		"traefik.enable":                                     "true",
+		"traefik.proxmox.autoweight":                         "true",
+		"traefik.http.routers.app.rule":                      "Host(`app.example.com`)",
+		"traefik.http.services.app.loadbalancer.server.port": "8080",
+	}
+	servicesMap := map[string][]internal.Service{
+		"pve1": {
+			{ID: 100, Name: "app-a", Status: internal.StatusRunning, CPU: 0.1, Config: labels, IPs: []internal.IP{{Address: "10.0.0.1", AddressType: "ipv4"}}},
+			{ID: 101, Name: "app-b", Status: internal.StatusRunning, CPU: 0.9, Config: labels, IPs: []internal.IP{{Address: "10.0.0.2", AddressType: "ipv4"}}},
+		},
+	}
+
+	config := generateConfiguration(servicesMap, generateOptions{labelPrefix: internal.DefaultLabelPrefix})
+
+	parent := config.HTTP.Services["app"]
+	if parent == nil || parent.Weighted == nil {
+		t.Fatalf("Expected a weighted service app, got %+v", parent)
+	}
+	if len(parent.Weighted.Services) != 2 {
+		t.Fatalf("Expected 2 weighted children, got %d", len(parent.Weighted.Services))
+	}
+	expected := map[string]int{"app-100": 90, "app-101": 11}
+	for _, child := range parent.Weighted.Services {
+		if *child.Weight != expected[child.Name] {
+			t.Errorf("Expected weight %d for %s, got %d", expected[child.Name], child.Name, *child.Weight)
+		}
+		lb := config.HTTP.Services[child.Name]
+		if lb == nil || lb.LoadBalancer == nil || len(lb.LoadBalancer.Servers) != 1 {
+			t.Errorf("Expected a load balancer for %s, got %+v", child.Name, lb)
+		}
+	}
+	if config.HTTP.Routers["app"].Service != "app" {
+		t.Errorf("Expected the router to target the weighted service, got %s", config.HTTP.Routers["app"].Service)
+	}
+}
@@ -4,30 +4,182 @@ package traefik_proxmox_provider
 import (
 	"context"
 	"encoding/json"
+	"net/http"
 
 	"github.com/NX211/traefik-proxmox-provider/provider"
+	"github.com/traefik/genconf/dynamic"
 )
 
 // Config the plugin configuration.
 type Config struct {
-	PollInterval   string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
-	ApiEndpoint    string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
-	ApiTokenId     string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
-	ApiToken       string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
-	ApiLogging     string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
-	ApiValidateSSL string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	PollInterval           string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
+	PollJitter             string `json:"pollJitter" yaml:"pollJitter" toml:"pollJitter"`
+	Debounce               string `json:"debounce" yaml:"debounce" toml:"debounce"`
+	PollTimeout            string `json:"pollTimeout" yaml:"pollTimeout" toml:"pollTimeout"`
+	ApiEndpoint            string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
+	Clusters               string `json:"clusters" yaml:"clusters" toml:"clusters"`
+	ClusterConcurrency     string `json:"clusterConcurrency" yaml:"clusterConcurrency" toml:"clusterConcurrency"`
+	ApiTokenId             string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
+	ApiToken               string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
+	ApiTokenFile           string `json:"apiTokenFile" yaml:"apiTokenFile" toml:"apiTokenFile"`
+	ApiLogging             string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
+	LogFormat              string `json:"logFormat" yaml:"logFormat" toml:"logFormat"`
+	ApiValidateSSL         string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	IncludeNodes           string `json:"includeNodes" yaml:"includeNodes" toml:"includeNodes"`
+	ExcludeNodes           string `json:"excludeNodes" yaml:"excludeNodes" toml:"excludeNodes"`
+	ExcludeVMIDs           string `json:"excludeVMIDs" yaml:"excludeVMIDs" toml:"excludeVMIDs"`
+	ExcludeNamePatterns    string `json:"excludeNamePatterns" yaml:"excludeNamePatterns" toml:"excludeNamePatterns"`
+	ConstraintTags         string `json:"constraintTags" yaml:"constraintTags" toml:"constraintTags"`
+	Pools                  string `json:"pools" yaml:"pools" toml:"pools"`
+	HaAware                string `json:"haAware" yaml:"haAware" toml:"haAware"`
+	LabelPrefix            string `json:"labelPrefix" yaml:"labelPrefix" toml:"labelPrefix"`
+	LabelSeparator         string `json:"labelSeparator" yaml:"labelSeparator" toml:"labelSeparator"`
+	LabelMarker            string `json:"labelMarker" yaml:"labelMarker" toml:"labelMarker"`
+	LabelMarkerRequired    string `json:"labelMarkerRequired" yaml:"labelMarkerRequired" toml:"labelMarkerRequired"`
+	LabelBlockMode         string `json:"labelBlockMode" yaml:"labelBlockMode" toml:"labelBlockMode"`
+	IncludeStopped         string `json:"includeStopped" yaml:"includeStopped" toml:"includeStopped"`
+	ScanMode               string `json:"scanMode" yaml:"scanMode" toml:"scanMode"`
+	ExposeTypes            string `json:"exposeTypes" yaml:"exposeTypes" toml:"exposeTypes"`
+	RequireOnboot          string `json:"requireOnboot" yaml:"requireOnboot" toml:"requireOnboot"`
+	RequireProtection      string `json:"requireProtection" yaml:"requireProtection" toml:"requireProtection"`
+	StoppedService         string `json:"stoppedService" yaml:"stoppedService" toml:"stoppedService"`
+	Metrics                string `json:"metrics" yaml:"metrics" toml:"metrics"`
+	ScanTimeout            string `json:"scanTimeout" yaml:"scanTimeout" toml:"scanTimeout"`
+	ConfigCacheTTL         string `json:"configCacheTTL" yaml:"configCacheTTL" toml:"configCacheTTL"`
+	NodeFailureThreshold   string `json:"nodeFailureThreshold" yaml:"nodeFailureThreshold" toml:"nodeFailureThreshold"`
+	NodeCooldown           string `json:"nodeCooldown" yaml:"nodeCooldown" toml:"nodeCooldown"`
+	NodeMaxCooldown        string `json:"nodeMaxCooldown" yaml:"nodeMaxCooldown" toml:"nodeMaxCooldown"`
+	ValidateLabels         string `json:"validateLabels" yaml:"validateLabels" toml:"validateLabels"`
+	SkipInvalidGuests      string `json:"skipInvalidGuests" yaml:"skipInvalidGuests" toml:"skipInvalidGuests"`
+	AllowIPv6              string `json:"allowIPv6" yaml:"allowIPv6" toml:"allowIPv6"`
+	DualStack              string `json:"dualStack" yaml:"dualStack" toml:"dualStack"`
+	PreferInterface        string `json:"preferInterface" yaml:"preferInterface" toml:"preferInterface"`
+	PreferPrefixLen        string `json:"preferPrefixLen" yaml:"preferPrefixLen" toml:"preferPrefixLen"`
+	PreferReverseDNS       string `json:"preferReverseDNS" yaml:"preferReverseDNS" toml:"preferReverseDNS"`
+	IPSelector             string `json:"ipSelector" yaml:"ipSelector" toml:"ipSelector"`
+	MaxServersPerService   string `json:"maxServersPerService" yaml:"maxServersPerService" toml:"maxServersPerService"`
+	UserAgent              string `json:"userAgent" yaml:"userAgent" toml:"userAgent"`
+	ApiProxyURL            string `json:"apiProxyURL" yaml:"apiProxyURL" toml:"apiProxyURL"`
+	ApiBasePath            string `json:"apiBasePath" yaml:"apiBasePath" toml:"apiBasePath"`
+	ApiExtraHeaders        string `json:"apiExtraHeaders" yaml:"apiExtraHeaders" toml:"apiExtraHeaders"`
+	ApiLogBodyLimit        string `json:"apiLogBodyLimit" yaml:"apiLogBodyLimit" toml:"apiLogBodyLimit"`
+	ApiMaxIdleConns        string `json:"apiMaxIdleConns" yaml:"apiMaxIdleConns" toml:"apiMaxIdleConns"`
+	ApiMaxIdleConnsPerHost string `json:"apiMaxIdleConnsPerHost" yaml:"apiMaxIdleConnsPerHost" toml:"apiMaxIdleConnsPerHost"`
+	ApiIdleConnTimeout     string `json:"apiIdleConnTimeout" yaml:"apiIdleConnTimeout" toml:"apiIdleConnTimeout"`
+	ApiRateLimit           string `json:"apiRateLimit" yaml:"apiRateLimit" toml:"apiRateLimit"`
+	ApiRateBurst           string `json:"apiRateBurst" yaml:"apiRateBurst" toml:"apiRateBurst"`
+	NoIPBehavior           string `json:"noIPBehavior" yaml:"noIPBehavior" toml:"noIPBehavior"`
+	NoIPGracePeriod        string `json:"noIPGracePeriod" yaml:"noIPGracePeriod" toml:"noIPGracePeriod"`
+	NoAddressPolicy        string `json:"noAddressPolicy" yaml:"noAddressPolicy" toml:"noAddressPolicy"`
+	HostnameSuffix         string `json:"hostnameSuffix" yaml:"hostnameSuffix" toml:"hostnameSuffix"`
+	NodeAddresses          string `json:"nodeAddresses" yaml:"nodeAddresses" toml:"nodeAddresses"`
+	ResolveHostnameIPv6    string `json:"resolveHostnameIPv6" yaml:"resolveHostnameIPv6" toml:"resolveHostnameIPv6"`
+	HttpsRedirect          string `json:"httpsRedirect" yaml:"httpsRedirect" toml:"httpsRedirect"`
+	ExposedByDefault       string `json:"exposedByDefault" yaml:"exposedByDefault" toml:"exposedByDefault"`
+	HttpEntryPoint         string `json:"httpEntryPoint" yaml:"httpEntryPoint" toml:"httpEntryPoint"`
+	HttpsEntryPoint        string `json:"httpsEntryPoint" yaml:"httpsEntryPoint" toml:"httpsEntryPoint"`
+	DefaultRule            string `json:"defaultRule" yaml:"defaultRule" toml:"defaultRule"`
+	DefaultMiddlewares     string `json:"defaultMiddlewares" yaml:"defaultMiddlewares" toml:"defaultMiddlewares"`
+	NodeHeaderInjection    string `json:"nodeHeaderInjection" yaml:"nodeHeaderInjection" toml:"nodeHeaderInjection"`
+	GuestHeaderInjection   string `json:"guestHeaderInjection" yaml:"guestHeaderInjection" toml:"guestHeaderInjection"`
+	PrefixNames            string `json:"prefixNames" yaml:"prefixNames" toml:"prefixNames"`
+	AppPresets             string `json:"appPresets" yaml:"appPresets" toml:"appPresets"`
+	UseGuestAgent          string `json:"useGuestAgent" yaml:"useGuestAgent" toml:"useGuestAgent"`
+	CloudInitLabels        string `json:"cloudInitLabels" yaml:"cloudInitLabels" toml:"cloudInitLabels"`
+	HealthAddress          string `json:"healthAddress" yaml:"healthAddress" toml:"healthAddress"`
+	SelfRouterRule         string `json:"selfRouterRule" yaml:"selfRouterRule" toml:"selfRouterRule"`
+	FixtureFile            string `json:"fixtureFile" yaml:"fixtureFile" toml:"fixtureFile"`
+	StaticConfigFile       string `json:"staticConfigFile" yaml:"staticConfigFile" toml:"staticConfigFile"`
+	ControlGuestName       string `json:"controlGuestName" yaml:"controlGuestName" toml:"controlGuestName"`
+	ControlGuestTag        string `json:"controlGuestTag" yaml:"controlGuestTag" toml:"controlGuestTag"`
+	FailFast               string `json:"failFast" yaml:"failFast" toml:"failFast"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	cfg := provider.CreateConfig()
 	return &Config{
-		PollInterval:   cfg.PollInterval,
-		ApiEndpoint:    cfg.ApiEndpoint,
-		ApiTokenId:     cfg.ApiTokenId,
-		ApiToken:       cfg.ApiToken,
-		ApiLogging:     cfg.ApiLogging,
-		ApiValidateSSL: cfg.ApiValidateSSL,
+		PollInterval:           cfg.PollInterval,
+		PollJitter:             cfg.PollJitter,
+		Debounce:               cfg.Debounce,
+		PollTimeout:            cfg.PollTimeout,
+		ApiEndpoint:            cfg.ApiEndpoint,
+		Clusters:               cfg.Clusters,
+		ClusterConcurrency:     cfg.ClusterConcurrency,
+		ApiTokenId:             cfg.ApiTokenId,
+		ApiToken:               cfg.ApiToken,
+		ApiTokenFile:           cfg.ApiTokenFile,
+		ApiLogging:             cfg.ApiLogging,
+		LogFormat:              cfg.LogFormat,
+		ApiValidateSSL:         cfg.ApiValidateSSL,
+		IncludeNodes:           cfg.IncludeNodes,
+		ExcludeNodes:           cfg.ExcludeNodes,
+		ExcludeVMIDs:           cfg.ExcludeVMIDs,
+		ExcludeNamePatterns:    cfg.ExcludeNamePatterns,
+		ConstraintTags:         cfg.ConstraintTags,
+		Pools:                  cfg.Pools,
+		HaAware:                cfg.HaAware,
+		LabelPrefix:            cfg.LabelPrefix,
+		LabelSeparator:         cfg.LabelSeparator,
+		LabelMarker:            cfg.LabelMarker,
+		LabelMarkerRequired:    cfg.LabelMarkerRequired,
+		LabelBlockMode:         cfg.LabelBlockMode,
+		IncludeStopped:         cfg.IncludeStopped,
+		ScanMode:               cfg.ScanMode,
+		ExposeTypes:            cfg.ExposeTypes,
+		RequireOnboot:          cfg.RequireOnboot,
+		RequireProtection:      cfg.RequireProtection,
+		StoppedService:         cfg.StoppedService,
+		Metrics:                cfg.Metrics,
+		ScanTimeout:            cfg.ScanTimeout,
+		ConfigCacheTTL:         cfg.ConfigCacheTTL,
+		NodeFailureThreshold:   cfg.NodeFailureThreshold,
+		NodeCooldown:           cfg.NodeCooldown,
+		NodeMaxCooldown:        cfg.NodeMaxCooldown,
+		ValidateLabels:         cfg.ValidateLabels,
+		SkipInvalidGuests:      cfg.SkipInvalidGuests,
+		AllowIPv6:              cfg.AllowIPv6,
+		DualStack:              cfg.DualStack,
+		PreferInterface:        cfg.PreferInterface,
+		PreferPrefixLen:        cfg.PreferPrefixLen,
+		PreferReverseDNS:       cfg.PreferReverseDNS,
+		IPSelector:             cfg.IPSelector,
+		MaxServersPerService:   cfg.MaxServersPerService,
+		UserAgent:              cfg.UserAgent,
+		ApiProxyURL:            cfg.ApiProxyURL,
+		ApiBasePath:            cfg.ApiBasePath,
+		ApiExtraHeaders:        cfg.ApiExtraHeaders,
+		ApiLogBodyLimit:        cfg.ApiLogBodyLimit,
+		ApiMaxIdleConns:        cfg.ApiMaxIdleConns,
+		ApiMaxIdleConnsPerHost: cfg.ApiMaxIdleConnsPerHost,
+		ApiIdleConnTimeout:     cfg.ApiIdleConnTimeout,
+		ApiRateLimit:           cfg.ApiRateLimit,
+		ApiRateBurst:           cfg.ApiRateBurst,
+		NoIPBehavior:           cfg.NoIPBehavior,
+		NoIPGracePeriod:        cfg.NoIPGracePeriod,
+		NoAddressPolicy:        cfg.NoAddressPolicy,
+		HostnameSuffix:         cfg.HostnameSuffix,
+		NodeAddresses:          cfg.NodeAddresses,
+		ResolveHostnameIPv6:    cfg.ResolveHostnameIPv6,
+		HttpsRedirect:          cfg.HttpsRedirect,
+		ExposedByDefault:       cfg.ExposedByDefault,
+		HttpEntryPoint:         cfg.HttpEntryPoint,
+		HttpsEntryPoint:        cfg.HttpsEntryPoint,
+		DefaultRule:            cfg.DefaultRule,
+		DefaultMiddlewares:     cfg.DefaultMiddlewares,
+		NodeHeaderInjection:    cfg.NodeHeaderInjection,
+		GuestHeaderInjection:   cfg.GuestHeaderInjection,
+		PrefixNames:            cfg.PrefixNames,
+		AppPresets:             cfg.AppPresets,
+		UseGuestAgent:          cfg.UseGuestAgent,
+		CloudInitLabels:        cfg.CloudInitLabels,
+		HealthAddress:          cfg.HealthAddress,
+		SelfRouterRule:         cfg.SelfRouterRule,
+		FixtureFile:            cfg.FixtureFile,
+		StaticConfigFile:       cfg.StaticConfigFile,
+		ControlGuestName:       cfg.ControlGuestName,
+		ControlGuestTag:        cfg.ControlGuestTag,
+		FailFast:               cfg.FailFast,
 	}
 }
 
@@ -39,12 +191,87 @@ type Provider struct {
 // New creates a new Provider plugin.
 func New(ctx context.Context, config *Config, name string) (*Provider, error) {
 	providerConfig := &provider.Config{
-		PollInterval:   config.PollInterval,
-		ApiEndpoint:    config.ApiEndpoint,
-		ApiTokenId:     config.ApiTokenId,
-		ApiToken:       config.ApiToken,
-		ApiLogging:     config.ApiLogging,
-		ApiValidateSSL: config.ApiValidateSSL,
+		PollInterval:           config.PollInterval,
+		PollJitter:             config.PollJitter,
+		Debounce:               config.Debounce,
+		PollTimeout:            config.PollTimeout,
+		ApiEndpoint:            config.ApiEndpoint,
+		Clusters:               config.Clusters,
+		ClusterConcurrency:     config.ClusterConcurrency,
+		ApiTokenId:             config.ApiTokenId,
+		ApiToken:               config.ApiToken,
+		ApiTokenFile:           config.ApiTokenFile,
+		ApiLogging:             config.ApiLogging,
+		LogFormat:              config.LogFormat,
+		ApiValidateSSL:         config.ApiValidateSSL,
+		IncludeNodes:           config.IncludeNodes,
+		ExcludeNodes:           config.ExcludeNodes,
+		ExcludeVMIDs:           config.ExcludeVMIDs,
+		ExcludeNamePatterns:    config.ExcludeNamePatterns,
+		ConstraintTags:         config.ConstraintTags,
+		Pools:                  config.Pools,
+		HaAware:                config.HaAware,
+		LabelPrefix:            config.LabelPrefix,
+		LabelSeparator:         config.LabelSeparator,
+		LabelMarker:            config.LabelMarker,
+		LabelMarkerRequired:    config.LabelMarkerRequired,
+		LabelBlockMode:         config.LabelBlockMode,
+		IncludeStopped:         config.IncludeStopped,
+		ScanMode:               config.ScanMode,
+		ExposeTypes:            config.ExposeTypes,
+		RequireOnboot:          config.RequireOnboot,
+		RequireProtection:      config.RequireProtection,
+		StoppedService:         config.StoppedService,
+		Metrics:                config.Metrics,
+		ScanTimeout:            config.ScanTimeout,
+		ConfigCacheTTL:         config.ConfigCacheTTL,
+		NodeFailureThreshold:   config.NodeFailureThreshold,
+		NodeCooldown:           config.NodeCooldown,
+		NodeMaxCooldown:        config.NodeMaxCooldown,
+		ValidateLabels:         config.ValidateLabels,
+		SkipInvalidGuests:      config.SkipInvalidGuests,
+		AllowIPv6:              config.AllowIPv6,
+		DualStack:              config.DualStack,
+		PreferInterface:        config.PreferInterface,
+		PreferPrefixLen:        config.PreferPrefixLen,
+		PreferReverseDNS:       config.PreferReverseDNS,
+		IPSelector:             config.IPSelector,
+		MaxServersPerService:   config.MaxServersPerService,
+		UserAgent:              config.UserAgent,
+		ApiProxyURL:            config.ApiProxyURL,
+		ApiBasePath:            config.ApiBasePath,
+		ApiExtraHeaders:        config.ApiExtraHeaders,
+		ApiLogBodyLimit:        config.ApiLogBodyLimit,
+		ApiMaxIdleConns:        config.ApiMaxIdleConns,
+		ApiMaxIdleConnsPerHost: config.ApiMaxIdleConnsPerHost,
+		ApiIdleConnTimeout:     config.ApiIdleConnTimeout,
+		ApiRateLimit:           config.ApiRateLimit,
+		ApiRateBurst:           config.ApiRateBurst,
+		NoIPBehavior:           config.NoIPBehavior,
+		NoIPGracePeriod:        config.NoIPGracePeriod,
+		NoAddressPolicy:        config.NoAddressPolicy,
+		HostnameSuffix:         config.HostnameSuffix,
+		NodeAddresses:          config.NodeAddresses,
+		ResolveHostnameIPv6:    config.ResolveHostnameIPv6,
+		HttpsRedirect:          config.HttpsRedirect,
+		ExposedByDefault:       config.ExposedByDefault,
+		HttpEntryPoint:         config.HttpEntryPoint,
+		HttpsEntryPoint:        config.HttpsEntryPoint,
+		DefaultRule:            config.DefaultRule,
+		DefaultMiddlewares:     config.DefaultMiddlewares,
+		NodeHeaderInjection:    config.NodeHeaderInjection,
+		GuestHeaderInjection:   config.GuestHeaderInjection,
+		PrefixNames:            config.PrefixNames,
+		AppPresets:             config.AppPresets,
+		UseGuestAgent:          config.UseGuestAgent,
+		CloudInitLabels:        config.CloudInitLabels,
+		HealthAddress:          config.HealthAddress,
+		SelfRouterRule:         config.SelfRouterRule,
+		FixtureFile:            config.FixtureFile,
+		StaticConfigFile:       config.StaticConfigFile,
+		ControlGuestName:       config.ControlGuestName,
+		ControlGuestTag:        config.ControlGuestTag,
+		FailFast:               config.FailFast,
 	}
 
 	innerProvider, err := provider.New(ctx, providerConfig, name)
@@ -67,6 +294,26 @@ func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
 	return p.provider.Provide(cfgChan)
 }
 
+// GenerateOnce performs a single scan and returns the generated configuration.
+func (p *Provider) GenerateOnce(ctx context.Context) (*dynamic.Configuration, error) {
+	return p.provider.GenerateOnce(ctx)
+}
+
+// Metrics returns the scan health metrics collector, or nil when metrics are disabled.
+func (p *Provider) Metrics() *provider.Metrics {
+	return p.provider.Metrics()
+}
+
+// LastPollStatus returns the outcome of the most recent poll.
+func (p *Provider) LastPollStatus() provider.PollStatus {
+	return p.provider.LastPollStatus()
+}
+
+// HealthHandler serves the /healthz and /readyz endpoints of the provider.
+func (p *Provider) HealthHandler() http.Handler {
+	return p.provider.HealthHandler()
+}
+
 // Stop the provider.
 func (p *Provider) Stop() error {
 	return p.provider.Stop()